@@ -0,0 +1,490 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rancher-sandbox/rd-open-webui-docker-ext/installer/types"
+)
+
+// progressBroadcaster forwards progress events to every subscribed client of
+// the API's event stream.  Slow clients miss events rather than stall the
+// operation reporting them.
+type progressBroadcaster struct {
+	mu          sync.Mutex
+	subscribers map[chan types.ProgressEvent]struct{}
+}
+
+func (b *progressBroadcaster) publish(event types.ProgressEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// subscribe returns a channel receiving progress events, and a function to
+// stop receiving them.
+func (b *progressBroadcaster) subscribe() (chan types.ProgressEvent, func()) {
+	ch := make(chan types.ProgressEvent, 64)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subscribers[ch] = struct{}{}
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.subscribers, ch)
+	}
+}
+
+// apiServer serves the installer's functions over HTTP, so that the UI need not
+// run the installer for each operation.
+type apiServer struct {
+	progress *progressBroadcaster
+	pulls    *pullQueue
+	// socket is set when serving on a Unix socket, which browsers cannot
+	// reach, so the Host header need not be checked.
+	socket bool
+	// install installs ollama; it is install, other than in tests.
+	install func(ctx context.Context) (*types.InstallResult, error)
+	// busy is held while an operation that changes the install or models runs;
+	// concurrent requests for such operations are refused.
+	busy sync.Mutex
+}
+
+// writeAPIResponse writes the given value as the JSON response.
+func writeAPIResponse(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("api: failed to write response: %s", err)
+	}
+}
+
+// writeAPIError writes the error as a JSON response, in the form ollama uses.
+func writeAPIError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, ErrModelNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, ErrModelExists):
+		status = http.StatusConflict
+	case errors.Is(err, ErrServerNotStarted):
+		status = http.StatusServiceUnavailable
+	}
+	writeAPIResponse(w, status, map[string]string{"error": err.Error()})
+}
+
+// isLoopbackHost reports whether the host (with an optional port) of a request
+// names the loopback interface.
+func isLoopbackHost(hostport string) bool {
+	host, _, err := net.SplitHostPort(hostport)
+	if err != nil {
+		host = strings.Trim(hostport, "[]")
+	}
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// checkBrowserRequest returns the status and error to refuse a request with if
+// it may have been sent by a web page rather than a local client, or zero if it
+// is acceptable.  Even without -api-token, a page could otherwise make a
+// browser send requests to the API: any request with an Origin is refused, as
+// is any naming a host other than the loopback interface (as after DNS
+// rebinding).  Requests that change anything must be JSON, which a page cannot
+// send cross-origin without an Origin header.
+func (s *apiServer) checkBrowserRequest(r *http.Request) (int, string) {
+	if r.Header.Get("Origin") != "" {
+		return http.StatusForbidden, "cross-origin requests are not allowed"
+	}
+	if !s.socket && !isLoopbackHost(r.Host) {
+		return http.StatusForbidden, "requests must be addressed to the loopback interface"
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil || mediaType != "application/json" {
+			return http.StatusUnsupportedMediaType, "Content-Type must be application/json"
+		}
+	}
+	return 0, ""
+}
+
+// handle registers a handler for the given method and path, refusing requests
+// that may come from a web page (see checkBrowserRequest) and checking the
+// token (if -api-token is set) first.  Handlers returning a value respond with
+// it as JSON; ones writing their own response return nil.
+func (s *apiServer) handle(mux *http.ServeMux, method, path string, handler func(w http.ResponseWriter, r *http.Request) (any, error)) {
+	mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		if status, message := s.checkBrowserRequest(r); status != 0 {
+			writeAPIResponse(w, status, map[string]string{"error": message})
+			return
+		}
+		if !checkBearerToken(r, *apiToken) {
+			writeAPIResponse(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+			return
+		}
+		if r.Method != method {
+			writeAPIResponse(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+			return
+		}
+		start := time.Now()
+		result, err := handler(w, r)
+		if err != nil {
+			log.Printf("api: path=%s error=%q duration=%s", r.URL.Path, err, time.Since(start))
+			writeAPIError(w, err)
+		} else if result != nil {
+			writeAPIResponse(w, http.StatusOK, result)
+		}
+	})
+}
+
+// exclusive runs the given operation unless another one is already running.
+func (s *apiServer) exclusive(w http.ResponseWriter, operation func() (any, error)) (any, error) {
+	if !s.busy.TryLock() {
+		writeAPIResponse(w, http.StatusConflict, map[string]string{"error": "another operation is in progress"})
+		return nil, nil
+	}
+	defer s.busy.Unlock()
+	return operation()
+}
+
+// readModelRequest reads the model named in a JSON request body.
+func readModelRequest(r *http.Request) (string, error) {
+	var body struct {
+		Model string `json:"model"`
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body, 64<<10)).Decode(&body); err != nil {
+		return "", fmt.Errorf("failed to read request: %w", err)
+	}
+	if body.Model == "" {
+		return "", fmt.Errorf("no model given")
+	}
+	return body.Model, nil
+}
+
+func (s *apiServer) handleHealth(w http.ResponseWriter, r *http.Request) (any, error) {
+	result := types.Health{SchemaVersion: types.SchemaVersion}
+	if err := checkHealth(r.Context(), *healthTimeout); err != nil {
+		result.Starting = errors.Is(err, ErrServerTimeout)
+		result.Error = err.Error()
+	} else {
+		result.Running = true
+	}
+	return result, nil
+}
+
+func (s *apiServer) handleStatus(w http.ResponseWriter, r *http.Request) (any, error) {
+	return getStatus(r.Context())
+}
+
+func (s *apiServer) handleInstall(w http.ResponseWriter, r *http.Request) (any, error) {
+	return s.exclusive(w, func() (any, error) {
+		log.Printf("Installing ollama...")
+		return s.install(r.Context())
+	})
+}
+
+func (s *apiServer) handleModels(w http.ResponseWriter, r *http.Request) (any, error) {
+	models, err := listModels(r.Context())
+	if err != nil {
+		return nil, err
+	}
+	return types.ModelList{SchemaVersion: types.SchemaVersion, Models: models}, nil
+}
+
+// handlePull queues pulls of the model or models named in the request body,
+// responding with the pull queue without waiting for them.
+func (s *apiServer) handlePull(w http.ResponseWriter, r *http.Request) (any, error) {
+	var body struct {
+		Model  string   `json:"model"`
+		Models []string `json:"models"`
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body, 64<<10)).Decode(&body); err != nil {
+		writeAPIResponse(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("failed to read request: %s", err)})
+		return nil, nil
+	}
+	models := body.Models
+	if body.Model != "" {
+		models = append(models, body.Model)
+	}
+	if len(models) == 0 {
+		writeAPIResponse(w, http.StatusBadRequest, map[string]string{"error": "no model given"})
+		return nil, nil
+	}
+	for _, model := range models {
+		if _, err := modelManifestPath(normalizeModelName(model)); err != nil {
+			writeAPIResponse(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return nil, nil
+		}
+	}
+	for _, model := range models {
+		if err := s.pulls.add(model); err != nil {
+			return nil, err
+		}
+	}
+	return s.pulls.status(), nil
+}
+
+// handlePulls responds with the pull queue.
+func (s *apiServer) handlePulls(w http.ResponseWriter, r *http.Request) (any, error) {
+	return s.pulls.status(), nil
+}
+
+// handleCancel cancels the pull of the model named in the request body, whether
+// queued or running.
+func (s *apiServer) handleCancel(w http.ResponseWriter, r *http.Request) (any, error) {
+	model, err := readModelRequest(r)
+	if err != nil {
+		writeAPIResponse(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return nil, nil
+	}
+	if err = s.pulls.cancel(model); err != nil {
+		return nil, err
+	}
+	return s.pulls.status(), nil
+}
+
+func (s *apiServer) handleDelete(w http.ResponseWriter, r *http.Request) (any, error) {
+	model, err := readModelRequest(r)
+	if err != nil {
+		writeAPIResponse(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return nil, nil
+	}
+	return s.exclusive(w, func() (any, error) {
+		if err := deleteModel(r.Context(), model); err != nil {
+			return nil, err
+		}
+		return s.handleModels(w, r)
+	})
+}
+
+// handleLogs responds with the end of the ollama server log, if it can be found.
+func (s *apiServer) handleLogs(w http.ResponseWriter, r *http.Request) (any, error) {
+	for _, logPath := range serverLogCandidates(r.Context()) {
+		file, err := os.Open(logPath)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to read server log: %w", err)
+		}
+		defer file.Close()
+		if info, err := file.Stat(); err == nil && info.Size() > maxDiagnosticsLogSize {
+			if _, err = file.Seek(-maxDiagnosticsLogSize, io.SeekEnd); err != nil {
+				return nil, fmt.Errorf("failed to read server log: %w", err)
+			}
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if _, err = io.Copy(w, file); err != nil {
+			log.Printf("api: failed to write server log: %s", err)
+		}
+		return nil, nil
+	}
+	writeAPIResponse(w, http.StatusNotFound, map[string]string{"error": "no server log found"})
+	return nil, nil
+}
+
+// startServerEvents starts a response of server-sent events.
+func startServerEvents(w http.ResponseWriter) (*http.ResponseController, error) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	controller := http.NewResponseController(w)
+	return controller, controller.Flush()
+}
+
+// writeServerEvent writes the value as a server-sent event with the given name,
+// flushing it to the client immediately.
+func writeServerEvent(w http.ResponseWriter, controller *http.ResponseController, name string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if _, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, data); err != nil {
+		return err
+	}
+	return controller.Flush()
+}
+
+// handleEvents streams progress events as server-sent events until the client
+// disconnects.
+func (s *apiServer) handleEvents(w http.ResponseWriter, r *http.Request) (any, error) {
+	events, unsubscribe := s.progress.subscribe()
+	defer unsubscribe()
+	controller, err := startServerEvents(w)
+	if err != nil {
+		return nil, nil
+	}
+	for {
+		select {
+		case <-r.Context().Done():
+			return nil, nil
+		case event := <-events:
+			if err = writeServerEvent(w, controller, "progress", event); err != nil {
+				return nil, nil
+			}
+		}
+	}
+}
+
+// handleInstallEvents installs ollama as handleInstall does, but streams the
+// progress of the install as server-sent events, ending with a "result" or an
+// "error" event.  The install is cancelled if the client disconnects.
+func (s *apiServer) handleInstallEvents(w http.ResponseWriter, r *http.Request) (any, error) {
+	return s.exclusive(w, func() (any, error) {
+		events, unsubscribe := s.progress.subscribe()
+		defer unsubscribe()
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		controller, err := startServerEvents(w)
+		if err != nil {
+			return nil, nil
+		}
+
+		type installOutcome struct {
+			result *types.InstallResult
+			err    error
+		}
+		done := make(chan installOutcome, 1)
+		go func() {
+			log.Printf("Installing ollama...")
+			result, err := s.install(ctx)
+			done <- installOutcome{result, err}
+		}()
+
+		// forward writes a progress event to the client; pulls run alongside
+		// the install, so their events are left to the general event stream.
+		forward := func(event types.ProgressEvent) error {
+			if event.Phase == PhasePull {
+				return nil
+			}
+			return writeServerEvent(w, controller, "progress", event)
+		}
+		for {
+			select {
+			case event := <-events:
+				if err := forward(event); err != nil {
+					// The client went away; stop the install, but wait for it
+					// to finish before allowing another operation.
+					cancel()
+					outcome := <-done
+					log.Printf("api: install stopped after client disconnected: %v", outcome.err)
+					return nil, nil
+				}
+			case outcome := <-done:
+				// Deliver any events published before the install returned.
+				for len(events) > 0 {
+					if err := forward(<-events); err != nil {
+						return nil, nil
+					}
+				}
+				if outcome.err != nil {
+					log.Printf("api: path=%s error=%q", r.URL.Path, outcome.err)
+					_ = writeServerEvent(w, controller, "error", map[string]string{"error": outcome.err.Error()})
+				} else {
+					_ = writeServerEvent(w, controller, "result", outcome.result)
+				}
+				return nil, nil
+			}
+		}
+	})
+}
+
+// newHandler returns the handler for the installer API.
+func (s *apiServer) newHandler() http.Handler {
+	mux := http.NewServeMux()
+	s.handle(mux, http.MethodGet, "/health", s.handleHealth)
+	s.handle(mux, http.MethodGet, "/status", s.handleStatus)
+	s.handle(mux, http.MethodPost, "/install", s.handleInstall)
+	s.handle(mux, http.MethodPost, "/install/events", s.handleInstallEvents)
+	s.handle(mux, http.MethodGet, "/models", s.handleModels)
+	s.handle(mux, http.MethodPost, "/models/pull", s.handlePull)
+	s.handle(mux, http.MethodPost, "/models/cancel", s.handleCancel)
+	s.handle(mux, http.MethodGet, "/pulls", s.handlePulls)
+	s.handle(mux, http.MethodPost, "/models/delete", s.handleDelete)
+	s.handle(mux, http.MethodGet, "/logs", s.handleLogs)
+	s.handle(mux, http.MethodGet, "/events", s.handleEvents)
+	return mux
+}
+
+// listenAPI listens on the given address, which must either be a Unix socket,
+// as unix:<path>, or on the loopback interface; the API must not be reachable
+// from other machines.
+func listenAPI(address string) (net.Listener, error) {
+	if socketPath, ok := strings.CutPrefix(address, "unix:"); ok {
+		// Remove any socket left behind by an earlier run that did not exit
+		// cleanly.
+		if err := os.Remove(socketPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("failed to remove stale socket: %w", err)
+		}
+		listener, err := net.Listen("unix", socketPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to listen on %s: %w", socketPath, err)
+		}
+		if err = os.Chmod(socketPath, 0o600); err != nil {
+			listener.Close()
+			return nil, fmt.Errorf("failed to restrict access to %s: %w", socketPath, err)
+		}
+		return listener, nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid API address %q: %w", address, err)
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return nil, fmt.Errorf("invalid API address %q: must be a loopback address or unix:<path>", address)
+	}
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", address, err)
+	}
+	return listener, nil
+}
+
+// runAPI serves the installer API on -api-listen until the context is
+// cancelled.  Progress events are streamed to clients as well as reported as
+// usual.
+func runAPI(ctx context.Context) error {
+	listener, err := listenAPI(*apiListen)
+	if err != nil {
+		return err
+	}
+	s := &apiServer{
+		progress: &progressBroadcaster{subscribers: make(map[chan types.ProgressEvent]struct{})},
+		pulls:    newPullQueue(ctx, *pullConcurrency),
+		socket:   strings.HasPrefix(*apiListen, "unix:"),
+		install:  install,
+	}
+	progressCallback = func(event types.ProgressEvent) {
+		emitProgress(event)
+		s.progress.publish(event)
+	}
+	server := &http.Server{Handler: s.newHandler(), BaseContext: func(net.Listener) context.Context { return ctx }}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+	log.Printf("Serving the installer API on %s", *apiListen)
+	if err = server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("failed to run API: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rancher-sandbox/rd-open-webui-docker-ext/installer/types"
+)
+
+func TestAPIRefusesBrowserRequests(t *testing.T) {
+	s := &apiServer{progress: &progressBroadcaster{subscribers: make(map[chan types.ProgressEvent]struct{})}}
+	handler := s.newHandler()
+	for _, tt := range []struct {
+		name        string
+		method      string
+		path        string
+		host        string
+		origin      string
+		contentType string
+		want        int
+	}{
+		// An empty model is refused by the handler itself, so a bad request
+		// shows the request got past the checks.
+		{"json", http.MethodPost, "/models/cancel", "127.0.0.1:11436", "", "application/json", http.StatusBadRequest},
+		{"json with charset", http.MethodPost, "/models/cancel", "localhost:11436", "", "application/json; charset=utf-8", http.StatusBadRequest},
+		{"ipv6 loopback", http.MethodPost, "/models/cancel", "[::1]:11436", "", "application/json", http.StatusBadRequest},
+		{"form", http.MethodPost, "/models/cancel", "127.0.0.1:11436", "", "application/x-www-form-urlencoded", http.StatusUnsupportedMediaType},
+		{"text", http.MethodPost, "/install", "127.0.0.1:11436", "", "text/plain", http.StatusUnsupportedMediaType},
+		{"no content type", http.MethodPost, "/models/delete", "127.0.0.1:11436", "", "", http.StatusUnsupportedMediaType},
+		{"origin", http.MethodPost, "/models/cancel", "127.0.0.1:11436", "https://example.com", "application/json", http.StatusForbidden},
+		{"origin on get", http.MethodGet, "/pulls", "127.0.0.1:11436", "null", "", http.StatusForbidden},
+		{"rebound host", http.MethodGet, "/pulls", "attacker.example:11436", "", "", http.StatusForbidden},
+		{"rebound host without port", http.MethodGet, "/pulls", "attacker.example", "", "", http.StatusForbidden},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "http://"+tt.host+tt.path, strings.NewReader(`{"model":""}`))
+			req.Host = tt.host
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, req)
+			if recorder.Code != tt.want {
+				t.Errorf("%s %s = %d, want %d: %s", tt.method, tt.path, recorder.Code, tt.want, recorder.Body)
+			}
+		})
+	}
+}
+
+func TestAPISocketAllowsAnyHost(t *testing.T) {
+	s := &apiServer{socket: true}
+	req := httptest.NewRequest(http.MethodGet, "http://unix/status", nil)
+	if status, message := s.checkBrowserRequest(req); status != 0 {
+		t.Errorf("request over a socket refused: %d %s", status, message)
+	}
+}
+
+// newTestAPIServer serves the installer API with the given install function.
+func newTestAPIServer(t *testing.T, install func(ctx context.Context) (*types.InstallResult, error)) (*apiServer, *httptest.Server) {
+	t.Helper()
+	s := &apiServer{
+		progress: &progressBroadcaster{subscribers: make(map[chan types.ProgressEvent]struct{})},
+		install:  install,
+	}
+	server := httptest.NewServer(s.newHandler())
+	t.Cleanup(server.Close)
+	return s, server
+}
+
+// serverEvent is a server-sent event, as read by readServerEvent.
+type serverEvent struct {
+	name string
+	data string
+}
+
+// readServerEvent reads the next server-sent event.
+func readServerEvent(t *testing.T, reader *bufio.Reader) serverEvent {
+	t.Helper()
+	var event serverEvent
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("failed to read event: %s", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return event
+		} else if name, ok := strings.CutPrefix(line, "event: "); ok {
+			event.name = name
+		} else if data, ok := strings.CutPrefix(line, "data: "); ok {
+			event.data = data
+		}
+	}
+}
+
+// startInstallEvents requests the install event stream, returning a reader of
+// its events.
+func startInstallEvents(t *testing.T, ctx context.Context, server *httptest.Server) *bufio.Reader {
+	t.Helper()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, server.URL+"/install/events", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("response %s with content type %q, want an event stream", resp.Status, resp.Header.Get("Content-Type"))
+	}
+	return bufio.NewReader(resp.Body)
+}
+
+// waitUntilIdle waits for the API server to finish its running operation.
+func waitUntilIdle(t *testing.T, s *apiServer) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if s.busy.TryLock() {
+			s.busy.Unlock()
+			return
+		}
+	}
+	t.Fatal("operation still running")
+}
+
+func TestInstallEvents(t *testing.T) {
+	received := make(chan struct{})
+	var s *apiServer
+	s, server := newTestAPIServer(t, func(ctx context.Context) (*types.InstallResult, error) {
+		s.progress.publish(types.ProgressEvent{Phase: PhaseDownload, Completed: 50, Total: 200, Percent: 25, File: "ollama-linux-amd64.tgz"})
+		// Pulls run alongside the install, and are left to /events.
+		s.progress.publish(types.ProgressEvent{Phase: PhasePull, File: "llama3"})
+		// The client can only receive the event before the install finishes
+		// if it was flushed.
+		select {
+		case <-received:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		s.progress.publish(types.ProgressEvent{Phase: PhaseExtract, Completed: 10, Done: true})
+		return &types.InstallResult{SchemaVersion: types.SchemaVersion, ExecutablePath: "/opt/ollama/bin/ollama", Fresh: true}, nil
+	})
+	reader := startInstallEvents(t, context.Background(), server)
+
+	event := readServerEvent(t, reader)
+	close(received)
+	var progress types.ProgressEvent
+	if err := json.Unmarshal([]byte(event.data), &progress); err != nil || event.name != "progress" {
+		t.Fatalf("first event %s %s (%v), want progress", event.name, event.data, err)
+	}
+	if progress.Phase != PhaseDownload || progress.Percent != 25 {
+		t.Errorf("first event %+v, want download at 25%%", progress)
+	}
+	if event = readServerEvent(t, reader); event.name != "progress" || !strings.Contains(event.data, `"phase":"extract"`) {
+		t.Errorf("second event %s %s, want extract progress", event.name, event.data)
+	}
+	event = readServerEvent(t, reader)
+	var result types.InstallResult
+	if err := json.Unmarshal([]byte(event.data), &result); err != nil || event.name != "result" {
+		t.Fatalf("last event %s %s (%v), want result", event.name, event.data, err)
+	}
+	if result.ExecutablePath != "/opt/ollama/bin/ollama" || !result.Fresh {
+		t.Errorf("result %+v, want the install result", result)
+	}
+	waitUntilIdle(t, s)
+}
+
+func TestInstallEventsError(t *testing.T) {
+	s, server := newTestAPIServer(t, func(ctx context.Context) (*types.InstallResult, error) {
+		return nil, errors.New("no space left")
+	})
+	reader := startInstallEvents(t, context.Background(), server)
+	if event := readServerEvent(t, reader); event.name != "error" || !strings.Contains(event.data, "no space left") {
+		t.Errorf("event %s %s, want the error", event.name, event.data)
+	}
+	waitUntilIdle(t, s)
+}
+
+func TestInstallEventsCancelledOnDisconnect(t *testing.T) {
+	cancelled := make(chan struct{})
+	var s *apiServer
+	s, server := newTestAPIServer(t, func(ctx context.Context) (*types.InstallResult, error) {
+		s.progress.publish(types.ProgressEvent{Phase: PhaseDownload, Completed: 1, Total: 2, Percent: 50})
+		<-ctx.Done()
+		close(cancelled)
+		return nil, ctx.Err()
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reader := startInstallEvents(t, ctx, server)
+	if event := readServerEvent(t, reader); event.name != "progress" {
+		t.Fatalf("event %s %s, want progress", event.name, event.data)
+	}
+
+	// A concurrent install is refused meanwhile.
+	req, err := http.NewRequest(http.MethodPost, server.URL+"/install", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusConflict {
+		t.Errorf("concurrent install got %s, want %d", resp.Status, http.StatusConflict)
+	}
+
+	cancel()
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("install not cancelled after the client disconnected")
+	}
+	waitUntilIdle(t, s)
+}
+
+func TestProgressBroadcasterDropsForSlowSubscribers(t *testing.T) {
+	b := &progressBroadcaster{subscribers: make(map[chan types.ProgressEvent]struct{})}
+	events, unsubscribe := b.subscribe()
+	published := make(chan struct{})
+	go func() {
+		for i := 0; i < 2*cap(events); i++ {
+			b.publish(types.ProgressEvent{Phase: PhaseDownload, Completed: int64(i)})
+		}
+		close(published)
+	}()
+	select {
+	case <-published:
+	case <-time.After(5 * time.Second):
+		t.Fatal("publishing blocked on a subscriber that is not reading")
+	}
+	if len(events) != cap(events) {
+		t.Errorf("subscriber has %d events, want %d", len(events), cap(events))
+	}
+	if event := <-events; event.Completed != 0 {
+		t.Errorf("first event %+v, want the first published; later ones are dropped", event)
+	}
+	unsubscribe()
+	b.publish(types.ProgressEvent{Phase: PhaseDownload})
+	if len(events) != cap(events)-1 {
+		t.Errorf("unsubscribed channel received an event")
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"debug/elf"
+	"debug/macho"
+	"debug/pe"
+	"errors"
+	"fmt"
+	"runtime"
+	"slices"
+	"strings"
+)
+
+// ErrWrongArchitecture is returned when the installed executable is not built
+// for this machine.
+var ErrWrongArchitecture = errors.New("executable architecture does not match host")
+
+// executableArchitectures returns the architectures (as GOARCH values) the
+// given ELF, Mach-O (possibly universal), or PE executable contains code for.
+// Architectures we do not know about are returned by their machine name.
+func executableArchitectures(path string) ([]string, error) {
+	if file, err := elf.Open(path); err == nil {
+		defer file.Close()
+		switch file.Machine {
+		case elf.EM_X86_64:
+			return []string{"amd64"}, nil
+		case elf.EM_AARCH64:
+			return []string{"arm64"}, nil
+		}
+		return []string{file.Machine.String()}, nil
+	}
+	machoArch := func(cpu macho.Cpu) string {
+		switch cpu {
+		case macho.CpuAmd64:
+			return "amd64"
+		case macho.CpuArm64:
+			return "arm64"
+		}
+		return cpu.String()
+	}
+	if file, err := macho.OpenFat(path); err == nil {
+		defer file.Close()
+		var arches []string
+		for _, arch := range file.Arches {
+			arches = append(arches, machoArch(arch.Cpu))
+		}
+		return arches, nil
+	}
+	if file, err := macho.Open(path); err == nil {
+		defer file.Close()
+		return []string{machoArch(file.Cpu)}, nil
+	}
+	if file, err := pe.Open(path); err == nil {
+		defer file.Close()
+		switch file.Machine {
+		case pe.IMAGE_FILE_MACHINE_AMD64:
+			return []string{"amd64"}, nil
+		case pe.IMAGE_FILE_MACHINE_ARM64:
+			return []string{"arm64"}, nil
+		}
+		return []string{fmt.Sprintf("pe machine %#x", file.Machine)}, nil
+	}
+	return nil, fmt.Errorf("failed to read %s: not a recognized executable format", path)
+}
+
+// checkExecutableArchitecture returns an error if the given executable cannot
+// run natively on this machine.  This catches installing the wrong asset at
+// install time, rather than with a confusing failure (or emulation) on first
+// run.
+func checkExecutableArchitecture(path string) error {
+	arches, err := executableArchitectures(path)
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(arches, runtime.GOARCH) {
+		return fmt.Errorf("installed binary is %s but host is %s: %w", strings.Join(arches, "/"), runtime.GOARCH, ErrWrongArchitecture)
+	}
+	return nil
+}
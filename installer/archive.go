@@ -0,0 +1,399 @@
+package main
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var errArchiveTooLarge = errors.New("archive exceeds extraction limits")
+
+// ErrInsufficientSpace is returned when the disk fills up while writing.
+var ErrInsufficientSpace = errors.New("insufficient disk space")
+
+// archiveBudget tracks how much more may be extracted from an archive, to guard
+// against decompression bombs filling the disk (or exhausting its inodes).
+type archiveBudget struct {
+	maxBytes         int64
+	maxEntries       int
+	maxPathLength    int
+	remainingBytes   int64
+	remainingEntries int
+}
+
+func newArchiveBudget() *archiveBudget {
+	return &archiveBudget{
+		maxBytes:         *maxArchiveSize,
+		maxEntries:       *maxArchiveEntries,
+		maxPathLength:    *maxArchivePathLength,
+		remainingBytes:   *maxArchiveSize,
+		remainingEntries: *maxArchiveEntries,
+	}
+}
+
+// addEntry accounts for a new entry in the archive, returning an error if there
+// are too many entries or its path is too long to be extracted.
+func (b *archiveBudget) addEntry(name string) error {
+	if b.maxPathLength > 0 && len(name) > b.maxPathLength {
+		return fmt.Errorf("error extracting %.64s...: path is %d bytes, longer than %d: %w", name, len(name), b.maxPathLength, errArchiveTooLarge)
+	}
+	b.remainingEntries--
+	if b.remainingEntries < 0 {
+		return fmt.Errorf("error extracting %s: more than %d entries: %w", name, b.maxEntries, errArchiveTooLarge)
+	}
+	return nil
+}
+
+// Policies for archive entries whose paths differ only in case, which would
+// overwrite one another on a case-insensitive filesystem, as set by
+// -case-collisions.
+const (
+	CaseCollisionError = "error" // Fail the install.
+	CaseCollisionSkip  = "skip"  // Keep the first entry, skipping the others.
+)
+
+var errCaseCollision = errors.New("archive entries differ only in case")
+
+// archiveName is a path extracted from an archive.
+type archiveName struct {
+	name  string
+	isDir bool
+}
+
+// archiveNames tracks the paths extracted from an archive, to catch entries
+// that would silently overwrite one another on a case-insensitive filesystem.
+// A nil *archiveNames, used for case-sensitive filesystems, accepts all paths.
+type archiveNames struct {
+	seen map[string]archiveName // By lower case path.
+}
+
+// newArchiveNames returns the tracker for extracting into installPath, which
+// must exist; it is nil if the filesystem there is case-sensitive.
+func newArchiveNames(installPath string) *archiveNames {
+	if !isCaseInsensitive(installPath) {
+		return nil
+	}
+	return &archiveNames{seen: make(map[string]archiveName)}
+}
+
+// isCaseInsensitive reports whether names in the given directory are matched
+// regardless of case, by creating a file and looking it up in upper case.  If
+// this cannot be determined, the directory is assumed to be case-insensitive,
+// as checking for collisions is then merely unnecessary.
+func isCaseInsensitive(dir string) bool {
+	file, err := os.CreateTemp(dir, ".case-check-")
+	if err != nil {
+		return true
+	}
+	name := file.Name()
+	file.Close()
+	defer os.Remove(name)
+	_, err = os.Stat(filepath.Join(dir, strings.ToUpper(filepath.Base(name))))
+	return err == nil
+}
+
+// claim records the path of a new entry, returning whether it should be
+// extracted.  An entry differing only in case from an earlier one is an error,
+// unless -case-collisions says to skip it; directories may differ in case, as
+// they are merged rather than overwritten.
+func (n *archiveNames) claim(name string, isDir bool) (bool, error) {
+	if n == nil {
+		return true, nil
+	}
+	cleaned := path.Clean(filepath.ToSlash(name))
+	key := strings.ToLower(cleaned)
+	previous, ok := n.seen[key]
+	if !ok {
+		n.seen[key] = archiveName{name: cleaned, isDir: isDir}
+		return true, nil
+	}
+	if previous.name == cleaned || (previous.isDir && isDir) {
+		return true, nil
+	}
+	if caseCollisions == CaseCollisionSkip {
+		log.Printf("Warning: skipping %s, which differs only in case from %s", name, previous.name)
+		return false, nil
+	}
+	return false, fmt.Errorf("error extracting %s: same path as %s on this case-insensitive filesystem: %w", name, previous.name, errCaseCollision)
+}
+
+// copy copies the contents of an entry, returning an error once the total
+// uncompressed size exceeds the limit.
+func (b *archiveBudget) copy(name string, w io.Writer, r io.Reader) (int64, error) {
+	n, err := io.Copy(w, io.LimitReader(r, b.remainingBytes+1))
+	b.remainingBytes -= n
+	if b.remainingBytes < 0 {
+		return n, fmt.Errorf("error extracting %s: more than %d bytes uncompressed: %w", name, b.maxBytes, errArchiveTooLarge)
+	}
+	return n, err
+}
+
+// writeArchiveEntry writes a regular file of the given size from an archive to
+// outPath, returning the SHA-256 digest of its contents.  On failure, the
+// partially written file is removed immediately, so that it does not use up
+// space on an already full disk; running out of space is reported as
+// ErrInsufficientSpace.
+func writeArchiveEntry(budget *archiveBudget, name, outPath string, mode os.FileMode, r io.Reader, size int64) ([]byte, error) {
+	file, err := os.OpenFile(outPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode)
+	if err != nil {
+		return nil, fmt.Errorf("error extracting %s: failed to create file: %w", name, err)
+	}
+	hasher := sha256.New()
+	n, err := budget.copy(name, io.MultiWriter(file, hasher), r)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && n < size {
+		err = fmt.Errorf("error extracting %s: extracted %d of %d bytes", name, n, size)
+	} else if isDiskFullError(err) {
+		err = fmt.Errorf("error extracting %s: wrote %d of %d bytes: %w: %w", name, n, size, ErrInsufficientSpace, err)
+	} else if err != nil && !errors.Is(err, errArchiveTooLarge) {
+		err = fmt.Errorf("error extracting %s: failed to copy: %w", name, err)
+	}
+	if err != nil {
+		_ = os.Remove(outPath)
+		return nil, err
+	}
+	return hasher.Sum(nil), nil
+}
+
+// maxModTimeSkew is how far in the future an archive entry's modification time
+// may be before it is clamped, allowing for small differences between clocks.
+const maxModTimeSkew = 5 * time.Minute
+
+// setModTime sets the modification time of an extracted file to the one
+// recorded in the archive.  Times in the future (beyond maxModTimeSkew) are
+// clamped to the current time, as they confuse backup and integrity tools;
+// past times are kept.
+func setModTime(name, outPath string, modTime time.Time) error {
+	if modTime.IsZero() {
+		return nil
+	}
+	now := time.Now()
+	if modTime.After(now.Add(maxModTimeSkew)) {
+		log.Printf("Clamping future modification time %s of %s to now", modTime.UTC().Format(time.RFC3339), name)
+		modTime = now
+	}
+	if err := os.Chtimes(outPath, modTime, modTime); err != nil {
+		return fmt.Errorf("error extracting %s: failed to set modification time: %w", name, err)
+	}
+	return nil
+}
+
+// verifyingReader hashes and counts everything read through it, so that an
+// archive read from an arbitrary source can be checked once fully consumed.
+type verifyingReader struct {
+	io.Reader
+	hasher   hash.Hash
+	read     int64
+	size     int64  // Expected size, or zero if unknown.
+	checksum string // Expected SHA-256 checksum (as a hex string), or empty if unknown.
+}
+
+func newVerifyingReader(r io.Reader, size int64, checksum string) *verifyingReader {
+	v := &verifyingReader{hasher: sha256.New(), size: size, checksum: strings.ToLower(checksum)}
+	v.Reader = io.TeeReader(r, v.hasher)
+	return v
+}
+
+func (v *verifyingReader) Read(p []byte) (int, error) {
+	n, err := v.Reader.Read(p)
+	v.read += int64(n)
+	return n, err
+}
+
+// verify consumes any remaining data (such as archive padding), then checks the
+// size and checksum, if they are known.  The size is only a minimum, as it may
+// have been under-reported; a larger archive is accepted if its checksum
+// matches.
+func (v *verifyingReader) verify() error {
+	if _, err := io.Copy(io.Discard, v); err != nil {
+		return fmt.Errorf("failed to read ollama archive: %w", err)
+	}
+	if v.size > 0 && v.read < v.size {
+		return fmt.Errorf("failed to read ollama archive: expected %d bytes, got %d", v.size, v.read)
+	} else if v.size > 0 && v.read > v.size {
+		log.Printf("Warning: ollama archive is larger than expected: got %d bytes, expected %d", v.read, v.size)
+	}
+	if actual := hex.EncodeToString(v.hasher.Sum(nil)); v.checksum != "" && actual != v.checksum {
+		return fmt.Errorf("failed to read ollama archive: expected sha256 %s, got %s: %w", v.checksum, actual, ErrChecksumMismatch)
+	}
+	return nil
+}
+
+// linkTarget returns the path a link in an archive refers to, relative to the
+// root of the archive.  Hard link names are relative to the root, while symbolic
+// link names are relative to the directory containing the link.
+func linkTarget(link *tar.Header) string {
+	if link.Typeflag == tar.TypeLink {
+		return path.Clean(link.Linkname)
+	}
+	return path.Join(path.Dir(link.Name), link.Linkname)
+}
+
+// checkLinkTarget returns an error unless the given link from an archive refers
+// to a path within the archive.  The raw link name is checked as well as the
+// path it resolves to, as an absolute (or volume-qualified) name would resolve
+// to a path that looks local once joined to the link's directory, but the link
+// itself would still point outside the install.
+func checkLinkTarget(link *tar.Header) error {
+	linkname := filepath.FromSlash(link.Linkname)
+	if link.Linkname == "" || path.IsAbs(link.Linkname) || filepath.IsAbs(linkname) ||
+		filepath.VolumeName(linkname) != "" || strings.HasPrefix(linkname, string(filepath.Separator)) {
+		return fmt.Errorf("error extracting %s: link to %s: %w", link.Name, link.Linkname, tar.ErrInsecurePath)
+	}
+	if link.Typeflag == tar.TypeLink && !filepath.IsLocal(linkname) {
+		return fmt.Errorf("error extracting %s: link to %s: %w", link.Name, link.Linkname, tar.ErrInsecurePath)
+	}
+	if !filepath.IsLocal(filepath.FromSlash(linkTarget(link))) {
+		return fmt.Errorf("error extracting %s: link to %s: %w", link.Name, link.Linkname, tar.ErrInsecurePath)
+	}
+	return nil
+}
+
+// checkLinkParents returns an error if any link is within a directory that is
+// itself a symbolic link in the archive: the target of such a link resolves
+// relative to wherever that directory points, so checkLinkTarget cannot vouch
+// for it.
+func checkLinkParents(links []tar.Header) error {
+	symlinks := make(map[string]bool, len(links))
+	for _, link := range links {
+		if link.Typeflag == tar.TypeSymlink {
+			symlinks[path.Clean(link.Name)] = true
+		}
+	}
+	for _, link := range links {
+		for dir := path.Dir(path.Clean(link.Name)); dir != "."; dir = path.Dir(dir) {
+			if symlinks[dir] {
+				return fmt.Errorf("error extracting %s: %s is a symbolic link: %w", link.Name, dir, tar.ErrInsecurePath)
+			}
+		}
+	}
+	return nil
+}
+
+// orderLinks groups links into levels, such that every link a link refers to is
+// in an earlier level; links within a level may be created in parallel.  Returns
+// an error if links form a cycle.
+func orderLinks(links []tar.Header) ([][]*tar.Header, error) {
+	byName := make(map[string]*tar.Header, len(links))
+	for i := range links {
+		byName[path.Clean(links[i].Name)] = &links[i]
+	}
+	depths := make(map[string]int, len(links))
+	visiting := make(map[string]bool)
+	var depthOf func(name string) (int, error)
+	depthOf = func(name string) (int, error) {
+		if depth, ok := depths[name]; ok {
+			return depth, nil
+		}
+		link := byName[name]
+		if visiting[name] {
+			return 0, fmt.Errorf("error extracting %s: links form a cycle", link.Name)
+		}
+		visiting[name] = true
+		depth := 0
+		if _, ok := byName[linkTarget(link)]; ok {
+			targetDepth, err := depthOf(linkTarget(link))
+			if err != nil {
+				return 0, err
+			}
+			depth = targetDepth + 1
+		}
+		visiting[name] = false
+		depths[name] = depth
+		return depth, nil
+	}
+
+	var levels [][]*tar.Header
+	for i := range links {
+		depth, err := depthOf(path.Clean(links[i].Name))
+		if err != nil {
+			return nil, err
+		}
+		for len(levels) <= depth {
+			levels = append(levels, nil)
+		}
+		levels[depth] = append(levels[depth], &links[i])
+	}
+	return levels, nil
+}
+
+// createHardLink and createSymlink create links; they may be replaced to
+// simulate filesystems that do not support links.
+var (
+	createHardLink = os.Link
+	createSymlink  = os.Symlink
+)
+
+// createLink creates a single (hard or symbolic) link from an archive.  If the
+// filesystem does not support the link, the file it refers to is copied instead
+// (unless -link-fallback is disabled), as ollama works just as well with copies.
+func createLink(installPath string, link *tar.Header) error {
+	newName := filepath.Join(installPath, filepath.FromSlash(link.Name))
+	target := filepath.Join(installPath, filepath.FromSlash(linkTarget(link)))
+	var err error
+	if link.Typeflag == tar.TypeLink {
+		err = createHardLink(target, newName)
+	} else {
+		err = createSymlink(link.Linkname, newName)
+	}
+	if err == nil {
+		return nil
+	}
+	if !*linkFallback || !isLinkUnsupportedError(err) {
+		return fmt.Errorf("error extracting %s: could not create link: %w", link.Name, err)
+	}
+	info, statErr := os.Stat(target)
+	if statErr != nil || !info.Mode().IsRegular() {
+		// Only links to files can be replaced by copies.
+		return fmt.Errorf("error extracting %s: could not create link: %w", link.Name, err)
+	}
+	log.Printf("Could not link %s, copying %s instead: %s", link.Name, linkTarget(link), err)
+	if err = copyFile(target, newName, info.Mode().Perm()); err != nil {
+		return fmt.Errorf("error extracting %s: could not copy link target: %w", link.Name, err)
+	}
+	return nil
+}
+
+// createLinks creates the (hard and symbolic) links from an archive, once all
+// regular files have been extracted into installPath.  Links are created in
+// dependency order, using up to -link-workers goroutines at a time.
+func createLinks(installPath string, links []tar.Header) error {
+	if err := checkLinkParents(links); err != nil {
+		return err
+	}
+	levels, err := orderLinks(links)
+	if err != nil {
+		return err
+	}
+	for _, level := range levels {
+		var wg sync.WaitGroup
+		sem := make(chan struct{}, max(*linkWorkers, 1))
+		errs := make([]error, len(level))
+		for i, link := range level {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(i int, link *tar.Header) {
+				defer func() { <-sem; wg.Done() }()
+				errs[i] = createLink(installPath, link)
+			}(i, link)
+		}
+		wg.Wait()
+		if err := errors.Join(errs...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,364 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testEntry is an entry of an archive built by buildTestArchive.
+type testEntry struct {
+	header tar.Header
+	body   string
+}
+
+// testFile returns an entry for a regular file with the given contents.
+func testFile(name, body string) testEntry {
+	return testEntry{header: tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0o644, Size: int64(len(body))}, body: body}
+}
+
+// testDir returns an entry for a directory.
+func testDir(name string) testEntry {
+	return testEntry{header: tar.Header{Typeflag: tar.TypeDir, Name: name, Mode: 0o755}}
+}
+
+// testLink returns an entry for a hard or symbolic link.
+func testLink(typeflag byte, name, linkname string) testEntry {
+	return testEntry{header: tar.Header{Typeflag: typeflag, Name: name, Linkname: linkname, Mode: 0o777}}
+}
+
+// buildTestTar returns an uncompressed tar archive of the given entries.
+func buildTestTar(t *testing.T, entries ...testEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	writer := tar.NewWriter(&buf)
+	for _, entry := range entries {
+		header := entry.header
+		if err := writer.WriteHeader(&header); err != nil {
+			t.Fatalf("failed to write %s: %s", header.Name, err)
+		}
+		if _, err := writer.Write([]byte(entry.body)); err != nil {
+			t.Fatalf("failed to write %s: %s", header.Name, err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// buildTestArchive returns a gzip-compressed tar archive of the given entries.
+func buildTestArchive(t *testing.T, entries ...testEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if _, err := writer.Write(buildTestTar(t, entries...)); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestCheckLinkTarget(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		typeflag byte
+		link     string
+		linkname string
+		insecure bool
+	}{
+		{"sibling symlink", tar.TypeSymlink, "lib/ollama/libfoo.so", "libfoo.so.1", false},
+		{"relative symlink", tar.TypeSymlink, "bin/ollama", "../lib/ollama/ollama", false},
+		{"absolute symlink", tar.TypeSymlink, "lib/ollama/passwd", "/etc/passwd", true},
+		{"escaping symlink", tar.TypeSymlink, "lib/ollama/passwd", "../../../etc/passwd", true},
+		{"empty symlink", tar.TypeSymlink, "lib/ollama/empty", "", true},
+		{"hard link", tar.TypeLink, "lib/ollama/libfoo.so", "lib/ollama/libfoo.so.1", false},
+		{"absolute hard link", tar.TypeLink, "lib/ollama/passwd", "/etc/passwd", true},
+		{"escaping hard link", tar.TypeLink, "lib/ollama/passwd", "lib/../../etc/passwd", true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkLinkTarget(&tar.Header{Typeflag: tt.typeflag, Name: tt.link, Linkname: tt.linkname})
+			if tt.insecure && !errors.Is(err, tar.ErrInsecurePath) {
+				t.Errorf("checkLinkTarget(%s -> %s) = %v, want %v", tt.link, tt.linkname, err, tar.ErrInsecurePath)
+			} else if !tt.insecure && err != nil {
+				t.Errorf("checkLinkTarget(%s -> %s) = %v, want no error", tt.link, tt.linkname, err)
+			}
+		})
+	}
+}
+
+func TestCheckLinkParents(t *testing.T) {
+	links := []tar.Header{
+		{Typeflag: tar.TypeSymlink, Name: "lib/current", Linkname: "."},
+		{Typeflag: tar.TypeSymlink, Name: "lib/current/escape", Linkname: "../outside"},
+	}
+	if err := checkLinkParents(links); !errors.Is(err, tar.ErrInsecurePath) {
+		t.Errorf("checkLinkParents() = %v, want %v", err, tar.ErrInsecurePath)
+	}
+	if err := checkLinkParents(links[:1]); err != nil {
+		t.Errorf("checkLinkParents() = %v, want no error", err)
+	}
+}
+
+// setForTest sets the given variable (such as a flag) for the duration of the
+// test.
+func setForTest[T any](t *testing.T, p *T, value T) {
+	t.Helper()
+	saved := *p
+	*p = value
+	t.Cleanup(func() { *p = saved })
+}
+
+func TestArchiveBudgetEntries(t *testing.T) {
+	setForTest(t, maxArchiveEntries, 2)
+	budget := newArchiveBudget()
+	for _, name := range []string{"bin/", "bin/ollama"} {
+		if err := budget.addEntry(name); err != nil {
+			t.Fatalf("addEntry(%s) = %v, want no error", name, err)
+		}
+	}
+	if err := budget.addEntry("lib/"); !errors.Is(err, errArchiveTooLarge) {
+		t.Errorf("addEntry() beyond the limit = %v, want %v", err, errArchiveTooLarge)
+	}
+}
+
+func TestArchiveBudgetPathLength(t *testing.T) {
+	setForTest(t, maxArchivePathLength, 10)
+	budget := newArchiveBudget()
+	if err := budget.addEntry("bin/ollama"); err != nil {
+		t.Errorf("addEntry() at the limit = %v, want no error", err)
+	}
+	if err := budget.addEntry("bin/ollama2"); !errors.Is(err, errArchiveTooLarge) {
+		t.Errorf("addEntry() beyond the limit = %v, want %v", err, errArchiveTooLarge)
+	}
+}
+
+func TestArchiveBudgetSize(t *testing.T) {
+	setForTest(t, maxArchiveSize, 10)
+	budget := newArchiveBudget()
+	var out bytes.Buffer
+	if n, err := budget.copy("a", &out, strings.NewReader("123456")); err != nil || n != 6 {
+		t.Fatalf("copy() = %d, %v; want 6 bytes", n, err)
+	}
+	if n, err := budget.copy("b", &out, strings.NewReader("7890")); err != nil || n != 4 {
+		t.Fatalf("copy() up to the limit = %d, %v; want 4 bytes", n, err)
+	}
+	if _, err := budget.copy("c", &out, strings.NewReader("x")); !errors.Is(err, errArchiveTooLarge) {
+		t.Errorf("copy() beyond the limit = %v, want %v", err, errArchiveTooLarge)
+	}
+}
+
+// withUnsupportedLinks makes creating links fail as on a filesystem without
+// support for them, for the duration of the test.
+func withUnsupportedLinks(t *testing.T) {
+	t.Helper()
+	unsupported := func(oldname, newname string) error {
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: errors.ErrUnsupported}
+	}
+	setForTest(t, &createHardLink, unsupported)
+	setForTest(t, &createSymlink, unsupported)
+}
+
+func TestCreateLinkCopyFallback(t *testing.T) {
+	withUnsupportedLinks(t)
+	setForTest(t, linkFallback, true)
+	installPath := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(installPath, "lib", "ollama"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(installPath, "lib", "ollama", "libfoo.so.1"), []byte("library"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	links := []tar.Header{
+		{Typeflag: tar.TypeSymlink, Name: "lib/ollama/libfoo.so", Linkname: "libfoo.so.1"},
+		{Typeflag: tar.TypeLink, Name: "lib/ollama/libfoo-hard.so", Linkname: "lib/ollama/libfoo.so.1"},
+	}
+	if err := createLinks(installPath, links); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"libfoo.so", "libfoo-hard.so"} {
+		linkPath := filepath.Join(installPath, "lib", "ollama", name)
+		info, err := os.Lstat(linkPath)
+		if err != nil {
+			t.Fatalf("%s was not created: %s", name, err)
+		}
+		if !info.Mode().IsRegular() {
+			t.Errorf("%s is %s, want a copy", name, info.Mode())
+		}
+		if got, err := os.ReadFile(linkPath); err != nil || string(got) != "library" {
+			t.Errorf("%s contains %q, %v; want %q", name, got, err, "library")
+		}
+	}
+}
+
+func TestCreateLinkWithoutFallback(t *testing.T) {
+	withUnsupportedLinks(t)
+	setForTest(t, linkFallback, false)
+	installPath := t.TempDir()
+	if err := os.WriteFile(filepath.Join(installPath, "libfoo.so.1"), []byte("library"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	link := tar.Header{Typeflag: tar.TypeSymlink, Name: "libfoo.so", Linkname: "libfoo.so.1"}
+	if err := createLink(installPath, &link); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("createLink() = %v, want %v", err, errors.ErrUnsupported)
+	}
+	if _, err := os.Lstat(filepath.Join(installPath, "libfoo.so")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("link was copied with the fallback disabled: %v", err)
+	}
+}
+
+func TestCreateLinkFallbackOnlyForFiles(t *testing.T) {
+	withUnsupportedLinks(t)
+	setForTest(t, linkFallback, true)
+	installPath := t.TempDir()
+	if err := os.Mkdir(filepath.Join(installPath, "lib"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	link := tar.Header{Typeflag: tar.TypeSymlink, Name: "current", Linkname: "lib"}
+	if err := createLink(installPath, &link); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("createLink() to a directory = %v, want %v", err, errors.ErrUnsupported)
+	}
+}
+
+func TestCreateLinkOtherErrors(t *testing.T) {
+	failed := errors.New("link failed")
+	setForTest(t, &createSymlink, func(oldname, newname string) error {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: failed}
+	})
+	setForTest(t, linkFallback, true)
+	installPath := t.TempDir()
+	if err := os.WriteFile(filepath.Join(installPath, "libfoo.so.1"), []byte("library"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	link := tar.Header{Typeflag: tar.TypeSymlink, Name: "libfoo.so", Linkname: "libfoo.so.1"}
+	if err := createLink(installPath, &link); !errors.Is(err, failed) {
+		t.Errorf("createLink() = %v, want %v", err, failed)
+	}
+}
+
+func TestIsCaseInsensitive(t *testing.T) {
+	dir := t.TempDir()
+	// Compare with what the filesystem actually does.
+	if err := os.WriteFile(filepath.Join(dir, "probe"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := os.Stat(filepath.Join(dir, "PROBE"))
+	if got, want := isCaseInsensitive(dir), err == nil; got != want {
+		t.Errorf("isCaseInsensitive(%s) = %v, want %v", dir, got, want)
+	}
+	// If it cannot be checked, collisions are checked for anyway.
+	if !isCaseInsensitive(filepath.Join(dir, "missing")) {
+		t.Error("isCaseInsensitive() of a missing directory = false, want true")
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil || len(entries) != 1 {
+		t.Errorf("isCaseInsensitive() left files behind: %v, %v", entries, err)
+	}
+}
+
+func TestArchiveNamesClaim(t *testing.T) {
+	archive := buildTestTar(t,
+		testDir("lib/"),
+		testFile("lib/libfoo.so", "lower"),
+		testDir("LIB/"),
+		testFile("lib/LibFoo.so", "mixed"),
+		testFile("lib/libfoo.so", "again"),
+		testFile("lib/other.so", "other"),
+	)
+	for _, tt := range []struct {
+		policy    string
+		extracted []string
+		wantErr   error
+	}{
+		{CaseCollisionSkip, []string{"lib/", "lib/libfoo.so", "LIB/", "lib/libfoo.so", "lib/other.so"}, nil},
+		{CaseCollisionError, []string{"lib/", "lib/libfoo.so", "LIB/"}, errCaseCollision},
+	} {
+		t.Run(tt.policy, func(t *testing.T) {
+			setForTest(t, &caseCollisions, tt.policy)
+			names := &archiveNames{seen: make(map[string]archiveName)}
+			reader := tar.NewReader(bytes.NewReader(archive))
+			var extracted []string
+			var err error
+			for {
+				header, nextErr := reader.Next()
+				if nextErr != nil {
+					break
+				}
+				var extract bool
+				if extract, err = names.claim(header.Name, header.Typeflag == tar.TypeDir); err != nil {
+					break
+				} else if extract {
+					extracted = append(extracted, header.Name)
+				}
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("claim() error = %v, want %v", err, tt.wantErr)
+			}
+			if strings.Join(extracted, ",") != strings.Join(tt.extracted, ",") {
+				t.Errorf("extracted %v, want %v", extracted, tt.extracted)
+			}
+		})
+	}
+}
+
+func TestArchiveNamesNil(t *testing.T) {
+	var names *archiveNames
+	for _, name := range []string{"bin/ollama", "bin/OLLAMA"} {
+		if extract, err := names.claim(name, false); !extract || err != nil {
+			t.Errorf("claim(%s) on a case-sensitive filesystem = %v, %v; want true", name, extract, err)
+		}
+	}
+}
+
+// sha256Hex returns the SHA-256 checksum of data, as a hex string.
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// errAny is a wanted error that matches any error.
+var errAny = errors.New("any error")
+
+func TestVerifyingReader(t *testing.T) {
+	body := []byte("an archive larger than its advertised size")
+	for _, tt := range []struct {
+		name     string
+		size     int64
+		checksum string
+		wantErr  error // nil for success, errAny for any other error.
+	}{
+		{"exact", int64(len(body)), sha256Hex(body), nil},
+		{"unknown size and checksum", 0, "", nil},
+		{"upper case checksum", int64(len(body)), strings.ToUpper(sha256Hex(body)), nil},
+		{"larger than advertised", int64(len(body)) / 2, sha256Hex(body), nil},
+		{"larger than advertised and mismatched", int64(len(body)) / 2, sha256Hex(body[:len(body)/2]), ErrChecksumMismatch},
+		{"mismatched", int64(len(body)), sha256Hex([]byte("something else")), ErrChecksumMismatch},
+		{"smaller than advertised", int64(len(body)) + 1, sha256Hex(body), errAny},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			verifier := newVerifyingReader(bytes.NewReader(body), tt.size, tt.checksum)
+			// Read only part of it, as an archive reader might; verify reads the rest.
+			if _, err := verifier.Read(make([]byte, 4)); err != nil {
+				t.Fatal(err)
+			}
+			err := verifier.verify()
+			switch {
+			case tt.wantErr == nil && err != nil:
+				t.Errorf("verify() = %v, want success", err)
+			case tt.wantErr == errAny && err == nil:
+				t.Error("verify() succeeded, want an error")
+			case tt.wantErr != nil && tt.wantErr != errAny && !errors.Is(err, tt.wantErr):
+				t.Errorf("verify() = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/rancher-sandbox/rd-open-webui-docker-ext/installer/types"
+)
+
+// How the installed executable was matched to the release.
+const (
+	VerifiedByFile    = "file"    // The release asset is the executable itself.
+	VerifiedByArchive = "archive" // The executable matches the copy in a verified archive.
+)
+
+// hashReader returns the SHA-256 checksum (as a hex string) of everything read
+// from r.
+func hashReader(r io.Reader) (string, error) {
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// archiveEntryChecksum returns the SHA-256 checksum of the regular file in the
+// given (zip or compressed tar) archive with the given base name, or the empty
+// string if there is none.
+func archiveEntryChecksum(archivePath, name string) (string, error) {
+	if strings.HasSuffix(archivePath, ".zip") {
+		reader, err := zip.OpenReader(archivePath)
+		if err != nil {
+			return "", err
+		}
+		defer reader.Close()
+		for _, file := range reader.File {
+			if path.Base(file.Name) != name || !file.Mode().IsRegular() {
+				continue
+			}
+			entry, err := file.Open()
+			if err != nil {
+				return "", err
+			}
+			defer entry.Close()
+			return hashReader(entry)
+		}
+		return "", nil
+	}
+	archive, err := os.Open(archivePath)
+	if err != nil {
+		return "", err
+	}
+	defer archive.Close()
+	decompressor, err := newDecompressor(archive)
+	if err != nil {
+		return "", err
+	}
+	defer decompressor.Close()
+	tarReader := tar.NewReader(decompressor)
+	for {
+		header, err := tarReader.Next()
+		if errors.Is(err, io.EOF) {
+			return "", nil
+		} else if err != nil {
+			return "", err
+		}
+		if header.Typeflag == tar.TypeReg && path.Base(header.Name) == name {
+			return hashReader(tarReader)
+		}
+	}
+}
+
+// installedReleaseTag returns the release the managed install came from: the
+// -release given, or else the one its cached archives were downloaded for, or
+// else the tag matching the version the executable reports.
+func installedReleaseTag(ctx context.Context, state *installerState, executablePath string) (string, error) {
+	if *releaseVersion != "latest" {
+		return *releaseVersion, nil
+	}
+	if cacheDir, err := getCacheDirectory(); err == nil {
+		for _, checksum := range state.InstalledArchives {
+			release, err := os.ReadFile(filepath.Join(cacheDir, checksum, cacheReleaseFile))
+			if tag := strings.TrimSpace(string(release)); err == nil && tag != "" && tag != "latest" {
+				return tag, nil
+			}
+		}
+	}
+	version, err := getExecutableVersion(ctx, executablePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to determine installed release: %w", err)
+	}
+	return "v" + strings.TrimPrefix(version, "v"), nil
+}
+
+// cachedAssetNames returns the names of the assets the current install was made
+// from, as long as they are all still cached, as the assets selected now may
+// differ, such as if a release has several for macOS.
+func cachedAssetNames(state *installerState) []string {
+	cacheDir, err := getCacheDirectory()
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, checksum := range state.InstalledArchives {
+		entries, err := os.ReadDir(filepath.Join(cacheDir, checksum))
+		if err != nil {
+			return nil
+		}
+		found := false
+		for _, entry := range entries {
+			if !entry.IsDir() && entry.Name() != cacheReleaseFile && entry.Name() != cacheValidatorsFile {
+				names = append(names, entry.Name())
+				found = true
+			}
+		}
+		if !found {
+			return nil
+		}
+	}
+	return names
+}
+
+// installedAssetPath returns the cached copy of the given asset of the current
+// install, downloading (and so verifying) it again if it is no longer cached.
+func installedAssetPath(ctx context.Context, state *installerState, release, assetName string) (string, error) {
+	if cacheDir, err := getCacheDirectory(); err == nil {
+		for _, checksum := range state.InstalledArchives {
+			cachedPath := filepath.Join(cacheDir, checksum, assetName)
+			if _, err := os.Stat(cachedPath); err == nil {
+				return cachedPath, nil
+			}
+		}
+	}
+	log.Printf("%s is no longer cached; downloading it to verify against", assetName)
+	return fetchAsset(ctx, release, assetName)
+}
+
+// verifyRelease checks that the managed install is authentic, by comparing it
+// against the checksums published with its release.  Releases only publish
+// checksums of whole assets, so where the asset is an archive, the archive is
+// verified, and then the installed executable is compared with its copy in the
+// archive.  Failing checks are reported in the result rather than as errors.
+func verifyRelease(ctx context.Context) (*types.ReleaseVerification, error) {
+	executablePath, err := findExecutable(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+	if executablePath == "" {
+		return nil, fmt.Errorf("ollama is not installed")
+	}
+	state, err := loadState(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result := &types.ReleaseVerification{SchemaVersion: types.SchemaVersion, ExecutablePath: executablePath}
+	if result.Release, err = installedReleaseTag(ctx, state, executablePath); err != nil {
+		return nil, err
+	}
+	if result.ExecutableChecksum, err = hashFile(executablePath); err != nil {
+		return nil, fmt.Errorf("failed to hash %s: %w", executablePath, err)
+	}
+	selection, err := selectAsset(ctx)
+	if err != nil {
+		return nil, err
+	}
+	assetNames := cachedAssetNames(state)
+	if len(assetNames) == 0 {
+		chooseReleaseAssets(ctx, result.Release, selection)
+		assetNames = selection.Assets
+	}
+
+	for _, assetName := range assetNames {
+		result.Assets = append(result.Assets, verifyReleaseAsset(ctx, state, result, assetName))
+	}
+	result.Passed = result.Method != ""
+	for _, asset := range result.Assets {
+		result.Passed = result.Passed && asset.Passed
+	}
+	if result.Method == "" {
+		log.Printf("Could not match %s to any asset of release %s", executablePath, result.Release)
+	}
+	return result, nil
+}
+
+// verifyReleaseAsset checks a single asset of the install against its published
+// checksum, setting the verification method of the result if the executable
+// was matched to it.
+func verifyReleaseAsset(ctx context.Context, state *installerState, result *types.ReleaseVerification, assetName string) types.AssetVerification {
+	asset := types.AssetVerification{Asset: assetName}
+	var err error
+	if asset.Expected, err = getAssetChecksum(ctx, result.Release, asset.Asset); err != nil {
+		asset.Message = err.Error()
+		return asset
+	}
+	if compressionForName(asset.Asset) == "" && !strings.HasSuffix(asset.Asset, ".zip") {
+		// The asset is the executable itself.
+		asset.Actual = result.ExecutableChecksum
+		asset.Passed = asset.Actual == asset.Expected
+		if asset.Passed {
+			result.Method = VerifiedByFile
+		} else {
+			asset.Message = fmt.Sprintf("%s does not match the published checksum", result.ExecutablePath)
+		}
+		return asset
+	}
+
+	if asset.Archive, err = installedAssetPath(ctx, state, result.Release, asset.Asset); err != nil {
+		asset.Message = err.Error()
+		return asset
+	}
+	if asset.Actual, err = hashFile(asset.Archive); err != nil {
+		asset.Message = fmt.Sprintf("failed to hash %s: %s", asset.Archive, err)
+		return asset
+	}
+	if asset.Actual != asset.Expected {
+		asset.Message = fmt.Sprintf("%s does not match the published checksum", asset.Archive)
+		return asset
+	}
+	entryChecksum, err := archiveEntryChecksum(asset.Archive, filepath.Base(result.ExecutablePath))
+	if err != nil {
+		asset.Message = fmt.Sprintf("failed to read %s: %s", asset.Archive, err)
+		return asset
+	}
+	switch entryChecksum {
+	case "":
+		// Accelerator archives do not contain the executable.
+	case result.ExecutableChecksum:
+		result.Method = VerifiedByArchive
+	default:
+		asset.Message = fmt.Sprintf("%s does not match its copy in %s", result.ExecutablePath, asset.Asset)
+		return asset
+	}
+	asset.Passed = true
+	return asset
+}
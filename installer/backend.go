@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"sync"
+
+	"github.com/rancher-sandbox/rd-open-webui-docker-ext/installer/types"
+)
+
+// Backends serving the ollama API, as recorded in the persisted state.
+const (
+	BackendAuto     = ""         // Not chosen; use an external server if -reuse-app allows.
+	BackendManaged  = "managed"  // The ollama serve process managed by the installer.
+	BackendExternal = "external" // An externally managed ollama, such as Ollama.app.
+)
+
+// Policies for an external ollama install (such as Homebrew's) whose version is
+// outside the supported range, as set by -incompatible-external.
+const (
+	ExternalPolicyWarn    = "warn"    // Use it anyway, logging a warning.
+	ExternalPolicyManaged = "managed" // Install a managed copy alongside it, and use that instead.
+)
+
+// externalAccepted caches the decisions of acceptExternalExecutable by path, as
+// each requires running the executable, and is logged.
+var (
+	externalAcceptedMu sync.Mutex
+	externalAccepted   = map[string]bool{}
+)
+
+// acceptExternalExecutable reports whether the given external ollama executable
+// may be used rather than a managed install.  One whose version is supported,
+// or cannot be determined, is always used; otherwise -incompatible-external
+// decides.
+func acceptExternalExecutable(ctx context.Context, executablePath string) bool {
+	externalAcceptedMu.Lock()
+	defer externalAcceptedMu.Unlock()
+	if accepted, ok := externalAccepted[executablePath]; ok {
+		return accepted
+	}
+	accepted := true
+	if version, err := getExecutableVersion(ctx, executablePath); err != nil {
+		log.Printf("Could not determine version of external ollama %s: %s", executablePath, err)
+	} else if compatibility, message := checkVersionCompatibility(version); compatibility == CompatibilityOlder || compatibility == CompatibilityNewer {
+		if externalPolicy == ExternalPolicyManaged {
+			log.Printf("Not using external ollama %s: %s", executablePath, message)
+			accepted = false
+		} else {
+			log.Printf("Warning: using external ollama %s anyway: %s", executablePath, message)
+		}
+	}
+	externalAccepted[executablePath] = accepted
+	return accepted
+}
+
+// useExternalServer returns the path of the running external ollama to use
+// instead of the managed one, or the empty string to use the managed one.  A
+// backend recorded by the switch mode takes precedence over -reuse-app.
+func useExternalServer(ctx context.Context) (string, error) {
+	state, err := loadState(ctx)
+	if err != nil {
+		return "", err
+	}
+	switch state.Backend {
+	case BackendManaged:
+		return "", nil
+	case BackendAuto:
+		if !*reuseApp {
+			return "", nil
+		}
+	}
+	return findExternalServer(ctx)
+}
+
+// currentBackend returns the backend in use, given the path of any running
+// external ollama.
+func currentBackend(ctx context.Context, externalPath string) (string, error) {
+	state, err := loadState(ctx)
+	if err != nil {
+		return "", err
+	}
+	if state.Backend != BackendAuto {
+		return state.Backend, nil
+	}
+	if *reuseApp && externalPath != "" {
+		return BackendExternal, nil
+	}
+	return BackendManaged, nil
+}
+
+// resolveBackendEndpoint returns which backend serves the ollama API, and where,
+// so that the UI need not repeat the precedence of the switch mode, -reuse-app
+// and detection.  This is where the proxy forwards requests to.  The address is
+// always the default one: the installer, the proxy and its API clients only
+// ever use it, so custom (OLLAMA_HOST) and remote endpoints are not supported.
+// Which process answers there is reported, with a warning if it is not the
+// chosen backend, such as an external ollama listening elsewhere.
+func resolveBackendEndpoint(ctx context.Context) (*types.BackendEndpoint, error) {
+	externalPath, err := useExternalServer(ctx)
+	if err != nil {
+		return nil, err
+	}
+	target, err := url.Parse(ollamaURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse ollama URL: %w", err)
+	}
+	result := &types.BackendEndpoint{
+		SchemaVersion:  types.SchemaVersion,
+		Backend:        BackendManaged,
+		URL:            target.String(),
+		Host:           target.Host,
+		TLS:            target.Scheme == "https",
+		ExternalServer: externalPath,
+	}
+	if externalPath != "" {
+		result.Backend = BackendExternal
+	}
+	owner, listenerPID, _, err := checkPortOwner(ctx)
+	if err != nil {
+		log.Printf("Failed to determine which process is answering at %s: %s", result.Host, err)
+		owner = PortOwnerUnknown
+	}
+	result.PortOwner = owner
+	result.Warning = endpointWarning(result.Backend, result.Host, owner, listenerPID, externalPath)
+	if result.Warning != "" {
+		log.Printf("Warning: %s", result.Warning)
+	}
+	return result, nil
+}
+
+// endpointWarning explains why the given backend may not be reached at the
+// ollama API address, given which process answers there (one of the PortOwner
+// constants), or returns the empty string.
+func endpointWarning(backend, host, owner string, listenerPID int, externalPath string) string {
+	switch {
+	case backend == BackendExternal && (owner == PortOwnerNone || owner == PortOwnerManaged):
+		return fmt.Sprintf("the external ollama %s is not answering at %s, the only address used; it may be listening elsewhere (such as set by OLLAMA_HOST), which is not supported", externalPath, host)
+	case backend == BackendManaged && owner == PortOwnerExternal:
+		return fmt.Sprintf("another process (pid %d) is answering at %s instead of the managed ollama", listenerPID, host)
+	case owner == PortOwnerNone:
+		return fmt.Sprintf("nothing is answering at %s; ollama is not running", host)
+	}
+	return ""
+}
+
+// switchBackend re-runs detection of an external ollama and switches to the
+// given backend (or, if empty, to an external ollama if one is running, and
+// the managed one otherwise), recording the choice so that later runs use it.
+// Switching to an external ollama stops the managed serve; switching back
+// starts it again.
+func switchBackend(ctx context.Context, backend string) (*types.SwitchResult, error) {
+	externalPath, err := findExternalServer(ctx)
+	if err != nil {
+		return nil, err
+	}
+	previous, err := currentBackend(ctx, externalPath)
+	if err != nil {
+		return nil, err
+	}
+	if backend == BackendAuto {
+		backend = BackendManaged
+		if externalPath != "" {
+			backend = BackendExternal
+		}
+	}
+	result := &types.SwitchResult{SchemaVersion: types.SchemaVersion, Backend: backend, Previous: previous}
+
+	switch backend {
+	case BackendExternal:
+		if externalPath == "" {
+			return nil, fmt.Errorf("failed to switch to external ollama: none is running")
+		}
+		result.ExternalServer = externalPath
+		if err = stopSupervisor(ctx); err != nil {
+			log.Printf("Failed to stop supervisor: %s", err)
+		}
+		executablePath, err := findExecutable(ctx, true)
+		if err != nil {
+			return nil, err
+		}
+		if executablePath != "" {
+			if _, err = terminateProcess(ctx, executablePath); err != nil {
+				return nil, fmt.Errorf("failed to stop managed ollama: %w", err)
+			}
+		}
+	case BackendManaged:
+		if externalPath != "" {
+			// Both would listen on the same port.
+			return nil, fmt.Errorf("failed to switch to managed ollama: quit the external ollama at %s first", externalPath)
+		}
+	default:
+		return nil, fmt.Errorf("unexpected backend %q: should be %q or %q", backend, BackendManaged, BackendExternal)
+	}
+
+	err = updateState(ctx, func(state *installerState) error {
+		state.Backend = backend
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to record backend: %w", err)
+	}
+	log.Printf("Switched from %s to %s ollama", previous, backend)
+
+	if backend == BackendExternal {
+		if err = waitForServer(ctx); err != nil {
+			return nil, err
+		}
+		return result, nil
+	}
+	isRunning, err := checkExistingInstance(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !isRunning {
+		if err = startServe(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEndpointWarning(t *testing.T) {
+	const host = "localhost:11434"
+	for _, tt := range []struct {
+		name    string
+		backend string
+		owner   string
+		want    string // A substring of the warning, or empty for none.
+	}{
+		{"managed answering", BackendManaged, PortOwnerManaged, ""},
+		{"external answering", BackendExternal, PortOwnerExternal, ""},
+		{"unknown listener", BackendManaged, PortOwnerUnknown, ""},
+		{"external elsewhere", BackendExternal, PortOwnerNone, "OLLAMA_HOST"},
+		{"external shadowed", BackendExternal, PortOwnerManaged, "OLLAMA_HOST"},
+		{"managed shadowed", BackendManaged, PortOwnerExternal, "pid 42"},
+		{"not running", BackendManaged, PortOwnerNone, "not running"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got := endpointWarning(tt.backend, host, tt.owner, 42, "/Applications/Ollama.app/Contents/Resources/ollama")
+			if (tt.want == "") != (got == "") || !strings.Contains(got, tt.want) {
+				t.Errorf("endpointWarning() = %q, want one containing %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/rancher-sandbox/rd-open-webui-docker-ext/installer/types"
+)
+
+// benchPrompt is the prompt used to measure time to first token.
+const benchPrompt = "Write a short paragraph about the ocean."
+
+// unloadModel asks the ollama server to unload the given model from memory, so
+// that the next request loads it from scratch.
+func unloadModel(ctx context.Context, model string) error {
+	// An empty prompt with a zero keep-alive unloads the model immediately.
+	_, err := generate(ctx, generateRequest{Model: model, KeepAlive: "0"})
+	if err != nil {
+		return fmt.Errorf("failed to unload %s: %w", model, err)
+	}
+	return nil
+}
+
+// timeToFirstToken measures how long a streamed generation takes to produce
+// its first token.  The rest of the response is discarded.
+func timeToFirstToken(ctx context.Context, model, prompt string) (time.Duration, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	// Stop the generation once the first token arrives.
+	defer cancel()
+	body, err := json.Marshal(generateRequest{Model: model, Prompt: prompt, Stream: true})
+	if err != nil {
+		return 0, fmt.Errorf("failed to generate with %s: %w", model, err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ollamaURL+"/api/generate", bytes.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("failed to generate with %s: %w", model, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	start := time.Now()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to generate with %s: %w", model, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return 0, fmt.Errorf("failed to generate with %s: unexpected status %s", model, resp.Status)
+	}
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		var chunk generateResponse
+		if err = json.Unmarshal(scanner.Bytes(), &chunk); err != nil {
+			return 0, fmt.Errorf("failed to generate with %s: error unmarshaling response: %w", model, err)
+		}
+		if chunk.Error != "" {
+			return 0, fmt.Errorf("failed to generate with %s: %s", model, chunk.Error)
+		}
+		if chunk.Response != "" || chunk.Done {
+			return time.Since(start), nil
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return 0, fmt.Errorf("failed to generate with %s: reading response: %w", model, err)
+	}
+	return 0, fmt.Errorf("failed to generate with %s: no tokens generated", model)
+}
+
+// summarizeDurations returns the minimum, median and maximum of the given
+// (non-empty) durations, in seconds.
+func summarizeDurations(durations []time.Duration) types.BenchStats {
+	sorted := slices.Clone(durations)
+	slices.Sort(sorted)
+	median := sorted[len(sorted)/2]
+	if len(sorted)%2 == 0 {
+		median = (sorted[len(sorted)/2-1] + median) / 2
+	}
+	return types.BenchStats{
+		Min:    sorted[0].Seconds(),
+		Median: median.Seconds(),
+		Max:    sorted[len(sorted)-1].Seconds(),
+	}
+}
+
+// benchmarkModel measures how long the given model takes to load from cold,
+// and then to produce its first token, over the given number of runs.  The
+// model is unloaded before each run and afterwards, so the server is left as
+// it was found (apart from the model no longer being loaded).
+func benchmarkModel(ctx context.Context, model string, runs int) (*types.BenchResult, error) {
+	if model == "" {
+		return nil, fmt.Errorf("no model given to benchmark")
+	}
+	if runs < 1 {
+		return nil, fmt.Errorf("invalid number of benchmark runs %d", runs)
+	}
+	model = normalizeModelName(model)
+	result := &types.BenchResult{SchemaVersion: types.SchemaVersion, Model: model, Runs: runs, Accelerator: detectAccelerator()}
+	defer func() {
+		// Use a fresh context, so that we clean up even if interrupted.
+		cleanupCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := unloadModel(cleanupCtx, model); err != nil {
+			log.Printf("Failed to clean up: %s", err)
+		}
+	}()
+
+	var loads, firstTokens []time.Duration
+	for run := 1; run <= runs; run++ {
+		if err := unloadModel(ctx, model); err != nil {
+			return nil, err
+		}
+		start := time.Now()
+		// An empty prompt only loads the model.
+		if _, err := generate(ctx, generateRequest{Model: model}); err != nil {
+			return nil, err
+		}
+		load := time.Since(start)
+		firstToken, err := timeToFirstToken(ctx, model, benchPrompt)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Run %d of %d: load %s, first token %s", run, runs, load, firstToken)
+		loads = append(loads, load)
+		firstTokens = append(firstTokens, firstToken)
+	}
+	result.LoadSeconds = summarizeDurations(loads)
+	result.FirstTokenSeconds = summarizeDurations(firstTokens)
+	return result, nil
+}
+
+// Print the results of benchmarking the given model as JSON.
+func printBenchmark(ctx context.Context, model string, runs int) error {
+	result, err := benchmarkModel(ctx, model, runs)
+	if err != nil {
+		return err
+	}
+	return printJSON(result)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// bundleReleaseFile names the file in the bundle directory holding the tag of
+// the bundled release.
+const bundleReleaseFile = "release"
+
+// getBundleDirectory returns the directory release assets may be bundled in
+// when building the extension image, so that the first install needs no
+// network access.  The directory holds the assets themselves, the release tag
+// in bundleReleaseFile, and their checksums in checksumAssetName (as published
+// with the release).
+func getBundleDirectory() (string, error) {
+	if *bundleDir != "" {
+		return filepath.Abs(*bundleDir)
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("failed to find executable path: %w", err)
+	}
+	extensionDir := filepath.Dir(filepath.Dir(executable))
+	return filepath.Join(extensionDir, "bundle"), nil
+}
+
+// findBundledAsset returns the path of the given asset in the bundle directory,
+// its tag and its expected checksum, if it is bundled for the given release
+// ("latest" accepts whichever release is bundled).  Returns an empty path if
+// the asset is not bundled, or cannot be verified.
+func findBundledAsset(release, assetName string) (string, string, string) {
+	dir, err := getBundleDirectory()
+	if err != nil {
+		return "", "", ""
+	}
+	contents, err := os.ReadFile(filepath.Join(dir, bundleReleaseFile))
+	if err != nil {
+		// Nothing is bundled.
+		return "", "", ""
+	}
+	tag := strings.TrimSpace(string(contents))
+	if release != "latest" && strings.TrimPrefix(release, "v") != strings.TrimPrefix(tag, "v") {
+		log.Printf("Not using bundled %s, which is of release %s rather than %s", assetName, tag, release)
+		return "", "", ""
+	}
+	bundledPath := filepath.Join(dir, assetName)
+	if _, err = os.Stat(bundledPath); err != nil {
+		return "", "", ""
+	}
+	checksums, err := os.Open(filepath.Join(dir, checksumAssetName))
+	if err != nil {
+		log.Printf("Warning: not using bundled %s, which has no checksums: %s", assetName, err)
+		return "", "", ""
+	}
+	defer checksums.Close()
+	checksum, err := findChecksum(checksums, assetName)
+	if err != nil || checksum == "" {
+		log.Printf("Warning: not using bundled %s, which has no checksum", assetName)
+		return "", "", ""
+	}
+	return bundledPath, tag, checksum
+}
+
+// cacheBundledAsset copies the given bundled asset into the download cache
+// (unless it is already there), verifying its checksum, and returns the path of
+// the cached copy.  The bundle directory is part of the image and so read-only,
+// and installs are made from the cache.
+func cacheBundledAsset(ctx context.Context, bundledPath, release, expected string) (string, error) {
+	cacheDir, err := getCacheDirectory()
+	if err != nil {
+		return "", err
+	}
+	if err = os.MkdirAll(cacheDir, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	unlock, err := acquireLock(ctx, filepath.Join(cacheDir, expected+".lock"))
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+	assetName := filepath.Base(bundledPath)
+	cachedPath := filepath.Join(cacheDir, expected, assetName)
+	if actual, err := hashFile(cachedPath); err == nil && actual == expected {
+		log.Printf("Using cached %s", cachedPath)
+		markCacheUsed(cachedPath, release)
+		return cachedPath, nil
+	}
+
+	log.Printf("Using bundled %s of release %s", bundledPath, release)
+	source, err := os.Open(bundledPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open bundled %s: %w", assetName, err)
+	}
+	defer source.Close()
+	if err = os.MkdirAll(filepath.Dir(cachedPath), 0o755); err != nil {
+		return "", fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	file, err := os.CreateTemp(filepath.Dir(cachedPath), assetName+".*.tmp")
+	if err != nil {
+		return "", fmt.Errorf("failed to copy bundled %s: %w", assetName, err)
+	}
+	defer func() {
+		file.Close()
+		_ = os.Remove(file.Name())
+	}()
+	hasher := sha256.New()
+	if _, err = io.Copy(io.MultiWriter(file, hasher), source); err != nil {
+		return "", fmt.Errorf("failed to copy bundled %s: %w", assetName, err)
+	}
+	if err = file.Close(); err != nil {
+		return "", fmt.Errorf("failed to copy bundled %s: %w", assetName, err)
+	}
+	if actual := hex.EncodeToString(hasher.Sum(nil)); actual != expected {
+		return "", fmt.Errorf("bundled %s has sha256 %s, expected %s: %w", assetName, actual, expected, ErrChecksumMismatch)
+	}
+	if err = os.Rename(file.Name(), cachedPath); err != nil {
+		return "", fmt.Errorf("failed to move bundled %s into cache: %w", assetName, err)
+	}
+	markCacheUsed(cachedPath, release)
+	return cachedPath, nil
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/rancher-sandbox/rd-open-webui-docker-ext/installer/types"
+)
+
+// cacheReleaseFile is the name of the file, next to a cached archive, that
+// records the release the archive was downloaded for.
+const cacheReleaseFile = "release"
+
+// cacheValidatorsFile is the name of the file, next to a cached archive, that
+// records the HTTP validators it was downloaded with, so that later downloads
+// of the same URL can be skipped if it has not changed.
+const cacheValidatorsFile = "validators.json"
+
+// cacheValidators are the HTTP validators of a cached archive's download.
+type cacheValidators struct {
+	URL          string `json:"url"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+}
+
+// saveCacheValidators records the validators of the given cached archive.
+// Failures are only logged, as they only cause the archive to be downloaded
+// again.
+func saveCacheValidators(cachedPath string, validators *cacheValidators) {
+	if validators.ETag == "" && validators.LastModified == "" {
+		return
+	}
+	contents, err := json.Marshal(validators)
+	if err == nil {
+		err = os.WriteFile(filepath.Join(filepath.Dir(cachedPath), cacheValidatorsFile), contents, 0o644)
+	}
+	if err != nil {
+		log.Printf("Failed to record validators of %s: %s", cachedPath, err)
+	}
+}
+
+// findCachedByURL returns the path and validators of the most recently used
+// cached archive downloaded from the given URL, if its contents are still
+// intact; otherwise it returns nil validators.
+func findCachedByURL(cacheDir, assetURL, assetName string) (string, *cacheValidators) {
+	dirEntries, err := os.ReadDir(cacheDir)
+	if err != nil {
+		return "", nil
+	}
+	var bestPath string
+	var best *cacheValidators
+	var bestUsed time.Time
+	for _, dirEntry := range dirEntries {
+		if !dirEntry.IsDir() {
+			continue
+		}
+		contents, err := os.ReadFile(filepath.Join(cacheDir, dirEntry.Name(), cacheValidatorsFile))
+		if err != nil {
+			continue
+		}
+		var validators cacheValidators
+		if err = json.Unmarshal(contents, &validators); err != nil || validators.URL != assetURL {
+			continue
+		}
+		cachedPath := filepath.Join(cacheDir, dirEntry.Name(), assetName)
+		info, err := os.Stat(cachedPath)
+		if err != nil || (best != nil && !info.ModTime().After(bestUsed)) {
+			continue
+		}
+		bestPath, best, bestUsed = cachedPath, &validators, info.ModTime()
+	}
+	if best == nil {
+		return "", nil
+	}
+	// Archives are cached by checksum; make sure it has not been corrupted.
+	if actual, err := hashFile(bestPath); err != nil || actual != filepath.Base(filepath.Dir(bestPath)) {
+		return "", nil
+	}
+	return bestPath, best
+}
+
+// markCacheUsed records that the given cached archive was just used for the
+// given release.  Failures are only logged, as they do not affect the install.
+func markCacheUsed(cachedPath, release string) {
+	now := time.Now()
+	if err := os.Chtimes(cachedPath, now, now); err != nil {
+		log.Printf("Failed to update last used time of %s: %s", cachedPath, err)
+	}
+	releasePath := filepath.Join(filepath.Dir(cachedPath), cacheReleaseFile)
+	if err := os.WriteFile(releasePath, []byte(release), 0o644); err != nil {
+		log.Printf("Failed to record release of %s: %s", cachedPath, err)
+	}
+}
+
+// listCache returns the archives in the download cache, most recently used
+// first.
+func listCache(ctx context.Context) (*types.CacheList, error) {
+	cacheDir, err := getCacheDirectory()
+	if err != nil {
+		return nil, err
+	}
+	state, err := loadState(ctx)
+	if err != nil {
+		return nil, err
+	}
+	list := &types.CacheList{SchemaVersion: types.SchemaVersion, Directory: cacheDir, Entries: []types.CacheEntry{}}
+
+	dirEntries, err := os.ReadDir(cacheDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return list, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to list cache: %w", err)
+	}
+	for _, dirEntry := range dirEntries {
+		// Each archive is in a directory named after its checksum; anything
+		// else is a lock or an in-progress download.
+		if !dirEntry.IsDir() {
+			continue
+		}
+		checksum := dirEntry.Name()
+		release, err := os.ReadFile(filepath.Join(cacheDir, checksum, cacheReleaseFile))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("failed to list cache: %w", err)
+		}
+		files, err := os.ReadDir(filepath.Join(cacheDir, checksum))
+		if err != nil {
+			return nil, fmt.Errorf("failed to list cache: %w", err)
+		}
+		for _, file := range files {
+			if file.IsDir() || file.Name() == cacheReleaseFile || file.Name() == cacheValidatorsFile {
+				continue
+			}
+			info, err := file.Info()
+			if err != nil {
+				return nil, fmt.Errorf("failed to list cache: %w", err)
+			}
+			list.Entries = append(list.Entries, types.CacheEntry{
+				Asset:     file.Name(),
+				Release:   strings.TrimSpace(string(release)),
+				Checksum:  checksum,
+				Size:      info.Size(),
+				LastUsed:  info.ModTime(),
+				Installed: slices.Contains(state.InstalledArchives, checksum),
+			})
+		}
+	}
+	slices.SortFunc(list.Entries, func(a, b types.CacheEntry) int {
+		return b.LastUsed.Compare(a.LastUsed)
+	})
+	return list, nil
+}
+
+// printCache prints the contents of the download cache as JSON.
+func printCache(ctx context.Context) error {
+	list, err := listCache(ctx)
+	if err != nil {
+		return err
+	}
+	return printJSON(list)
+}
+
+// clearCache removes archives from the download cache, optionally keeping
+// those of the current install.  Archives being downloaded by another process
+// are removed once that process has finished with them.
+func clearCache(ctx context.Context, keepInstalled bool) (*types.CacheClearResult, error) {
+	list, err := listCache(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result := &types.CacheClearResult{SchemaVersion: types.SchemaVersion, Removed: []types.CacheEntry{}}
+	for _, entry := range list.Entries {
+		if keepInstalled && entry.Installed {
+			log.Printf("Keeping %s (%s), which is installed", entry.Asset, entry.Release)
+			continue
+		}
+		unlock, err := acquireLock(ctx, filepath.Join(list.Directory, entry.Checksum+".lock"))
+		if err != nil {
+			return nil, err
+		}
+		err = os.RemoveAll(filepath.Join(list.Directory, entry.Checksum))
+		unlock()
+		if err != nil {
+			return nil, fmt.Errorf("failed to remove cached %s: %w", entry.Asset, err)
+		}
+		result.Removed = append(result.Removed, entry)
+		result.FreedBytes += entry.Size
+	}
+	return result, nil
+}
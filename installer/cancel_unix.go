@@ -0,0 +1,15 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// cancelProcess asks another installer process to abort its operation; it will
+// exit once it has cleaned up.
+func cancelProcess(proc *os.Process) error {
+	return proc.Signal(unix.SIGTERM)
+}
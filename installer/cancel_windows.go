@@ -0,0 +1,11 @@
+package main
+
+import "os"
+
+// cancelProcess aborts another installer process.  Windows has no equivalent of
+// SIGTERM for console processes we don't share a console with, so the process is
+// terminated outright; any in-progress pull is aborted by ollama when the
+// connection is dropped.
+func cancelProcess(proc *os.Process) error {
+	return proc.Kill()
+}
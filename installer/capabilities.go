@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/rancher-sandbox/rd-open-webui-docker-ext/installer/types"
+)
+
+// apiCapability is a feature of the ollama API the UI may depend on, and the
+// endpoint that provides it.
+type apiCapability struct {
+	name       string
+	method     string
+	path       string
+	minVersion []int // First version providing it; nil if all supported ones do.
+}
+
+// apiCapabilities are the features reported by the capabilities mode.
+var apiCapabilities = []apiCapability{
+	{name: "generate", method: http.MethodPost, path: "/api/generate"},
+	{name: "chat", method: http.MethodPost, path: "/api/chat"},
+	{name: "embeddings", method: http.MethodPost, path: "/api/embeddings"},
+	{name: "embed", method: http.MethodPost, path: "/api/embed", minVersion: []int{0, 3, 4}},
+	{name: "loaded-models", method: http.MethodGet, path: "/api/ps"},
+	{name: "openai-chat", method: http.MethodPost, path: "/v1/chat/completions"},
+	{name: "openai-embeddings", method: http.MethodPost, path: "/v1/embeddings", minVersion: []int{0, 3, 0}},
+	{name: "structured-create", method: http.MethodPost, path: "/api/create", minVersion: structuredCreateVersion},
+}
+
+// probeEndpoint checks whether the running server has the given endpoint,
+// without it doing any work: requests that would are sent an empty body, which
+// an existing endpoint rejects as invalid rather than as not found.
+func probeEndpoint(ctx context.Context, method, path string) (bool, error) {
+	var body io.Reader = http.NoBody
+	if method == http.MethodPost {
+		body = strings.NewReader("{}")
+	}
+	req, err := http.NewRequestWithContext(ctx, method, ollamaURL+path, body)
+	if err != nil {
+		return false, fmt.Errorf("failed to probe %s: %w", path, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("failed to probe %s: %w", path, err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode != http.StatusNotFound && resp.StatusCode != http.StatusMethodNotAllowed, nil
+}
+
+// getCapabilities reports which features of the ollama API the running server
+// supports, so that the UI can hide those it does not.  Each endpoint is
+// probed; where a version introduced a feature to an existing endpoint, the
+// server's version decides instead.
+func getCapabilities(ctx context.Context) (*types.Capabilities, error) {
+	version, err := getRunningVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+	parsedVersion, versionErr := parseVersion(version)
+	if versionErr != nil {
+		log.Printf("Could not parse ollama version: %s", versionErr)
+	}
+	result := &types.Capabilities{
+		SchemaVersion: types.SchemaVersion,
+		Version:       version,
+		Available:     make(map[string]bool),
+		Capabilities:  []types.Capability{},
+	}
+	// Endpoints shared by several capabilities are only probed once.
+	probed := make(map[string]bool)
+	for _, capability := range apiCapabilities {
+		entry := types.Capability{Name: capability.name, Endpoint: capability.method + " " + capability.path}
+		available, ok := probed[entry.Endpoint]
+		if !ok {
+			if available, err = probeEndpoint(ctx, capability.method, capability.path); err != nil {
+				return nil, err
+			}
+			probed[entry.Endpoint] = available
+		}
+		entry.Available = available
+		if capability.minVersion != nil {
+			entry.MinVersion = formatVersion(capability.minVersion)
+			// An unknown version is assumed to be recent.
+			entry.Available = available && (versionErr != nil || compareVersionPrefix(parsedVersion, capability.minVersion) >= 0)
+		}
+		result.Available[entry.Name] = entry.Available
+		result.Capabilities = append(result.Capabilities, entry)
+	}
+	return result, nil
+}
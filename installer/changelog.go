@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+
+	"github.com/rancher-sandbox/rd-open-webui-docker-ext/installer/types"
+)
+
+// changelogMaxPages is how many pages of releases are listed at most when
+// looking for the installed release; older releases are left out.
+const changelogMaxPages = 5
+
+// listReleases returns a page (counting from 1) of the releases of releaseRepo,
+// newest first.
+func listReleases(ctx context.Context, page int) ([]releaseInfo, error) {
+	releasesURL, err := url.JoinPath(*githubAPIURL, "repos", releaseRepo, "releases")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list releases: %w", err)
+	}
+	releasesURL += "?per_page=100&page=" + strconv.Itoa(page)
+	req, err := newRequest(ctx, http.MethodGet, releasesURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list releases: %w", err)
+	}
+	resp, err := downloadClient().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list releases: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("failed to list releases: unexpected status %s", resp.Status)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list releases: reading response: %w", err)
+	}
+	var releases []releaseInfo
+	if err = json.Unmarshal(body, &releases); err != nil {
+		return nil, fmt.Errorf("failed to list releases: error unmarshaling response: %w", err)
+	}
+	return releases, nil
+}
+
+// getChangelog returns the release notes of the releases after the installed
+// version of ollama, up to and including the given release, newest first.  If
+// ollama is not installed (or its version is unknown), only the notes of the
+// given release are returned.  Drafts and prereleases are skipped, unless the
+// given release is the prerelease itself.
+func getChangelog(ctx context.Context, release string) (*types.Changelog, error) {
+	result := &types.Changelog{SchemaVersion: types.SchemaVersion, Releases: []types.ChangelogEntry{}}
+	executablePath, err := findExecutable(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	if executablePath != "" {
+		if result.InstalledVersion, err = getExecutableVersion(ctx, executablePath); err != nil {
+			log.Printf("Failed to determine installed version: %s", err)
+		}
+	}
+	target, err := getRelease(ctx, release)
+	if err != nil {
+		return nil, err
+	}
+	result.TargetVersion = target.TagName
+	targetVersion, err := parseVersion(target.TagName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get changelog: %w", err)
+	}
+	installedVersion, err := parseVersion(result.InstalledVersion)
+	if err != nil {
+		// Without a version to start from, only the target is of interest.
+		result.Releases = append(result.Releases, newChangelogEntry(target))
+		return result, nil
+	}
+	if slices.Compare(installedVersion, targetVersion) >= 0 {
+		return result, nil
+	}
+
+	var entries []types.ChangelogEntry
+	reachedInstalled := false
+	for page := 1; page <= changelogMaxPages && !reachedInstalled; page++ {
+		releases, err := listReleases(ctx, page)
+		if err != nil {
+			return nil, err
+		}
+		if len(releases) == 0 {
+			// Every release has been listed.
+			reachedInstalled = true
+			break
+		}
+		for i := range releases {
+			info := &releases[i]
+			version, err := parseVersion(info.TagName)
+			if err != nil {
+				continue
+			}
+			if slices.Compare(version, installedVersion) <= 0 {
+				reachedInstalled = true
+				continue
+			}
+			if slices.Compare(version, targetVersion) > 0 || info.Draft {
+				continue
+			}
+			if info.Prerelease && info.TagName != target.TagName {
+				continue
+			}
+			entries = append(entries, newChangelogEntry(info))
+		}
+	}
+	// Releases are listed by creation date, which need not match version order
+	// when fixes are released for older versions.
+	slices.SortStableFunc(entries, func(a, b types.ChangelogEntry) int {
+		aVersion, _ := parseVersion(a.Tag)
+		bVersion, _ := parseVersion(b.Tag)
+		return slices.Compare(bVersion, aVersion)
+	})
+	result.Releases = append(result.Releases, entries...)
+	result.Truncated = !reachedInstalled
+	return result, nil
+}
+
+// newChangelogEntry describes the given release for the changelog.
+func newChangelogEntry(info *releaseInfo) types.ChangelogEntry {
+	entry := types.ChangelogEntry{
+		Tag:         info.TagName,
+		Name:        info.Name,
+		PublishedAt: info.PublishedAt,
+		URL:         info.HTMLURL,
+		Notes:       info.Body,
+	}
+	if entry.Notes == "" {
+		log.Printf("Release %s has no release notes", info.TagName)
+	}
+	return entry
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// Compression formats supported for tar archives.
+const (
+	CompressionGzip = "gzip"
+	CompressionXz   = "xz"
+	CompressionZstd = "zstd"
+)
+
+// compressionFormats lists the supported compression formats, with the magic
+// bytes their streams start with and the asset name suffixes that imply them.
+var compressionFormats = []struct {
+	name     string
+	magic    []byte
+	suffixes []string
+}{
+	{CompressionGzip, []byte{0x1f, 0x8b}, []string{".tgz", ".gz"}},
+	{CompressionXz, []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}, []string{".txz", ".xz"}},
+	{CompressionZstd, []byte{0x28, 0xb5, 0x2f, 0xfd}, []string{".tzst", ".zst"}},
+}
+
+// compressionForName returns the compression format implied by the given asset
+// name, or the empty string if it is not a compressed archive.
+func compressionForName(name string) string {
+	for _, format := range compressionFormats {
+		for _, suffix := range format.suffixes {
+			if strings.HasSuffix(name, suffix) {
+				return format.name
+			}
+		}
+	}
+	return ""
+}
+
+// compressionMagic returns the magic bytes of the given compression format.
+func compressionMagic(name string) []byte {
+	for _, format := range compressionFormats {
+		if format.name == name {
+			return format.magic
+		}
+	}
+	return nil
+}
+
+// newDecompressor returns a reader of the decompressed contents of r, detecting
+// the compression format from its magic bytes (as asset names may not follow
+// any convention).  The reader must be closed once done.
+func newDecompressor(r io.Reader) (io.ReadCloser, error) {
+	buffered := bufio.NewReader(r)
+	for _, format := range compressionFormats {
+		header, err := buffered.Peek(len(format.magic))
+		if err != nil && err != io.EOF {
+			return nil, fmt.Errorf("failed to read archive: %w", err)
+		}
+		if !bytes.Equal(header, format.magic) {
+			continue
+		}
+		switch format.name {
+		case CompressionGzip:
+			reader, err := gzip.NewReader(buffered)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read gzip archive: %w", err)
+			}
+			return reader, nil
+		case CompressionXz:
+			reader, err := xz.NewReader(buffered)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read xz archive: %w", err)
+			}
+			return io.NopCloser(reader), nil
+		case CompressionZstd:
+			// Extraction is sequential; extra goroutines would not help.
+			reader, err := zstd.NewReader(buffered, zstd.WithDecoderConcurrency(1))
+			if err != nil {
+				return nil, fmt.Errorf("failed to read zstd archive: %w", err)
+			}
+			return reader.IOReadCloser(), nil
+		}
+	}
+	return nil, fmt.Errorf("failed to read archive: unknown compression format")
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// compress returns data compressed in the given format.
+func compress(t *testing.T, format string, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	var writer io.WriteCloser
+	var err error
+	switch format {
+	case CompressionGzip:
+		writer = gzip.NewWriter(&buf)
+	case CompressionXz:
+		writer, err = xz.NewWriter(&buf)
+	case CompressionZstd:
+		writer, err = zstd.NewWriter(&buf)
+	default:
+		t.Fatalf("unknown compression format %s", format)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = writer.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err = writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestNewDecompressor(t *testing.T) {
+	archive := buildTestTar(t, testDir("bin/"), testFile("bin/ollama", "executable"))
+	for _, format := range []string{CompressionGzip, CompressionXz, CompressionZstd} {
+		t.Run(format, func(t *testing.T) {
+			compressed := compress(t, format, archive)
+			if magic := compressionMagic(format); !bytes.HasPrefix(compressed, magic) {
+				t.Fatalf("compressed data does not start with %x", magic)
+			}
+			reader, err := newDecompressor(bytes.NewReader(compressed))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer reader.Close()
+			tarReader := tar.NewReader(reader)
+			var names []string
+			for {
+				header, err := tarReader.Next()
+				if err == io.EOF {
+					break
+				} else if err != nil {
+					t.Fatalf("failed to read decompressed archive: %s", err)
+				}
+				names = append(names, header.Name)
+			}
+			if len(names) != 2 || names[0] != "bin/" || names[1] != "bin/ollama" {
+				t.Errorf("decompressed archive has %v, want [bin/ bin/ollama]", names)
+			}
+		})
+	}
+}
+
+func TestNewDecompressorUnknownFormat(t *testing.T) {
+	for _, data := range [][]byte{nil, []byte("PK\x03\x04"), buildTestTar(t, testFile("ollama", "executable"))} {
+		if reader, err := newDecompressor(bytes.NewReader(data)); err == nil {
+			reader.Close()
+			t.Errorf("newDecompressor(%.8q) succeeded, want an error", data)
+		}
+	}
+}
+
+func TestCompressionForName(t *testing.T) {
+	for name, want := range map[string]string{
+		"ollama-linux-amd64.tgz":      CompressionGzip,
+		"ollama-linux-amd64.tar.gz":   CompressionGzip,
+		"ollama-linux-amd64.txz":      CompressionXz,
+		"ollama-linux-amd64.tar.xz":   CompressionXz,
+		"ollama-linux-amd64.tzst":     CompressionZstd,
+		"ollama-linux-amd64.tar.zst":  CompressionZstd,
+		"ollama-windows-amd64.zip":    "",
+		"ollama-darwin":               "",
+		"ollama-linux-amd64.tgz.sha1": "",
+	} {
+		if got := compressionForName(name); got != want {
+			t.Errorf("compressionForName(%s) = %q, want %q", name, got, want)
+		}
+	}
+}
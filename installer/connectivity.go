@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/rancher-sandbox/rd-open-webui-docker-ext/installer/types"
+)
+
+// connectivityTimeout is how long each connectivity check may take.
+const connectivityTimeout = 15 * time.Second
+
+// Kinds of connectivity failures, from the closest to the furthest away.
+const (
+	ConnectivityErrorProxy   = "proxy"   // The proxy could not be reached, or refused the request.
+	ConnectivityErrorDNS     = "dns"     // The host name could not be resolved.
+	ConnectivityErrorConnect = "connect" // The connection was refused or the network is unreachable.
+	ConnectivityErrorTimeout = "timeout" // No response in time.
+	ConnectivityErrorTLS     = "tls"     // The certificate was not trusted (or did not match -mirror-pin).
+	ConnectivityErrorHTTP    = "http"    // The server responded with an error of its own.
+	ConnectivityErrorOther   = "other"
+)
+
+// connectivityTargets returns the URLs the installer needs to reach to install:
+// the release API, and the host assets are downloaded from (the mirror, if
+// set).
+func connectivityTargets() []types.ConnectivityCheck {
+	targets := []types.ConnectivityCheck{{Name: "github-api", URL: *githubAPIURL}}
+	if *mirrorURL != "" {
+		return append(targets, types.ConnectivityCheck{Name: "mirror", URL: *mirrorURL})
+	}
+	return append(targets, types.ConnectivityCheck{Name: "github", URL: "https://github.com"})
+}
+
+// classifyConnectivityError determines the kind of a failed request; proxied
+// is whether the request went through a proxy, so that failures to connect
+// can be attributed to it.
+func classifyConnectivityError(err error, proxied bool) string {
+	var opErr *net.OpError
+	var dnsErr *net.DNSError
+	var certErr *tls.CertificateVerificationError
+	var authorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	switch {
+	case errors.As(err, &opErr) && opErr.Op == "proxyconnect":
+		return ConnectivityErrorProxy
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, os.ErrDeadlineExceeded):
+		return ConnectivityErrorTimeout
+	case errors.As(err, &dnsErr), errors.As(err, &opErr) && opErr.Op == "dial":
+		if proxied {
+			// We only ever connect to the proxy itself.
+			return ConnectivityErrorProxy
+		}
+		if dnsErr != nil {
+			return ConnectivityErrorDNS
+		}
+		return ConnectivityErrorConnect
+	case errors.As(err, &certErr), errors.As(err, &authorityErr), errors.As(err, &hostnameErr):
+		return ConnectivityErrorTLS
+	case proxied:
+		// Such as the proxy refusing to CONNECT, or requiring authentication.
+		return ConnectivityErrorProxy
+	}
+	return ConnectivityErrorOther
+}
+
+// checkTarget sends a HEAD request to the URL of the given check, without
+// following redirects, reporting whether (and how quickly) it responded.
+func checkTarget(ctx context.Context, result types.ConnectivityCheck) types.ConnectivityCheck {
+	ctx, cancel := context.WithTimeout(ctx, connectivityTimeout)
+	defer cancel()
+	req, err := newRequest(ctx, http.MethodHead, result.URL, nil)
+	if err != nil {
+		result.ErrorKind, result.Error = ConnectivityErrorOther, err.Error()
+		return result
+	}
+	proxyURL, err := http.ProxyFromEnvironment(req)
+	if err != nil {
+		result.ErrorKind, result.Error = ConnectivityErrorProxy, err.Error()
+		return result
+	}
+	if proxyURL != nil && *dialSocket == "" {
+		result.Proxy = proxyURL.Redacted()
+	}
+	client := *downloadClient()
+	client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			// The URL is already reported.
+			err = urlErr.Err
+		}
+		result.ErrorKind, result.Error = classifyConnectivityError(err, result.Proxy != ""), err.Error()
+		return result
+	}
+	resp.Body.Close()
+	result.Latency = time.Since(start).Round(time.Millisecond).String()
+	result.Status = resp.StatusCode
+	switch {
+	case resp.StatusCode == http.StatusProxyAuthRequired:
+		result.ErrorKind, result.Error = ConnectivityErrorProxy, "proxy requires authentication; see -proxy-authorization"
+	case resp.StatusCode >= 500:
+		result.ErrorKind, result.Error = ConnectivityErrorHTTP, "unexpected status "+resp.Status
+	default:
+		// Any other response, even an error such as 404 for a mirror's base
+		// URL, shows the host can be reached.
+		result.Reachable = true
+	}
+	return result
+}
+
+// checkConnectivity checks that the hosts needed to install can be reached,
+// without downloading anything, so that the UI can report network problems
+// before starting an install.
+func checkConnectivity(ctx context.Context) *types.Connectivity {
+	result := &types.Connectivity{SchemaVersion: types.SchemaVersion, Reachable: true}
+	for _, target := range connectivityTargets() {
+		check := checkTarget(ctx, target)
+		result.Reachable = result.Reachable && check.Reachable
+		result.Checks = append(result.Checks, check)
+	}
+	return result
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// structuredCreateVersion is the first ollama version whose create API takes
+// the model's settings as fields, rather than as a Modelfile.
+var structuredCreateVersion = []int{0, 5, 5}
+
+// modelfile is a parsed Modelfile, limited to what can be created from a model
+// in a registry; creating from local weights or adapters needs their blobs
+// uploaded first, which we do not support.
+type modelfile struct {
+	From       string
+	System     string
+	Template   string
+	License    string
+	Parameters map[string]any
+	Messages   []map[string]string
+}
+
+// parseModelfileValue converts a parameter value to the type ollama expects.
+func parseModelfileValue(value string) any {
+	if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return n
+	}
+	if f, err := strconv.ParseFloat(value, 64); err == nil {
+		return f
+	}
+	if b, err := strconv.ParseBool(value); err == nil {
+		return b
+	}
+	return value
+}
+
+// parseModelfile parses the instructions of a Modelfile.  Values may be quoted,
+// or triple-quoted to span lines.
+func parseModelfile(contents string) (*modelfile, error) {
+	result := &modelfile{Parameters: make(map[string]any)}
+	scanner := bufio.NewScanner(strings.NewReader(contents))
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		instruction, value, _ := strings.Cut(line, " ")
+		value = strings.TrimSpace(value)
+		if rest, ok := strings.CutPrefix(value, `"""`); ok {
+			// Read until the closing triple quote.
+			var text strings.Builder
+			for {
+				if before, _, found := strings.Cut(rest, `"""`); found {
+					text.WriteString(before)
+					break
+				}
+				text.WriteString(rest)
+				if !scanner.Scan() {
+					return nil, fmt.Errorf("line %d: unterminated \"\"\"", lineNumber)
+				}
+				lineNumber++
+				text.WriteString("\n")
+				rest = scanner.Text()
+			}
+			value = text.String()
+		} else if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		}
+		switch strings.ToUpper(instruction) {
+		case "FROM":
+			result.From = value
+		case "SYSTEM":
+			result.System = value
+		case "TEMPLATE":
+			result.Template = value
+		case "LICENSE":
+			result.License = value
+		case "PARAMETER":
+			name, parameter, ok := strings.Cut(value, " ")
+			if !ok {
+				return nil, fmt.Errorf("line %d: expected PARAMETER <name> <value>", lineNumber)
+			}
+			parameter = strings.TrimSpace(parameter)
+			if unquoted, err := strconv.Unquote(parameter); err == nil {
+				parameter = unquoted
+			}
+			if name == "stop" {
+				// Stop sequences may be given several times.
+				stops, _ := result.Parameters[name].([]string)
+				result.Parameters[name] = append(stops, parameter)
+			} else {
+				result.Parameters[name] = parseModelfileValue(parameter)
+			}
+		case "MESSAGE":
+			role, content, ok := strings.Cut(value, " ")
+			if !ok {
+				return nil, fmt.Errorf("line %d: expected MESSAGE <role> <content>", lineNumber)
+			}
+			result.Messages = append(result.Messages, map[string]string{"role": role, "content": strings.TrimSpace(content)})
+		case "ADAPTER":
+			return nil, fmt.Errorf("line %d: adapters are not supported", lineNumber)
+		default:
+			return nil, fmt.Errorf("line %d: unknown instruction %s", lineNumber, instruction)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if result.From == "" {
+		return nil, fmt.Errorf("no FROM instruction")
+	}
+	if strings.HasPrefix(result.From, ".") || strings.HasPrefix(result.From, "/") || strings.HasPrefix(result.From, "~") {
+		return nil, fmt.Errorf("creating from local files (%s) is not supported", result.From)
+	}
+	return result, nil
+}
+
+// createModel creates the named model from the given Modelfile via the running
+// ollama server, calling progress for each update received.  The base model
+// must exist in its registry; it is pulled first if needed.
+func createModel(ctx context.Context, name, contents string, progress func(pullProgress)) error {
+	name = normalizeModelName(name)
+	if _, err := modelManifestPath(name); err != nil {
+		return fmt.Errorf("failed to create %s: %w", name, err)
+	}
+	parsed, err := parseModelfile(contents)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: invalid Modelfile: %w", name, err)
+	}
+	base := normalizeModelName(parsed.From)
+	if pulled, err := modelPulled(ctx, base); err != nil {
+		return fmt.Errorf("failed to create %s: %w", name, err)
+	} else if !pulled {
+		if _, err = getModelManifest(ctx, base); err != nil {
+			return fmt.Errorf("failed to create %s: base model %s: %w", name, base, err)
+		}
+		if err = runPull(ctx, base); err != nil {
+			return fmt.Errorf("failed to create %s: %w", name, err)
+		}
+	}
+
+	request := map[string]any{"model": name, "stream": true}
+	version, err := getRunningVersion(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", name, err)
+	}
+	if parsedVersion, err := parseVersion(version); err == nil && compareVersionPrefix(parsedVersion, structuredCreateVersion) >= 0 {
+		request["from"] = base
+		if parsed.System != "" {
+			request["system"] = parsed.System
+		}
+		if parsed.Template != "" {
+			request["template"] = parsed.Template
+		}
+		if parsed.License != "" {
+			request["license"] = parsed.License
+		}
+		if len(parsed.Parameters) > 0 {
+			request["parameters"] = parsed.Parameters
+		}
+		if len(parsed.Messages) > 0 {
+			request["messages"] = parsed.Messages
+		}
+	} else {
+		request["modelfile"] = contents
+	}
+	return postCreate(ctx, name, request, progress)
+}
+
+// postCreate sends the given create request to the running ollama server,
+// calling progress for each update received.
+func postCreate(ctx context.Context, name string, request map[string]any, progress func(pullProgress)) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", name, err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ollamaURL+"/api/create", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", name, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", name, err)
+	}
+	defer resp.Body.Close()
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var update pullProgress
+		if err = decoder.Decode(&update); err != nil {
+			if resp.StatusCode >= 300 {
+				return fmt.Errorf("failed to create %s: unexpected status %s", name, resp.Status)
+			}
+			return fmt.Errorf("failed to create %s: error reading response: %w", name, err)
+		}
+		if update.Error != "" {
+			return fmt.Errorf("failed to create %s: %s", name, update.Error)
+		}
+		if progress != nil {
+			progress(update)
+		}
+		if update.Status == "success" {
+			return nil
+		}
+	}
+}
+
+// runCreate creates the named model from the Modelfile at the given path,
+// logging progress.
+func runCreate(ctx context.Context, name, modelfilePath string) error {
+	contents, err := os.ReadFile(modelfilePath)
+	if err != nil {
+		return fmt.Errorf("failed to read Modelfile: %w", err)
+	}
+	log.Printf("Creating %s...", name)
+	return createModel(ctx, name, string(contents), func(update pullProgress) {
+		log.Printf("%s", update.Status)
+	})
+}
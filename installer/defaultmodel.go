@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/rancher-sandbox/rd-open-webui-docker-ext/installer/types"
+)
+
+// modelPulled reports whether the given (normalized) model is available on the
+// running ollama server.
+func modelPulled(ctx context.Context, model string) (bool, error) {
+	models, err := listModels(ctx)
+	if err != nil {
+		return false, err
+	}
+	for _, info := range models {
+		if normalizeModelName(info.Name) == model {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// getDefaultModel returns the default model, and whether it has been pulled.
+// If the server is not running, the model is reported as not pulled.
+func getDefaultModel(ctx context.Context) (*types.DefaultModel, error) {
+	state, err := loadState(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result := &types.DefaultModel{SchemaVersion: types.SchemaVersion, Model: state.DefaultModel}
+	if result.Model != "" {
+		if result.Pulled, err = modelPulled(ctx, result.Model); err != nil {
+			log.Printf("Failed to check if %s is pulled: %s", result.Model, err)
+		}
+	}
+	return result, nil
+}
+
+// setDefaultModel records the model the UI (and the proxy) should use when none
+// is chosen; an empty model clears it.  Models that have not been pulled (or
+// cannot be checked, if the server is not running) are accepted with a
+// warning, or pulled first if pull is set.
+func setDefaultModel(ctx context.Context, model string, pull bool) (*types.DefaultModel, error) {
+	result := &types.DefaultModel{SchemaVersion: types.SchemaVersion}
+	if model != "" {
+		result.Model = normalizeModelName(model)
+		pulled, err := modelPulled(ctx, result.Model)
+		if err != nil && pull {
+			return nil, fmt.Errorf("failed to check models: %w", err)
+		} else if err != nil {
+			// The server may not be running yet; the UI checks again later.
+			log.Printf("Warning: could not check if %s has been pulled: %s", result.Model, err)
+		} else if !pulled && pull {
+			if err = runPull(ctx, result.Model); err != nil {
+				return nil, err
+			}
+			pulled = true
+		} else if !pulled {
+			log.Printf("Warning: default model %s has not been pulled", result.Model)
+		}
+		result.Pulled = pulled
+	}
+	err := updateState(ctx, func(state *installerState) error {
+		state.DefaultModel = result.Model
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to record default model: %w", err)
+	}
+	return result, nil
+}
+
+// withDefaultModel returns the given generate or chat request body with the
+// model set to the default, if the request does not name one.  Bodies that are
+// not JSON objects are returned unchanged, for ollama to reject.
+func withDefaultModel(body []byte, model string) []byte {
+	var request map[string]json.RawMessage
+	if model == "" || json.Unmarshal(body, &request) != nil {
+		return body
+	}
+	var requested string
+	if raw, ok := request["model"]; ok && json.Unmarshal(raw, &requested) == nil && requested != "" {
+		return body
+	}
+	request["model"], _ = json.Marshal(model)
+	rewritten, err := json.Marshal(request)
+	if err != nil {
+		return body
+	}
+	return rewritten
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"time"
+
+	"github.com/rancher-sandbox/rd-open-webui-docker-ext/installer/types"
+)
+
+// secretNamePattern matches the names of flags and environment variables whose
+// values must not be included in diagnostics.
+var secretNamePattern = regexp.MustCompile(`(?i)token|secret|password|passwd|key|auth|credential|header`)
+
+// maxDiagnosticsLogSize is how much of the end of each log file is included in
+// diagnostics.
+const maxDiagnosticsLogSize = 1 << 20
+
+// redactValue returns the value of the named setting, redacted if it may be a
+// secret; credentials in URLs are redacted too.
+func redactValue(name, value string) string {
+	if value == "" {
+		return value
+	}
+	if secretNamePattern.MatchString(name) {
+		return "REDACTED"
+	}
+	if u, err := url.Parse(value); err == nil && u.User != nil {
+		return u.Redacted()
+	}
+	return value
+}
+
+// redactEnvironment returns a copy of the environment with secrets redacted.
+func redactEnvironment(env map[string]string) map[string]string {
+	if env == nil {
+		return nil
+	}
+	result := make(map[string]string, len(env))
+	for key, value := range env {
+		result[key] = redactValue(key, value)
+	}
+	return result
+}
+
+// diagnosticsConfig returns the effective value of every flag, with secrets
+// redacted.
+func diagnosticsConfig() map[string]string {
+	config := make(map[string]string)
+	flag.VisitAll(func(f *flag.Flag) {
+		config[f.Name] = redactValue(f.Name, f.Value.String())
+	})
+	// Environment variables for serve are redacted individually.
+	config["serve-env"] = envFlag(redactEnvironment(serveEnv)).String()
+	return config
+}
+
+// diagnosticsVerify checks the managed install without modifying it.
+func diagnosticsVerify(ctx context.Context) map[string]string {
+	result := make(map[string]string)
+	executablePath, err := findExecutable(ctx, false)
+	if err != nil {
+		result["error"] = err.Error()
+		return result
+	}
+	if executablePath == "" {
+		result["error"] = "ollama is not installed"
+		return result
+	}
+	result["executablePath"] = executablePath
+	if err = checkExecutableArchitecture(executablePath); err != nil {
+		result["architecture"] = err.Error()
+	} else {
+		result["architecture"] = "ok"
+	}
+	if version, err := getExecutableVersion(ctx, executablePath); err != nil {
+		result["version"] = err.Error()
+	} else {
+		result["version"] = version
+	}
+	return result
+}
+
+// diagnosticsSystem describes the machine.
+func diagnosticsSystem(ctx context.Context) map[string]any {
+	system := map[string]any{
+		"os":            runtime.GOOS,
+		"arch":          runtime.GOARCH,
+		"cpus":          runtime.NumCPU(),
+		"goVersion":     runtime.Version(),
+		"collectedAt":   time.Now().UTC(),
+		"accelerator":   detectAccelerator(),
+		"freeDiskSpace": map[string]any{},
+		"filesystems":   map[string]any{},
+	}
+	if selection, err := selectAsset(ctx); err == nil {
+		system["selectedAccelerator"] = selection.Accelerator
+		system["gpuDriverVersion"] = selection.DriverVersion
+	}
+	freeSpace := system["freeDiskSpace"].(map[string]any)
+	filesystems := system["filesystems"].(map[string]any)
+	for name, getDir := range map[string]func(context.Context) (string, error){
+		"install": getDefaultInstallLocation,
+		"models":  getModelsDirectory,
+		"state":   getStateDirectory,
+	} {
+		dir, err := getDir(ctx)
+		if err != nil {
+			continue
+		}
+		// The directory may not exist yet; use the nearest one that does.
+		dir = nearestExistingDirectory(dir)
+		if free, err := freeDiskSpace(dir); err == nil {
+			freeSpace[name] = free
+		}
+		if fsType, network, err := getFilesystemType(dir); err == nil {
+			filesystems[name] = map[string]any{"type": fsType, "network": network}
+		}
+	}
+	return system
+}
+
+// serverLogCandidates returns the locations ollama may write its server log to,
+// starting with that of the managed serve process.
+func serverLogCandidates(ctx context.Context) []string {
+	var candidates []string
+	if logPath, err := getServeLogFile(ctx); err == nil {
+		candidates = append(candidates, logPath)
+	}
+	if homeDir, err := os.UserHomeDir(); err == nil {
+		// Ollama.app on macOS.
+		candidates = append(candidates, filepath.Join(homeDir, ".ollama", "logs", "server.log"))
+	}
+	if cacheDir, err := os.UserCacheDir(); err == nil && runtime.GOOS == "windows" {
+		// The Windows ollama app, under %LOCALAPPDATA%.
+		candidates = append(candidates, filepath.Join(cacheDir, "Ollama", "server.log"))
+	}
+	return candidates
+}
+
+// addZipJSON adds the given value to the zip archive as a JSON file.
+func addZipJSON(writer *zip.Writer, name string, v any) error {
+	w, err := writer.Create(name)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// addZipLogTail adds (the end of) the given log file to the zip archive.
+func addZipLogTail(writer *zip.Writer, name, logPath string) error {
+	file, err := os.Open(logPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if info, err := file.Stat(); err == nil && info.Size() > maxDiagnosticsLogSize {
+		if _, err = file.Seek(-maxDiagnosticsLogSize, io.SeekEnd); err != nil {
+			return err
+		}
+	}
+	w, err := writer.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, file)
+	return err
+}
+
+// writeDiagnostics writes a zip file of information useful for diagnosing
+// problems to the given path, with secrets redacted.  Failing to collect any
+// one piece of information is recorded in the bundle rather than being fatal.
+func writeDiagnostics(ctx context.Context, output string) (*types.DiagnosticsResult, error) {
+	if output == "" {
+		return nil, fmt.Errorf("no output file given for the diagnostics bundle")
+	}
+	file, err := os.OpenFile(output, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create diagnostics bundle: %w", err)
+	}
+	succeeded := false
+	defer func() {
+		file.Close()
+		if !succeeded {
+			_ = os.Remove(output)
+		}
+	}()
+	writer := zip.NewWriter(file)
+	result := &types.DiagnosticsResult{SchemaVersion: types.SchemaVersion, Path: output}
+
+	errorValue := func(err error) map[string]string {
+		return map[string]string{"error": err.Error()}
+	}
+	var status any
+	if installStatus, err := getStatus(ctx); err != nil {
+		status = errorValue(err)
+	} else {
+		installStatus.ServeEnvironment = redactEnvironment(installStatus.ServeEnvironment)
+		status = installStatus
+	}
+	var state any
+	if installerState, err := loadState(ctx); err != nil {
+		state = errorValue(err)
+	} else {
+		if installerState.Serve != nil {
+			installerState.Serve.Environment = redactEnvironment(installerState.Serve.Environment)
+		}
+		state = installerState
+	}
+	for _, entry := range []struct {
+		name  string
+		value any
+	}{
+		{"config.json", diagnosticsConfig()},
+		{"status.json", status},
+		{"state.json", state},
+		{"verify.json", diagnosticsVerify(ctx)},
+		{"system.json", diagnosticsSystem(ctx)},
+	} {
+		if err = addZipJSON(writer, entry.name, entry.value); err != nil {
+			return nil, fmt.Errorf("failed to write diagnostics bundle: %w", err)
+		}
+		result.Files = append(result.Files, entry.name)
+	}
+	if *fileManifestPath != "" {
+		if err = addZipLogTail(writer, "file-manifest.json", *fileManifestPath); err != nil {
+			log.Printf("Failed to add file manifest to diagnostics: %s", err)
+		} else {
+			result.Files = append(result.Files, "file-manifest.json")
+		}
+	}
+	for i, logPath := range serverLogCandidates(ctx) {
+		name := fmt.Sprintf("logs/server-%d.log", i)
+		if err = addZipLogTail(writer, name, logPath); errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			log.Printf("Failed to add %s to diagnostics: %s", logPath, err)
+			continue
+		}
+		result.Files = append(result.Files, name)
+	}
+
+	if err = writer.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write diagnostics bundle: %w", err)
+	}
+	if err = file.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write diagnostics bundle: %w", err)
+	}
+	succeeded = true
+	return result, nil
+}
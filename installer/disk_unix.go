@@ -0,0 +1,57 @@
+//go:build !windows
+
+package main
+
+import (
+	"errors"
+	"os"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// isDiskFullError reports whether the error is due to running out of space.
+func isDiskFullError(err error) bool {
+	return errors.Is(err, unix.ENOSPC) || errors.Is(err, unix.EDQUOT)
+}
+
+// isLinkUnsupportedError reports whether the error is due to the filesystem
+// not supporting a link, such as vfat or a link across mounts.
+func isLinkUnsupportedError(err error) bool {
+	return errors.Is(err, unix.EXDEV) || errors.Is(err, unix.EPERM) || errors.Is(err, unix.EMLINK) ||
+		errors.Is(err, errors.ErrUnsupported)
+}
+
+// sameFilesystem reports whether the two (existing) paths are on the same
+// filesystem, so that files can be renamed from one to the other.
+func sameFilesystem(a, b string) (bool, error) {
+	var statA, statB unix.Stat_t
+	if err := unix.Stat(a, &statA); err != nil {
+		return false, &os.PathError{Op: "stat", Path: a, Err: err}
+	}
+	if err := unix.Stat(b, &statB); err != nil {
+		return false, &os.PathError{Op: "stat", Path: b, Err: err}
+	}
+	return statA.Dev == statB.Dev, nil
+}
+
+// freeDiskSpace returns the space available to us on the filesystem containing
+// the given path, in bytes.
+func freeDiskSpace(path string) (uint64, error) {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+	return uint64(stat.Bavail) * uint64(stat.Bsize), nil
+}
+
+// fileChangeTime returns when the given file, or its metadata, was last
+// changed.  Unlike the modification time, this cannot be set, so it shows
+// whether the file was written since.
+func fileChangeTime(path string) (time.Time, error) {
+	var stat unix.Stat_t
+	if err := unix.Stat(path, &stat); err != nil {
+		return time.Time{}, &os.PathError{Op: "stat", Path: path, Err: err}
+	}
+	return time.Unix(stat.Ctim.Unix()), nil
+}
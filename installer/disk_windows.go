@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// isDiskFullError reports whether the error is due to running out of space.
+func isDiskFullError(err error) bool {
+	return errors.Is(err, windows.ERROR_DISK_FULL) || errors.Is(err, windows.ERROR_HANDLE_DISK_FULL)
+}
+
+// isLinkUnsupportedError reports whether the error is due to the filesystem
+// not supporting a link, such as FAT, or (for symbolic links) to lacking the
+// privilege to create one outside of developer mode.
+func isLinkUnsupportedError(err error) bool {
+	return errors.Is(err, windows.ERROR_NOT_SAME_DEVICE) || errors.Is(err, windows.ERROR_NOT_SUPPORTED) ||
+		errors.Is(err, windows.ERROR_INVALID_FUNCTION) || errors.Is(err, windows.ERROR_PRIVILEGE_NOT_HELD) ||
+		errors.Is(err, errors.ErrUnsupported)
+}
+
+// sameFilesystem reports whether the two paths are on the same volume, so that
+// files can be renamed from one to the other.
+func sameFilesystem(a, b string) (bool, error) {
+	absA, err := filepath.Abs(a)
+	if err != nil {
+		return false, err
+	}
+	absB, err := filepath.Abs(b)
+	if err != nil {
+		return false, err
+	}
+	return strings.EqualFold(filepath.VolumeName(absA), filepath.VolumeName(absB)), nil
+}
+
+// freeDiskSpace returns the space available to us on the volume containing the
+// given path, in bytes.
+func freeDiskSpace(path string) (uint64, error) {
+	pathPtr, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, err
+	}
+	var available uint64
+	if err = windows.GetDiskFreeSpaceEx(pathPtr, &available, nil, nil); err != nil {
+		return 0, err
+	}
+	return available, nil
+}
+
+// fileBasicInfo is FILE_BASIC_INFO, as returned by GetFileInformationByHandleEx.
+type fileBasicInfo struct {
+	CreationTime   int64
+	LastAccessTime int64
+	LastWriteTime  int64
+	ChangeTime     int64
+	FileAttributes uint32
+	_              uint32
+}
+
+// fileChangeTime returns when the given file, or its metadata, was last
+// changed.  Unlike the modification time, this cannot be set, so it shows
+// whether the file was written since.
+func fileChangeTime(path string) (time.Time, error) {
+	pathPtr, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	handle, err := windows.CreateFile(pathPtr, windows.FILE_READ_ATTRIBUTES,
+		windows.FILE_SHARE_READ|windows.FILE_SHARE_WRITE|windows.FILE_SHARE_DELETE, nil,
+		windows.OPEN_EXISTING, windows.FILE_FLAG_BACKUP_SEMANTICS, 0)
+	if err != nil {
+		return time.Time{}, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	defer windows.CloseHandle(handle)
+	var info fileBasicInfo
+	if err = windows.GetFileInformationByHandleEx(handle, windows.FileBasicInfo, (*byte)(unsafe.Pointer(&info)), uint32(unsafe.Sizeof(info))); err != nil {
+		return time.Time{}, &os.PathError{Op: "stat", Path: path, Err: err}
+	}
+	changed := windows.Filetime{LowDateTime: uint32(info.ChangeTime), HighDateTime: uint32(info.ChangeTime >> 32)}
+	return time.Unix(0, changed.Nanoseconds()), nil
+}
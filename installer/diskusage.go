@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rancher-sandbox/rd-open-webui-docker-ext/installer/types"
+)
+
+// getModelDiskUsage reports the space each model's blobs occupy in the models
+// directory.  Unlike the sizes ollama reports, blobs shared between models
+// (such as the weights of a model and a copy with a different system prompt)
+// are only counted once in the total, and a model's unique size is what
+// deleting it (then pruning) would reclaim.
+func getModelDiskUsage(ctx context.Context) (*types.DiskUsage, error) {
+	modelsDir, err := getModelsDirectory(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result := &types.DiskUsage{SchemaVersion: types.SchemaVersion, ModelsDir: modelsDir, Models: []types.ModelDiskUsage{}}
+
+	// Sizes of the blobs on disk, by digest; blobs that are not complete, such
+	// as partial downloads, are only counted as unreferenced.
+	blobSizes := make(map[string]int64)
+	entries, err := os.ReadDir(filepath.Join(modelsDir, "blobs"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to list blobs: %w", err)
+	}
+	var otherSize int64
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		info, err := entry.Info()
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to check blob %s: %w", entry.Name(), err)
+		}
+		digest := "sha256:" + strings.TrimPrefix(entry.Name(), "sha256-")
+		if _, err := blobPath(digest); err != nil {
+			otherSize += info.Size()
+			continue
+		}
+		blobSizes[digest] = info.Size()
+	}
+
+	// The distinct blobs of each model, and how many models reference each.
+	var models []string
+	modelBlobs := make(map[string][]string)
+	references := make(map[string]int)
+	err = walkManifests(ctx, modelsDir, func(manifestPath string, manifest *modelManifest) error {
+		name, err := modelNameFromManifestPath(manifestPath)
+		if err != nil {
+			log.Printf("Skipping %s", err)
+			return nil
+		}
+		seen := make(map[string]bool)
+		for _, digest := range manifest.digests() {
+			if seen[digest] {
+				continue
+			}
+			seen[digest] = true
+			modelBlobs[name] = append(modelBlobs[name], digest)
+			references[digest]++
+		}
+		models = append(models, name)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range models {
+		usage := types.ModelDiskUsage{Name: name}
+		for _, digest := range modelBlobs[name] {
+			size, ok := blobSizes[digest]
+			if !ok {
+				usage.MissingBlobs++
+				continue
+			}
+			usage.Size += size
+			if references[digest] == 1 {
+				usage.UniqueSize += size
+			} else {
+				usage.SharedSize += size
+			}
+		}
+		result.Models = append(result.Models, usage)
+	}
+	for digest, size := range blobSizes {
+		switch {
+		case references[digest] == 0:
+			result.UnreferencedSize += size
+		case references[digest] > 1:
+			result.SharedSize += size
+			result.TotalSize += size
+		default:
+			result.TotalSize += size
+		}
+	}
+	result.UnreferencedSize += otherSize
+	return result, nil
+}
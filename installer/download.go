@@ -0,0 +1,394 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrChecksumMismatch is returned when a downloaded file does not match its
+// published checksum.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// errNotModified is returned when a conditional download finds the cached copy
+// of an asset is still current.
+var errNotModified = errors.New("not modified")
+
+// checksumAssetName is the name of the release asset listing the SHA-256
+// checksums of the other assets.
+const checksumAssetName = "sha256sum.txt"
+
+// getCacheDirectory returns the directory downloaded archives are cached in.
+// This is outside of the extension directory so that it can be shared between
+// versions of the extension.
+func getCacheDirectory() (string, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find cache directory: %w", err)
+	}
+	return filepath.Join(cacheDir, "rancher-desktop-open-webui", "archives"), nil
+}
+
+// getAssetChecksum returns the published SHA-256 checksum (as a hex string) of
+// the given release asset.
+func getAssetChecksum(ctx context.Context, release, assetName string) (string, error) {
+	checksumURL, err := getReleaseAssetURL(ctx, release, checksumAssetName)
+	if err != nil {
+		return "", err
+	}
+	req, err := newRequest(ctx, http.MethodGet, checksumURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to get checksums: %w", err)
+	}
+	resp, err := downloadClient().Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to get checksums: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return "", fmt.Errorf("failed to get checksums: unexpected status %s", resp.Status)
+	}
+	checksum, err := findChecksum(resp.Body, assetName)
+	if err != nil {
+		return "", fmt.Errorf("failed to get checksums: reading response: %w", err)
+	}
+	if checksum == "" {
+		return "", fmt.Errorf("failed to find checksum for %q in release %q", assetName, release)
+	}
+	return checksum, nil
+}
+
+// findChecksum returns the checksum of the given asset from a list of
+// checksums, or the empty string if it is not listed.  Each line is in the
+// format emitted by sha256sum, e.g. "<hash>  ./ollama-linux-amd64.tgz".
+func findChecksum(r io.Reader, assetName string) (string, error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		name := strings.TrimPrefix(strings.TrimPrefix(fields[1], "*"), "./")
+		if name == assetName {
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+	return "", scanner.Err()
+}
+
+// hashFile returns the SHA-256 checksum (as a hex string) of the given file.
+func hashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hasher := sha256.New()
+	if _, err = io.Copy(hasher, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// fetchAsset ensures the given release asset is in the download cache,
+// returning its path.  Cached archives are addressed by their checksum, so
+// concurrent installs of the same asset share a single download; the lock is
+// per checksum, so unrelated downloads do not block each other.
+func fetchAsset(ctx context.Context, release, assetName string) (string, error) {
+	if bundledPath, tag, checksum := findBundledAsset(release, assetName); bundledPath != "" {
+		cachedPath, err := cacheBundledAsset(ctx, bundledPath, tag, checksum)
+		if err == nil {
+			return cachedPath, nil
+		}
+		log.Printf("Warning: could not use bundled %s, downloading it instead: %s", assetName, err)
+	}
+	assetURL, err := getReleaseAssetURL(ctx, release, assetName)
+	if err != nil {
+		return "", err
+	}
+	cacheDir, err := getCacheDirectory()
+	if err != nil {
+		return "", err
+	}
+	if err = os.MkdirAll(cacheDir, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create cache directory: %w", err)
+	}
+
+	expected, err := getAssetChecksum(ctx, release, assetName)
+	if err != nil {
+		// Older releases do not publish checksums; only fail if asked to.
+		if *strictChecksum {
+			return "", fmt.Errorf("failed to verify %s: %w", assetName, err)
+		}
+		log.Printf("Warning: could not get checksum for %s, it will not be verified: %s", assetName, err)
+	} else {
+		unlock, err := acquireLock(ctx, filepath.Join(cacheDir, expected+".lock"))
+		if err != nil {
+			return "", err
+		}
+		defer unlock()
+		cachedPath := filepath.Join(cacheDir, expected, assetName)
+		if actual, err := hashFile(cachedPath); err == nil && actual == expected {
+			log.Printf("Using cached %s", cachedPath)
+			markCacheUsed(cachedPath, release)
+			return cachedPath, nil
+		}
+	}
+
+	// Without a checksum to find the cached archive by, ask the server whether
+	// the copy previously downloaded from the same URL is still current.
+	var conditional *cacheValidators
+	var conditionalPath string
+	if expected == "" {
+		conditionalPath, conditional = findCachedByURL(cacheDir, assetURL, assetName)
+	}
+
+	log.Printf("Downloading ollama from %s...", assetURL)
+	downloadDir, err := tempDirectoryFor(cacheDir)
+	if err != nil {
+		return "", err
+	}
+	file, err := os.CreateTemp(downloadDir, assetName+".*.tmp")
+	if err != nil {
+		return "", fmt.Errorf("failed to create download file: %w", err)
+	}
+	defer func() {
+		file.Close()
+		_ = os.Remove(file.Name())
+	}()
+
+	download, err := downloadVerified(ctx, assetURL, assetName, file, expected, conditional)
+	if errors.Is(err, errNotModified) {
+		log.Printf("Using cached %s, unchanged since it was downloaded", conditionalPath)
+		markCacheUsed(conditionalPath, release)
+		return conditionalPath, nil
+	} else if err != nil {
+		return "", err
+	}
+	actual := download.checksum
+	if err = file.Close(); err != nil {
+		return "", fmt.Errorf("failed to write download file: %w", err)
+	}
+
+	cachedPath := filepath.Join(cacheDir, actual, assetName)
+	if err = os.MkdirAll(filepath.Dir(cachedPath), 0o755); err != nil {
+		return "", fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	if err = os.Rename(file.Name(), cachedPath); err != nil {
+		return "", fmt.Errorf("failed to move download into cache: %w", err)
+	}
+	markCacheUsed(cachedPath, release)
+	saveCacheValidators(cachedPath, &download.validators)
+	return cachedPath, nil
+}
+
+// downloadVerified downloads the given URL into file, checking that it has the
+// expected checksum (if known).  A checksum mismatch is usually corruption in
+// transit (or in a caching proxy), or a truncated download, so the asset is
+// downloaded again up to -checksum-retries times before failing with
+// ErrChecksumMismatch.  Returns errNotModified as downloadWithRetries does.
+func downloadVerified(ctx context.Context, assetURL, assetName string, file *os.File, expected string, conditional *cacheValidators) (*downloadResult, error) {
+	for mismatches := 0; ; mismatches++ {
+		download, err := downloadWithRetries(ctx, assetURL, assetName, file, conditional, mismatches > 0)
+		if err != nil {
+			return nil, err
+		}
+		if err = checkArchiveFormat(file, assetName, download.contentType); err != nil {
+			return nil, err
+		}
+		if expected == "" || download.checksum == expected {
+			return download, nil
+		}
+		err = fmt.Errorf("error downloading %s: expected sha256 %s, got %s: %w", assetName, expected, download.checksum, ErrChecksumMismatch)
+		if mismatches >= *checksumRetries {
+			return nil, err
+		}
+		log.Printf("Warning: %s; downloading again", err)
+	}
+}
+
+// downloadResult describes a completed download.
+type downloadResult struct {
+	checksum    string          // SHA-256 checksum, as a hex string.
+	contentType string          // Content type reported by the server.
+	validators  cacheValidators // For conditional requests for the same asset.
+}
+
+// httpStatusError is returned when a download gets an unexpected HTTP status.
+type httpStatusError struct {
+	Status     string
+	StatusCode int
+}
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("error downloading ollama: status %s", e.Status)
+}
+
+// isRetryableDownloadError reports whether a failed download attempt may
+// succeed if retried: network failures, server errors and rate limiting are
+// retried, while client errors, running out of disk space, and cancellation
+// are not.
+func isRetryableDownloadError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, ErrInsufficientSpace) || errors.Is(err, errNotModified) {
+		return false
+	}
+	var statusErr *httpStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= 500 || statusErr.StatusCode == http.StatusTooManyRequests
+	}
+	return true
+}
+
+// downloadWithRetries downloads the given URL into file, retrying up to
+// -download-attempts times with exponential backoff from -download-retry-delay.
+// Each attempt is logged, so that intermittent failures can be diagnosed from
+// the log.  If conditional is given, the download is skipped with
+// errNotModified if the asset has not changed since it was last downloaded.
+// If bypassCache is set, caching proxies are asked not to serve a stored copy.
+func downloadWithRetries(ctx context.Context, assetURL, assetName string, file *os.File, conditional *cacheValidators, bypassCache bool) (*downloadResult, error) {
+	start := time.Now()
+	attempts := max(*downloadAttempts, 1)
+	delay := *downloadRetryDelay
+	for attempt := 1; ; attempt++ {
+		result, err := downloadAttempt(ctx, assetURL, assetName, file, conditional, bypassCache)
+		if err == nil {
+			log.Printf("download: url=%s result=ok attempts=%d elapsed=%s", assetURL, attempt, time.Since(start))
+			return result, nil
+		} else if errors.Is(err, errNotModified) {
+			log.Printf("download: url=%s result=not-modified attempts=%d elapsed=%s", assetURL, attempt, time.Since(start))
+			return nil, err
+		}
+		if attempt >= attempts || !isRetryableDownloadError(err) {
+			log.Printf("download: attempt=%d/%d url=%s error=%q", attempt, attempts, assetURL, err)
+			log.Printf("download: url=%s result=failed attempts=%d elapsed=%s", assetURL, attempt, time.Since(start))
+			return nil, err
+		}
+		log.Printf("download: attempt=%d/%d url=%s error=%q retry_delay=%s", attempt, attempts, assetURL, err, delay)
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("failed to download ollama: %w", ctx.Err())
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// downloadAttempt makes a single attempt at downloading the given URL into
+// file, replacing any previous contents.  If conditional is given, the request
+// is made conditional on the asset having changed; if bypassCache is set,
+// caching proxies must fetch it afresh.
+func downloadAttempt(ctx context.Context, assetURL, assetName string, file *os.File, conditional *cacheValidators, bypassCache bool) (*downloadResult, error) {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to reset download file: %w", err)
+	}
+	if err := file.Truncate(0); err != nil {
+		return nil, fmt.Errorf("failed to reset download file: %w", err)
+	}
+	req, err := newAssetRequest(ctx, http.MethodGet, assetURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if bypassCache {
+		req.Header.Set("Cache-Control", "no-cache")
+		req.Header.Set("Pragma", "no-cache")
+	}
+	if conditional != nil {
+		if conditional.ETag != "" {
+			req.Header.Set("If-None-Match", conditional.ETag)
+		}
+		if conditional.LastModified != "" {
+			req.Header.Set("If-Modified-Since", conditional.LastModified)
+		}
+	}
+	resp, err := downloadClient().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download ollama: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified && conditional != nil {
+		return nil, errNotModified
+	}
+	if resp.StatusCode >= 300 {
+		return nil, &httpStatusError{Status: resp.Status, StatusCode: resp.StatusCode}
+	}
+	hasher := sha256.New()
+	reporter := newProgressReporter(PhaseDownload, max(resp.ContentLength, 0))
+	reporter.setFile(assetName)
+	length, err := io.Copy(io.MultiWriter(file, hasher), &progressReader{Reader: resp.Body, reporter: reporter})
+	if isDiskFullError(err) {
+		return nil, fmt.Errorf("failed to download ollama: %w: %w", ErrInsufficientSpace, err)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to download ollama: %w", err)
+	}
+	// The transport reads no more than the advertised length, so the download
+	// from a mirror that under-reports it is truncated; its checksum then does
+	// not match, and downloadVerified downloads it again.
+	if resp.ContentLength > 0 && length < resp.ContentLength {
+		return nil, fmt.Errorf("partial read downloading ollama: got %d of %d bytes", length, resp.ContentLength)
+	}
+	reporter.done()
+	return &downloadResult{
+		checksum:    hex.EncodeToString(hasher.Sum(nil)),
+		contentType: resp.Header.Get("Content-Type"),
+		validators: cacheValidators{
+			URL:          assetURL,
+			ETag:         resp.Header.Get("ETag"),
+			LastModified: resp.Header.Get("Last-Modified"),
+		},
+	}, nil
+}
+
+// checkArchiveFormat checks that a downloaded asset looks like the archive type
+// its name implies, so that (for example) an HTML error page served by a
+// misconfigured mirror gets a clear error rather than a decompression failure.
+func checkArchiveFormat(file io.ReaderAt, assetName, contentType string) error {
+	var magic []byte
+	var format string
+	switch {
+	case compressionForName(assetName) != "":
+		format = compressionForName(assetName)
+		magic = compressionMagic(format)
+	case strings.HasSuffix(assetName, ".zip"):
+		magic, format = []byte("PK\x03\x04"), "zip"
+	default:
+		return nil
+	}
+	header := make([]byte, len(magic))
+	if _, err := file.ReadAt(header, 0); err != nil || !bytes.Equal(header, magic) {
+		hint := ""
+		if strings.HasPrefix(contentType, "text/html") {
+			hint = " (got HTML error page?)"
+		}
+		return fmt.Errorf("downloaded content for %s is not a %s archive%s; content type %q", assetName, format, hint, contentType)
+	}
+	return nil
+}
+
+// copyFile copies the contents of src to a new file at dest.
+func copyFile(src, dest string, mode os.FileMode) error {
+	input, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+	output, err := os.OpenFile(dest, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(output, input); err != nil {
+		output.Close()
+		return err
+	}
+	return output.Close()
+}
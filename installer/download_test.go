@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// lengthServer serves body with the Content-Length returned by advertised for
+// each request (counting from zero), written as a raw response so that it can
+// disagree with the body, as from a misconfigured mirror.  The headers of each
+// request are recorded.
+func lengthServer(t *testing.T, body []byte, advertised func(request int) int, requests *[]http.Header) *httptest.Server {
+	t.Helper()
+	var mu sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		request := len(*requests)
+		*requests = append(*requests, r.Header.Clone())
+		mu.Unlock()
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		fmt.Fprintf(buf, "HTTP/1.1 200 OK\r\nContent-Type: application/gzip\r\nContent-Length: %d\r\nConnection: close\r\n\r\n", advertised(request))
+		_, _ = buf.Write(body)
+		_ = buf.Flush()
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// downloadTestAsset downloads the asset served at url into a new file, with
+// the given number of retries on a checksum mismatch.
+func downloadTestAsset(t *testing.T, url, expected string, retries int) (*downloadResult, []byte, error) {
+	t.Helper()
+	setForTest(t, checksumRetries, retries)
+	setForTest(t, downloadAttempts, 1)
+	file, err := os.Create(filepath.Join(t.TempDir(), "ollama-linux-amd64.tgz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	result, err := downloadVerified(context.Background(), url, "ollama-linux-amd64.tgz", file, expected, nil)
+	contents, readErr := os.ReadFile(file.Name())
+	if readErr != nil {
+		t.Fatal(readErr)
+	}
+	return result, contents, err
+}
+
+func TestDownloadVerifiedUnderReportedLength(t *testing.T) {
+	body := buildTestArchive(t, testFile("bin/ollama", strings.Repeat("executable ", 1000)))
+	var requests []http.Header
+	server := lengthServer(t, body, func(int) int { return len(body) / 2 }, &requests)
+
+	_, _, err := downloadTestAsset(t, server.URL, sha256Hex(body), 1)
+	// The transport stops at the advertised length, so the download is
+	// truncated, rather than accepted.
+	if !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("downloadVerified() = %v, want %v", err, ErrChecksumMismatch)
+	}
+	if len(requests) != 2 {
+		t.Fatalf("made %d requests, want 2", len(requests))
+	}
+	if got := requests[1].Get("Cache-Control"); got != "no-cache" {
+		t.Errorf("retry Cache-Control = %q, want no-cache", got)
+	}
+}
+
+func TestDownloadVerifiedRefetchesTruncated(t *testing.T) {
+	body := buildTestArchive(t, testFile("bin/ollama", strings.Repeat("executable ", 1000)))
+	var requests []http.Header
+	// A caching proxy serves a bad copy, but not when asked to bypass it.
+	server := lengthServer(t, body, func(request int) int {
+		if request == 0 {
+			return len(body) / 2
+		}
+		return len(body)
+	}, &requests)
+
+	result, contents, err := downloadTestAsset(t, server.URL, sha256Hex(body), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(requests) != 2 {
+		t.Errorf("made %d requests, want 2", len(requests))
+	}
+	if result.checksum != sha256Hex(body) || !bytes.Equal(contents, body) {
+		t.Errorf("downloaded %d bytes with sha256 %s, want %d with %s", len(contents), result.checksum, len(body), sha256Hex(body))
+	}
+}
+
+func TestDownloadVerifiedExactLength(t *testing.T) {
+	body := buildTestArchive(t, testFile("bin/ollama", "executable"))
+	var requests []http.Header
+	server := lengthServer(t, body, func(int) int { return len(body) }, &requests)
+
+	result, contents, err := downloadTestAsset(t, server.URL, sha256Hex(body), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(requests) != 1 || result.checksum != sha256Hex(body) || !bytes.Equal(contents, body) {
+		t.Errorf("downloaded %d bytes with sha256 %s in %d requests, want %d with %s in 1", len(contents), result.checksum, len(requests), len(body), sha256Hex(body))
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/rancher-sandbox/rd-open-webui-docker-ext/installer/types"
+)
+
+// parseEndpoint parses an ollama endpoint given as host:port (using plain
+// HTTP, as ollama does by default) or as an http or https URL, returning the
+// base URL of its API.
+func parseEndpoint(endpoint string) (*url.URL, error) {
+	endpoint = strings.TrimSpace(endpoint)
+	if endpoint == "" {
+		return nil, fmt.Errorf("no endpoint given")
+	}
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "http://" + endpoint
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid endpoint %q: %w", endpoint, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid endpoint %q: scheme must be http or https", endpoint)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid endpoint %q: no host", endpoint)
+	}
+	if u.User != nil || u.RawQuery != "" || u.Fragment != "" {
+		return nil, fmt.Errorf("invalid endpoint %q: must not contain credentials, a query or a fragment", endpoint)
+	}
+	if u.Port() == "" && u.Scheme == "http" {
+		// ollama listens on 11434 unless told otherwise; a bare host is more
+		// likely to mean that than port 80.
+		u.Host = net.JoinHostPort(u.Hostname(), "11434")
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	return u, nil
+}
+
+// checkEndpoint checks whether a compatible ollama server responds at the given
+// endpoint (see parseEndpoint), so that the UI can validate an endpoint before
+// using it as the backend.  Failures to reach the server are reported in the
+// result rather than returned.
+func checkEndpoint(ctx context.Context, endpoint string) (*types.EndpointCheck, error) {
+	base, err := parseEndpoint(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	result := &types.EndpointCheck{SchemaVersion: types.SchemaVersion, Endpoint: base.String()}
+	ctx, cancel := context.WithTimeout(ctx, connectivityTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, base.JoinPath("api", "version").String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check endpoint: %w", err)
+	}
+	proxyURL, err := http.ProxyFromEnvironment(req)
+	if err != nil {
+		result.ErrorKind, result.Error = ConnectivityErrorProxy, err.Error()
+		return result, nil
+	}
+	start := time.Now()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			// The endpoint is already reported.
+			err = urlErr.Err
+		}
+		result.ErrorKind, result.Error = classifyConnectivityError(err, proxyURL != nil), err.Error()
+		var recordErr tls.RecordHeaderError
+		if errors.As(err, &recordErr) || strings.Contains(err.Error(), "server gave HTTP response to HTTPS client") {
+			result.ErrorKind = ConnectivityErrorTLS
+			result.Error = "the endpoint does not use TLS; use an http:// URL instead"
+		} else if result.ErrorKind == ConnectivityErrorTLS {
+			result.Error = fmt.Sprintf("the endpoint's certificate is not trusted: %s", err)
+		}
+		return result, nil
+	}
+	defer resp.Body.Close()
+	result.Latency = time.Since(start).Round(time.Millisecond).String()
+	result.Status = resp.StatusCode
+	if resp.StatusCode == http.StatusBadRequest && base.Scheme == "http" {
+		// Such as Go's "Client sent an HTTP request to an HTTPS server".
+		result.ErrorKind, result.Error = ConnectivityErrorHTTP, fmt.Sprintf("unexpected status %s; if the endpoint uses TLS, use an https:// URL", resp.Status)
+		return result, nil
+	} else if resp.StatusCode >= 300 {
+		result.ErrorKind, result.Error = ConnectivityErrorHTTP, fmt.Sprintf("unexpected status %s; is this an ollama server?", resp.Status)
+		return result, nil
+	}
+	var body struct {
+		Version string `json:"version"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&body); err != nil || body.Version == "" {
+		result.ErrorKind, result.Error = ConnectivityErrorHTTP, "the endpoint did not report an ollama version; is this an ollama server?"
+		return result, nil
+	}
+	result.Reachable = true
+	result.Version = body.Version
+	result.Compatibility, result.CompatibilityMessage = checkVersionCompatibility(body.Version)
+	result.Compatible = result.Compatibility == CompatibilitySupported
+	return result, nil
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+
+	"github.com/rancher-sandbox/rd-open-webui-docker-ext/installer/types"
+)
+
+// statExecutable checks whether the ollama executable exists at the given path.
+// A symbolic link that cannot be followed, such as one left dangling after its
+// target was removed, is not merely missing: the reason it is broken is
+// returned, so that it can be reported for repair.
+func statExecutable(executablePath string) (bool, string) {
+	_, err := os.Stat(executablePath)
+	if err == nil {
+		return true, ""
+	}
+	info, lstatErr := os.Lstat(executablePath)
+	if lstatErr != nil || info.Mode()&os.ModeSymlink == 0 {
+		return false, ""
+	}
+	target, readErr := os.Readlink(executablePath)
+	if readErr != nil {
+		return false, fmt.Sprintf("unreadable symbolic link: %s", readErr)
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return false, fmt.Sprintf("symbolic link to missing %s", target)
+	}
+	return false, fmt.Sprintf("symbolic link to %s: %s", target, err)
+}
+
+// extraExecutableCandidates returns the locations given by -search-path, in
+// order.  Each entry is either the path of an ollama executable, or a directory
+// containing one.
+func extraExecutableCandidates() []string {
+	executableName := "ollama"
+	if runtime.GOOS == "windows" {
+		executableName += ".exe"
+	}
+	var candidates []string
+	for _, entry := range filepath.SplitList(*searchPath) {
+		if entry == "" {
+			continue
+		}
+		candidate, err := filepath.Abs(entry)
+		if err != nil {
+			log.Printf("Ignoring search location %s: %s", entry, err)
+			continue
+		}
+		if !strings.EqualFold(filepath.Base(candidate), executableName) {
+			candidate = filepath.Join(candidate, executableName)
+		}
+		if !slices.Contains(candidates, candidate) {
+			candidates = append(candidates, candidate)
+		}
+	}
+	return candidates
+}
+
+// searchExecutable checks each location where ollama may be installed,
+// returning them all (in order) along with whether they exist.  Failing to
+// determine the default install location is an error, as otherwise a managed
+// install would be mistaken for a missing one.
+func searchExecutable(ctx context.Context, defaultOnly bool) ([]types.SearchedLocation, error) {
+	candidates, err := executableCandidates(ctx, defaultOnly)
+	if err != nil {
+		return nil, err
+	}
+	if !defaultOnly {
+		// Extra locations only add to where external installs are found; the
+		// managed install is always the default location.
+		for _, candidate := range extraExecutableCandidates() {
+			if !slices.Contains(candidates, candidate) {
+				candidates = append(candidates, candidate)
+			}
+		}
+	}
+	result := make([]types.SearchedLocation, 0, len(candidates))
+	for _, candidate := range candidates {
+		exists, broken := statExecutable(candidate)
+		result = append(result, types.SearchedLocation{Path: candidate, Exists: exists, Broken: broken})
+	}
+	return result, nil
+}
+
+// Find an existing install of ollama; if defaultOnly is false, this may include
+// externally installed copies of ollama, and the install chosen with the
+// select-install mode is preferred.  External copies of an unsupported version
+// are skipped if -incompatible-external says so.  If not found, returns empty
+// string.
+func findExecutable(ctx context.Context, defaultOnly bool) (string, error) {
+	if !defaultOnly {
+		state, err := loadState(ctx)
+		if err != nil {
+			return "", err
+		}
+		if state.SelectedExecutable != "" {
+			exists, broken := statExecutable(state.SelectedExecutable)
+			if exists {
+				return state.SelectedExecutable, nil
+			} else if broken != "" {
+				log.Printf("Selected ollama %s is broken (%s); searching for another", state.SelectedExecutable, broken)
+			} else {
+				log.Printf("Selected ollama %s no longer exists; searching for another", state.SelectedExecutable)
+			}
+		}
+	}
+	locations, err := searchExecutable(ctx, defaultOnly)
+	if err != nil {
+		return "", err
+	}
+	for i, location := range locations {
+		if location.Exists {
+			// The first location is the managed install; others are external.
+			if i > 0 && !acceptExternalExecutable(ctx, location.Path) {
+				continue
+			}
+			// Found an existing ollama
+			return location.Path, nil
+		}
+		if location.Broken != "" {
+			// Reinstalling repairs the managed install; an external one must
+			// be repaired by the user.
+			log.Printf("Warning: ignoring broken ollama install %s: %s", location.Path, location.Broken)
+		}
+	}
+	return "", nil
+}
+
+// findAllExecutables returns every distinct install of ollama, in search order.
+// Locations that are the same file (such as via a symbolic link) are reported
+// once, at the first location found.
+func findAllExecutables(ctx context.Context) ([]types.Install, error) {
+	locations, err := searchExecutable(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	managedPath, err := findExecutable(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+	selectedPath, err := findExecutable(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	var managedInfo, selectedInfo os.FileInfo
+	if managedPath != "" {
+		managedInfo, _ = os.Stat(managedPath)
+	}
+	if selectedPath != "" {
+		selectedInfo, _ = os.Stat(selectedPath)
+	}
+	sameFile := func(a, b os.FileInfo) bool {
+		return a != nil && b != nil && os.SameFile(a, b)
+	}
+
+	installs := []types.Install{}
+	var seen []os.FileInfo
+	for _, location := range locations {
+		if !location.Exists {
+			continue
+		}
+		info, err := os.Stat(location.Path)
+		if err != nil {
+			continue
+		}
+		duplicate := false
+		for _, other := range seen {
+			if os.SameFile(info, other) {
+				duplicate = true
+				break
+			}
+		}
+		if duplicate {
+			continue
+		}
+		seen = append(seen, info)
+		install := types.Install{
+			Path:     location.Path,
+			Managed:  sameFile(info, managedInfo),
+			Selected: sameFile(info, selectedInfo),
+		}
+		if install.Version, err = getExecutableVersion(ctx, location.Path); err != nil {
+			log.Printf("Failed to determine version of %s: %s", location.Path, err)
+		}
+		installs = append(installs, install)
+	}
+	return installs, nil
+}
+
+// selectExecutable chooses the install of ollama the extension uses, which
+// must be one of those found; an empty path reverts to the first one found.
+func selectExecutable(ctx context.Context, executablePath string) ([]types.Install, error) {
+	if executablePath != "" {
+		installs, err := findAllExecutables(ctx)
+		if err != nil {
+			return nil, err
+		}
+		info, err := os.Stat(executablePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to select ollama: %w", err)
+		}
+		found := false
+		for _, install := range installs {
+			if installInfo, err := os.Stat(install.Path); err == nil && os.SameFile(info, installInfo) {
+				executablePath = install.Path
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("failed to select ollama: %s is not a detected install", executablePath)
+		}
+	}
+	err := updateState(ctx, func(state *installerState) error {
+		state.SelectedExecutable = executablePath
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to record selected ollama: %w", err)
+	}
+	return findAllExecutables(ctx)
+}
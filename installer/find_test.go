@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestStatExecutable(t *testing.T) {
+	root := t.TempDir()
+	executable := filepath.Join(root, "ollama-real")
+	if err := os.WriteFile(executable, nil, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	valid := filepath.Join(root, "valid")
+	dangling := filepath.Join(root, "dangling")
+	symlinkOrSkip(t, executable, valid)
+	symlinkOrSkip(t, filepath.Join(root, "deleted"), dangling)
+
+	for _, tt := range []struct {
+		name       string
+		path       string
+		wantExists bool
+		wantBroken string
+	}{
+		{"file", executable, true, ""},
+		{"valid symlink", valid, true, ""},
+		{"dangling symlink", dangling, false, "symbolic link to missing " + filepath.Join(root, "deleted")},
+		{"missing", filepath.Join(root, "missing"), false, ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			exists, broken := statExecutable(tt.path)
+			if exists != tt.wantExists || broken != tt.wantBroken {
+				t.Errorf("statExecutable(%s) = %v, %q; want %v, %q", tt.path, exists, broken, tt.wantExists, tt.wantBroken)
+			}
+		})
+	}
+}
+
+func TestSearchExecutableReportsDanglingSymlink(t *testing.T) {
+	root := t.TempDir()
+	name := "ollama"
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	dangling := filepath.Join(root, name)
+	symlinkOrSkip(t, filepath.Join(root, "deleted"), dangling)
+	setForTest(t, searchPath, dangling)
+	setForTest(t, installDir, filepath.Join(root, "install"))
+
+	locations, err := searchExecutable(context.Background(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, location := range locations {
+		if location.Path != dangling {
+			continue
+		}
+		if location.Exists || !strings.Contains(location.Broken, "symbolic link to missing") {
+			t.Errorf("dangling symlink reported as exists=%v broken=%q", location.Exists, location.Broken)
+		}
+		return
+	}
+	t.Errorf("dangling symlink %s not searched: %+v", dangling, locations)
+}
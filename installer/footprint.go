@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/rancher-sandbox/rd-open-webui-docker-ext/installer/types"
+)
+
+// Categories of disk space used, as reported by the footprint mode.
+const (
+	FootprintInstall = "install" // The ollama install.
+	FootprintCache   = "cache"   // Cached release archives.
+	FootprintLogs    = "logs"    // The serve log and its rotated copies.
+	FootprintModels  = "models"  // Model blobs.
+)
+
+// directorySize returns the total size of the regular files within dir (or of
+// dir itself, if it is a file), without following symbolic links; a missing
+// directory is empty.  The excluded directories are skipped, so that space is
+// not counted twice when one category is nested within another.
+func directorySize(dir string, excluded ...string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			for _, exclude := range excluded {
+				if filepath.Clean(exclude) == path {
+					return filepath.SkipDir
+				}
+			}
+			return nil
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		info, err := entry.Info()
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		size += info.Size()
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to measure %s: %w", dir, err)
+	}
+	return size, nil
+}
+
+// getFootprint reports the disk space used by the install, cached archives,
+// serve logs and models, by category.  Where space can be reclaimed without
+// losing anything in use, the category says how much, and with which mode.
+func getFootprint(ctx context.Context) (*types.Footprint, error) {
+	result := &types.Footprint{SchemaVersion: types.SchemaVersion, Categories: []types.FootprintCategory{}}
+	add := func(category types.FootprintCategory) {
+		result.Categories = append(result.Categories, category)
+		result.TotalSize += category.Size
+	}
+
+	modelsDir, err := getModelsDirectory(ctx)
+	if err != nil {
+		return nil, err
+	}
+	installPath, err := getDefaultInstallLocation(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// Models may be kept within the install; they are counted separately.
+	installSize, err := directorySize(installPath, modelsDir)
+	if err != nil {
+		return nil, err
+	}
+	add(types.FootprintCategory{Name: FootprintInstall, Path: installPath, Size: installSize})
+
+	cache, err := listCache(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cacheCategory := types.FootprintCategory{Name: FootprintCache, Path: cache.Directory, ReclaimMode: string(ModeCacheClear)}
+	for _, entry := range cache.Entries {
+		cacheCategory.Size += entry.Size
+		if !entry.Installed {
+			cacheCategory.ReclaimableSize += entry.Size
+		}
+	}
+	add(cacheCategory)
+
+	logPath, err := getServeLogFile(ctx)
+	if err != nil {
+		return nil, err
+	}
+	logsCategory := types.FootprintCategory{Name: FootprintLogs, Path: logPath}
+	for _, file := range append([]string{logPath}, rotatedLogFiles(logPath)...) {
+		if info, err := os.Stat(file); err == nil {
+			logsCategory.Size += info.Size()
+		}
+	}
+	add(logsCategory)
+
+	models, err := getModelDiskUsage(ctx)
+	if err != nil {
+		return nil, err
+	}
+	add(types.FootprintCategory{
+		Name:            FootprintModels,
+		Path:            models.ModelsDir,
+		Size:            models.TotalSize + models.UnreferencedSize,
+		ReclaimableSize: models.UnreferencedSize,
+		ReclaimMode:     string(ModePrune),
+	})
+	return result, nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// generateRequest is a request to the ollama generate API.
+type generateRequest struct {
+	Model     string         `json:"model"`
+	Prompt    string         `json:"prompt"`
+	Stream    bool           `json:"stream"`
+	KeepAlive string         `json:"keep_alive,omitempty"`
+	Options   map[string]any `json:"options,omitempty"`
+}
+
+// generateResponse is a (non-streaming) response from the generate API.
+// Durations are in nanoseconds.
+type generateResponse struct {
+	Response      string `json:"response"`
+	Done          bool   `json:"done"`
+	TotalDuration int64  `json:"total_duration"`
+	LoadDuration  int64  `json:"load_duration"`
+	EvalCount     int    `json:"eval_count"`
+	EvalDuration  int64  `json:"eval_duration"`
+	Error         string `json:"error,omitempty"`
+}
+
+// generate runs a (non-streaming) generation on the running ollama server.
+func generate(ctx context.Context, request generateRequest) (*generateResponse, error) {
+	request.Stream = false
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate with %s: %w", request.Model, err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ollamaURL+"/api/generate", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate with %s: %w", request.Model, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate with %s: %w", request.Model, err)
+	}
+	defer resp.Body.Close()
+	var result generateResponse
+	if err = json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to generate with %s: error unmarshaling response (status %s): %w", request.Model, resp.Status, err)
+	}
+	if result.Error != "" {
+		return nil, fmt.Errorf("failed to generate with %s: %s", request.Model, result.Error)
+	}
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("failed to generate with %s: unexpected status %s", request.Model, resp.Status)
+	}
+	return &result, nil
+}
@@ -3,8 +3,8 @@ module github.com/rancher-sandbox/rd-open-webui-docker-ext/installer
 go 1.21.1
 
 require (
+	github.com/klauspost/compress v1.13.6
+	github.com/ulikunitz/xz v0.5.12
 	github.com/xenking/zipstream v1.0.1
 	golang.org/x/sys v0.29.0
 )
-
-require github.com/klauspost/compress v1.13.6 // indirect
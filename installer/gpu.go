@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+)
+
+// Accelerators that ollama may use.
+const (
+	AcceleratorCPU   = "cpu"
+	AcceleratorCUDA  = "cuda"
+	AcceleratorROCm  = "rocm"
+	AcceleratorMetal = "metal"
+)
+
+// assetSelection describes the release assets chosen for this machine.
+type assetSelection struct {
+	Assets        []string // Names of the release assets, in extraction order.
+	Accelerator   string   // The accelerator the assets were chosen for.
+	Detected      string   // The accelerator detected, which may differ if forced.
+	ForcedCPU     bool     // Whether the CPU build was forced by the user.
+	DriverVersion string   // Version of the GPU driver, if known.
+	Reason        string   // Why the accelerator differs from the one detected, if it does.
+}
+
+// newAssetSelection creates an asset selection for the detected accelerator,
+// applying the force-CPU override, and falling back to the CPU if the GPU
+// driver is too old for ollama.
+func newAssetSelection(ctx context.Context, detected string) *assetSelection {
+	selection := &assetSelection{Accelerator: detected, Detected: detected}
+	if *forceCPU && detected != AcceleratorCPU {
+		log.Printf("Ignoring detected %s acceleration: CPU was forced.", detected)
+		selection.Accelerator = AcceleratorCPU
+		selection.ForcedCPU = true
+		selection.Reason = "CPU was forced"
+		return selection
+	}
+	if detected == AcceleratorCUDA {
+		version, err := getNvidiaDriverVersion(ctx)
+		if err != nil {
+			// Without nvidia-smi we cannot tell; assume the driver works.
+			log.Printf("Could not check NVIDIA driver version: %s", err)
+		} else {
+			selection.DriverVersion = version
+			if ok, err := checkNvidiaDriverVersion(version); err != nil {
+				log.Printf("Could not check NVIDIA driver version: %s", err)
+			} else if !ok {
+				selection.Accelerator = AcceleratorCPU
+				selection.Reason = fmt.Sprintf("NVIDIA driver %s is older than the required %s", version, *minNvidiaDriver)
+				log.Printf("Warning: ignoring detected %s acceleration: %s.", detected, selection.Reason)
+				return selection
+			}
+		}
+	}
+	log.Printf("Selecting ollama build for %s.", detected)
+	return selection
+}
+
+// getNvidiaDriverVersion returns the installed NVIDIA driver version, such as
+// "535.104.05", as reported by nvidia-smi.  With several GPUs, the first is
+// used, as they share a driver.
+func getNvidiaDriverVersion(ctx context.Context) (string, error) {
+	output, err := exec.CommandContext(ctx, "nvidia-smi", "--query-gpu=driver_version", "--format=csv,noheader").Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to run nvidia-smi: %w", err)
+	}
+	version, _, _ := strings.Cut(strings.TrimSpace(string(output)), "\n")
+	if version == "" {
+		return "", fmt.Errorf("nvidia-smi did not report a driver version")
+	}
+	return strings.TrimSpace(version), nil
+}
+
+// checkNvidiaDriverVersion reports whether the given NVIDIA driver version is at
+// least -min-nvidia-driver.
+func checkNvidiaDriverVersion(version string) (bool, error) {
+	parsed, err := parseVersion(version)
+	if err != nil {
+		return false, err
+	}
+	minimum, err := parseVersion(*minNvidiaDriver)
+	if err != nil {
+		return false, fmt.Errorf("invalid minimum NVIDIA driver version: %w", err)
+	}
+	return compareVersionPrefix(parsed, minimum) >= 0, nil
+}
+
+// cpuOnlyEnvironment returns environment variables to make ollama ignore any
+// GPUs, if the CPU was selected despite a GPU being detected.  This is needed
+// where the installed build includes GPU support regardless.
+func cpuOnlyEnvironment(selection *assetSelection) map[string]string {
+	if selection.Accelerator != AcceleratorCPU || selection.Detected == AcceleratorCPU {
+		return nil
+	}
+	// Ollama documents using an invalid GPU ID to force CPU usage.
+	return map[string]string{"CUDA_VISIBLE_DEVICES": "-1", "ROCR_VISIBLE_DEVICES": "-1"}
+}
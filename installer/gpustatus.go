@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/rancher-sandbox/rd-open-webui-docker-ext/installer/types"
+)
+
+var (
+	// logFieldPattern matches the key=value fields of ollama's structured log
+	// lines; values containing spaces are quoted.
+	logFieldPattern = regexp.MustCompile(`(\w+)=("(?:[^"\\]|\\.)*"|\S+)`)
+	// gpuMessagePattern matches log messages that may explain why the server
+	// is not using the GPU.
+	gpuMessagePattern = regexp.MustCompile(`(?i)gpu|cuda|rocm|vram|driver|nvidia|amdgpu|metal`)
+)
+
+// parseLogFields returns the fields of a structured server log line.
+func parseLogFields(line string) map[string]string {
+	fields := make(map[string]string)
+	for _, match := range logFieldPattern.FindAllStringSubmatch(line, -1) {
+		value := match[2]
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		}
+		fields[match[1]] = value
+	}
+	return fields
+}
+
+// serverStartupInfo is what the server logged about its compute devices when
+// it last started.
+type serverStartupInfo struct {
+	devices  []types.InferenceDevice
+	warnings []string // GPU related warnings, oldest first.
+}
+
+// parseServerStartup reads the given server log, returning the compute devices
+// (and any GPU related warnings) logged since the server last started.
+func parseServerStartup(logPath string) (*serverStartupInfo, error) {
+	file, err := os.Open(logPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info := &serverStartupInfo{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for scanner.Scan() {
+		if !slogLinePattern.MatchString(scanner.Text()) {
+			continue
+		}
+		fields := parseLogFields(scanner.Text())
+		msg := fields["msg"]
+		switch {
+		case strings.HasPrefix(msg, "Listening on"):
+			// The server (re)started; only what follows is current.
+			info = &serverStartupInfo{}
+		case msg == "inference compute":
+			info.devices = append(info.devices, types.InferenceDevice{
+				ID:          fields["id"],
+				Library:     fields["library"],
+				Name:        fields["name"],
+				Driver:      fields["driver"],
+				TotalMemory: fields["total"],
+			})
+		case (fields["level"] != "INFO" && fields["level"] != "DEBUG") || strings.Contains(msg, "no compatible GPUs"):
+			if gpuMessagePattern.MatchString(msg) {
+				info.warnings = append(info.warnings, msg)
+			}
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", logPath, err)
+	}
+	return info, nil
+}
+
+// listLoadedModels returns the models the running server has loaded, with how
+// much of each is in VRAM.
+func listLoadedModels(ctx context.Context) ([]types.LoadedModel, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ollamaURL+"/api/ps", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list loaded models: %w", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list loaded models: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("failed to list loaded models: unexpected status %s", resp.Status)
+	}
+	var body struct {
+		Models []struct {
+			Name     string `json:"name"`
+			Size     int64  `json:"size"`
+			SizeVRAM int64  `json:"size_vram"`
+		} `json:"models"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("failed to list loaded models: error unmarshaling response: %w", err)
+	}
+	models := make([]types.LoadedModel, 0, len(body.Models))
+	for _, model := range body.Models {
+		models = append(models, types.LoadedModel{Name: model.Name, Size: model.Size, VRAMSize: model.SizeVRAM})
+	}
+	return models, nil
+}
+
+// getGPUStatus reports whether the running server actually runs inference on
+// the GPU, as opposed to which build was installed: the server may fall back to
+// the CPU if, for example, the driver is too old.  Loaded models are the most
+// direct evidence; otherwise, the devices the server logged at startup are used.
+func getGPUStatus(ctx context.Context) (*types.GPUStatus, error) {
+	if err := checkHealth(ctx, *healthTimeout); err != nil {
+		return nil, fmt.Errorf("ollama is not running: %w", err)
+	}
+	status := &types.GPUStatus{SchemaVersion: types.SchemaVersion}
+	selection, err := selectAsset(ctx)
+	if err != nil {
+		return nil, err
+	}
+	status.InstalledAccelerator = selection.Accelerator
+
+	startup := &serverStartupInfo{}
+	for _, logPath := range serverLogCandidates(ctx) {
+		info, err := parseServerStartup(logPath)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if len(info.devices) > 0 {
+			startup, status.LogFile = info, logPath
+			break
+		}
+	}
+	status.Devices = startup.devices
+	for _, device := range startup.devices {
+		if device.Library != AcceleratorCPU {
+			status.Backend = device.Library
+			break
+		}
+		status.Backend = AcceleratorCPU
+	}
+
+	if status.LoadedModels, err = listLoadedModels(ctx); err != nil {
+		return nil, err
+	}
+	if len(status.LoadedModels) > 0 {
+		for _, model := range status.LoadedModels {
+			status.UsingGPU = status.UsingGPU || model.VRAMSize > 0
+		}
+		if !status.UsingGPU {
+			status.Backend = AcceleratorCPU
+		}
+	} else {
+		status.UsingGPU = status.Backend != "" && status.Backend != AcceleratorCPU
+	}
+
+	if !status.UsingGPU && status.InstalledAccelerator != AcceleratorCPU {
+		if len(startup.warnings) > 0 {
+			status.Reason = startup.warnings[len(startup.warnings)-1]
+		} else if status.Backend == AcceleratorCPU {
+			status.Reason = "the server found no usable GPU"
+		} else {
+			status.Reason = "the server log does not say which devices it uses; load a model to check"
+		}
+	}
+	return status, nil
+}
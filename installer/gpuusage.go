@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rancher-sandbox/rd-open-webui-docker-ext/installer/types"
+)
+
+// gpuSample is a single measurement of GPU usage.
+type gpuSample struct {
+	Utilization int   // Percent.
+	VRAMUsed    int64 // Bytes.
+}
+
+// sampleNvidiaGPU measures GPU usage via nvidia-smi, using the busiest GPU if
+// there are several.
+func sampleNvidiaGPU(ctx context.Context) (gpuSample, error) {
+	output, err := exec.CommandContext(ctx, "nvidia-smi",
+		"--query-gpu=utilization.gpu,memory.used",
+		"--format=csv,noheader,nounits").Output()
+	if err != nil {
+		return gpuSample{}, fmt.Errorf("failed to run nvidia-smi: %w", err)
+	}
+	var result gpuSample
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		utilization, memory, ok := strings.Cut(line, ",")
+		if !ok {
+			continue
+		}
+		util, err := strconv.Atoi(strings.TrimSpace(utilization))
+		if err != nil {
+			continue
+		}
+		// Memory is reported in MiB.
+		mib, err := strconv.ParseInt(strings.TrimSpace(memory), 10, 64)
+		if err != nil {
+			continue
+		}
+		result.Utilization = max(result.Utilization, util)
+		result.VRAMUsed = max(result.VRAMUsed, mib<<20)
+	}
+	return result, nil
+}
+
+// sampleAMDGPU measures GPU usage via rocm-smi, using the busiest GPU if there
+// are several.
+func sampleAMDGPU(ctx context.Context) (gpuSample, error) {
+	output, err := exec.CommandContext(ctx, "rocm-smi", "--showuse", "--showmeminfo", "vram", "--json").Output()
+	if err != nil {
+		return gpuSample{}, fmt.Errorf("failed to run rocm-smi: %w", err)
+	}
+	var cards map[string]map[string]string
+	if err = json.Unmarshal(output, &cards); err != nil {
+		return gpuSample{}, fmt.Errorf("failed to parse rocm-smi output: %w", err)
+	}
+	var result gpuSample
+	for _, card := range cards {
+		if util, err := strconv.Atoi(card["GPU use (%)"]); err == nil {
+			result.Utilization = max(result.Utilization, util)
+		}
+		if used, err := strconv.ParseInt(card["VRAM Total Used Memory (B)"], 10, 64); err == nil {
+			result.VRAMUsed = max(result.VRAMUsed, used)
+		}
+	}
+	return result, nil
+}
+
+// measureGPUUsage runs a test generation with the given model, sampling GPU
+// usage while it runs.  This confirms whether inference actually uses the GPU.
+func measureGPUUsage(ctx context.Context, model string) (*types.GPUUsage, error) {
+	usage := &types.GPUUsage{SchemaVersion: types.SchemaVersion, Accelerator: detectAccelerator(), Model: model}
+	var sample func(context.Context) (gpuSample, error)
+	switch usage.Accelerator {
+	case AcceleratorCUDA:
+		sample = sampleNvidiaGPU
+	case AcceleratorROCm:
+		sample = sampleAMDGPU
+	default:
+		return nil, fmt.Errorf("no supported GPU detected (found %s)", usage.Accelerator)
+	}
+	if _, err := sample(ctx); err != nil {
+		return nil, err
+	}
+
+	done := make(chan error, 1)
+	start := time.Now()
+	go func() {
+		_, err := generate(ctx, generateRequest{
+			Model:  model,
+			Prompt: "Write a short paragraph about the ocean.",
+		})
+		done <- err
+	}()
+
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-done:
+			if err != nil {
+				return nil, err
+			}
+			usage.Duration = time.Since(start).String()
+			return usage, nil
+		case <-ticker.C:
+			result, err := sample(ctx)
+			if err != nil {
+				log.Printf("Ignoring failure to sample GPU usage: %s", err)
+				continue
+			}
+			usage.Samples++
+			usage.PeakUtilization = max(usage.PeakUtilization, result.Utilization)
+			usage.PeakVRAMBytes = max(usage.PeakVRAMBytes, result.VRAMUsed)
+		}
+	}
+}
+
+// Print the GPU usage during a test generation as JSON.
+func printGPUUsage(ctx context.Context, model string) error {
+	usage, err := measureGPUUsage(ctx, model)
+	if err != nil {
+		return err
+	}
+	return printJSON(usage)
+}
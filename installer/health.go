@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+// ErrServerNotStarted is returned by health checks when nothing is listening,
+// such as when ollama has not been started (or has not opened its port yet).
+var ErrServerNotStarted = errors.New("ollama is not running")
+
+// ErrServerTimeout is returned by health checks when ollama does not respond
+// in time, such as when it is starting up or overloaded.
+var ErrServerTimeout = errors.New("ollama did not respond in time")
+
+// checkHealth checks once whether the ollama server responds, waiting at most
+// the given time for a response.
+func checkHealth(ctx context.Context, timeout time.Duration) error {
+	checkCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(checkCtx, http.MethodGet, checkURL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to check Ollama: %w", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	var opErr *net.OpError
+	if err != nil && ctx.Err() != nil {
+		// We were cancelled, rather than the check timing out.
+		return fmt.Errorf("failed to check Ollama: %w", ctx.Err())
+	} else if errors.As(err, &opErr) && opErr.Op == "dial" && !opErr.Timeout() {
+		// Connection refused (or similar): nothing is listening.
+		return fmt.Errorf("failed to check Ollama: %w: %w", ErrServerNotStarted, err)
+	} else if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, os.ErrDeadlineExceeded) {
+		return fmt.Errorf("failed to check Ollama: %w after %s", ErrServerTimeout, timeout)
+	} else if err != nil {
+		return fmt.Errorf("failed to check Ollama: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("failed to check Ollama: unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// waitForHealthy polls the ollama server until it responds, or the given time
+// has passed; the last health check failure is returned in the latter case.
+func waitForHealthy(ctx context.Context, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		err := checkHealth(ctx, *healthTimeout)
+		if err == nil {
+			return nil
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return fmt.Errorf("failed to wait for Ollama: %w", ctxErr)
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("ollama did not become healthy within %s: %w", timeout, err)
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("failed to wait for Ollama: %w", ctx.Err())
+		case <-time.After(time.Second):
+		}
+	}
+}
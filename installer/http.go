@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dialContext is used to make connections for the download client; it may be
+// replaced to redirect connections (for example, to a socket-backed proxy).
+var dialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second, FallbackDelay: *fallbackDelay}
+	return dialer.DialContext(ctx, restrictNetwork(network), address)
+}
+
+// IP families that downloads may be restricted to, via -ip-family.
+const (
+	IPFamilyAny  = ""     // Dual-stack, preferring IPv6 with a fallback to IPv4.
+	IPFamilyIPv4 = "ipv4" // Only IPv4, for networks where IPv6 is broken.
+	IPFamilyIPv6 = "ipv6" // Only IPv6.
+)
+
+// restrictNetwork returns the network to dial given the requested one, taking
+// -ip-family into account.
+func restrictNetwork(network string) string {
+	if network != "tcp" {
+		return network
+	}
+	switch ipFamily {
+	case IPFamilyIPv4:
+		return "tcp4"
+	case IPFamilyIPv6:
+		return "tcp6"
+	}
+	return network
+}
+
+// downloadClient returns the HTTP client for requests leaving the machine, that
+// is, for release information and assets.  Requests to the local ollama server
+// use the default client.
+var downloadClient = sync.OnceValue(func() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialContext
+	if *dialSocket != "" {
+		socket := *dialSocket
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", socket)
+		}
+	}
+	if len(mirrorPins) > 0 {
+		transport.TLSClientConfig = &tls.Config{VerifyConnection: verifyMirrorPin}
+	}
+	if *proxyAuthorization != "" {
+		// Sent when tunneling HTTPS through the proxy.  Basic credentials in
+		// the proxy URL, if any, take precedence.
+		transport.ProxyConnectHeader = http.Header{"Proxy-Authorization": {*proxyAuthorization}}
+		return &http.Client{Transport: &proxyAuthTransport{Transport: transport, authorization: *proxyAuthorization}}
+	}
+	return &http.Client{Transport: transport}
+})
+
+// proxyAuthTransport adds the Proxy-Authorization header to plain HTTP requests
+// sent through a proxy.  It is only added when a proxy is used, so that the
+// credentials are never sent to the server itself.
+type proxyAuthTransport struct {
+	*http.Transport
+	authorization string
+}
+
+func (t *proxyAuthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme == "http" && t.Proxy != nil {
+		proxyURL, err := t.Proxy(req)
+		if err != nil {
+			return nil, err
+		}
+		if proxyURL != nil {
+			req = req.Clone(req.Context())
+			req.Header.Set("Proxy-Authorization", t.authorization)
+		}
+	}
+	return t.Transport.RoundTrip(req)
+}
+
+// installerVersion is the version of the extension, set at build time with
+// -ldflags "-X main.installerVersion=...".
+var installerVersion = "dev"
+
+// defaultUserAgent returns the User-Agent identifying the installer.
+func defaultUserAgent() string {
+	return fmt.Sprintf("rd-open-webui-installer/%s (%s/%s)", installerVersion, runtime.GOOS, runtime.GOARCH)
+}
+
+// headerFlag collects "Name: value" headers from a repeatable flag.
+type headerFlag http.Header
+
+func (f headerFlag) String() string {
+	var headers []string
+	for name, values := range f {
+		for _, value := range values {
+			headers = append(headers, name+": "+value)
+		}
+	}
+	return strings.Join(headers, ", ")
+}
+
+func (f headerFlag) Set(value string) error {
+	name, val, ok := strings.Cut(value, ":")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" || strings.ContainsAny(name, " \t") {
+		return fmt.Errorf(`expected "Name: value", got %q`, value)
+	}
+	val = strings.TrimSpace(val)
+	if strings.ContainsAny(val, "\r\n") {
+		return fmt.Errorf("invalid value for header %s", name)
+	}
+	name = textproto.CanonicalMIMEHeaderKey(name)
+	switch name {
+	case "Host", "Authorization":
+		// The mirror token is the only credential we send.
+		return fmt.Errorf("header %s may not be set", name)
+	case "User-Agent":
+		return fmt.Errorf("use -user-agent to set the User-Agent")
+	}
+	http.Header(f).Add(name, val)
+	return nil
+}
+
+// newAssetRequest creates a request for a release asset.  Assets are already
+// compressed, and are verified against their checksum as downloaded, so they
+// are requested without any content encoding.
+func newAssetRequest(ctx context.Context, method, assetURL string) (*http.Request, error) {
+	req, err := newRequest(ctx, method, assetURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept-Encoding", "identity")
+	return req, nil
+}
+
+// parseCertificatePin parses a certificate pin, in the form "sha256/<base64>"
+// used by HTTP Public Key Pinning.  The pin of a server's certificate can be
+// obtained with:
+//
+//	openssl s_client -connect <host>:443 </dev/null | openssl x509 -pubkey -noout |
+//	  openssl pkey -pubin -outform der | openssl dgst -sha256 -binary | base64
+func parseCertificatePin(value string) ([]byte, error) {
+	encoded, ok := strings.CutPrefix(value, "sha256/")
+	if !ok {
+		return nil, fmt.Errorf("invalid certificate pin %q: expected sha256/<base64>", value)
+	}
+	pin, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(pin) != sha256.Size {
+		return nil, fmt.Errorf("invalid certificate pin %q: expected a base64 SHA-256 hash", value)
+	}
+	return pin, nil
+}
+
+// verifyMirrorPin checks that, for connections to the mirror, the certificate
+// chain presented contains a public key matching one of -mirror-pin.  This is
+// in addition to the usual certificate verification; connections to any other
+// host (such as GitHub) are not pinned.
+func verifyMirrorPin(state tls.ConnectionState) error {
+	base, err := url.Parse(*mirrorURL)
+	if err != nil || *mirrorURL == "" {
+		return nil
+	}
+	host := base.Hostname()
+	if net.ParseIP(host) != nil {
+		// No server name is sent for IP addresses, so pin all of them.
+		host = ""
+	}
+	if !strings.EqualFold(state.ServerName, host) {
+		return nil
+	}
+	for _, cert := range state.PeerCertificates {
+		hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+		for _, pin := range mirrorPins {
+			if bytes.Equal(hash[:], pin) {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("certificate of mirror %s does not match any -mirror-pin", base.Host)
+}
+
+// isMirrorURL reports whether the given URL is within the configured mirror.
+func isMirrorURL(target *url.URL) bool {
+	if *mirrorURL == "" {
+		return false
+	}
+	base, err := url.Parse(*mirrorURL)
+	if err != nil {
+		return false
+	}
+	if !strings.EqualFold(target.Scheme, base.Scheme) || !strings.EqualFold(target.Host, base.Host) {
+		return false
+	}
+	prefix := strings.TrimSuffix(base.Path, "/") + "/"
+	return strings.HasPrefix(target.Path, prefix)
+}
+
+// newRequest creates an HTTP request for a request leaving the machine, with
+// the configured User-Agent and extra headers.  If the URL is within the
+// configured mirror, the mirror token is attached; it is never sent anywhere
+// else, and never over plain HTTP.
+//
+// Accept-Encoding is deliberately left unset, so that the transport requests
+// gzip and transparently decompresses API responses; see newAssetRequest for
+// assets, which must not be decompressed.
+func newRequest(ctx context.Context, method, rawURL string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, body)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range extraHeaders {
+		req.Header[name] = append([]string(nil), values...)
+	}
+	req.Header.Set("User-Agent", *userAgent)
+	if *mirrorToken != "" && isMirrorURL(req.URL) {
+		if req.URL.Scheme != "https" {
+			return nil, fmt.Errorf("refusing to send mirror token to %s: mirror must use https", req.URL.Redacted())
+		}
+		req.Header.Set("Authorization", "Bearer "+*mirrorToken)
+	}
+	return req, nil
+}
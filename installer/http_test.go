@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// gzipServer serves the given body gzip-encoded if the client accepts it, and
+// as is otherwise, recording the Accept-Encoding of the last request.
+func gzipServer(t *testing.T, body []byte, acceptEncoding *string) *httptest.Server {
+	t.Helper()
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	if _, err := writer.Write(body); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*acceptEncoding = r.Header.Get("Accept-Encoding")
+		w.Header().Set("Content-Type", "application/json")
+		if strings.Contains(*acceptEncoding, "gzip") {
+			w.Header().Set("Content-Encoding", "gzip")
+			_, _ = w.Write(compressed.Bytes())
+		} else {
+			_, _ = w.Write(body)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestNewRequestDecodesGzipResponse(t *testing.T) {
+	want := []assetInfo{{Name: "ollama-linux-amd64.tgz"}, {Name: "ollama-darwin.tgz"}}
+	body, err := json.Marshal(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var acceptEncoding string
+	server := gzipServer(t, body, &acceptEncoding)
+
+	req, err := newRequest(context.Background(), http.MethodGet, server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := downloadClient().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if !strings.Contains(acceptEncoding, "gzip") {
+		t.Errorf("request Accept-Encoding = %q, want gzip", acceptEncoding)
+	}
+	if !resp.Uncompressed {
+		t.Error("response was not transparently decompressed")
+	}
+	var got []assetInfo
+	if err = json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatalf("failed to decode response: %s", err)
+	}
+	if len(got) != len(want) || got[0].Name != want[0].Name || got[1].Name != want[1].Name {
+		t.Errorf("decoded %+v, want %+v", got, want)
+	}
+}
+
+func TestNewAssetRequestIsNotDecompressed(t *testing.T) {
+	body := []byte("already compressed asset")
+	var acceptEncoding string
+	server := gzipServer(t, body, &acceptEncoding)
+
+	req, err := newAssetRequest(context.Background(), http.MethodGet, server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := downloadClient().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if acceptEncoding != "identity" {
+		t.Errorf("request Accept-Encoding = %q, want identity", acceptEncoding)
+	}
+	got, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, body) {
+		t.Errorf("asset body = %q, want %q", got, body)
+	}
+}
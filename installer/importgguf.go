@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rancher-sandbox/rd-open-webui-docker-ext/installer/types"
+)
+
+// ggufMagic is the start of every GGUF model file.
+const ggufMagic = "GGUF"
+
+// maxGGUFVersion is the newest GGUF format version ollama reads.
+const maxGGUFVersion = 3
+
+// checkGGUF checks that the file at the given path is a GGUF model file of a
+// version ollama can read, returning its size.
+func checkGGUF(path string) (int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	var header struct {
+		Magic   [4]byte
+		Version uint32
+	}
+	if err = binary.Read(file, binary.LittleEndian, &header); err != nil || string(header.Magic[:]) != ggufMagic {
+		return 0, fmt.Errorf("%s is not a GGUF file", path)
+	}
+	if header.Version < 1 || header.Version > maxGGUFVersion {
+		return 0, fmt.Errorf("%s is GGUF version %d, which is not supported", path, header.Version)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// uploadBlob uploads the given file to the running ollama server as a blob,
+// unless the server already has it, returning its digest.
+func uploadBlob(ctx context.Context, path string, size int64) (string, error) {
+	checksum, err := hashFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash %s: %w", path, err)
+	}
+	digest := "sha256:" + checksum
+	blobURL := ollamaURL + "/api/blobs/" + digest
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, blobURL, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to check for blob %s: %w", digest, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		log.Printf("Ollama already has %s", filepath.Base(path))
+		return digest, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	reporter := newProgressReporter(PhaseUpload, size)
+	reporter.setFile(filepath.Base(path))
+	req, err = http.NewRequestWithContext(ctx, http.MethodPost, blobURL, &progressReader{Reader: file, reporter: reporter})
+	if err != nil {
+		return "", err
+	}
+	req.ContentLength = size
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to upload %s: %w", path, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		return "", fmt.Errorf("failed to upload %s: unexpected status %s: %s", path, resp.Status, strings.TrimSpace(string(message)))
+	}
+	reporter.done()
+	return digest, nil
+}
+
+// isLocalModelSource returns whether an import source names a local file, as
+// opposed to a model in a registry.
+func isLocalModelSource(source string) bool {
+	return filepath.IsAbs(source) || strings.HasPrefix(source, ".") || strings.HasPrefix(source, "~") ||
+		strings.ContainsRune(source, '\\') || strings.HasSuffix(strings.ToLower(source), ".gguf")
+}
+
+// importModelSource creates the named model from a GGUF file, or from a model
+// in a registry (such as an OCI registry reference, "registry.example.com/
+// namespace/model:tag"), without pulling it from ollama's own registry.
+func importModelSource(ctx context.Context, name, source string) (*types.ModelImport, error) {
+	name = normalizeModelName(name)
+	if _, err := modelManifestPath(name); err != nil {
+		return nil, fmt.Errorf("failed to import %s: %w", name, err)
+	}
+	result := &types.ModelImport{SchemaVersion: types.SchemaVersion, Model: name, Source: source}
+	logProgress := func(update pullProgress) {
+		log.Printf("%s", update.Status)
+	}
+
+	if _, err := os.Stat(source); errors.Is(err, os.ErrNotExist) && !isLocalModelSource(source) {
+		log.Printf("Importing %s from %s...", name, source)
+		if err = createModel(ctx, name, "FROM "+source, logProgress); err != nil {
+			return nil, err
+		}
+		return result, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to import %s: %w", name, err)
+	}
+
+	size, err := checkGGUF(source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to import %s: %w", name, err)
+	}
+	result.Size = size
+	log.Printf("Importing %s from %s...", name, source)
+	if result.Digest, err = uploadBlob(ctx, source, size); err != nil {
+		return nil, fmt.Errorf("failed to import %s: %w", name, err)
+	}
+	request := map[string]any{"model": name, "stream": true}
+	version, err := getRunningVersion(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to import %s: %w", name, err)
+	}
+	if parsedVersion, err := parseVersion(version); err == nil && compareVersionPrefix(parsedVersion, structuredCreateVersion) >= 0 {
+		request["files"] = map[string]string{filepath.Base(source): result.Digest}
+	} else {
+		// Older servers take a Modelfile referring to the uploaded blob.
+		request["modelfile"] = "FROM @" + result.Digest
+	}
+	if err = postCreate(ctx, name, request, logProgress); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/rancher-sandbox/rd-open-webui-docker-ext/installer/types"
+)
+
+// Problems found with the links of an install.
+const (
+	LinkProblemMissing     = "missing"      // The link does not exist.
+	LinkProblemCopied      = "copied"       // The link was replaced by a copy of its target.
+	LinkProblemWrongTarget = "wrong-target" // The symbolic link points elsewhere.
+)
+
+// archiveLinks reads the (hard and symbolic) links in the given compressed tar
+// archive, keyed by their cleaned path within the archive; links in later
+// archives replace those of the same name, as when extracting.
+func archiveLinks(archivePath string, links map[string]tar.Header) error {
+	archive, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+	decompressor, err := newDecompressor(archive)
+	if err != nil {
+		return err
+	}
+	defer decompressor.Close()
+	tarReader := tar.NewReader(decompressor)
+	for {
+		header, err := tarReader.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		name := path.Clean(header.Name)
+		switch header.Typeflag {
+		case tar.TypeLink, tar.TypeSymlink:
+			links[name] = *header
+		default:
+			// A later archive may replace a link with a file.
+			delete(links, name)
+		}
+	}
+}
+
+// checkLink reports what is wrong with the given link in the install at root,
+// or the empty string if nothing is.
+func checkLink(root string, link *tar.Header) (string, error) {
+	linkPath := filepath.Join(root, filepath.FromSlash(link.Name))
+	info, err := os.Lstat(linkPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return LinkProblemMissing, nil
+	} else if err != nil {
+		return "", err
+	}
+	if link.Typeflag == tar.TypeSymlink {
+		if info.Mode()&os.ModeSymlink == 0 {
+			return LinkProblemCopied, nil
+		}
+		target, err := os.Readlink(linkPath)
+		if err != nil {
+			return "", err
+		}
+		if target != link.Linkname {
+			return LinkProblemWrongTarget, nil
+		}
+		return "", nil
+	}
+	targetInfo, err := os.Lstat(filepath.Join(root, filepath.FromSlash(link.Linkname)))
+	if err != nil {
+		return "", fmt.Errorf("failed to check link target: %w", err)
+	}
+	if !os.SameFile(info, targetInfo) {
+		return LinkProblemCopied, nil
+	}
+	return "", nil
+}
+
+// recreateLink replaces whatever is at the path of the given link in the
+// install at root with the link itself.
+func recreateLink(root string, link *tar.Header) error {
+	linkPath := filepath.Join(root, filepath.FromSlash(link.Name))
+	if info, err := os.Lstat(linkPath); err == nil && info.IsDir() {
+		return fmt.Errorf("%s is a directory", linkPath)
+	}
+	if err := os.MkdirAll(filepath.Dir(linkPath), 0o755); err != nil {
+		return err
+	}
+	// Create the link next to its final location, so that the replacement is
+	// atomic and a failure leaves the existing file in place.
+	tempPath := linkPath + ".repair"
+	_ = os.Remove(tempPath)
+	var err error
+	if link.Typeflag == tar.TypeLink {
+		err = createHardLink(filepath.Join(root, filepath.FromSlash(link.Linkname)), tempPath)
+	} else {
+		err = createSymlink(link.Linkname, tempPath)
+	}
+	if err != nil {
+		return err
+	}
+	if err = os.Rename(tempPath, linkPath); err != nil {
+		_ = os.Remove(tempPath)
+		return err
+	}
+	return nil
+}
+
+// repairLinks checks that the links recorded in the cached archives of the
+// managed install still exist and point where they should, such as after a
+// backup tool flattened them into copies, recreating those that do not.  If
+// dryRun is set, problems are only reported.  Nothing is downloaded; if the
+// archives are no longer cached, the install must be reinstalled instead.
+func repairLinks(ctx context.Context, dryRun bool) (*types.LinkRepairResult, error) {
+	installPath, err := getDefaultInstallLocation(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkInstallLocation(installPath); err != nil {
+		return nil, err
+	}
+	if info, err := os.Stat(installPath); err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("failed to repair links: ollama is not installed at %s", installPath)
+	}
+	archives, err := findInstalledArchives(ctx)
+	if errors.Is(err, errArchivesNotCached) || errors.Is(err, errNotFromArchives) {
+		return nil, fmt.Errorf("failed to repair links: %w", err)
+	} else if err != nil {
+		return nil, err
+	}
+	byName := make(map[string]tar.Header)
+	for _, archivePath := range archives {
+		if err = archiveLinks(archivePath, byName); err != nil {
+			return nil, fmt.Errorf("failed to read links from %s: %w", archivePath, err)
+		}
+	}
+	links := make([]tar.Header, 0, len(byName))
+	for _, link := range byName {
+		if !filepath.IsLocal(filepath.FromSlash(link.Name)) || checkLinkTarget(&link) != nil {
+			continue
+		}
+		links = append(links, link)
+	}
+	// Repair links in dependency order, so that hard links are compared with
+	// (and recreated from) targets that have been repaired themselves.
+	levels, err := orderLinks(links)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &types.LinkRepairResult{SchemaVersion: types.SchemaVersion, DryRun: dryRun, Repaired: []types.LinkRepair{}}
+	for _, level := range levels {
+		for _, link := range level {
+			if err = ctx.Err(); err != nil {
+				return nil, err
+			}
+			result.Checked++
+			problem, err := checkLink(installPath, link)
+			if err != nil {
+				return nil, fmt.Errorf("failed to check link %s: %w", link.Name, err)
+			}
+			if problem == "" {
+				continue
+			}
+			repair := types.LinkRepair{
+				Path:     filepath.Join(installPath, filepath.FromSlash(link.Name)),
+				Target:   link.Linkname,
+				Symbolic: link.Typeflag == tar.TypeSymlink,
+				Problem:  problem,
+			}
+			if !dryRun {
+				if err = recreateLink(installPath, link); err != nil {
+					return nil, fmt.Errorf("failed to repair link %s: %w", repair.Path, err)
+				}
+				log.Printf("Repaired link %s (%s)", repair.Path, problem)
+			}
+			result.Repaired = append(result.Repaired, repair)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rancher-sandbox/rd-open-webui-docker-ext/installer/types"
+)
+
+// lockOwner is the contents of a lock file: the process holding the lock.
+type lockOwner struct {
+	PID        int       `json:"pid"`
+	AcquiredAt time.Time `json:"acquiredAt"`
+}
+
+// readLockOwner returns the process holding the given lock.  This fails for a
+// lock that is still being written, or was taken by an older installer.
+func readLockOwner(path string) (*lockOwner, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	owner := &lockOwner{}
+	if err = json.Unmarshal(contents, owner); err != nil {
+		return nil, fmt.Errorf("failed to read lock %s: %w", path, err)
+	}
+	if owner.PID <= 0 {
+		return nil, fmt.Errorf("failed to read lock %s: no owner recorded", path)
+	}
+	return owner, nil
+}
+
+// staleBreakerAge is how old the breaker file of a lock (see clearStaleLock)
+// must be before it is assumed to have been left behind by a process that
+// crashed while clearing the lock; clearing a lock only takes moments.
+const staleBreakerAge = 30 * time.Second
+
+// clearStaleLock removes the given lock, which is held by the given process
+// that has exited.  Other processes may be clearing the same lock, and one may
+// then take it, so clearing is serialized by exclusively creating a breaker
+// file beside the lock, and the lock is only removed if it still records the
+// stale owner.  A lock that may be live is never moved or replaced.  Returns
+// whether it was removed.
+func clearStaleLock(path string, owner *lockOwner) bool {
+	breaker := path + ".breaker"
+	file, err := os.OpenFile(breaker, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		if info, statErr := os.Stat(breaker); statErr == nil && time.Since(info.ModTime()) > staleBreakerAge {
+			log.Printf("Removing %s, left behind while clearing a stale lock", breaker)
+			_ = os.Remove(breaker)
+		}
+		return false
+	}
+	file.Close()
+	defer os.Remove(breaker)
+	// While the breaker is held, only the (exited) owner could remove the
+	// lock, and nobody can take it, so it cannot change after this check.
+	current, err := readLockOwner(path)
+	if err != nil || current.PID != owner.PID || !current.AcquiredAt.Equal(owner.AcquiredAt) {
+		return false
+	}
+	if err = os.Remove(path); err != nil {
+		return false
+	}
+	log.Printf("Cleared stale lock %s, held by process %d (since %s) which has exited", path, owner.PID, owner.AcquiredAt.Format(time.RFC3339))
+	return true
+}
+
+// acquireLock takes an exclusive lock by creating the given lock file, waiting
+// for any other process holding it to finish.  The lock records its owner, so
+// that a lock left behind by a process that exited without releasing it (such
+// as by crashing) is cleared rather than waited for.  Returns a function that
+// releases the lock.
+func acquireLock(ctx context.Context, path string) (func(), error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create lock directory: %w", err)
+	}
+	logged := false
+	for {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+		if err == nil {
+			err = json.NewEncoder(file).Encode(lockOwner{PID: os.Getpid(), AcquiredAt: time.Now().UTC()})
+			file.Close()
+			if err != nil {
+				_ = os.Remove(path)
+				return nil, fmt.Errorf("failed to acquire lock %s: %w", path, err)
+			}
+			return func() { _ = os.Remove(path) }, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("failed to acquire lock %s: %w", path, err)
+		}
+		if owner, err := readLockOwner(path); err == nil && !processRunning(owner.PID) && clearStaleLock(path, owner) {
+			continue
+		}
+		if !logged {
+			log.Printf("Waiting for another process to release %s...", path)
+			logged = true
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("failed to acquire lock %s: %w", path, ctx.Err())
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// clearLocks removes the installer's lock files, for when one blocks it and is
+// not cleared automatically (such as when the owner's pid has been reused).
+func clearLocks(ctx context.Context) (*types.UnlockResult, error) {
+	stateDir, err := getStateDirectory(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cacheDir, err := getCacheDirectory()
+	if err != nil {
+		return nil, err
+	}
+	return clearLockFiles(stateDir, cacheDir)
+}
+
+// clearLockFiles removes the lock files in the given directories.  Locks that
+// may be held, as their owner is running or cannot be read (such as a lock
+// still being written), are only removed with -confirm.
+func clearLockFiles(dirs ...string) (*types.UnlockResult, error) {
+	result := &types.UnlockResult{SchemaVersion: types.SchemaVersion, Locks: []types.LockInfo{}}
+	for _, dir := range dirs {
+		paths, err := filepath.Glob(filepath.Join(dir, "*.lock"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to find locks: %w", err)
+		}
+		for _, path := range paths {
+			info := types.LockInfo{Path: path}
+			owner, err := readLockOwner(path)
+			if errors.Is(err, os.ErrNotExist) {
+				// Released since it was found.
+				continue
+			} else if err != nil {
+				info.Unreadable = true
+			} else {
+				info.PID = owner.PID
+				info.AcquiredAt = &owner.AcquiredAt
+				info.Running = processRunning(owner.PID)
+			}
+			switch {
+			case *confirm:
+				if err = os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+					return nil, fmt.Errorf("failed to remove lock %s: %w", path, err)
+				}
+				log.Printf("Removed lock %s", path)
+				info.Removed = true
+			case info.Running:
+				log.Printf("Not removing %s, held by running process %d; pass -confirm to remove it anyway", path, info.PID)
+			case info.Unreadable:
+				log.Printf("Not removing %s, whose owner cannot be read (it may still be being taken); pass -confirm to remove it anyway", path)
+			default:
+				info.Removed = clearStaleLock(path, owner)
+			}
+			result.Locks = append(result.Locks, info)
+		}
+	}
+	return result, nil
+}
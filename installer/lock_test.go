@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// deadPID is a process ID that is not in use.
+const deadPID = 1 << 30
+
+// writeTestLock writes a lock held by the given owner.
+func writeTestLock(t *testing.T, path string, owner lockOwner) {
+	t.Helper()
+	contents, err := json.Marshal(owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(path, contents, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAcquireLockClearsStaleLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.lock")
+	writeTestLock(t, path, lockOwner{PID: deadPID, AcquiredAt: time.Now().UTC()})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	release, err := acquireLock(ctx, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+	if owner, err := readLockOwner(path); err != nil || owner.PID != os.Getpid() {
+		t.Errorf("lock owner = %+v, %v; want process %d", owner, err, os.Getpid())
+	}
+	if _, err = os.Stat(path + ".breaker"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("breaker was left behind: %v", err)
+	}
+}
+
+func TestClearStaleLockKeepsRetakenLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.lock")
+	stale := lockOwner{PID: deadPID, AcquiredAt: time.Now().UTC().Add(-time.Minute)}
+	// Another process cleared the stale lock and took it since it was read.
+	writeTestLock(t, path, lockOwner{PID: os.Getpid(), AcquiredAt: time.Now().UTC()})
+	if clearStaleLock(path, &stale) {
+		t.Error("clearStaleLock() removed a lock taken by another process")
+	}
+	if owner, err := readLockOwner(path); err != nil || owner.PID != os.Getpid() {
+		t.Errorf("lock owner = %+v, %v; want process %d", owner, err, os.Getpid())
+	}
+}
+
+func TestClearStaleLockWaitsForBreaker(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.lock")
+	stale := lockOwner{PID: deadPID, AcquiredAt: time.Now().UTC()}
+	writeTestLock(t, path, stale)
+	breaker := path + ".breaker"
+	if err := os.WriteFile(breaker, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if clearStaleLock(path, &stale) {
+		t.Error("clearStaleLock() removed a lock another process is clearing")
+	}
+	if _, err := os.Stat(breaker); err != nil {
+		t.Errorf("breaker in use was removed: %v", err)
+	}
+
+	// A breaker left behind by a crashed process is removed, so that the
+	// lock can be cleared on the next attempt.
+	old := time.Now().Add(-2 * staleBreakerAge)
+	if err := os.Chtimes(breaker, old, old); err != nil {
+		t.Fatal(err)
+	}
+	if clearStaleLock(path, &stale) {
+		t.Error("clearStaleLock() removed a lock while an old breaker existed")
+	}
+	if !clearStaleLock(path, &stale) {
+		t.Error("clearStaleLock() did not remove the stale lock")
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("stale lock was not removed: %v", err)
+	}
+}
+
+func TestClearLockFiles(t *testing.T) {
+	for _, tt := range []struct {
+		name        string
+		contents    string
+		confirm     bool
+		wantRemoved bool
+	}{
+		{"stale", `{"pid":` + strconv.Itoa(deadPID) + `,"acquiredAt":"2024-01-01T00:00:00Z"}`, false, true},
+		{"running", `{"pid":` + strconv.Itoa(os.Getpid()) + `,"acquiredAt":"2024-01-01T00:00:00Z"}`, false, false},
+		{"running confirmed", `{"pid":` + strconv.Itoa(os.Getpid()) + `,"acquiredAt":"2024-01-01T00:00:00Z"}`, true, true},
+		{"being written", ``, false, false},
+		{"being written confirmed", ``, true, true},
+		{"unreadable", `{"pid":`, false, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			setForTest(t, confirm, tt.confirm)
+			dir := t.TempDir()
+			path := filepath.Join(dir, "test.lock")
+			if err := os.WriteFile(path, []byte(tt.contents), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			result, err := clearLockFiles(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(result.Locks) != 1 || result.Locks[0].Removed != tt.wantRemoved {
+				t.Fatalf("clearLockFiles() = %+v, want the lock with removed %v", result.Locks, tt.wantRemoved)
+			}
+			if _, err = os.Stat(path); errors.Is(err, os.ErrNotExist) != tt.wantRemoved {
+				t.Errorf("lock removed = %v, want %v", errors.Is(err, os.ErrNotExist), tt.wantRemoved)
+			}
+		})
+	}
+}
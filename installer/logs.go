@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Severities of server log lines, from least to most severe, for -severity.
+const (
+	SeverityDebug = "debug"
+	SeverityInfo  = "info"
+	SeverityWarn  = "warn"
+	SeverityError = "error"
+)
+
+var severities = []string{SeverityDebug, SeverityInfo, SeverityWarn, SeverityError}
+
+// logFollowInterval is how often the log is checked for new lines when following.
+const logFollowInterval = 500 * time.Millisecond
+
+var (
+	// slogLinePattern matches the structured lines of ollama's own logging,
+	// such as `time=2024-06-01T10:00:00.000Z level=INFO source=routes.go:1 msg=...`.
+	slogLinePattern = regexp.MustCompile(`^time=(\S+) level=([A-Z]+)`)
+	// ginLinePattern matches the request log, such as
+	// `[GIN] 2024/06/01 - 10:00:00 | 200 | ...`.
+	ginLinePattern = regexp.MustCompile(`^\[GIN\] (\d{4}/\d\d/\d\d - \d\d:\d\d:\d\d) \| +(\d{3}) \|`)
+)
+
+// logLine is a classified line of the server log.
+type logLine struct {
+	text     string
+	severity string
+	time     time.Time // Zero if unknown.
+}
+
+// logClassifier classifies server log lines.  Lines that are not recognized,
+// such as continuations of a multi-line message or a stack trace, take the
+// severity and time of the line before them.
+type logClassifier struct {
+	severity string
+	time     time.Time
+}
+
+func (c *logClassifier) classify(text string) logLine {
+	if match := slogLinePattern.FindStringSubmatch(text); match != nil {
+		if t, err := time.Parse(time.RFC3339Nano, match[1]); err == nil {
+			c.time = t
+		}
+		switch match[2] {
+		case "ERROR":
+			c.severity = SeverityError
+		case "WARN":
+			c.severity = SeverityWarn
+		case "DEBUG":
+			c.severity = SeverityDebug
+		default:
+			c.severity = SeverityInfo
+		}
+	} else if match := ginLinePattern.FindStringSubmatch(text); match != nil {
+		if t, err := time.ParseInLocation("2006/01/02 - 15:04:05", match[1], time.Local); err == nil {
+			c.time = t
+		}
+		switch match[2][0] {
+		case '5':
+			c.severity = SeverityError
+		case '4':
+			c.severity = SeverityWarn
+		default:
+			c.severity = SeverityInfo
+		}
+	} else if lower := strings.ToLower(text); strings.HasPrefix(text, "panic:") || strings.HasPrefix(text, "fatal error:") || strings.Contains(lower, "error:") {
+		// Runner (llama.cpp) output and Go runtime failures.
+		c.severity = SeverityError
+	} else if strings.Contains(lower, "warning:") {
+		c.severity = SeverityWarn
+	} else if c.severity == "" {
+		c.severity = SeverityInfo
+	}
+	return logLine{text: text, severity: c.severity, time: c.time}
+}
+
+// logFilter selects log lines by minimum severity and time.
+type logFilter struct {
+	minSeverity int       // Index into severities.
+	since       time.Time // Lines with an unknown time are included.
+}
+
+func newLogFilter(severity string, window time.Duration) *logFilter {
+	filter := &logFilter{minSeverity: max(slices.Index(severities, severity), 0)}
+	if window > 0 {
+		filter.since = time.Now().Add(-window)
+	}
+	return filter
+}
+
+func (f *logFilter) matches(line logLine) bool {
+	if slices.Index(severities, line.severity) < f.minSeverity {
+		return false
+	}
+	return line.time.IsZero() || !line.time.Before(f.since)
+}
+
+// findServerLog returns the server log to read: the given path if set, or else
+// the first log of the candidates that exists.
+func findServerLog(ctx context.Context, logPath string) (string, error) {
+	if logPath != "" {
+		return logPath, nil
+	}
+	for _, candidate := range serverLogCandidates(ctx) {
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("no server log found; has ollama been started?")
+}
+
+// printLogFile writes the lines of the given (complete) log matching the filter
+// to output.
+func printLogFile(logPath string, classifier *logClassifier, filter *logFilter, output io.Writer) error {
+	file, err := os.Open(logPath)
+	if errors.Is(err, os.ErrNotExist) {
+		// Rotated away since it was found.
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to open log: %w", err)
+	}
+	defer file.Close()
+	reader := bufio.NewReader(file)
+	for {
+		text, err := reader.ReadString('\n')
+		if text != "" {
+			if line := classifier.classify(strings.TrimRight(text, "\r\n")); filter.matches(line) {
+				if _, err := fmt.Fprintln(output, line.text); err != nil {
+					return fmt.Errorf("failed to write log: %w", err)
+				}
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed to read log: %w", err)
+		}
+	}
+}
+
+// printLogs writes the lines of the server log (or the log at logPath, if set)
+// matching the severity and time window to standard output, unmodified.  With
+// neither set, this is the raw log.  Rotated copies of the log (as log.1
+// onwards) are read first, oldest first.  If follow is set, lines added to the
+// log are printed as they appear, until the context is cancelled.
+func printLogs(ctx context.Context, logPath, severity string, window time.Duration, follow bool) error {
+	filter := newLogFilter(severity, window)
+	logPath, err := findServerLog(ctx, logPath)
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(logPath)
+	if err != nil {
+		return fmt.Errorf("failed to open log: %w", err)
+	}
+	defer func() { file.Close() }()
+
+	classifier := &logClassifier{}
+	output := bufio.NewWriter(os.Stdout)
+	defer output.Flush()
+	for _, rotated := range rotatedLogFiles(logPath) {
+		if err = printLogFile(rotated, classifier, filter, output); err != nil {
+			return err
+		}
+	}
+	reader := bufio.NewReader(file)
+	var offset int64
+	var partial string
+	for {
+		chunk, err := reader.ReadString('\n')
+		offset += int64(len(chunk))
+		if err == nil {
+			line := classifier.classify(strings.TrimRight(partial+chunk, "\r\n"))
+			partial = ""
+			if filter.matches(line) {
+				if _, err = fmt.Fprintln(output, line.text); err != nil {
+					return fmt.Errorf("failed to write log: %w", err)
+				}
+			}
+			continue
+		} else if !errors.Is(err, io.EOF) {
+			return fmt.Errorf("failed to read log: %w", err)
+		}
+		// Keep an incomplete last line until the rest of it is written.
+		partial += chunk
+		if !follow {
+			if partial != "" {
+				if line := classifier.classify(partial); filter.matches(line) {
+					fmt.Fprintln(output, line.text)
+				}
+			}
+			return nil
+		}
+		if err = output.Flush(); err != nil {
+			return fmt.Errorf("failed to write log: %w", err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(logFollowInterval):
+		}
+		// If the log was rotated or truncated, start again from the beginning
+		// of the new one.
+		if info, err := os.Stat(logPath); err == nil {
+			current, statErr := file.Stat()
+			if statErr != nil || !os.SameFile(info, current) || info.Size() < offset {
+				reopened, err := os.Open(logPath)
+				if err != nil {
+					return fmt.Errorf("failed to open log: %w", err)
+				}
+				file.Close()
+				file, offset, partial = reopened, 0, ""
+				reader.Reset(file)
+			}
+		}
+	}
+}
@@ -4,41 +4,202 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
-	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"regexp"
 	"slices"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
+
+	"github.com/rancher-sandbox/rd-open-webui-docker-ext/installer/types"
 )
 
 const (
-	checkURL = "http://localhost:11434/api/tags"
+	ollamaURL = "http://localhost:11434"
+	checkURL  = ollamaURL + "/api/tags"
 )
 
 type Mode string
 
 const (
-	ModeInstall   Mode = "install"   // Install ollama to the default location.
-	ModeUninstall Mode = "uninstall" // Uninstall ollama that we have installed.
-	ModeCheck     Mode = "check"     // Check if Ollama is installed, printing "true" or "false".
-	ModeStart     Mode = "start"     // Run ollama in a new process and return immediately.
-	ModeShutdown  Mode = "shutdown"  // Terminate any running ollama instrances.
+	ModeInstall    Mode = "install"            // Install ollama to the default location.
+	ModeUninstall  Mode = "uninstall"          // Uninstall ollama that we have installed.
+	ModeCheck      Mode = "check"              // Check if Ollama is installed, printing "true" or "false".
+	ModeStart      Mode = "start"              // Run ollama in a new process and return immediately.
+	ModeShutdown   Mode = "shutdown"           // Terminate any running ollama instrances.
+	ModeStatus     Mode = "status"             // Print the install status as JSON.
+	ModeList       Mode = "list"               // Print the locally available models as JSON.
+	ModeLatest     Mode = "latest"             // Print information about the latest release as JSON.
+	ModePull       Mode = "pull"               // Pull the models given by -model.
+	ModeCancel     Mode = "cancel"             // Cancel an in-progress pull of the model given by -model.
+	ModeModelsDir  Mode = "models-dir"         // Ensure the models directory exists, printing its path.
+	ModeExport     Mode = "export"             // Export the model given by -model to the tarball given by -file.
+	ModeImport     Mode = "import"             // Import a model from the tarball given by -file.
+	ModeSupervise  Mode = "supervise"          // Run ollama, restarting it if it crashes; used by -watchdog.
+	ModeMigrate    Mode = "migrate-models"     // Move the models directory to -destination.
+	ModeGPUUsage   Mode = "gpu-usage"          // Print GPU usage during a test generation with -model as JSON.
+	ModeCacheList  Mode = "cache-list"         // Print the cached archives as JSON.
+	ModeCacheClear Mode = "cache-clear"        // Remove cached archives, printing what was removed as JSON.
+	ModeResolveURL Mode = "resolve-url"        // Print the URL of -asset (or the selected assets) in -release as JSON.
+	ModeProxy      Mode = "proxy"              // Serve a streaming proxy for the generate and chat APIs on -listen.
+	ModeFixPerms   Mode = "fix-permissions"    // Restore the file modes of the managed install, printing changes as JSON.
+	ModeBench      Mode = "bench"              // Print model load and first token latency of -model as JSON.
+	ModePrune      Mode = "prune"              // Remove model blobs no manifest references, printing them as JSON.
+	ModeDiagnose   Mode = "diagnostics"        // Write a diagnostics bundle to -file, printing its contents as JSON.
+	ModeSwitch     Mode = "switch"             // Switch between managed and external ollama (-backend), printing the result as JSON.
+	ModeInstalls   Mode = "list-installs"      // Print every distinct ollama install found as JSON.
+	ModeSelect     Mode = "select-install"     // Use the ollama install at -executable (or the first found if empty), printing all as JSON.
+	ModePlan       Mode = "plan-upgrade"       // Print what installing -release would change as JSON, without changing anything.
+	ModeGetDefault Mode = "default-model"      // Print the model the UI uses by default as JSON.
+	ModeSetDefault Mode = "set-default"        // Set the model the UI uses by default to -model (empty to clear), printing it as JSON.
+	ModePulls      Mode = "pulls"              // Print the model pulls in progress or interrupted as JSON.
+	ModeResume     Mode = "resume-pulls"       // Resume every interrupted model pull.
+	ModeCheckFit   Mode = "check-fit"          // Print whether -model likely fits in memory as JSON.
+	ModeCopy       Mode = "copy-model"         // Copy -model to -destination, printing the models as JSON.
+	ModeRename     Mode = "rename-model"       // Rename -model to -destination, printing the models as JSON.
+	ModeCreate     Mode = "create-model"       // Create -model from the Modelfile given by -file, printing the models as JSON.
+	ModeAPI        Mode = "api"                // Serve the installer's HTTP API on -api-listen until interrupted.
+	ModeReload     Mode = "reload-serve"       // Restart the managed serve if its settings changed, printing them as JSON.
+	ModeConnect    Mode = "check-connectivity" // Check that GitHub (and any mirror) can be reached, printing the results as JSON.
+	ModeLogs       Mode = "logs"               // Print the server log (or -file), filtered by -severity and -since; with -follow, keep printing.
+	ModeDiskUsage  Mode = "disk-usage"         // Print the disk space each model occupies, counting shared blobs once, as JSON.
+	ModeEndpoint   Mode = "check-endpoint"     // Check whether a compatible ollama responds at -endpoint, printing the result as JSON.
+	ModeLinks      Mode = "repair-links"       // Recreate broken links of the managed install from the cached archives, printing them as JSON.
+	ModeChangelog  Mode = "changelog"          // Print the release notes from the installed version up to -release as JSON.
+	ModeRotateLogs Mode = "rotate-logs"        // Rotate the serve log now, printing the result as JSON.
+	ModeStopAll    Mode = "stop-all"           // Stop every ollama process, managed or external (requires -confirm), printing them as JSON.
+	ModeGPUStatus  Mode = "gpu-status"         // Print whether the running server actually uses the GPU, and which backend, as JSON.
+	ModeVerifyRel  Mode = "verify-release"     // Check the managed install against the checksums published with its release, printing the result as JSON.
+	ModeImportGGUF Mode = "import-gguf"        // Create -model from the GGUF file (or registry reference) given by -file, printing the result as JSON.
+	ModeWarm       Mode = "warm"               // Load -model into memory without generating, printing the load time as JSON.
+	ModeFootprint  Mode = "footprint"          // Print the disk space used by the install, cached archives, logs and models as JSON.
+	ModeCapability Mode = "capabilities"       // Print which ollama API features the running server supports as JSON.
+	ModeUnlock     Mode = "unlock"             // Remove installer lock files left behind, printing them as JSON.
+	ModeRepair     Mode = "repair"             // Restore missing or corrupt files of the managed install from the cached archives, printing them as JSON.
+	ModeBackendURL Mode = "backend-endpoint"   // Print the backend serving the ollama API, and its (fixed) address, as JSON.
 )
 
 var (
 	mode           = ModeInstall
-	allModes       = []Mode{ModeInstall, ModeUninstall, ModeCheck, ModeStart, ModeShutdown}
+	allModes       = []Mode{ModeInstall, ModeUninstall, ModeCheck, ModeStart, ModeShutdown, ModeStatus, ModeList, ModeLatest, ModePull, ModeCancel, ModeModelsDir, ModeExport, ModeImport, ModeSupervise, ModeMigrate, ModeGPUUsage, ModeCacheList, ModeCacheClear, ModeResolveURL, ModeProxy, ModeFixPerms, ModeBench, ModePrune, ModeDiagnose, ModeSwitch, ModeInstalls, ModeSelect, ModePlan, ModeGetDefault, ModeSetDefault, ModePulls, ModeResume, ModeCheckFit, ModeCopy, ModeRename, ModeCreate, ModeAPI, ModeReload, ModeConnect, ModeLogs, ModeDiskUsage, ModeEndpoint, ModeLinks, ModeChangelog, ModeRotateLogs, ModeStopAll, ModeGPUStatus, ModeVerifyRel, ModeImportGGUF, ModeWarm, ModeFootprint, ModeCapability, ModeUnlock, ModeRepair, ModeBackendURL}
 	releaseVersion = flag.String("release", "latest", "release to download when installing")
-	pullModel      = flag.String("model", "tinyllama", "model to pull on install; set to empty string to skip")
+	assetName      = flag.String("asset", "", "release asset to resolve, or on macOS to install; defaults to those selected for this machine")
+	modelName      = flag.String("model", "tinyllama", "model to pull on install (set to empty string to skip), or comma-separated models to pull")
+	archiveFile    = flag.String("file", "", "path of the model tarball to export or import, the Modelfile to create from, the GGUF file (or registry reference) to import, the diagnostics bundle to write, or the log to print")
+	executable     = flag.String("executable", "", "path of the ollama install to select")
+	destination    = flag.String("destination", "", "new models directory when migrating models, or new model name when copying or renaming")
+	installScope   = InstallScopeUser
+	installDir     = flag.String("install-dir", "", "location to install ollama to, which may be read-only after installing (on macOS, the executable path); it is only removed or replaced if the installer created it; defaults to the location for -install-scope")
+	bundleDir      = flag.String("bundle-dir", "", "directory of release assets bundled with the extension, used instead of downloading when present; defaults to within the extension")
+	tempDir        = flag.String("temp-dir", os.Getenv("OLLAMA_INSTALLER_TMPDIR"), "directory for downloads and extraction in progress; defaults to $OLLAMA_INSTALLER_TMPDIR, or next to their destination")
+	searchPath     = flag.String("search-path", os.Getenv("OLLAMA_INSTALLER_SEARCH_PATH"), "extra locations to look for an existing ollama in, after the built-in ones, as a list of directories or executables separated as in $PATH; defaults to $OLLAMA_INSTALLER_SEARCH_PATH")
+	stateDir       = flag.String("state-dir", "", "writable directory for installer state; defaults to within the extension")
+	modelsDir      = flag.String("models-dir", "", "directory to store models in; defaults to $OLLAMA_MODELS, or ollama's default")
+	mirrorURL      = flag.String("mirror", os.Getenv("OLLAMA_MIRROR"), "base URL of a mirror to download release assets from, as <mirror>/<release>/<asset>")
+	mirrorToken    = flag.String("mirror-token", "", "bearer token for the mirror; defaults to $OLLAMA_MIRROR_TOKEN")
+	mirrorPins     = [][]byte{}
+	dialSocket     = flag.String("dial-socket", "", "path of a Unix socket to make all download connections through")
+	strictChecksum = flag.Bool("strict-checksum", false, "fail the install if the release does not publish a checksum for the asset")
+	quiet          = flag.Bool("quiet", false, "only log errors; results are still written to standard output")
+	jsonEvents     = flag.Bool("json-events", false, "write install progress to standard output as JSON lines")
+	forceCPU       = flag.Bool("force-cpu", os.Getenv("OLLAMA_FORCE_CPU") == "1", "ignore any detected GPUs; defaults to true if $OLLAMA_FORCE_CPU is 1")
+
+	downloadAttempts   = flag.Int("download-attempts", 3, "number of times to try downloading an asset before giving up")
+	downloadRetryDelay = flag.Duration("download-retry-delay", 2*time.Second, "delay before retrying a failed download; doubles with each attempt")
+	checksumRetries    = flag.Int("checksum-retries", 1, "number of times to download an asset again, bypassing caches, if its checksum does not match")
+
+	minNvidiaDriver = flag.String("min-nvidia-driver", "531", "oldest NVIDIA driver version to use CUDA with; older drivers use the CPU")
+
+	serveEnv  = envFlag{}
+	keepAlive = ""
+	serveNice = 0
+	ipFamily  = os.Getenv("OLLAMA_DOWNLOAD_IP_FAMILY")
+
+	// releaseRepo is the GitHub repository, as owner/repo, to install releases of.
+	releaseRepo       = "ollama/ollama"
+	githubRepoPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9-]{0,38}/[A-Za-z0-9._-]{1,100}$`)
+	githubAPIURL      = flag.String("github-api", "https://api.github.com", "base URL of the GitHub API to find releases with, such as for GitHub Enterprise")
+
+	userAgent    = flag.String("user-agent", defaultUserAgent(), "User-Agent for requests leaving the machine")
+	extraHeaders = headerFlag{}
+
+	proxyAuthorization = flag.String("proxy-authorization", "", "Proxy-Authorization header for the HTTP proxy used to download, such as \"Negotiate <token>\"; defaults to $OLLAMA_PROXY_AUTHORIZATION")
+
+	fallbackDelay = flag.Duration("fallback-delay", 0, "time to wait for an IPv6 connection before also trying IPv4 when downloading (default 300ms)")
+
+	watchdog         = flag.Bool("watchdog", false, "when starting, supervise ollama and restart it if it crashes")
+	watchdogRestarts = flag.Int("watchdog-restarts", 5, "maximum number of restarts within the watchdog window before giving up")
+	watchdogWindow   = flag.Duration("watchdog-window", 10*time.Minute, "period over which the watchdog counts restarts")
+
+	pullDefault     = flag.Bool("pull-default", false, "when setting the default model, pull it if it has not been pulled")
+	pullConcurrency = flag.Int("pull-concurrency", 1, "maximum number of models to pull at once when pulling several; the rest are queued")
+
+	dryRun            = flag.Bool("dry-run", false, "when pruning or repairing files or links, only report what would be changed")
+	overwriteModified = flag.Bool("overwrite-modified", false, "when repairing files, also restore those modified since they were installed")
+	benchRuns         = flag.Int("bench-runs", 3, "number of times to load the model when benchmarking")
+
+	purge       = flag.Bool("purge", false, "when uninstalling, also remove installer state, the serve log and cached archives")
+	purgeModels = flag.Bool("purge-models", false, "with -purge, also remove the models directory and every model in it")
+
+	listenAddress = flag.String("listen", "127.0.0.1:11435", "address for the proxy to listen on")
+	proxyToken    = flag.String("proxy-token", "", "bearer token proxy clients must present; defaults to $OLLAMA_PROXY_TOKEN")
+
+	apiListen = flag.String("api-listen", "127.0.0.1:11436", "loopback address, or unix:<path> for a Unix socket, for the installer API to listen on")
+	apiToken  = flag.String("api-token", "", "bearer token installer API clients must present; defaults to $OLLAMA_INSTALLER_API_TOKEN")
+
+	endpoint = flag.String("endpoint", "", "ollama endpoint to check, as host:port (default port 11434) or an http or https URL")
+
+	backend        = BackendAuto
+	externalPolicy = ExternalPolicyWarn
+	reuseApp       = flag.Bool("reuse-app", true, "use a running Ollama.app (macOS) rather than installing or starting a managed copy")
+	stopTimeout    = flag.Duration("stop-timeout", 10*time.Second, "time to wait for ollama to exit after asking it to stop (SIGTERM, or Ctrl+Break on Windows) before killing it; ollama cannot be asked to finish in-flight requests first")
+
+	confirm = flag.Bool("confirm", false, "confirm stopping all ollama processes, including those of external installs, or removing locks that may be held by running processes")
+
+	logSeverity = ""
+	logWindow   = flag.Duration("since", 0, "when printing logs, only print lines written within this long; defaults to all")
+	followLogs  = flag.Bool("follow", false, "when printing logs, keep printing lines as they are written until interrupted")
+
+	serveLogSize = flag.Int64("serve-log-size", 10<<20, "size in bytes beyond which the serve log is rotated")
+	serveLogKeep = flag.Int("serve-log-keep", 3, "number of rotated serve logs to keep")
+
+	healthTimeout = flag.Duration("health-timeout", 2*time.Second, "time to wait for ollama to respond to each health check")
+	startTimeout  = flag.Duration("start-timeout", 2*time.Minute, "time to wait for ollama to become healthy after starting it")
+	drainTimeout  = flag.Duration("drain-timeout", 30*time.Second, "time to wait for requests in flight through the proxy to finish before restarting serve")
+
+	keepInstalled = flag.Bool("keep-installed", true, "when clearing the cache, keep the archives of the current install")
+
+	fileManifestPath = flag.String("file-manifest", "", "path of a JSON manifest of the exact files the install must contain")
+	executableMode   = fs.FileMode(0)
+
+	maxArchiveSize       = flag.Int64("max-archive-size", 16<<30, "maximum total uncompressed size of the ollama archive, in bytes")
+	linkWorkers          = flag.Int("link-workers", 4, "maximum number of links to create in parallel when extracting")
+	linkFallback         = flag.Bool("link-fallback", true, "when extracting, copy files the install filesystem cannot link to")
+	maxArchiveEntries    = flag.Int("max-archive-entries", 10000, "maximum number of entries in the ollama archive")
+	maxArchivePathLength = flag.Int("max-archive-path-length", 200, "maximum length, in bytes, of a path in the ollama archive")
+	caseCollisions       = CaseCollisionError
+
+	minOllamaVersion = flag.String("min-version", "0.3", "oldest supported ollama version; later components are ignored if omitted")
+	maxOllamaVersion = flag.String("max-version", "0", "newest supported ollama version; later components are ignored if omitted")
+	versionCheck     = VersionCheckStrict
 )
 
 func main() {
-	ctx := context.Background()
+	// Cancel the context on termination, so that in-progress operations (such as
+	// model pulls) can be aborted cleanly.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 	log.SetFlags(log.LUTC | log.Ldate | log.Ltime)
 	flag.Func("mode", fmt.Sprintf("operation mode; one of %+v (default %q)", allModes, mode), func(s string) error {
 		if i := slices.Index(allModes, Mode(s)); i > -1 {
@@ -48,86 +209,641 @@ func main() {
 		}
 		return nil
 	})
+	flag.Func("ip-family", fmt.Sprintf("restrict downloads to %q or %q; defaults to $OLLAMA_DOWNLOAD_IP_FAMILY, or both", IPFamilyIPv4, IPFamilyIPv6), func(s string) error {
+		if s != IPFamilyAny && s != IPFamilyIPv4 && s != IPFamilyIPv6 {
+			return fmt.Errorf("unexpected IP family %s: should be %q or %q", s, IPFamilyIPv4, IPFamilyIPv6)
+		}
+		ipFamily = s
+		return nil
+	})
+	flag.Func("repo", fmt.Sprintf("GitHub repository to install releases of, as owner/repo, such as for a fork (default %q)", releaseRepo), func(s string) error {
+		if !githubRepoPattern.MatchString(s) || strings.Contains(s, "..") {
+			return fmt.Errorf("invalid repository %q: should be owner/repo", s)
+		}
+		releaseRepo = s
+		return nil
+	})
+	flag.Func("keep-alive", "how long the managed serve keeps models loaded when idle, such as 5m, or -1 for always; defaults to ollama's default", func(s string) error {
+		if err := parseKeepAlive(s); err != nil {
+			return err
+		}
+		keepAlive = s
+		return nil
+	})
+	flag.Func("serve-nice", fmt.Sprintf("niceness to start the managed serve with, from %d to %d, where higher values yield the CPU (and on Linux, disk) to other programs; default 0", minServeNice, maxServeNice), func(s string) error {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return fmt.Errorf("invalid niceness %s: %w", s, err)
+		}
+		if n < minServeNice || n > maxServeNice {
+			return fmt.Errorf("niceness %d out of range %d to %d", n, minServeNice, maxServeNice)
+		}
+		if n < 0 && !isElevated() {
+			return fmt.Errorf("raising the serve priority with niceness %d requires running as %s", n, elevatedUserName)
+		}
+		serveNice = n
+		return nil
+	})
+	flag.Func("install-scope", fmt.Sprintf("where to install ollama when -install-dir is not given: %q, within the extension, or %q, system-wide (requires elevated privileges); default %q", InstallScopeUser, InstallScopeSystem, InstallScopeUser), func(s string) error {
+		if s != InstallScopeUser && s != InstallScopeSystem {
+			return fmt.Errorf("unexpected install scope %s: should be %q or %q", s, InstallScopeUser, InstallScopeSystem)
+		}
+		installScope = s
+		return nil
+	})
+	flag.Func("backend", fmt.Sprintf("ollama to switch to, %q or %q; defaults to an external one if running", BackendManaged, BackendExternal), func(s string) error {
+		if s != BackendManaged && s != BackendExternal {
+			return fmt.Errorf("unexpected backend %s: should be %q or %q", s, BackendManaged, BackendExternal)
+		}
+		backend = s
+		return nil
+	})
+	flag.Func("incompatible-external", fmt.Sprintf("what to do when an external ollama install is of an unsupported version: %q to use it anyway, or %q to install a managed copy alongside it (default %q)", ExternalPolicyWarn, ExternalPolicyManaged, externalPolicy), func(s string) error {
+		if s != ExternalPolicyWarn && s != ExternalPolicyManaged {
+			return fmt.Errorf("unexpected external policy %s: should be %q or %q", s, ExternalPolicyWarn, ExternalPolicyManaged)
+		}
+		externalPolicy = s
+		return nil
+	})
+	flag.Func("case-collisions", fmt.Sprintf("what to do with archive entries whose paths differ only in case, when installing to a case-insensitive filesystem: %q to fail, or %q to keep the first (default %q)", CaseCollisionError, CaseCollisionSkip, caseCollisions), func(s string) error {
+		if s != CaseCollisionError && s != CaseCollisionSkip {
+			return fmt.Errorf("unexpected case collision policy %s: should be %q or %q", s, CaseCollisionError, CaseCollisionSkip)
+		}
+		caseCollisions = s
+		return nil
+	})
+	flag.Func("mirror-pin", `SHA-256 hash of a public key (SPKI) the mirror's certificate chain must contain, as "sha256/<base64>"; may be repeated`, func(s string) error {
+		pin, err := parseCertificatePin(s)
+		if err != nil {
+			return err
+		}
+		mirrorPins = append(mirrorPins, pin)
+		return nil
+	})
+	flag.Func("version-check", fmt.Sprintf("how the installed version must match -release: %q, %q to ignore prerelease suffixes, or %q (default %q)", VersionCheckStrict, VersionCheckNumeric, VersionCheckOff, versionCheck), func(s string) error {
+		if s != VersionCheckStrict && s != VersionCheckNumeric && s != VersionCheckOff {
+			return fmt.Errorf("unexpected version check %s: should be %q, %q or %q", s, VersionCheckStrict, VersionCheckNumeric, VersionCheckOff)
+		}
+		versionCheck = s
+		return nil
+	})
+	flag.Func("executable-mode", "octal file mode for the ollama executable, such as 0700; must be executable by the owner (default the archive's mode, made executable by the owner)", func(s string) error {
+		mode, err := parseExecutableMode(s)
+		if err != nil {
+			return err
+		}
+		executableMode = mode
+		return nil
+	})
+	flag.Func("severity", fmt.Sprintf("when printing logs, only print lines at least this severe; one of %v", severities), func(s string) error {
+		if !slices.Contains(severities, s) {
+			return fmt.Errorf("unexpected severity %s: should be one of %v", s, severities)
+		}
+		logSeverity = s
+		return nil
+	})
+	flag.Var(serveEnv, "serve-env", "additional KEY=VALUE environment variable for the serve process; may be repeated")
+	flag.Var(extraHeaders, "header", `additional "Name: value" header for requests leaving the machine, such as for a gateway; may be repeated`)
 	flag.Parse()
+	if *mirrorToken == "" {
+		// Not the flag default, to avoid printing it in the usage message.
+		*mirrorToken = os.Getenv("OLLAMA_MIRROR_TOKEN")
+	}
+	if *proxyAuthorization == "" {
+		*proxyAuthorization = os.Getenv("OLLAMA_PROXY_AUTHORIZATION")
+	}
+	if *proxyToken == "" {
+		*proxyToken = os.Getenv("OLLAMA_PROXY_TOKEN")
+	}
+	if *apiToken == "" {
+		*apiToken = os.Getenv("OLLAMA_INSTALLER_API_TOKEN")
+	}
+	if *quiet {
+		log.SetOutput(io.Discard)
+	}
 
 	switch mode {
 	case ModeInstall:
+		if err := checkInstallScopePrivileges(); err != nil {
+			fatal(err)
+		}
 		log.Printf("Installing ollama...")
-		if err := install(ctx); err != nil {
-			log.Fatal(err)
+		result, err := install(ctx)
+		if err != nil {
+			fatal(err)
+		}
+		if err = printJSON(result); err != nil {
+			fatal(err)
 		}
 	case ModeUninstall:
+		if *purgeModels && !*purge {
+			fatal(fmt.Errorf("-purge-models requires -purge"))
+		}
+		if err := checkInstallScopePrivileges(); err != nil {
+			fatal(err)
+		}
 		log.Printf("Uninstalling ollama...")
-		if err := uninstallOllama(ctx); err != nil {
-			log.Fatal(err)
+		result, err := uninstallOllama(ctx)
+		if err != nil {
+			fatal(err)
+		}
+		err = updateState(ctx, func(state *installerState) error {
+			state.InstalledArchives = nil
+			state.InstalledFiles = nil
+			return nil
+		})
+		if err != nil {
+			fatal(err)
+		}
+		if *purge {
+			if err = purgeInstallerData(ctx, result, *purgeModels); err != nil {
+				fatal(err)
+			}
+		}
+		if result.Removed {
+			log.Printf("Removed ollama from %s", result.Path)
+		} else {
+			log.Printf("Ollama was not installed at %s", result.Path)
+		}
+		if err = printJSON(result); err != nil {
+			fatal(err)
 		}
 	case ModeCheck:
 		if err := checkInstall(ctx); err != nil {
-			log.Fatal(err)
+			fatal(err)
 		}
 	case ModeStart:
 		if err := startOllama(ctx); err != nil {
-			log.Fatal(err)
+			fatal(err)
 		}
 	case ModeShutdown:
 		if err := shutdownOllama(ctx); err != nil {
-			log.Fatal(err)
+			fatal(err)
+		}
+	case ModeStatus:
+		if err := printStatus(ctx); err != nil {
+			fatal(err)
+		}
+	case ModeList:
+		if err := printModels(ctx); err != nil {
+			fatal(err)
+		}
+	case ModeLatest:
+		if err := printRelease(ctx, *releaseVersion); err != nil {
+			fatal(err)
+		}
+	case ModePull:
+		if err := runPulls(ctx, *modelName); err != nil {
+			fatal(err)
+		}
+	case ModeCancel:
+		if err := cancelPull(ctx, *modelName); err != nil {
+			fatal(err)
+		}
+	case ModeModelsDir:
+		dir, err := ensureModelsDirectory(ctx)
+		if err != nil {
+			fatal(err)
+		}
+		if _, err = fmt.Println(dir); err != nil {
+			fatal(err)
+		}
+	case ModeExport:
+		if err := exportModel(ctx, *modelName, *archiveFile); err != nil {
+			fatal(err)
+		}
+	case ModeImport:
+		if err := importModel(ctx, *archiveFile); err != nil {
+			fatal(err)
+		}
+	case ModeSupervise:
+		if err := superviseServe(ctx); err != nil {
+			fatal(err)
+		}
+	case ModeMigrate:
+		if err := migrateModels(ctx, *destination); err != nil {
+			fatal(err)
+		}
+	case ModeGPUUsage:
+		if err := printGPUUsage(ctx, *modelName); err != nil {
+			fatal(err)
+		}
+	case ModeResolveURL:
+		if err := printResolvedAssets(ctx, *releaseVersion, *assetName); err != nil {
+			fatal(err)
+		}
+	case ModeProxy:
+		if err := runProxy(ctx); err != nil {
+			fatal(err)
+		}
+	case ModeLogs:
+		if err := printLogs(ctx, *archiveFile, logSeverity, *logWindow, *followLogs); err != nil {
+			fatal(err)
+		}
+	case ModeDiskUsage:
+		result, err := getModelDiskUsage(ctx)
+		if err != nil {
+			fatal(err)
+		}
+		if err = printJSON(result); err != nil {
+			fatal(err)
+		}
+	case ModeBackendURL:
+		result, err := resolveBackendEndpoint(ctx)
+		if err != nil {
+			fatal(err)
+		}
+		if err = printJSON(result); err != nil {
+			fatal(err)
+		}
+	case ModeRepair:
+		result, err := repairFiles(ctx, *dryRun)
+		if err != nil {
+			fatal(err)
+		}
+		if err = printJSON(result); err != nil {
+			fatal(err)
+		}
+	case ModeUnlock:
+		result, err := clearLocks(ctx)
+		if err != nil {
+			fatal(err)
+		}
+		if err = printJSON(result); err != nil {
+			fatal(err)
+		}
+	case ModeCapability:
+		result, err := getCapabilities(ctx)
+		if err != nil {
+			fatal(err)
+		}
+		if err = printJSON(result); err != nil {
+			fatal(err)
+		}
+	case ModeFootprint:
+		result, err := getFootprint(ctx)
+		if err != nil {
+			fatal(err)
+		}
+		if err = printJSON(result); err != nil {
+			fatal(err)
+		}
+	case ModeWarm:
+		result, err := warmModel(ctx, *modelName)
+		if err != nil {
+			fatal(err)
+		}
+		if err = printJSON(result); err != nil {
+			fatal(err)
+		}
+	case ModeImportGGUF:
+		result, err := importModelSource(ctx, *modelName, *archiveFile)
+		if err != nil {
+			fatal(err)
+		}
+		if err = printJSON(result); err != nil {
+			fatal(err)
+		}
+	case ModeVerifyRel:
+		result, err := verifyRelease(ctx)
+		if err != nil {
+			fatal(err)
+		}
+		if err = printJSON(result); err != nil {
+			fatal(err)
+		}
+	case ModeGPUStatus:
+		result, err := getGPUStatus(ctx)
+		if err != nil {
+			fatal(err)
+		}
+		if err = printJSON(result); err != nil {
+			fatal(err)
+		}
+	case ModeStopAll:
+		result, err := stopAllProcesses(ctx)
+		if err != nil {
+			fatal(err)
+		}
+		if err = printJSON(result); err != nil {
+			fatal(err)
+		}
+	case ModeRotateLogs:
+		result, err := rotateServeLog(ctx, true)
+		if err != nil {
+			fatal(err)
+		}
+		if err = printJSON(result); err != nil {
+			fatal(err)
+		}
+	case ModeChangelog:
+		result, err := getChangelog(ctx, *releaseVersion)
+		if err != nil {
+			fatal(err)
+		}
+		if err = printJSON(result); err != nil {
+			fatal(err)
+		}
+	case ModeLinks:
+		result, err := repairLinks(ctx, *dryRun)
+		if err != nil {
+			fatal(err)
+		}
+		if err = printJSON(result); err != nil {
+			fatal(err)
+		}
+	case ModeEndpoint:
+		result, err := checkEndpoint(ctx, *endpoint)
+		if err != nil {
+			fatal(err)
+		}
+		if err = printJSON(result); err != nil {
+			fatal(err)
+		}
+	case ModeConnect:
+		result := checkConnectivity(ctx)
+		if err := printJSON(result); err != nil {
+			fatal(err)
+		}
+	case ModeReload:
+		result, err := reloadServe(ctx)
+		if err != nil {
+			fatal(err)
+		}
+		if err = printJSON(result); err != nil {
+			fatal(err)
+		}
+	case ModeAPI:
+		if err := runAPI(ctx); err != nil {
+			fatal(err)
+		}
+	case ModeFixPerms:
+		result, err := fixPermissions(ctx)
+		if err != nil {
+			fatal(err)
+		}
+		if err = printJSON(result); err != nil {
+			fatal(err)
+		}
+	case ModeBench:
+		if err := printBenchmark(ctx, *modelName, *benchRuns); err != nil {
+			fatal(err)
+		}
+	case ModePrune:
+		result, err := pruneBlobs(ctx, *dryRun)
+		if err != nil {
+			fatal(err)
+		}
+		if result.DryRun {
+			log.Printf("Would reclaim %d bytes", result.ReclaimedBytes)
+		} else {
+			log.Printf("Reclaimed %d bytes", result.ReclaimedBytes)
+		}
+		if err = printJSON(result); err != nil {
+			fatal(err)
+		}
+	case ModeCopy:
+		if err := copyModel(ctx, *modelName, *destination); err != nil {
+			fatal(err)
+		}
+		if err := printModels(ctx); err != nil {
+			fatal(err)
+		}
+	case ModeRename:
+		if err := renameModel(ctx, *modelName, *destination); err != nil {
+			fatal(err)
+		}
+		if err := printModels(ctx); err != nil {
+			fatal(err)
+		}
+	case ModeCreate:
+		if err := runCreate(ctx, *modelName, *archiveFile); err != nil {
+			fatal(err)
+		}
+		if err := printModels(ctx); err != nil {
+			fatal(err)
+		}
+	case ModeCheckFit:
+		result, err := checkModelFit(ctx, *modelName)
+		if err != nil {
+			fatal(err)
+		}
+		if err = printJSON(result); err != nil {
+			fatal(err)
+		}
+	case ModePulls:
+		if err := printPulls(ctx); err != nil {
+			fatal(err)
+		}
+	case ModeResume:
+		if err := resumePulls(ctx); err != nil {
+			fatal(err)
+		}
+	case ModeGetDefault:
+		result, err := getDefaultModel(ctx)
+		if err != nil {
+			fatal(err)
+		}
+		if err = printJSON(result); err != nil {
+			fatal(err)
+		}
+	case ModeSetDefault:
+		result, err := setDefaultModel(ctx, *modelName, *pullDefault)
+		if err != nil {
+			fatal(err)
+		}
+		if err = printJSON(result); err != nil {
+			fatal(err)
+		}
+	case ModePlan:
+		if err := printUpgradePlan(ctx, *releaseVersion); err != nil {
+			fatal(err)
+		}
+	case ModeInstalls:
+		installs, err := findAllExecutables(ctx)
+		if err != nil {
+			fatal(err)
+		}
+		if err = printJSON(types.InstallList{SchemaVersion: types.SchemaVersion, Installs: installs}); err != nil {
+			fatal(err)
+		}
+	case ModeSelect:
+		installs, err := selectExecutable(ctx, *executable)
+		if err != nil {
+			fatal(err)
+		}
+		if err = printJSON(types.InstallList{SchemaVersion: types.SchemaVersion, Installs: installs}); err != nil {
+			fatal(err)
+		}
+	case ModeSwitch:
+		result, err := switchBackend(ctx, backend)
+		if err != nil {
+			fatal(err)
+		}
+		if err = printJSON(result); err != nil {
+			fatal(err)
+		}
+	case ModeDiagnose:
+		result, err := writeDiagnostics(ctx, *archiveFile)
+		if err != nil {
+			fatal(err)
+		}
+		if err = printJSON(result); err != nil {
+			fatal(err)
+		}
+	case ModeCacheList:
+		if err := printCache(ctx); err != nil {
+			fatal(err)
+		}
+	case ModeCacheClear:
+		result, err := clearCache(ctx, *keepInstalled)
+		if err != nil {
+			fatal(err)
+		}
+		log.Printf("Freed %d bytes", result.FreedBytes)
+		if err = printJSON(result); err != nil {
+			fatal(err)
 		}
 	}
 }
 
+// fatal logs the error and exits; errors are logged even with -quiet.
+func fatal(err error) {
+	log.SetOutput(os.Stderr)
+	log.Fatal(err)
+}
+
 // Check if Ollama is already running.
 func checkExistingInstance(ctx context.Context) (bool, error) {
 	log.Printf("Checking if %s returns a valid response...", checkURL)
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, checkURL, nil)
-	if err != nil {
-		return false, fmt.Errorf("failed to check Ollama: %v", err)
-	}
-	resp, err := http.DefaultClient.Do(req)
-	if err == nil && resp.StatusCode < 400 {
-		defer resp.Body.Close()
+	err := checkHealth(ctx, *healthTimeout)
+	if err == nil {
 		log.Printf("Ollama seems to be running correctly.")
 		return true, nil
 	}
+	if ctx.Err() != nil {
+		return false, err
+	}
+	if !errors.Is(err, ErrServerNotStarted) {
+		log.Printf("Ollama is not responding correctly: %s", err)
+	}
 	return false, nil
 }
 
-func install(ctx context.Context) error {
+func install(ctx context.Context) (*types.InstallResult, error) {
+	start := time.Now()
 	isRunning, err := checkExistingInstance(ctx)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	externalPath, err := useExternalServer(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if externalPath != "" {
+		log.Printf("Reusing externally managed ollama at %s", externalPath)
+		result := newInstallResult(externalPath)
+		result.External = true
+		return result, nil
 	}
 	if isRunning {
-		return nil
+		return newInstallResult(""), nil
+	}
+	executablePath, err := findExecutable(ctx, false)
+	if err != nil {
+		return nil, err
 	}
-	executablePath := findExecutable(ctx, false)
+	result := newInstallResult(executablePath)
 	if executablePath == "" {
 		// If a previous executable is not found, install it to the default
 		// location.
 		installLocation, err := getDefaultInstallLocation(ctx)
 		if err != nil {
-			return fmt.Errorf("failed to get install location: %w", err)
+			return nil, fmt.Errorf("failed to get install location: %w", err)
 		}
-		_, err = installOllama(ctx, *releaseVersion, installLocation)
+		if err = checkInstallLocation(installLocation); err != nil {
+			return nil, err
+		}
+		if err = ensureWritableDirectory(filepath.Dir(installLocation), "install directory"); err != nil {
+			return nil, err
+		}
+		warnIfNetworkFilesystem(installLocation)
+		result, err = installOllama(ctx, *releaseVersion, installLocation)
+		if err != nil {
+			return nil, fmt.Errorf("failed to install ollama: %w", err)
+		}
+		executablePath = result.ExecutablePath
+		err = updateState(ctx, func(state *installerState) error {
+			state.InstalledArchives = result.Checksums
+			return nil
+		})
 		if err != nil {
-			return fmt.Errorf("failed to install ollama: %w", err)
+			return nil, err
+		}
+		if err = recordInstalledFiles(ctx, installLocation); err != nil {
+			log.Printf("Warning: %s; repair will preserve all changed files", err)
 		}
 	}
 
 	// To ensure the file has been completely written (and virus scanners are done
 	// scanning), try to run it a few times.
 	for i := 0; i < 10; i++ {
-		if err = exec.Command(executablePath, "--version").Run(); err == nil {
+		if result.Version, err = getExecutableVersion(ctx, executablePath); err == nil {
 			break
 		}
 		time.Sleep(time.Second)
 	}
+	if err != nil {
+		log.Printf("Failed to determine installed version: %s", err)
+	}
+	result.DurationSeconds = time.Since(start).Seconds()
 
-	return nil
+	return result, nil
+}
+
+// newInstallResult creates the result of an install, for an install at the
+// given path.
+func newInstallResult(executablePath string) *types.InstallResult {
+	return &types.InstallResult{SchemaVersion: types.SchemaVersion, ExecutablePath: executablePath}
+}
+
+// newUninstallResult creates the result of uninstalling the install at the
+// given path, recording whether it is present and its version.  This must be
+// called before anything is removed.
+func newUninstallResult(ctx context.Context, installPath, executablePath string) *types.UninstallResult {
+	result := &types.UninstallResult{SchemaVersion: types.SchemaVersion, Path: installPath}
+	if _, err := os.Lstat(installPath); err == nil {
+		result.Removed = true
+	}
+	if _, err := os.Stat(executablePath); err == nil {
+		if version, err := getExecutableVersion(ctx, executablePath); err == nil {
+			result.Version = version
+		} else {
+			log.Printf("Failed to determine version of %s: %s", executablePath, err)
+		}
+	}
+	return result
+}
+
+// addInstalledAsset records that the given (downloaded) asset was installed.
+func addInstalledAsset(result *types.InstallResult, assetName, archivePath string) {
+	result.Assets = append(result.Assets, assetName)
+	// Cached archives are stored in a directory named after their checksum.
+	result.Checksums = append(result.Checksums, filepath.Base(filepath.Dir(archivePath)))
+	if info, err := os.Stat(archivePath); err == nil {
+		result.Size += info.Size()
+	}
 }
 
 type releaseInfo struct {
-	AssetsURL string `json:"assets_url"`
+	TagName     string    `json:"tag_name"`
+	Name        string    `json:"name"`
+	PublishedAt time.Time `json:"published_at"`
+	HTMLURL     string    `json:"html_url"`
+	AssetsURL   string    `json:"assets_url"`
+	Body        string    `json:"body"` // Release notes, in Markdown.
+	Draft       bool      `json:"draft"`
+	Prerelease  bool      `json:"prerelease"`
 }
 
 type assetInfo struct {
@@ -135,54 +851,78 @@ type assetInfo struct {
 	URL  string `json:"browser_download_url"`
 }
 
-// getReleaseAssetURL returns the download URL for a specific asset in a release.
-func getReleaseAssetURL(ctx context.Context, release, assetName string) (string, error) {
-	releaseURL := fmt.Sprintf("https://api.github.com/repos/ollama/ollama/releases/tags/%s", release)
+// getRelease returns information about a release; release may be "latest".
+func getRelease(ctx context.Context, release string) (*releaseInfo, error) {
+	releaseURL, err := url.JoinPath(*githubAPIURL, "repos", releaseRepo, "releases", "tags", release)
 	if release == "latest" {
-		releaseURL = "https://api.github.com/repos/ollama/ollama/releases/latest"
+		releaseURL, err = url.JoinPath(*githubAPIURL, "repos", releaseRepo, "releases", "latest")
 	}
-	releaseReq, err := http.NewRequestWithContext(ctx, http.MethodGet, releaseURL, nil)
 	if err != nil {
-		return "", fmt.Errorf("failed to find release: %w", err)
+		return nil, fmt.Errorf("failed to find release: %w", err)
 	}
-	releaseResp, err := http.DefaultClient.Do(releaseReq)
+	releaseReq, err := newRequest(ctx, http.MethodGet, releaseURL, nil)
 	if err != nil {
-		return "", fmt.Errorf("failed to find release: %w", err)
+		return nil, fmt.Errorf("failed to find release: %w", err)
+	}
+	releaseResp, err := downloadClient().Do(releaseReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find release: %w", err)
 	}
 	defer releaseResp.Body.Close()
 	if releaseResp.StatusCode >= 300 {
-		return "", fmt.Errorf("failed to find release: unexpected status %s", releaseResp.Status)
+		return nil, fmt.Errorf("failed to find release: unexpected status %s", releaseResp.Status)
 	}
 	releaseBody, err := io.ReadAll(releaseResp.Body)
 	if err != nil {
-		return "", fmt.Errorf("failed to find release: reading response: %w", err)
+		return nil, fmt.Errorf("failed to find release: reading response: %w", err)
 	}
-	var releaseInfo releaseInfo
-	if err = json.Unmarshal(releaseBody, &releaseInfo); err != nil {
-		return "", fmt.Errorf("failed to find release: error unmarshaling response: %w", err)
+	var info releaseInfo
+	if err = json.Unmarshal(releaseBody, &info); err != nil {
+		return nil, fmt.Errorf("failed to find release: error unmarshaling response: %w", err)
 	}
+	return &info, nil
+}
 
-	assetsReq, err := http.NewRequestWithContext(ctx, http.MethodGet, releaseInfo.AssetsURL, nil)
+// listReleaseAssets returns the assets published with a release.
+func listReleaseAssets(ctx context.Context, release string) ([]assetInfo, error) {
+	releaseInfo, err := getRelease(ctx, release)
 	if err != nil {
-		return "", fmt.Errorf("failed to find assets: %w", err)
+		return nil, err
 	}
-	assetsResp, err := http.DefaultClient.Do(assetsReq)
+
+	assetsReq, err := newRequest(ctx, http.MethodGet, releaseInfo.AssetsURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find assets: %w", err)
+	}
+	assetsResp, err := downloadClient().Do(assetsReq)
 	if err != nil {
-		return "", fmt.Errorf("failed to find assets: %w", err)
+		return nil, fmt.Errorf("failed to find assets: %w", err)
 	}
 	defer assetsResp.Body.Close()
 	if assetsResp.StatusCode >= 300 {
-		return "", fmt.Errorf("failed to find assets: unexpected status %s", assetsResp.Status)
+		return nil, fmt.Errorf("failed to find assets: unexpected status %s", assetsResp.Status)
 	}
 	assetsBody, err := io.ReadAll(assetsResp.Body)
 	if err != nil {
-		return "", fmt.Errorf("failed to find assets: reading response: %w", err)
+		return nil, fmt.Errorf("failed to find assets: reading response: %w", err)
 	}
 	var assets []assetInfo
 	if err = json.Unmarshal(assetsBody, &assets); err != nil {
-		return "", fmt.Errorf("failed to find assets: error unmarshaling response: %w", err)
+		return nil, fmt.Errorf("failed to find assets: error unmarshaling response: %w", err)
+	}
+	return assets, nil
+}
+
+// getReleaseAssetURL returns the download URL for a specific asset in a release.
+func getReleaseAssetURL(ctx context.Context, release, assetName string) (string, error) {
+	if *mirrorURL != "" {
+		return url.JoinPath(*mirrorURL, release, assetName)
 	}
 
+	assets, err := listReleaseAssets(ctx, release)
+	if err != nil {
+		return "", err
+	}
 	for _, asset := range assets {
 		if asset.Name == assetName {
 			return asset.URL, nil
@@ -192,10 +932,63 @@ func getReleaseAssetURL(ctx context.Context, release, assetName string) (string,
 	return "", fmt.Errorf("failed to find asset %q in release %q", assetName, release)
 }
 
+// availableAssets returns which of the given assets a release has.  A mirror
+// cannot list its assets, so each is probed in turn instead.
+func availableAssets(ctx context.Context, release string, candidates []string) ([]string, error) {
+	var available []string
+	if *mirrorURL != "" {
+		for _, candidate := range candidates {
+			assetURL, err := getReleaseAssetURL(ctx, release, candidate)
+			if err != nil {
+				return nil, err
+			}
+			req, err := newAssetRequest(ctx, http.MethodHead, assetURL)
+			if err != nil {
+				return nil, fmt.Errorf("failed to find assets: %w", err)
+			}
+			resp, err := downloadClient().Do(req)
+			if err != nil {
+				return nil, fmt.Errorf("failed to find assets: %w", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode < 300 {
+				available = append(available, candidate)
+			}
+		}
+		return available, nil
+	}
+	assets, err := listReleaseAssets(ctx, release)
+	if err != nil {
+		return nil, err
+	}
+	for _, candidate := range candidates {
+		if slices.ContainsFunc(assets, func(asset assetInfo) bool { return asset.Name == candidate }) {
+			available = append(available, candidate)
+		}
+	}
+	return available, nil
+}
+
 // Get the default install location.  Note that this does not return the
 // location of any externally installed copies of ollama.
 func getDefaultInstallLocation(ctx context.Context) (string, error) {
-	executable, err := os.Executable()
+	if *installDir != "" {
+		return filepath.Abs(*installDir)
+	}
+	return getScopeInstallLocation(installScope)
+}
+
+// installerExecutable returns the path of the installer itself, from which the
+// extension's directories are found.
+var installerExecutable = os.Executable
+
+// getScopeInstallLocation returns the default install location for the given
+// scope: within the extension for the user, or a system-wide location.
+func getScopeInstallLocation(scope string) (string, error) {
+	if scope == InstallScopeSystem {
+		return systemInstallLocation()
+	}
+	executable, err := installerExecutable()
 	if err != nil {
 		return "", fmt.Errorf("failed to find executable path: %w", err)
 	}
@@ -203,6 +996,21 @@ func getDefaultInstallLocation(ctx context.Context) (string, error) {
 	return filepath.Join(extensionDir, "ollama"), nil
 }
 
+// Get the directory used to hold installer state, such as in-progress pulls.
+// This must be writable, unlike the install location which may be read-only
+// once ollama has been installed.
+func getStateDirectory(ctx context.Context) (string, error) {
+	if *stateDir != "" {
+		return filepath.Abs(*stateDir)
+	}
+	executable, err := installerExecutable()
+	if err != nil {
+		return "", fmt.Errorf("failed to find executable path: %w", err)
+	}
+	extensionDir := filepath.Dir(filepath.Dir(executable))
+	return filepath.Join(extensionDir, "state"), nil
+}
+
 // Print "true" if Ollama is installed, or "false" otherwise.
 func checkInstall(ctx context.Context) error {
 	isRunning, err := checkExistingInstance(ctx)
@@ -212,7 +1020,12 @@ func checkInstall(ctx context.Context) error {
 		}
 		return nil
 	}
-	location := findExecutable(ctx, false)
+	location, err := findExecutable(ctx, false)
+	if err != nil {
+		// Do not report "false" here, as that would prompt a reinstall over a
+		// managed install we merely failed to locate.
+		return err
+	}
 	if location != "" {
 		if _, err := os.Stat(location); err == nil {
 			if _, err = fmt.Println("true"); err != nil {
@@ -221,6 +1034,15 @@ func checkInstall(ctx context.Context) error {
 			return nil
 		}
 	}
+	if locations, err := searchExecutable(ctx, false); err == nil {
+		for _, location := range locations {
+			if location.Broken != "" {
+				log.Printf("Searched %s (broken: %s)", location.Path, location.Broken)
+			} else {
+				log.Printf("Searched %s (not found)", location.Path)
+			}
+		}
+	}
 	fmt.Println("false")
 	return nil
 }
@@ -233,53 +1055,98 @@ func startOllama(ctx context.Context) error {
 	if isRunning {
 		return nil
 	}
+	externalPath, err := useExternalServer(ctx)
+	if err != nil {
+		return err
+	}
+	if externalPath != "" {
+		// Ollama.app is running but its server is not responding yet; wait for
+		// it rather than starting a second server on the same port.
+		log.Printf("Using externally managed ollama at %s", externalPath)
+		if err = waitForServer(ctx); err != nil {
+			return err
+		}
+	} else if err = startServe(ctx); err != nil {
+		return err
+	}
+
+	if *modelName != "" {
+		if err = runPull(ctx, *modelName); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
 
-	executablePath := findExecutable(ctx, false)
+// startServe starts the ollama server, waiting until it responds.
+func startServe(ctx context.Context) error {
+	executablePath, err := findExecutable(ctx, false)
+	if err != nil {
+		return err
+	}
 	if executablePath == "" {
 		return fmt.Errorf("failed to find ollama executable; was it installed?")
 	}
 
-	// Do not wait for serveProc to complete.
-	serveProc := exec.Command(executablePath, "serve")
-	serveProc.Stdout = os.Stdout
-	serveProc.Stderr = os.Stderr
-	if err = serveProc.Start(); err != nil {
-		return fmt.Errorf("failed to start ollama server: %v", err)
+	stateDir, err := getStateDirectory(ctx)
+	if err != nil {
+		return err
+	}
+	if err = ensureWritableDirectory(stateDir, "state directory"); err != nil {
+		return err
+	}
+	modelsDir, err := ensureModelsDirectory(ctx)
+	if err != nil {
+		return err
 	}
 
-	log.Printf("Waiting for %s to succeed...", checkURL)
-	for {
-		req, err := http.NewRequestWithContext(ctx, http.MethodGet, checkURL, nil)
-		if err != nil {
-			return fmt.Errorf("failed to check Ollama: %v", err)
-		}
-		resp, err := http.DefaultClient.Do(req)
-		if err == nil && resp.StatusCode < 400 {
-			resp.Body.Close()
-			break
+	// Do not wait for the serve process (or its supervisor) to complete.
+	if *watchdog {
+		if err = startSupervisor(); err != nil {
+			return err
 		}
-		time.Sleep(time.Second)
+	} else if _, err = launchServe(ctx, executablePath, modelsDir); err != nil {
+		return err
 	}
 
-	if *pullModel != "" {
-		pullProc := exec.CommandContext(ctx, executablePath, "pull", *pullModel)
-		pullProc.Stdout = os.Stdout
-		pullProc.Stderr = os.Stderr
-		if err = pullProc.Run(); err != nil {
-			return fmt.Errorf("failed to pull %s: %v", *pullModel, err)
-		}
+	return waitForServer(ctx)
+}
+
+// waitForServer waits until the ollama server responds, and warns if its
+// version is not supported.
+func waitForServer(ctx context.Context) error {
+	log.Printf("Waiting for %s to succeed...", checkURL)
+	if err := waitForHealthy(ctx, *startTimeout); err != nil {
+		return err
+	}
+
+	if version, err := getRunningVersion(ctx); err != nil {
+		log.Printf("Failed to determine ollama version: %s", err)
+	} else if compatibility, message := checkVersionCompatibility(version); compatibility != CompatibilitySupported {
+		log.Printf("Warning: %s", message)
 	}
 
 	return nil
 }
 
 func shutdownOllama(ctx context.Context) error {
-	executablePath := findExecutable(ctx, true)
+	// Stop any supervisor first, so that it does not restart ollama.
+	if err := stopSupervisor(ctx); err != nil {
+		log.Printf("Failed to stop supervisor: %s", err)
+	}
+	executablePath, err := findExecutable(ctx, true)
+	if err != nil {
+		return err
+	}
+	if externalPath, err := findExternalServer(ctx); err == nil && externalPath != "" {
+		log.Printf("Leaving externally managed ollama at %s running", externalPath)
+	}
 	if executablePath == "" {
 		// When shutting down, it is not an error if it was not found.
 		return nil
 	}
-	err := terminateProcess(ctx, executablePath)
+	_, err = terminateProcess(ctx, executablePath)
 	if err != nil {
 		return err
 	}
@@ -6,11 +6,17 @@ import (
 	"fmt"
 	"io"
 	"log"
-	"net/http"
+	"net"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"runtime"
 	"slices"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/rancher-sandbox/rd-open-webui-docker-ext/installer/types"
 	"golang.org/x/sys/unix"
 )
 
@@ -19,16 +25,21 @@ const (
 	KERN_PROCARGS = 38
 )
 
-// Find an existing install of ollama; if defaultOnly is false, this may include
-// externally installed copies of ollama.  If not found, returns empty string.
-func findExecutable(ctx context.Context, defaultOnly bool) string {
+// Get the locations where ollama may be installed, in order of preference; if
+// defaultOnly is false, this may include externally installed copies of ollama.
+func executableCandidates(ctx context.Context, defaultOnly bool) ([]string, error) {
 	var potentialLocations []string
 
-	if installLocation, err := getDefaultInstallLocation(ctx); err == nil {
-		potentialLocations = append(potentialLocations, installLocation)
+	installLocation, err := getDefaultInstallLocation(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to determine default install location: %w", err)
 	}
+	potentialLocations = append(potentialLocations, installLocation)
 
 	if !defaultOnly {
+		if alternate := alternateInstallLocation(); alternate != "" {
+			potentialLocations = append(potentialLocations, alternate)
+		}
 		potentialLocations = append(potentialLocations,
 			"/usr/local/bin/ollama",
 			"/Applications/Ollama.app/Contents/Resources/ollama",
@@ -40,136 +51,366 @@ func findExecutable(ctx context.Context, defaultOnly bool) string {
 		}
 	}
 
-	for _, location := range potentialLocations {
-		if _, err := os.Stat(location); err == nil {
-			// Found an existing ollama
-			return location
-		}
-	}
-	return ""
+	return potentialLocations, nil
 }
 
-func installOllama(ctx context.Context, release, executablePath string) (string, error) {
+func installOllama(ctx context.Context, release, executablePath string) (*types.InstallResult, error) {
+	result := newInstallResult(executablePath)
 	if _, err := os.Stat(executablePath); err == nil {
-		return executablePath, nil
+		return result, nil
 	} else if !errors.Is(err, os.ErrNotExist) {
-		return "", fmt.Errorf("failed to check ollama executable: %w", err)
+		return nil, fmt.Errorf("failed to check ollama executable: %w", err)
 	}
 
-	assetURL, err := getReleaseAssetURL(ctx, release, "ollama-darwin")
+	selection, err := selectAsset(ctx)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-
-	log.Printf("Downloading ollama from %s...", assetURL)
-
-	// For darwin, Ollama is a single executable.
-	if err = os.MkdirAll(filepath.Dir(executablePath), 0o755); err != nil {
-		return "", fmt.Errorf("failed to create ollama directory: %w", err)
+	chooseReleaseAssets(ctx, release, selection)
+	result.Accelerator = selection.Accelerator
+	manifest, err := loadFileManifest()
+	if err != nil {
+		return nil, err
 	}
-	file, err := os.OpenFile(executablePath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o755)
+	cachedPath, err := fetchAsset(ctx, release, selection.Assets[0])
 	if err != nil {
-		return "", fmt.Errorf("failed to create executable: %w", err)
+		return nil, err
 	}
-	defer file.Close()
-	succeeded := false
-	defer func() {
-		if !succeeded {
-			os.Remove(executablePath)
-		}
-	}()
+	addInstalledAsset(result, selection.Assets[0], cachedPath)
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, assetURL, nil)
+	// For darwin, Ollama is a single executable; copy it from the cache.  It is
+	// not hard linked, as changing the mode of the install would then change
+	// the cached file as well.  It is staged first so that a failed install
+	// leaves nothing behind.
+	stagingPath, err := newStagingDirectory(executablePath)
 	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
+		return nil, err
 	}
-	resp, err := http.DefaultClient.Do(req)
+	defer os.RemoveAll(stagingPath)
+	stagedPath := filepath.Join(stagingPath, filepath.Base(executablePath))
+	cached, err := os.Open(cachedPath)
 	if err != nil {
-		return "", fmt.Errorf("failed to download ollama: %w", err)
+		return nil, fmt.Errorf("failed to open ollama download: %w", err)
+	}
+	defer cached.Close()
+	var cachedSize int64
+	if info, err := cached.Stat(); err == nil {
+		cachedSize = info.Size()
+	}
+	// The download was verified when it was downloaded.
+	if err = installFromReader(cached, cachedSize, "", stagedPath, manifest); err != nil {
+		return nil, err
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode >= 300 {
-		return "", fmt.Errorf("error downloading ollama: status %s", resp.Status)
+	if err = manifest.checkComplete(); err != nil {
+		return nil, err
 	}
-	length, err := io.Copy(file, resp.Body)
+	if err = checkExecutableArchitecture(stagedPath); err != nil {
+		return nil, err
+	}
+	if err = verifyExecutableVersion(ctx, release, stagedPath); err != nil {
+		return nil, err
+	}
+	if err = commitStaged(stagedPath, executablePath); err != nil {
+		return nil, err
+	}
+
+	result.Fresh = true
+	return result, nil
+}
+
+// installFromReader writes the ollama executable, of the given size (if known),
+// to executablePath, without regard to where it came from.  It is checked
+// against the checksum (if given) and the manifest (if any).  The caller must
+// remove executablePath on failure, and check the manifest is complete.
+func installFromReader(r io.Reader, size int64, checksum, executablePath string, manifest *fileManifest) error {
+	reporter := newProgressReporter(PhaseExtract, size)
+	reporter.setFile(filepath.Base(executablePath))
+	verifier := newVerifyingReader(r, size, checksum)
+	file, err := os.OpenFile(executablePath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o755)
 	if err != nil {
-		return "", fmt.Errorf("failed to write ollama: %w", err)
+		return fmt.Errorf("failed to write ollama: %w", err)
 	}
-	if resp.ContentLength > 0 {
-		if length < resp.ContentLength {
-			return "", fmt.Errorf("partial read downloading ollama")
-		}
+	_, err = io.Copy(file, &progressReader{Reader: verifier, reporter: reporter})
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if isDiskFullError(err) {
+		return fmt.Errorf("failed to write ollama: %w: %w", ErrInsufficientSpace, err)
+	} else if err != nil {
+		return fmt.Errorf("failed to write ollama: %w", err)
+	}
+	if err = verifier.verify(); err != nil {
+		return err
+	}
+	// The file may have existed with a different mode.
+	if err = os.Chmod(executablePath, resolveExecutableMode(0o755)); err != nil {
+		return fmt.Errorf("failed to change ollama file mode: %w", err)
 	}
-	if err = file.Chmod(0o755); err != nil {
-		return "", fmt.Errorf("failed to change ollama file mode: %w", err)
+	if err = manifest.checkFile(filepath.Base(executablePath), executablePath); err != nil {
+		return err
 	}
-	succeeded = true
+	reporter.done()
+	return nil
+}
 
-	return executablePath, nil
+// detectAccelerator returns the GPU acceleration available on this machine.
+func detectAccelerator() string {
+	if runtime.GOARCH == "arm64" {
+		return AcceleratorMetal
+	}
+	return AcceleratorCPU
 }
 
-func uninstallOllama(ctx context.Context) error {
+// getSystemMemory returns the total system memory, in bytes; the available
+// memory is not reported, as macOS readily frees caches.  The memory is shared
+// with the GPU.
+func getSystemMemory() (uint64, uint64, error) {
+	total, err := unix.SysctlUint64("hw.memsize")
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to read system memory: %w", err)
+	}
+	return total, 0, nil
+}
+
+// networkFilesystems are the names of network filesystem types.
+var networkFilesystems = []string{"nfs", "smbfs", "afpfs", "webdav", "ftp", "cifs"}
+
+// getFilesystemType returns the type of the filesystem containing the given
+// (existing) path, and whether it is a network filesystem.
+func getFilesystemType(path string) (string, bool, error) {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(path, &stat); err != nil {
+		return "", false, &os.PathError{Op: "statfs", Path: path, Err: err}
+	}
+	name := unix.ByteSliceToString(stat.Fstypename[:])
+	return name, slices.Contains(networkFilesystems, name), nil
+}
+
+// selectAsset determines which release assets to install.  The darwin
+// executable is universal.
+func selectAsset(ctx context.Context) (*assetSelection, error) {
+	selection := newAssetSelection(ctx, detectAccelerator())
+	if *assetName != "" {
+		selection.Assets = []string{*assetName}
+	} else {
+		candidates := darwinAssetCandidates()
+		selection.Assets = candidates[len(candidates)-1:]
+	}
+	return selection, nil
+}
+
+// darwinAssetCandidates returns the names the macOS asset may be published
+// under, in order of preference: a build for this architecture alone is smaller
+// than the universal one, which every release has.
+func darwinAssetCandidates() []string {
+	return []string{"ollama-darwin-" + runtime.GOARCH, "ollama-darwin"}
+}
+
+// chooseReleaseAssets updates the selection for the assets the release actually
+// has, preferring an asset for this architecture alone over the universal one.
+// If the release cannot be checked, the universal asset is kept; the -asset
+// override is always kept.
+func chooseReleaseAssets(ctx context.Context, release string, selection *assetSelection) {
+	if *assetName != "" {
+		return
+	}
+	available, err := availableAssets(ctx, release, darwinAssetCandidates())
+	if err != nil {
+		log.Printf("Could not check the assets of release %s, using %s: %s", release, selection.Assets[0], err)
+		return
+	}
+	if len(available) > 0 {
+		selection.Assets = available[:1]
+	}
+	log.Printf("Selected asset %s of release %s", selection.Assets[0], release)
+}
+
+func uninstallOllama(ctx context.Context) (*types.UninstallResult, error) {
 	installPath, err := getDefaultInstallLocation(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to find ollama install: %w", err)
+		return nil, fmt.Errorf("failed to find ollama install: %w", err)
+	}
+	if err = checkInstallLocation(installPath); err != nil {
+		return nil, err
+	}
+	if err = checkInstallOwnership(installPath); err != nil {
+		return nil, err
 	}
-	if err = terminateProcess(ctx, installPath); err != nil {
-		return fmt.Errorf("error terminating existing ollama process: %w", err)
+	result := newUninstallResult(ctx, installPath, installPath)
+	if result.TerminatedPIDs, err = terminateProcess(ctx, installPath); err != nil {
+		return nil, fmt.Errorf("error terminating existing ollama process: %w", err)
 	}
+
 	err = os.Remove(installPath)
 	if err != nil && !errors.Is(err, os.ErrNotExist) {
-		return err
+		return nil, err
+	}
+	// The marker is beside the executable, rather than removed with it.
+	err = os.Remove(installMarkerPath(installPath))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
 	}
 
-	return nil
+	return result, nil
 }
 
-func terminateProcess(ctx context.Context, executablePath string) error {
+// listProcesses returns all processes on the system.  The sysctl can fail
+// transiently on busy systems (for example, if the process table grows between
+// sizing the buffer and reading it), so retry a few times before giving up.
+func listProcesses(ctx context.Context) ([]unix.KinfoProc, error) {
+	const attempts = 3
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		var procs []unix.KinfoProc
+		procs, err = unix.SysctlKinfoProcSlice("kern.proc.all")
+		if err == nil {
+			return procs, nil
+		}
+		if attempt == attempts || (!errors.Is(err, unix.ENOMEM) && !errors.Is(err, unix.EINTR) && !errors.Is(err, unix.EAGAIN)) {
+			break
+		}
+		log.Printf("Failed to list processes (attempt %d of %d): %s", attempt, attempts, err)
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("failed to list processes: %w", ctx.Err())
+		case <-time.After(time.Duration(attempt) * 100 * time.Millisecond):
+		}
+	}
+	return nil, fmt.Errorf("failed to list processes: %w", err)
+}
+
+// processPath returns the executable path of the given process, or the empty
+// string if it could not be determined.  Interrupted calls are retried.
+func processPath(pid int) string {
+	const attempts = 3
+	var buf []byte
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		buf, err = unix.SysctlRaw(CTL_KERN, KERN_PROCARGS, pid)
+		if !errors.Is(err, unix.EINTR) {
+			break
+		}
+	}
+	if err != nil {
+		if !errors.Is(err, unix.EINVAL) {
+			log.Printf("Failed to get command line of pid %d: %s", pid, err)
+		}
+		return ""
+	}
+	return parseProcessArgs(buf)
+}
+
+// parseProcessArgs returns the executable path from a KERN_PROCARGS buffer, or
+// the empty string if the buffer is malformed (such as being truncated).
+func parseProcessArgs(buf []byte) string {
+	// The buffer starts with a null-terminated executable path, plus
+	// command line arguments and things.
+	index := slices.Index(buf, 0)
+	if index < 1 || buf[0] != '/' {
+		// If we have unexpected data, don't fall over; a truncated or
+		// otherwise malformed buffer is skipped rather than mis-parsed.
+		return ""
+	}
+	return string(buf[:index])
+}
+
+// findExternalServer returns the path of a running Ollama.app process, or the
+// empty string if none is running.  Ollama.app manages its own server (from
+// its menu bar item), so we must never stop or uninstall it.
+func findExternalServer(ctx context.Context) (string, error) {
+	procs, err := listProcesses(ctx)
+	if err != nil {
+		return "", err
+	}
+	for _, proc := range procs {
+		procPath := processPath(int(proc.Proc.P_pid))
+		if strings.Contains(procPath, "/Ollama.app/Contents/") {
+			return procPath, nil
+		}
+	}
+	return "", nil
+}
+
+func terminateProcess(ctx context.Context, executablePath string) ([]int, error) {
 	executableInfo, err := os.Stat(executablePath)
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
-			return nil
+			return nil, nil
 		}
-		return fmt.Errorf("failed to get executable info: %w", err)
+		return nil, fmt.Errorf("failed to get executable info: %w", err)
+	}
+	pids, err := findProcesses(ctx, func(_ int, _ string, info os.FileInfo) bool {
+		return os.SameFile(executableInfo, info)
+	})
+	if err != nil {
+		return nil, err
 	}
+	return stopProcesses(ctx, pids), nil
+}
 
-	procs, err := unix.SysctlKinfoProcSlice("kern.proc.all")
+// findProcesses returns the pids of the processes whose executable matches;
+// match is called with the pid of each process and the path and info of its
+// executable.
+func findProcesses(ctx context.Context, match func(pid int, executablePath string, info os.FileInfo) bool) ([]int, error) {
+	procs, err := listProcesses(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to list processes: %w", err)
+		return nil, err
 	}
+	var pids []int
 	for _, proc := range procs {
 		pid := int(proc.Proc.P_pid)
-		buf, err := unix.SysctlRaw(CTL_KERN, KERN_PROCARGS, pid)
-		if err != nil {
-			if !errors.Is(err, unix.EINVAL) {
-				log.Printf("Failed to get command line of pid %d: %s", pid, err)
-			}
-			continue
-		}
-		// The buffer starts with a null-terminated executable path, plus
-		// command line arguments and things.
-		index := slices.Index(buf, 0)
-		if index < 0 {
-			// If we have unexpected data, don't fall over.
+		procPath := processPath(pid)
+		if procPath == "" {
 			continue
 		}
-		procPath := string(buf[:index])
 		procInfo, err := os.Stat(procPath)
 		if err != nil {
 			continue
 		}
-		if os.SameFile(executableInfo, procInfo) {
-			process, err := os.FindProcess(pid)
-			if err != nil {
-				continue
-			}
-			err = process.Signal(unix.SIGTERM)
-			if err == nil {
-				log.Printf("Terminated process %d", pid)
-			} else if !errors.Is(err, unix.EINVAL) {
-				log.Printf("Ignoring failure to terminate pid %d: %s", pid, err)
-			}
+		if match(pid, procPath, procInfo) {
+			pids = append(pids, pid)
 		}
 	}
-	return nil
+	return pids, nil
+}
+
+// findPortListener returns the pid of the process listening on the given TCP
+// port, as reported by lsof.  Returns 0 if nothing is listening; if something
+// is, but the process cannot be seen, -1 is returned.
+func findPortListener(ctx context.Context, port int) (int, error) {
+	output, err := exec.CommandContext(ctx, "lsof", "-nP", fmt.Sprintf("-iTCP:%d", port), "-sTCP:LISTEN", "-t").Output()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 && len(output) == 0 {
+		// lsof found nothing; it cannot list the sockets of other users'
+		// processes without root, so check whether the port is in use at all.
+		conn, err := net.DialTimeout("tcp", fmt.Sprintf("127.0.0.1:%d", port), time.Second)
+		if err != nil {
+			return 0, nil
+		}
+		conn.Close()
+		return -1, nil
+	} else if err != nil {
+		return 0, fmt.Errorf("failed to find process listening on port %d: %w", port, err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(strings.SplitN(string(output), "\n", 2)[0]))
+	if err != nil {
+		return 0, fmt.Errorf("failed to find process listening on port %d: unexpected output %q", port, output)
+	}
+	return pid, nil
+}
+
+// processExecutable returns the path of the executable the given process runs.
+func processExecutable(pid int) (string, error) {
+	if executablePath := processPath(pid); executablePath != "" {
+		return executablePath, nil
+	}
+	return "", fmt.Errorf("failed to get executable of process %d", pid)
+}
+
+// systemInstallLocation returns the system-wide install location; on macOS,
+// this is the path of the executable itself.  It is in a directory of its own,
+// rather than /usr/local/bin, where Homebrew and manual installs of ollama live
+// and which must not be mistaken for the managed install.
+func systemInstallLocation() (string, error) {
+	return "/opt/ollama/ollama", nil
 }
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestParseProcessArgs(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		buf  []byte
+		want string
+	}{
+		{"valid", []byte("/usr/local/bin/ollama\x00\x00\x00ollama\x00serve\x00"), "/usr/local/bin/ollama"},
+		{"path only", []byte("/usr/local/bin/ollama\x00"), "/usr/local/bin/ollama"},
+		{"nil", nil, ""},
+		{"empty", []byte{}, ""},
+		{"truncated path", []byte("/usr/local/bin/oll"), ""},
+		{"leading terminator", []byte("\x00/usr/local/bin/ollama\x00"), ""},
+		{"relative path", []byte("ollama\x00serve\x00"), ""},
+		{"garbage", []byte{0x03, 0x00, 0x00, 0x00, '/', 'x', 0x00}, ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseProcessArgs(tt.buf); got != tt.want {
+				t.Errorf("parseProcessArgs(%q) = %q, want %q", tt.buf, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSystemInstallLocationIsDedicated(t *testing.T) {
+	location, err := systemInstallLocation()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Other installs of ollama live in these directories; the managed one
+	// must not, or it would be mistaken for them (and they for it).
+	for _, shared := range []string{"/usr/local/bin", "/opt/homebrew/bin", "/usr/bin"} {
+		if filepath.Dir(location) == shared {
+			t.Errorf("system install location %s is in the shared directory %s", location, shared)
+		}
+	}
+}
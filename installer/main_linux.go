@@ -2,90 +2,145 @@ package main
 
 import (
 	"archive/tar"
-	"compress/gzip"
 	"context"
 	"errors"
 	"fmt"
 	"io"
 	"log"
-	"net/http"
 	"os"
 	"path/filepath"
 	"runtime"
 	"strconv"
+	"strings"
 
+	"github.com/rancher-sandbox/rd-open-webui-docker-ext/installer/types"
 	"golang.org/x/sys/unix"
 )
 
-func findExecutable(ctx context.Context, defaultOnly bool) string {
+// Get the locations where ollama may be installed, in order of preference; if
+// defaultOnly is false, this may include externally installed copies of ollama.
+func executableCandidates(ctx context.Context, defaultOnly bool) ([]string, error) {
 	var potentialLocations []string
 
-	if installLocation, err := getDefaultInstallLocation(ctx); err == nil {
-		executablePath := filepath.Join(installLocation, "bin", "ollama")
-		potentialLocations = append(potentialLocations, executablePath)
+	installLocation, err := getDefaultInstallLocation(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to determine default install location: %w", err)
 	}
+	executablePath := filepath.Join(installLocation, "bin", "ollama")
+	potentialLocations = append(potentialLocations, executablePath)
 
 	if !defaultOnly {
+		if alternate := alternateInstallLocation(); alternate != "" {
+			potentialLocations = append(potentialLocations, filepath.Join(alternate, "bin", "ollama"))
+		}
 		potentialLocations = append(potentialLocations, "/usr/local/bin/ollama")
 	}
 
-	for _, location := range potentialLocations {
-		if _, err := os.Stat(location); err == nil {
-			// Found an existing ollama
-			return location
-		}
-	}
-	return ""
+	return potentialLocations, nil
 }
 
-func installOllama(ctx context.Context, release, installPath string) (string, error) {
+func installOllama(ctx context.Context, release, installPath string) (*types.InstallResult, error) {
 	succeeded := false
 	executablePath := filepath.Join(installPath, "bin", "ollama")
 
+	result := newInstallResult(executablePath)
 	if _, err := os.Stat(executablePath); err == nil {
-		return executablePath, nil
+		return result, nil
 	} else if !errors.Is(err, os.ErrNotExist) {
-		return "", fmt.Errorf("failed to check ollama executable: %w", err)
+		return nil, fmt.Errorf("failed to check ollama executable: %w", err)
 	}
 
+	// Extract into a staging directory, so that a failed install does not
+	// leave anything behind at installPath.
+	stagingPath, err := newStagingDirectory(installPath)
+	if err != nil {
+		return nil, err
+	}
 	defer func() {
 		if !succeeded {
 			// On failure, remove partially extracted files.
-			_ = os.RemoveAll(installPath)
+			_ = os.RemoveAll(stagingPath)
 		}
 	}()
 
-	filename := "ollama-linux-amd64.tgz"
-	if runtime.GOARCH == "arm64" {
-		filename = "ollama-linux-arm64.tgz"
+	selection, err := selectAsset(ctx)
+	if err != nil {
+		return nil, err
 	}
-	assetURL, err := getReleaseAssetURL(ctx, release, filename)
+	result.Accelerator = selection.Accelerator
+	manifest, err := loadFileManifest()
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	// For Linux, Ollama is an archive that we need to extract; accelerator
+	// support may come as additional archives extracted over the base.
+	for _, assetName := range selection.Assets {
+		archivePath, err := fetchAsset(ctx, release, assetName)
+		if err != nil {
+			return nil, err
+		}
+		addInstalledAsset(result, assetName, archivePath)
+		if err = extractArchive(archivePath, stagingPath, manifest); err != nil {
+			return nil, err
+		}
+	}
+	if err = manifest.checkComplete(); err != nil {
+		return nil, err
+	}
+	if err = applyExecutableMode(filepath.Join(stagingPath, "bin", "ollama")); err != nil {
+		return nil, err
+	}
+	if err = checkExecutableArchitecture(filepath.Join(stagingPath, "bin", "ollama")); err != nil {
+		return nil, err
+	}
+	if err = verifyExecutableVersion(ctx, release, filepath.Join(stagingPath, "bin", "ollama")); err != nil {
+		return nil, err
+	}
+	if err = commitStaged(stagingPath, installPath); err != nil {
+		return nil, err
 	}
 
-	log.Printf("Downloading ollama from %s...", assetURL)
+	succeeded = true
 
-	// For Linux, Ollama is an archive that we need to extract.
-	//TODO: Support ROCm
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, assetURL, nil)
-	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
-	}
-	resp, err := http.DefaultClient.Do(req)
+	result.Fresh = true
+	return result, nil
+}
+
+// extractArchive extracts the given compressed tar archive into installPath,
+// checking regular files against the manifest (if any) as they are extracted.
+func extractArchive(archivePath, installPath string, manifest *fileManifest) error {
+	archive, err := os.Open(archivePath)
 	if err != nil {
-		return "", fmt.Errorf("failed to download ollama: %w", err)
+		return fmt.Errorf("failed to open ollama archive: %w", err)
 	}
-	if resp.StatusCode >= 300 {
-		return "", fmt.Errorf("error downloading ollama: status %s", resp.Status)
+	defer archive.Close()
+	var archiveSize int64
+	if info, err := archive.Stat(); err == nil {
+		archiveSize = info.Size()
 	}
-	defer resp.Body.Close()
+	// The archive was verified when it was downloaded.
+	return installFromReader(archive, archiveSize, "", installPath, manifest)
+}
 
-	gzipReader, err := gzip.NewReader(resp.Body)
+// installFromReader extracts a compressed tar archive of the given size (if known)
+// into installPath, without regard to where the archive came from.  The archive
+// is checked against the checksum (if given) once read; regular files are
+// checked against the manifest (if any) as they are extracted.  The caller must
+// remove installPath on failure, and check the manifest is complete.
+func installFromReader(r io.Reader, size int64, checksum, installPath string, manifest *fileManifest) error {
+	// Progress is measured through the compressed archive, as the uncompressed
+	// size is not known up front.
+	reporter := newProgressReporter(PhaseExtract, size)
+	verifier := newVerifyingReader(r, size, checksum)
+
+	decompressor, err := newDecompressor(&progressReader{Reader: verifier, reporter: reporter})
 	if err != nil {
-		return "", fmt.Errorf("failed to read gzip archive: %w", err)
+		return fmt.Errorf("failed to read ollama archive: %w", err)
 	}
-	tarReader := tar.NewReader(gzipReader)
+	defer decompressor.Close()
+	tarReader := tar.NewReader(decompressor)
+	budget := newArchiveBudget()
+	names := newArchiveNames(installPath)
 	var links []tar.Header
 	for {
 		header, err := tarReader.Next()
@@ -93,96 +148,213 @@ func installOllama(ctx context.Context, release, installPath string) (string, er
 			break
 		}
 		if err != nil {
-			return "", fmt.Errorf("error reading tar archive: %w", err)
+			return fmt.Errorf("error reading tar archive: %w", err)
+		}
+		if err = budget.addEntry(header.Name); err != nil {
+			return err
 		}
 		if !filepath.IsLocal(header.Name) {
-			return "", fmt.Errorf("error extracting archive: path %s: %w", header.Name, tar.ErrInsecurePath)
+			return fmt.Errorf("error extracting archive: path %s: %w", header.Name, tar.ErrInsecurePath)
+		}
+		if extract, err := names.claim(header.Name, header.Typeflag == tar.TypeDir); err != nil {
+			return err
+		} else if !extract {
+			continue
 		}
+		reporter.setFile(header.Name)
 		outPath := filepath.Join(installPath, header.Name)
 		info := header.FileInfo()
 		switch header.Typeflag {
 		case tar.TypeDir:
 			if err = os.MkdirAll(outPath, info.Mode()); err != nil {
-				return "", fmt.Errorf("error extracting %s: failed to make directory: %w", header.Name, err)
+				return fmt.Errorf("error extracting %s: failed to make directory: %w", header.Name, err)
 			}
 			if err = os.Chmod(outPath, header.FileInfo().Mode()); err != nil {
-				return "", fmt.Errorf("error extracting %s: failed to change permissions: %w", header.Name, err)
+				return fmt.Errorf("error extracting %s: failed to change permissions: %w", header.Name, err)
 			}
 		case tar.TypeReg:
-			file, err := os.OpenFile(outPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, info.Mode())
-			if err != nil {
-				return "", fmt.Errorf("error extracting %s: failed to create file: %w", header.Name, err)
+			if err = manifest.expect(header.Name, header.Size); err != nil {
+				return err
 			}
-			n, err := io.Copy(file, tarReader)
-			file.Close()
+			digest, err := writeArchiveEntry(budget, header.Name, outPath, info.Mode(), tarReader, header.Size)
 			if err != nil {
-				return "", fmt.Errorf("error extracting %s: failed to copy: %w", header.Name, err)
+				return err
+			}
+			if err = manifest.check(header.Name, digest); err != nil {
+				return err
 			}
-			if n < header.Size {
-				return "", fmt.Errorf("error extracting %s: extracted %d of %d bytes", header.Name, n, header.Size)
+			if err = setModTime(header.Name, outPath, header.ModTime); err != nil {
+				return err
 			}
 		case tar.TypeLink, tar.TypeSymlink:
 			// defer hard & symlink creation until the files exist; note we copy here.
-			if !filepath.IsLocal(header.Linkname) {
-				return "", fmt.Errorf("error extracting %s: %w", header.Name, tar.ErrInsecurePath)
+			if err = checkLinkTarget(header); err != nil {
+				return err
 			}
 			links = append(links, *header)
 		default:
-			return "", fmt.Errorf("error extracting %s: don't know how to handle %v", header.Name, header.Typeflag)
+			return fmt.Errorf("error extracting %s: don't know how to handle %v", header.Name, header.Typeflag)
 		}
 	}
 
-	for _, link := range links {
-		newName := filepath.Join(installPath, link.Name)
-		oldName := filepath.Join(installPath, link.Linkname)
-		if link.Typeflag == tar.TypeLink {
-			err = os.Link(oldName, newName)
-		} else {
-			err = os.Symlink(oldName, newName)
+	if err = verifier.verify(); err != nil {
+		return err
+	}
+	if err = createLinks(installPath, links); err != nil {
+		return err
+	}
+
+	reporter.done()
+	return nil
+}
+
+// detectAccelerator returns the GPU acceleration available on this machine.
+func detectAccelerator() string {
+	if _, err := os.Stat("/proc/driver/nvidia/version"); err == nil {
+		return AcceleratorCUDA
+	}
+	if _, err := os.Stat("/sys/module/amdgpu"); err == nil && runtime.GOARCH == "amd64" {
+		return AcceleratorROCm
+	}
+	return AcceleratorCPU
+}
+
+// getSystemMemory returns the total and available system memory, in bytes.
+func getSystemMemory() (uint64, uint64, error) {
+	contents, err := os.ReadFile("/proc/meminfo")
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to read system memory: %w", err)
+	}
+	var total, available uint64
+	for _, line := range strings.Split(string(contents), "\n") {
+		// Lines are of the form "MemTotal:       16318436 kB".
+		fields := strings.Fields(line)
+		if len(fields) != 3 || fields[2] != "kB" {
+			continue
 		}
+		value, err := strconv.ParseUint(fields[1], 10, 64)
 		if err != nil {
-			return "", fmt.Errorf("error extracting %s: could not create link: %w", link.Name, err)
+			continue
+		}
+		switch fields[0] {
+		case "MemTotal:":
+			total = value << 10
+		case "MemAvailable:":
+			available = value << 10
 		}
 	}
+	if total == 0 {
+		return 0, 0, fmt.Errorf("failed to read system memory: no total in /proc/meminfo")
+	}
+	return total, available, nil
+}
 
-	succeeded = true
+// networkFilesystems maps the statfs magic numbers of network filesystems to
+// their names.  FUSE is not included, as it is as often local as not.
+var networkFilesystems = map[int64]string{
+	0x6969:     "nfs",
+	0x517b:     "smb",
+	0xfe534d42: "smb2",
+	0xff534d42: "cifs",
+	0x5346414f: "afs",
+	0x00c36400: "ceph",
+	0x01021997: "9p",
+}
 
-	return executablePath, nil
+// getFilesystemType returns the type of the filesystem containing the given
+// (existing) path, and whether it is a network filesystem.  Local filesystems
+// are reported by their magic number, as there are too many to name.
+func getFilesystemType(path string) (string, bool, error) {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(path, &stat); err != nil {
+		return "", false, &os.PathError{Op: "statfs", Path: path, Err: err}
+	}
+	// The width of the type differs between architectures.
+	magic := int64(stat.Type) & 0xffffffff
+	if name, ok := networkFilesystems[magic]; ok {
+		return name, true, nil
+	}
+	return fmt.Sprintf("0x%x", magic), false, nil
 }
 
-func uninstallOllama(ctx context.Context) error {
+// selectAsset determines which release assets to install.  The base archive
+// includes CUDA support; ROCm support is an additional archive.
+func selectAsset(ctx context.Context) (*assetSelection, error) {
+	selection := newAssetSelection(ctx, detectAccelerator())
+	filename := "ollama-linux-amd64.tgz"
+	if runtime.GOARCH == "arm64" {
+		filename = "ollama-linux-arm64.tgz"
+	}
+	selection.Assets = append(selection.Assets, filename)
+	if selection.Accelerator == AcceleratorROCm {
+		selection.Assets = append(selection.Assets, "ollama-linux-amd64-rocm.tgz")
+	}
+	return selection, nil
+}
+
+// chooseReleaseAssets updates the selection for the assets the release actually
+// has; every release has the assets for this platform.
+func chooseReleaseAssets(ctx context.Context, release string, selection *assetSelection) {
+}
+
+// findExternalServer returns the path of a running, externally managed ollama
+// server application; there is none on this platform.
+func findExternalServer(ctx context.Context) (string, error) {
+	return "", nil
+}
+
+func uninstallOllama(ctx context.Context) (*types.UninstallResult, error) {
 	installDir, err := getDefaultInstallLocation(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to find ollama install: %w", err)
+		return nil, fmt.Errorf("failed to find ollama install: %w", err)
+	}
+	if err = checkInstallLocation(installDir); err != nil {
+		return nil, err
+	}
+	if err = checkInstallOwnership(installDir); err != nil {
+		return nil, err
 	}
-
 	executablePath := filepath.Join(installDir, "bin", "ollama")
-	if err = terminateProcess(ctx, executablePath); err != nil {
-		return fmt.Errorf("error terminating existing ollama process: %w", err)
+	result := newUninstallResult(ctx, installDir, executablePath)
+	if result.TerminatedPIDs, err = terminateProcess(ctx, executablePath); err != nil {
+		return nil, fmt.Errorf("error terminating existing ollama process: %w", err)
 	}
 
 	err = os.RemoveAll(installDir)
 	if err != nil && !errors.Is(err, os.ErrNotExist) {
-		return err
+		return nil, err
 	}
 
-	return nil
+	return result, nil
 }
 
-func terminateProcess(ctx context.Context, executablePath string) error {
+func terminateProcess(ctx context.Context, executablePath string) ([]int, error) {
 	executableInfo, err := os.Stat(executablePath)
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
-			return nil
+			return nil, nil
 		}
-		return fmt.Errorf("failed to get executable info: %w", err)
+		return nil, fmt.Errorf("failed to get executable info: %w", err)
+	}
+	pids, err := findProcesses(ctx, func(_ int, _ string, info os.FileInfo) bool {
+		return os.SameFile(executableInfo, info)
+	})
+	if err != nil {
+		return nil, err
 	}
+	return stopProcesses(ctx, pids), nil
+}
 
-	// Check /proc/<pid>/exe to see if they're the correct file.
+// findProcesses returns the pids of the processes whose executable matches;
+// match is called with the pid of each process and the path and info of its
+// executable.  The info is that of /proc/<pid>/exe, so it remains valid even if
+// the executable has since been deleted or replaced.
+func findProcesses(ctx context.Context, match func(pid int, executablePath string, info os.FileInfo) bool) ([]int, error) {
 	pidfds, err := os.ReadDir("/proc")
 	if err != nil {
-		return fmt.Errorf("error listing processes: %w", err)
+		return nil, fmt.Errorf("error listing processes: %w", err)
 	}
+	var pids []int
 	for _, pidfd := range pidfds {
 		if !pidfd.IsDir() {
 			continue
@@ -198,20 +370,79 @@ func terminateProcess(ctx context.Context, executablePath string) error {
 			}
 			continue
 		}
-		if !os.SameFile(executableInfo, exeInfo) {
+		exePath, _ := os.Readlink(filepath.Join("/proc", pidfd.Name(), "exe"))
+		if !match(pid, strings.TrimSuffix(exePath, " (deleted)"), exeInfo) {
 			continue
 		}
-		proc, err := os.FindProcess(pid)
+		pids = append(pids, pid)
+	}
+	return pids, nil
+}
+
+// findPortListener returns the pid of the process listening on the given TCP
+// port, found by matching the socket inodes in /proc/net/tcp{,6} against the
+// open files of each process.  Returns 0 if nothing is listening; if something
+// is, but the process cannot be seen (such as one of another user), -1 is
+// returned.
+func findPortListener(ctx context.Context, port int) (int, error) {
+	inodes := make(map[string]bool)
+	for _, table := range []string{"/proc/net/tcp", "/proc/net/tcp6"} {
+		contents, err := os.ReadFile(table)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return 0, fmt.Errorf("failed to list listening sockets: %w", err)
+		}
+		for _, line := range strings.Split(string(contents), "\n")[1:] {
+			// sl local_address rem_address st tx_queue:rx_queue tr:tm->when retrnsmt uid timeout inode
+			fields := strings.Fields(line)
+			if len(fields) < 10 || fields[3] != "0A" { // TCP_LISTEN
+				continue
+			}
+			_, portHex, ok := strings.Cut(fields[1], ":")
+			if localPort, err := strconv.ParseUint(portHex, 16, 16); ok && err == nil && int(localPort) == port {
+				inodes["socket:["+fields[9]+"]"] = true
+			}
+		}
+	}
+	if len(inodes) == 0 {
+		return 0, nil
+	}
+	pidfds, err := os.ReadDir("/proc")
+	if err != nil {
+		return 0, fmt.Errorf("error listing processes: %w", err)
+	}
+	for _, pidfd := range pidfds {
+		if err = ctx.Err(); err != nil {
+			return 0, err
+		}
+		pid, err := strconv.Atoi(pidfd.Name())
+		if err != nil {
+			continue
+		}
+		fdDir := filepath.Join("/proc", pidfd.Name(), "fd")
+		fds, err := os.ReadDir(fdDir)
 		if err != nil {
+			// Exited, or not ours to see.
 			continue
 		}
-		err = proc.Signal(unix.SIGTERM)
-		if err == nil {
-			log.Printf("Terminated process %d", pid)
-		} else if !errors.Is(err, unix.EINVAL) {
-			log.Printf("Ignoring failure to terminate pid %d: %s", pid, err)
+		for _, fd := range fds {
+			if target, err := os.Readlink(filepath.Join(fdDir, fd.Name())); err == nil && inodes[target] {
+				return pid, nil
+			}
 		}
 	}
+	return -1, nil
+}
 
-	return nil
+// processExecutable returns the path of the executable the given process runs.
+func processExecutable(pid int) (string, error) {
+	return os.Readlink(filepath.Join("/proc", strconv.Itoa(pid), "exe"))
+}
+
+// systemInstallLocation returns the system-wide install location.  This is a
+// directory of its own, rather than /usr/local, as the whole install location
+// is replaced when installing and removed when uninstalling.
+func systemInstallLocation() (string, error) {
+	return "/opt/ollama", nil
 }
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/rancher-sandbox/rd-open-webui-docker-ext/installer/types"
+)
+
+func TestInstallFromReaderChainedLinks(t *testing.T) {
+	// The links come before what they refer to, so they must be ordered: the
+	// hard link refers to a symbolic link, which refers to another.
+	archive := buildTestArchive(t,
+		testLink(tar.TypeLink, "lib/ollama/libfoo-hard.so", "lib/ollama/libfoo.so"),
+		testLink(tar.TypeSymlink, "lib/ollama/libfoo.so", "libfoo.so.1"),
+		testLink(tar.TypeSymlink, "lib/ollama/libfoo.so.1", "libfoo.so.1.2.3"),
+		testLink(tar.TypeSymlink, "bin/libfoo.so", "../lib/ollama/libfoo.so"),
+		testDir("bin/"),
+		testDir("lib/"),
+		testDir("lib/ollama/"),
+		testFile("lib/ollama/libfoo.so.1.2.3", "library"),
+	)
+	installPath := t.TempDir()
+	if err := installFromReader(bytes.NewReader(archive), int64(len(archive)), "", installPath, nil); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{
+		"lib/ollama/libfoo.so.1": "libfoo.so.1.2.3",
+		"lib/ollama/libfoo.so":   "libfoo.so.1",
+		"bin/libfoo.so":          "../lib/ollama/libfoo.so",
+	} {
+		if got, err := os.Readlink(filepath.Join(installPath, name)); err != nil || got != want {
+			t.Errorf("link %s = %q, %v; want %q", name, got, err, want)
+		}
+	}
+	for _, name := range []string{"lib/ollama/libfoo-hard.so", "bin/libfoo.so"} {
+		if got, err := os.ReadFile(filepath.Join(installPath, name)); err != nil || string(got) != "library" {
+			t.Errorf("reading %s = %q, %v; want %q", name, got, err, "library")
+		}
+	}
+}
+
+func TestInstallFromReaderRejectsEscapingLinks(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		entry testEntry
+	}{
+		{"absolute symlink", testLink(tar.TypeSymlink, "lib/ollama/passwd", "/etc/passwd")},
+		{"escaping symlink", testLink(tar.TypeSymlink, "lib/ollama/passwd", "../../../etc/passwd")},
+		{"absolute hard link", testLink(tar.TypeLink, "lib/ollama/passwd", "/etc/passwd")},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			archive := buildTestArchive(t, testDir("lib/"), testDir("lib/ollama/"), tt.entry)
+			installPath := t.TempDir()
+			err := installFromReader(bytes.NewReader(archive), int64(len(archive)), "", installPath, nil)
+			if !errors.Is(err, tar.ErrInsecurePath) {
+				t.Errorf("installFromReader() = %v, want %v", err, tar.ErrInsecurePath)
+			}
+			if _, err = os.Lstat(filepath.Join(installPath, "lib/ollama/passwd")); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("escaping link was created: %v", err)
+			}
+		})
+	}
+}
+
+func TestUninstallThroughSymlinkedParent(t *testing.T) {
+	root := t.TempDir()
+	parent := filepath.Join(root, "parent")
+	link := filepath.Join(root, "link")
+	installPath := filepath.Join(parent, "ollama")
+	if err := os.MkdirAll(filepath.Join(installPath, "bin"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(installPath, "bin", "ollama"), nil, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeInstallMarker(installPath); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(parent, link); err != nil {
+		t.Fatal(err)
+	}
+	setForTest(t, installDir, filepath.Join(link, "ollama"))
+	setForTest(t, stateDir, t.TempDir())
+	if _, err := uninstallOllama(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(installPath); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("install was not removed: %v", err)
+	}
+	if _, err := os.Stat(link); err != nil {
+		t.Errorf("uninstall removed more than the install: %v", err)
+	}
+}
+
+func TestInstallFromReaderLimits(t *testing.T) {
+	entries := []testEntry{testDir("bin/"), testFile("bin/ollama", "0123456789"), testFile("bin/other", "0123456789")}
+	for _, tt := range []struct {
+		name  string
+		limit func(t *testing.T)
+	}{
+		{"entries", func(t *testing.T) { setForTest(t, maxArchiveEntries, 2) }},
+		{"size", func(t *testing.T) { setForTest(t, maxArchiveSize, 15) }},
+		{"path length", func(t *testing.T) { setForTest(t, maxArchivePathLength, 9) }},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.limit(t)
+			archive := buildTestArchive(t, entries...)
+			err := installFromReader(bytes.NewReader(archive), int64(len(archive)), "", t.TempDir(), nil)
+			if !errors.Is(err, errArchiveTooLarge) {
+				t.Errorf("installFromReader() = %v, want %v", err, errArchiveTooLarge)
+			}
+		})
+	}
+}
+
+// withCachedInstall installs an archive of the given entries, as if downloaded
+// into the cache, and returns the install location.
+func withCachedInstall(t *testing.T, assetName string, entries ...testEntry) string {
+	t.Helper()
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	setForTest(t, stateDir, t.TempDir())
+	installPath := filepath.Join(t.TempDir(), "ollama")
+	setForTest(t, installDir, installPath)
+	archive := buildTestArchive(t, entries...)
+	checksum := sha256Hex(archive)
+	cacheDir, err := getCacheDirectory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.MkdirAll(filepath.Join(cacheDir, checksum), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(filepath.Join(cacheDir, checksum, assetName), archive, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err = installFromReader(bytes.NewReader(archive), int64(len(archive)), checksum, installPath, nil); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	err = updateState(ctx, func(state *installerState) error {
+		state.InstalledArchives = []string{checksum}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = recordInstalledFiles(ctx, installPath); err != nil {
+		t.Fatal(err)
+	}
+	return installPath
+}
+
+// fileRepairNames returns the paths, relative to the install, of the repairs.
+func fileRepairNames(t *testing.T, installPath string, repairs []types.FileRepair) []string {
+	t.Helper()
+	var names []string
+	for _, repair := range repairs {
+		name, err := filepath.Rel(installPath, repair.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name+":"+repair.Problem)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func TestRepairFiles(t *testing.T) {
+	for _, tt := range []struct {
+		name          string
+		overwrite     bool
+		dryRun        bool
+		wantRestored  []string
+		wantPreserved []string
+	}{
+		{"preserve", false, false, []string{"bin/ollama:missing", "lib/libfoo.so:corrupt"}, []string{"bin/wrapper:modified"}},
+		{"overwrite modified", true, false, []string{"bin/ollama:missing", "bin/wrapper:modified", "lib/libfoo.so:corrupt"}, nil},
+		{"dry run", false, true, []string{"bin/ollama:missing", "lib/libfoo.so:corrupt"}, []string{"bin/wrapper:modified"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			setForTest(t, overwriteModified, tt.overwrite)
+			installPath := withCachedInstall(t, "ollama-linux-amd64.tgz",
+				testDir("bin/"), testDir("lib/"),
+				testFile("bin/ollama", "executable"),
+				testFile("bin/wrapper", "#!/bin/sh\nexec ollama\n"),
+				testFile("lib/libfoo.so", "library"),
+				testFile("lib/libbar.so", "intact"))
+			// Ensure the edit is seen as a change, as change times are
+			// coarser than they appear.
+			time.Sleep(10 * time.Millisecond)
+			if err := os.Remove(filepath.Join(installPath, "bin/ollama")); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Truncate(filepath.Join(installPath, "lib/libfoo.so"), 0); err != nil {
+				t.Fatal(err)
+			}
+			edited := "#!/bin/sh\nexec nice ollama\n"
+			if err := os.WriteFile(filepath.Join(installPath, "bin/wrapper"), []byte(edited), 0o755); err != nil {
+				t.Fatal(err)
+			}
+
+			result, err := repairFiles(context.Background(), tt.dryRun)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Checked != 4 {
+				t.Errorf("checked %d files, want 4", result.Checked)
+			}
+			if got := fileRepairNames(t, installPath, result.Restored); !slices.Equal(got, tt.wantRestored) {
+				t.Errorf("restored %v, want %v", got, tt.wantRestored)
+			}
+			if got := fileRepairNames(t, installPath, result.Preserved); !slices.Equal(got, tt.wantPreserved) {
+				t.Errorf("preserved %v, want %v", got, tt.wantPreserved)
+			}
+			want := map[string]string{"bin/ollama": "executable", "lib/libfoo.so": "library", "bin/wrapper": edited}
+			if tt.dryRun {
+				want = map[string]string{"lib/libfoo.so": "", "bin/wrapper": edited}
+			} else if tt.overwrite {
+				want["bin/wrapper"] = "#!/bin/sh\nexec ollama\n"
+			}
+			for name, contents := range want {
+				if got, err := os.ReadFile(filepath.Join(installPath, name)); err != nil || string(got) != contents {
+					t.Errorf("%s = %q, %v; want %q", name, got, err, contents)
+				}
+			}
+			if entries, _ := filepath.Glob(filepath.Join(installPath, "*", "*.repair")); len(entries) > 0 {
+				t.Errorf("temporary files left behind: %v", entries)
+			}
+		})
+	}
+}
+
+func TestRepairFilesUnavailableArchives(t *testing.T) {
+	installPath := withCachedInstall(t, "ollama-linux-amd64.tgz", testDir("bin/"), testFile("bin/ollama", "executable"))
+	state, err := loadState(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cacheDir, err := getCacheDirectory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	archiveDir := filepath.Join(cacheDir, state.InstalledArchives[0])
+	// An asset that is not a tar archive, as on Windows and macOS.
+	if err = os.Rename(filepath.Join(archiveDir, "ollama-linux-amd64.tgz"), filepath.Join(archiveDir, "ollama-windows-amd64.zip")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = repairFiles(context.Background(), false); !errors.Is(err, errNotFromArchives) {
+		t.Errorf("repairFiles() with a zip asset = %v, want %v", err, errNotFromArchives)
+	}
+	if err = os.RemoveAll(archiveDir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = repairFiles(context.Background(), false); !errors.Is(err, errArchivesNotCached) {
+		t.Errorf("repairFiles() without the archive = %v, want %v", err, errArchivesNotCached)
+	}
+	if _, err = os.Stat(filepath.Join(installPath, "bin", "ollama")); err != nil {
+		t.Errorf("install changed: %v", err)
+	}
+}
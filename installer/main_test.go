@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// errUnknownExecutable is returned by unknownExecutable.
+var errUnknownExecutable = errors.New("executable path unknown")
+
+// unknownExecutable replaces installerExecutable to make the installer's own
+// location unknown, so that the default install location cannot be found.
+func unknownExecutable() (string, error) {
+	return "", errUnknownExecutable
+}
+
+func TestFindExecutableUnknownInstallLocation(t *testing.T) {
+	setForTest(t, &installerExecutable, unknownExecutable)
+	for _, defaultOnly := range []bool{true, false} {
+		location, err := findExecutable(context.Background(), defaultOnly)
+		if !errors.Is(err, errUnknownExecutable) {
+			t.Errorf("findExecutable(%v) error = %v, want %v", defaultOnly, err, errUnknownExecutable)
+		}
+		if location != "" {
+			t.Errorf("findExecutable(%v) = %q, want no location", defaultOnly, location)
+		}
+	}
+}
+
+func TestGetDefaultInstallLocationUnknown(t *testing.T) {
+	setForTest(t, &installerExecutable, unknownExecutable)
+	if location, err := getDefaultInstallLocation(context.Background()); !errors.Is(err, errUnknownExecutable) {
+		t.Errorf("getDefaultInstallLocation() = %q, %v; want error %v", location, err, errUnknownExecutable)
+	}
+}
+
+func TestCheckInstallUnknownInstallLocation(t *testing.T) {
+	setForTest(t, &installerExecutable, unknownExecutable)
+	if checkHealth(context.Background(), time.Second) == nil {
+		t.Skip("ollama is running, so the install is not looked for")
+	}
+	// Failing to look for the install must not be reported as "not installed".
+	if err := checkInstall(context.Background()); !errors.Is(err, errUnknownExecutable) {
+		t.Errorf("checkInstall() error = %v, want %v", err, errUnknownExecutable)
+	}
+}
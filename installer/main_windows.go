@@ -7,7 +7,6 @@ import (
 	"fmt"
 	"io"
 	"log"
-	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -15,19 +14,27 @@ import (
 	"time"
 	"unsafe"
 
+	"github.com/rancher-sandbox/rd-open-webui-docker-ext/installer/types"
 	"github.com/xenking/zipstream"
 	"golang.org/x/sys/windows"
 )
 
-func findExecutable(ctx context.Context, defaultOnly bool) string {
+// Get the locations where ollama may be installed, in order of preference; if
+// defaultOnly is false, this may include externally installed copies of ollama.
+func executableCandidates(ctx context.Context, defaultOnly bool) ([]string, error) {
 	var potentialLocations []string
 
-	if installLocation, err := getDefaultInstallLocation(ctx); err == nil {
-		executablePath := filepath.Join(installLocation, "ollama.exe")
-		potentialLocations = append(potentialLocations, executablePath)
+	installLocation, err := getDefaultInstallLocation(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to determine default install location: %w", err)
 	}
+	executablePath := filepath.Join(installLocation, "ollama.exe")
+	potentialLocations = append(potentialLocations, executablePath)
 
 	if !defaultOnly {
+		if alternate := alternateInstallLocation(); alternate != "" {
+			potentialLocations = append(potentialLocations, filepath.Join(alternate, "ollama.exe"))
+		}
 		programsDir, err := windows.KnownFolderPath(windows.FOLDERID_UserProgramFiles, windows.KF_FLAG_DEFAULT)
 		if err == nil {
 			// See Ollama setup source code:
@@ -35,135 +42,290 @@ func findExecutable(ctx context.Context, defaultOnly bool) string {
 			// https://github.com/ollama/ollama/blob/03608cb46ecdccaf8c340c9390626a9d8fcc3c6b/app/ollama.iss#L92
 			potentialLocations = append(potentialLocations, filepath.Join(programsDir, "Ollama", "ollama.exe"))
 		}
-	}
-
-	for _, location := range potentialLocations {
-		if _, err := os.Stat(location); err == nil {
-			// Found an existing ollama
-			return location
+		if programFiles, err := windows.KnownFolderPath(windows.FOLDERID_ProgramFiles, windows.KF_FLAG_DEFAULT); err == nil {
+			// Where a manual install for all users may be.
+			potentialLocations = append(potentialLocations, filepath.Join(programFiles, "Ollama", "ollama.exe"))
 		}
 	}
-	return ""
+
+	return potentialLocations, nil
 }
 
-func installOllama(ctx context.Context, release, installPath string) (string, error) {
+func installOllama(ctx context.Context, release, installPath string) (*types.InstallResult, error) {
 	succeeded := false
 	executablePath := filepath.Join(installPath, "ollama.exe")
 
+	result := newInstallResult(executablePath)
 	if _, err := os.Stat(executablePath); err == nil {
-		return executablePath, nil
+		return result, nil
 	} else if !errors.Is(err, os.ErrNotExist) {
-		return "", fmt.Errorf("failed to check ollama executable: %w", err)
+		return nil, fmt.Errorf("failed to check ollama executable: %w", err)
 	}
 
+	// Extract into a staging directory, so that a failed install does not
+	// leave anything behind at installPath.
+	stagingPath, err := newStagingDirectory(installPath)
+	if err != nil {
+		return nil, err
+	}
 	defer func() {
 		if !succeeded {
 			// On failure, remove partially extracted files.
-			_ = os.RemoveAll(installPath)
+			_ = os.RemoveAll(stagingPath)
 		}
 	}()
 
-	assetURL, err := getReleaseAssetURL(ctx, release, "ollama-windows-amd64.zip")
+	selection, err := selectAsset(ctx)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-
-	log.Printf("Downloading ollama from %s...", assetURL)
+	result.Accelerator = selection.Accelerator
+	manifest, err := loadFileManifest()
+	if err != nil {
+		return nil, err
+	}
+	archivePath, err := fetchAsset(ctx, release, selection.Assets[0])
+	if err != nil {
+		return nil, err
+	}
+	addInstalledAsset(result, selection.Assets[0], archivePath)
 
 	// For Windows, Ollama is a zip archive that we need  to extract.
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, assetURL, nil)
+	archive, err := os.Open(archivePath)
 	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
+		return nil, fmt.Errorf("failed to open ollama archive: %w", err)
 	}
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return "", fmt.Errorf("failed to download ollama: %w", err)
+	defer archive.Close()
+	var archiveSize int64
+	if info, err := archive.Stat(); err == nil {
+		archiveSize = info.Size()
+	}
+	// The archive was verified when it was downloaded.
+	if err = installFromReader(archive, archiveSize, "", stagingPath, manifest); err != nil {
+		return nil, err
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode >= 300 {
-		return "", fmt.Errorf("error downloading ollama: status %s", resp.Status)
+	if err = manifest.checkComplete(); err != nil {
+		return nil, err
+	}
+	if err = checkExecutableArchitecture(filepath.Join(stagingPath, "ollama.exe")); err != nil {
+		return nil, err
+	}
+	if err = verifyExecutableVersion(ctx, release, filepath.Join(stagingPath, "ollama.exe")); err != nil {
+		return nil, err
+	}
+	if err = commitStaged(stagingPath, installPath); err != nil {
+		return nil, err
+	}
+
+	// Anti-virus might have locked the executable; try to run `--version` until
+	// it succeeds before returning.
+	for i := 0; i < 60; i++ {
+		err = exec.CommandContext(ctx, executablePath, "--version").Run()
+		if err == nil {
+			break
+		}
+		time.Sleep(time.Second)
 	}
 
-	zipReader := zipstream.NewReader(resp.Body)
+	succeeded = true
+
+	result.Fresh = true
+	return result, nil
+}
+
+// installFromReader extracts a zip archive of the given size (if known) into
+// installPath, without regard to where the archive came from.  The archive is
+// checked against the checksum (if given) once read; files are checked against
+// the manifest (if any) as they are extracted.  The caller must remove
+// installPath on failure, and check the manifest is complete.
+func installFromReader(r io.Reader, size int64, checksum, installPath string, manifest *fileManifest) error {
+	// Progress is measured through the compressed archive, as the uncompressed
+	// size is not known up front.
+	reporter := newProgressReporter(PhaseExtract, size)
+	verifier := newVerifyingReader(r, size, checksum)
+
+	zipReader := zipstream.NewReader(&progressReader{Reader: verifier, reporter: reporter})
+	budget := newArchiveBudget()
+	names := newArchiveNames(installPath)
 	for {
 		info, err := zipReader.Next()
 		if errors.Is(err, io.EOF) {
 			break
 		}
 		if err != nil {
-			return "", fmt.Errorf("error reading ollama archive: %w", err)
+			return fmt.Errorf("error reading ollama archive: %w", err)
+		}
+		if err = budget.addEntry(info.Name); err != nil {
+			return err
 		}
 		if !filepath.IsLocal(info.Name) || strings.ContainsRune(info.Name, '\\') {
-			return "", fmt.Errorf("error extracting archive: %s: %w", info.Name, zip.ErrInsecurePath)
+			return fmt.Errorf("error extracting archive: %s: %w", info.Name, zip.ErrInsecurePath)
+		}
+		if extract, err := names.claim(info.Name, strings.HasSuffix(info.Name, "/")); err != nil {
+			return err
+		} else if !extract {
+			continue
 		}
+		reporter.setFile(info.Name)
 		outPath := filepath.Join(installPath, info.Name)
 		if strings.HasSuffix(info.Name, "/") {
 			if err = os.MkdirAll(outPath, info.Mode()); err != nil {
-				return "", fmt.Errorf("error extracting archive: %s: %w", info.Name, err)
+				return fmt.Errorf("error extracting archive: %s: %w", info.Name, err)
 			}
 		} else {
 			if err = os.MkdirAll(filepath.Dir(outPath), 0o755); err != nil {
-				return "", fmt.Errorf("error extracting archive: %s: failed to create parent: %w", info.Name, err)
+				return fmt.Errorf("error extracting archive: %s: failed to create parent: %w", info.Name, err)
 			}
-			file, err := os.OpenFile(outPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, info.Mode())
-			if err != nil {
-				return "", fmt.Errorf("error extracting archive: %s: %w", info.Name, err)
+			if err = manifest.expect(info.Name, int64(info.UncompressedSize64)); err != nil {
+				return err
 			}
-			n, err := io.Copy(file, zipReader)
+			digest, err := writeArchiveEntry(budget, info.Name, outPath, info.Mode(), zipReader, int64(info.UncompressedSize64))
 			if err != nil {
-				return "", fmt.Errorf("error extracting archive: %s: %w", info.Name, err)
+				return err
 			}
-			if n < int64(info.UncompressedSize64) {
-				return "", fmt.Errorf("error extracting archive: %s: extracted %d of %d bytes", info.Name, n, info.UncompressedSize64)
+			if err = manifest.check(info.Name, digest); err != nil {
+				return err
+			}
+			if err = setModTime(info.Name, outPath, info.Modified); err != nil {
+				return err
 			}
 		}
 	}
 
-	// Anti-virus might have locked the executable; try to run `--version` until
-	// it succeeds before returning.
-	for i := 0; i < 60; i++ {
-		err = exec.CommandContext(ctx, executablePath, "--version").Run()
-		if err == nil {
-			break
-		}
-		time.Sleep(time.Second)
+	if err := verifier.verify(); err != nil {
+		return err
 	}
+	reporter.done()
+	return nil
+}
 
-	succeeded = true
+// detectAccelerator returns the GPU acceleration available on this machine,
+// based on the presence of the vendor runtime libraries.
+func detectAccelerator() string {
+	systemDir, err := windows.GetSystemDirectory()
+	if err != nil {
+		return AcceleratorCPU
+	}
+	if _, err := os.Stat(filepath.Join(systemDir, "nvml.dll")); err == nil {
+		return AcceleratorCUDA
+	}
+	if _, err := os.Stat(filepath.Join(systemDir, "amdhip64.dll")); err == nil {
+		return AcceleratorROCm
+	}
+	return AcceleratorCPU
+}
 
-	return executablePath, nil
+// memoryStatusEx is the MEMORYSTATUSEX structure.
+type memoryStatusEx struct {
+	Length               uint32
+	MemoryLoad           uint32
+	TotalPhys            uint64
+	AvailPhys            uint64
+	TotalPageFile        uint64
+	AvailPageFile        uint64
+	TotalVirtual         uint64
+	AvailVirtual         uint64
+	AvailExtendedVirtual uint64
 }
 
-func uninstallOllama(ctx context.Context) error {
+var procGlobalMemoryStatusEx = windows.NewLazySystemDLL("kernel32.dll").NewProc("GlobalMemoryStatusEx")
+
+// getSystemMemory returns the total and available system memory, in bytes.
+func getSystemMemory() (uint64, uint64, error) {
+	status := memoryStatusEx{}
+	status.Length = uint32(unsafe.Sizeof(status))
+	if ok, _, err := procGlobalMemoryStatusEx.Call(uintptr(unsafe.Pointer(&status))); ok == 0 {
+		return 0, 0, fmt.Errorf("failed to read system memory: %w", err)
+	}
+	return status.TotalPhys, status.AvailPhys, nil
+}
+
+// getFilesystemType returns the type of the filesystem containing the given
+// (existing) path, and whether it is on a network drive (or share).
+func getFilesystemType(path string) (string, bool, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return "", false, err
+	}
+	root, err := windows.UTF16PtrFromString(filepath.VolumeName(absPath) + `\`)
+	if err != nil {
+		return "", false, err
+	}
+	network := windows.GetDriveType(root) == windows.DRIVE_REMOTE
+	var name [windows.MAX_PATH + 1]uint16
+	if err = windows.GetVolumeInformation(root, nil, 0, nil, nil, nil, &name[0], uint32(len(name))); err != nil {
+		return "", network, &os.PathError{Op: "GetVolumeInformation", Path: path, Err: err}
+	}
+	return windows.UTF16ToString(name[:]), network, nil
+}
+
+// selectAsset determines which release assets to install.  The Windows archive
+// contains support for all accelerators.
+func selectAsset(ctx context.Context) (*assetSelection, error) {
+	selection := newAssetSelection(ctx, detectAccelerator())
+	selection.Assets = []string{"ollama-windows-amd64.zip"}
+	return selection, nil
+}
+
+// chooseReleaseAssets updates the selection for the assets the release actually
+// has; every release has the assets for this platform.
+func chooseReleaseAssets(ctx context.Context, release string, selection *assetSelection) {
+}
+
+// findExternalServer returns the path of a running, externally managed ollama
+// server application; there is none on this platform.
+func findExternalServer(ctx context.Context) (string, error) {
+	return "", nil
+}
+
+func uninstallOllama(ctx context.Context) (*types.UninstallResult, error) {
 	installDir, err := getDefaultInstallLocation(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to find ollama install: %w", err)
+		return nil, fmt.Errorf("failed to find ollama install: %w", err)
+	}
+	if err = checkInstallLocation(installDir); err != nil {
+		return nil, err
+	}
+	if err = checkInstallOwnership(installDir); err != nil {
+		return nil, err
 	}
 	executablePath := filepath.Join(installDir, "ollama.exe")
-	if err = terminateProcess(ctx, executablePath); err != nil {
-		return fmt.Errorf("error terminating existing ollama process: %w", err)
+	result := newUninstallResult(ctx, installDir, executablePath)
+	if result.TerminatedPIDs, err = terminateProcess(ctx, executablePath); err != nil {
+		return nil, fmt.Errorf("error terminating existing ollama process: %w", err)
 	}
 
 	err = os.RemoveAll(installDir)
 	if err != nil && !errors.Is(err, os.ErrNotExist) {
-		return err
+		return nil, err
 	}
 
-	return nil
+	return result, nil
 }
 
 // terminateProcess terminates the ollama process; this is required because on
 // Windows running processes cannot be deleted.
-func terminateProcess(ctx context.Context, executablePath string) error {
-
+func terminateProcess(ctx context.Context, executablePath string) ([]int, error) {
 	ollamaInfo, err := os.Stat(executablePath)
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
-			return nil
+			return nil, nil
 		}
-		return fmt.Errorf("error examining ollama executable: %w", err)
+		return nil, fmt.Errorf("error examining ollama executable: %w", err)
 	}
+	pids, err := findProcesses(ctx, func(_ int, _ string, info os.FileInfo) bool {
+		return os.SameFile(ollamaInfo, info)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return stopProcesses(ctx, pids), nil
+}
 
+// findProcesses returns the pids of the processes whose executable matches;
+// match is called with the pid of each process and the path and info of its
+// executable.
+func findProcesses(ctx context.Context, match func(pid int, executablePath string, info os.FileInfo) bool) ([]int, error) {
 	pids := make([]uint32, 4096)
 	// Try EnumProcesses until the number of pids returned is less than the
 	// buffer size.
@@ -171,7 +333,7 @@ func terminateProcess(ctx context.Context, executablePath string) error {
 		var bytesReturned uint32
 		err := windows.EnumProcesses(pids, &bytesReturned)
 		if err != nil || len(pids) < 1 {
-			return fmt.Errorf("failed to enumerate processes: %w", err)
+			return nil, fmt.Errorf("failed to enumerate processes: %w", err)
 		}
 		pidsReturned := uintptr(bytesReturned) / unsafe.Sizeof(pids[0])
 		if pidsReturned < uintptr(len(pids)) {
@@ -182,48 +344,109 @@ func terminateProcess(ctx context.Context, executablePath string) error {
 		pids = make([]uint32, len(pids)*2)
 	}
 
+	var matched []int
 	for _, pid := range pids {
-		// Do each iteration in a function so defer statements run faster.
-		err := (func() error {
-			hProc, err := windows.OpenProcess(
-				windows.PROCESS_QUERY_LIMITED_INFORMATION|windows.PROCESS_TERMINATE,
-				false,
-				pid)
-			if err != nil {
-				log.Printf("Ignoring error opening process %d: %s", pid, err)
-				return nil
+		executablePath, err := processExecutable(int(pid))
+		if err != nil {
+			log.Printf("Ignoring error getting process %d executable: %s", pid, err)
+			continue
+		}
+		executableInfo, err := os.Stat(executablePath)
+		if err != nil {
+			continue
+		}
+		if match(int(pid), executablePath, executableInfo) {
+			matched = append(matched, int(pid))
+		}
+	}
+	return matched, nil
+}
+
+// queryProcessImage returns the path of the executable of the given process.
+func queryProcessImage(hProc windows.Handle) (string, error) {
+	nameBuf := make([]uint16, 1024)
+	for {
+		bufSize := uint32(len(nameBuf))
+		if err := windows.QueryFullProcessImageName(hProc, 0, &nameBuf[0], &bufSize); err != nil {
+			return "", err
+		}
+		if int(bufSize) < len(nameBuf) {
+			return windows.UTF16ToString(nameBuf), nil
+		}
+		nameBuf = make([]uint16, len(nameBuf)*2)
+	}
+}
+
+// processExecutable returns the path of the executable the given process runs.
+func processExecutable(pid int) (string, error) {
+	hProc, err := windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION, false, uint32(pid))
+	if err != nil {
+		return "", fmt.Errorf("failed to open process %d: %w", pid, err)
+	}
+	defer windows.CloseHandle(hProc)
+	return queryProcessImage(hProc)
+}
+
+var procGetExtendedTcpTable = windows.NewLazySystemDLL("iphlpapi.dll").NewProc("GetExtendedTcpTable")
+
+const tcpTableOwnerPIDListener = 3 // TCP_TABLE_OWNER_PID_LISTENER
+
+// findPortListener returns the pid of the process listening on the given TCP
+// port, from the IPv4 and IPv6 TCP tables.  Returns 0 if nothing is listening.
+func findPortListener(ctx context.Context, port int) (int, error) {
+	for _, family := range []struct {
+		af      uint32
+		rowSize uintptr // sizeof(MIB_TCPROW_OWNER_PID) or sizeof(MIB_TCP6ROW_OWNER_PID)
+		portAt  uintptr // offsetof(..., dwLocalPort)
+		pidAt   uintptr // offsetof(..., dwOwningPid)
+	}{
+		{windows.AF_INET, 24, 8, 20},
+		{windows.AF_INET6, 56, 20, 52},
+	} {
+		var size uint32
+		var buf []byte
+		for {
+			var ptr uintptr
+			if len(buf) > 0 {
+				ptr = uintptr(unsafe.Pointer(&buf[0]))
 			}
-			defer windows.CloseHandle(hProc)
-
-			nameBuf := make([]uint16, 1024)
-			for {
-				bufSize := uint32(len(nameBuf))
-				err = windows.QueryFullProcessImageName(hProc, 0, &nameBuf[0], &bufSize)
-				if err != nil {
-					return fmt.Errorf("error getting process %d executable: %w", pid, err)
-				}
-				if int(bufSize) < len(nameBuf) {
-					break
-				}
-				nameBuf = make([]uint16, len(nameBuf)*2)
+			result, _, _ := procGetExtendedTcpTable.Call(
+				ptr, uintptr(unsafe.Pointer(&size)), 0, uintptr(family.af), tcpTableOwnerPIDListener, 0)
+			if result == uintptr(windows.ERROR_INSUFFICIENT_BUFFER) {
+				buf = make([]byte, size)
+				continue
+			} else if result != 0 {
+				return 0, fmt.Errorf("failed to list listening sockets: %w", windows.Errno(result))
 			}
-			executablePath := windows.UTF16ToString(nameBuf)
-			executableInfo, err := os.Stat(executablePath)
-			if err != nil {
-				return nil
+			break
+		}
+		if len(buf) < 4 {
+			continue
+		}
+		entries := uintptr(*(*uint32)(unsafe.Pointer(&buf[0])))
+		for i := uintptr(0); i < entries; i++ {
+			row := 4 + i*family.rowSize
+			if row+family.rowSize > uintptr(len(buf)) {
+				break
 			}
-			if os.SameFile(ollamaInfo, executableInfo) {
-				if err = windows.TerminateProcess(hProc, 0); err != nil {
-					return fmt.Errorf("failed to terminate pid %d (%s): %w", pid, executablePath, err)
-				}
+			// The port is in network byte order, in the low 16 bits.
+			localPort := int(buf[row+family.portAt])<<8 | int(buf[row+family.portAt+1])
+			if localPort == port {
+				return int(*(*uint32)(unsafe.Pointer(&buf[row+family.pidAt]))), nil
 			}
-
-			return nil
-		})()
-		if err != nil {
-			log.Printf("%s", err)
 		}
 	}
+	return 0, nil
+}
 
-	return nil
+// systemInstallLocation returns the system-wide install location, under
+// Program Files.  It is in a directory of the extension's own, rather than
+// Program Files\Ollama, where manual installs of ollama may live and which must
+// not be mistaken for the managed install.
+func systemInstallLocation() (string, error) {
+	programFiles, err := windows.KnownFolderPath(windows.FOLDERID_ProgramFiles, windows.KF_FLAG_DEFAULT)
+	if err != nil {
+		return "", fmt.Errorf("failed to find Program Files: %w", err)
+	}
+	return filepath.Join(programFiles, "Rancher Desktop Open WebUI", "ollama"), nil
 }
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/sys/windows"
+)
+
+func TestSystemInstallLocationIsDedicated(t *testing.T) {
+	location, err := systemInstallLocation()
+	if err != nil {
+		t.Fatal(err)
+	}
+	programFiles, err := windows.KnownFolderPath(windows.FOLDERID_ProgramFiles, windows.KF_FLAG_DEFAULT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A manual install of ollama for all users lives here; the managed one
+	// must not, or it would be mistaken for it (and it for the managed one).
+	shared := filepath.Join(programFiles, "Ollama")
+	if strings.EqualFold(location, shared) || strings.EqualFold(filepath.Dir(location), programFiles) {
+		t.Errorf("system install location %s is not in a directory of its own under %s", location, programFiles)
+	}
+}
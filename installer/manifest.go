@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+)
+
+// ErrManifestMismatch is returned when the extracted files do not match the
+// expected file manifest.
+var ErrManifestMismatch = errors.New("install does not match file manifest")
+
+// manifestFile describes a single expected file in a fileManifest.
+type manifestFile struct {
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// fileManifest lists the exact regular files an install is expected to
+// contain, keyed by their slash-separated path relative to the install
+// directory; it is read from the file given by -file-manifest.  Directories and
+// links are not listed.  A nil manifest accepts any files.
+type fileManifest struct {
+	Files map[string]manifestFile `json:"files"`
+	seen  map[string]bool
+}
+
+// loadFileManifest reads the manifest given by -file-manifest; if none was
+// given, nil is returned.
+func loadFileManifest() (*fileManifest, error) {
+	if *fileManifestPath == "" {
+		return nil, nil
+	}
+	contents, err := os.ReadFile(*fileManifestPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file manifest: %w", err)
+	}
+	manifest := &fileManifest{seen: make(map[string]bool)}
+	if err = json.Unmarshal(contents, manifest); err != nil {
+		return nil, fmt.Errorf("failed to read file manifest: error unmarshaling %s: %w", *fileManifestPath, err)
+	}
+	if len(manifest.Files) == 0 {
+		return nil, fmt.Errorf("failed to read file manifest: %s lists no files", *fileManifestPath)
+	}
+	return manifest, nil
+}
+
+// expect checks, before extracting it, that the given file is in the manifest
+// with the given size.
+func (m *fileManifest) expect(name string, size int64) error {
+	if m == nil {
+		return nil
+	}
+	name = path.Clean(name)
+	expected, ok := m.Files[name]
+	if !ok {
+		return fmt.Errorf("error extracting %s: unexpected file: %w", name, ErrManifestMismatch)
+	}
+	if size != expected.Size {
+		return fmt.Errorf("error extracting %s: expected %d bytes, got %d: %w", name, expected.Size, size, ErrManifestMismatch)
+	}
+	return nil
+}
+
+// check verifies the SHA-256 digest of an extracted file, and marks it as
+// present.
+func (m *fileManifest) check(name string, digest []byte) error {
+	if m == nil {
+		return nil
+	}
+	name = path.Clean(name)
+	if actual := hex.EncodeToString(digest); !strings.EqualFold(actual, m.Files[name].SHA256) {
+		return fmt.Errorf("error extracting %s: expected sha256 %s, got %s: %w", name, m.Files[name].SHA256, actual, ErrManifestMismatch)
+	}
+	m.seen[name] = true
+	return nil
+}
+
+// checkFile checks an already installed file against the manifest, where name
+// is its path within the install.
+func (m *fileManifest) checkFile(name, filePath string) error {
+	if m == nil {
+		return nil
+	}
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to check %s: %w", name, err)
+	}
+	if err = m.expect(name, info.Size()); err != nil {
+		return err
+	}
+	checksum, err := hashFile(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to check %s: %w", name, err)
+	}
+	digest, err := hex.DecodeString(checksum)
+	if err != nil {
+		return fmt.Errorf("failed to check %s: %w", name, err)
+	}
+	return m.check(name, digest)
+}
+
+// checkComplete returns an error if any file in the manifest was not extracted.
+func (m *fileManifest) checkComplete() error {
+	if m == nil {
+		return nil
+	}
+	var missing []string
+	for name := range m.Files {
+		if !m.seen[name] {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		slices.Sort(missing)
+		return fmt.Errorf("missing files %s: %w", strings.Join(missing, ", "), ErrManifestMismatch)
+	}
+	return nil
+}
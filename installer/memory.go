@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/rancher-sandbox/rd-open-webui-docker-ext/installer/types"
+)
+
+// modelMemoryOverhead is the factor by which a model's memory use exceeds the
+// size of its weights, for the context (KV cache) and runtime buffers.  It is
+// a rough estimate, good enough to tell whether a model will not fit.
+const modelMemoryOverhead = 1.2
+
+// Media types of the model layers loaded into memory.
+var loadedLayerTypes = []string{"application/vnd.ollama.image.model", "application/vnd.ollama.image.projector"}
+
+// getGPUMemory returns the total memory of the GPUs for the given accelerator,
+// in bytes, or zero if it is unknown or shared with the system (as with Metal).
+func getGPUMemory(ctx context.Context, accelerator string) (uint64, error) {
+	var total uint64
+	switch accelerator {
+	case AcceleratorCUDA:
+		output, err := exec.CommandContext(ctx, "nvidia-smi", "--query-gpu=memory.total", "--format=csv,noheader,nounits").Output()
+		if err != nil {
+			return 0, fmt.Errorf("failed to run nvidia-smi: %w", err)
+		}
+		for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+			mebibytes, err := strconv.ParseUint(strings.TrimSpace(line), 10, 64)
+			if err != nil {
+				return 0, fmt.Errorf("failed to parse GPU memory %q: %w", line, err)
+			}
+			total += mebibytes << 20
+		}
+	case AcceleratorROCm:
+		paths, _ := filepath.Glob("/sys/class/drm/card*/device/mem_info_vram_total")
+		for _, vramPath := range paths {
+			contents, err := os.ReadFile(vramPath)
+			if err != nil {
+				return 0, fmt.Errorf("failed to read GPU memory: %w", err)
+			}
+			bytes, err := strconv.ParseUint(strings.TrimSpace(string(contents)), 10, 64)
+			if err != nil {
+				return 0, fmt.Errorf("failed to parse GPU memory %q: %w", contents, err)
+			}
+			total += bytes
+		}
+	}
+	return total, nil
+}
+
+// getModelManifest returns the manifest of the given model: the local copy if
+// it has been pulled, and otherwise the one in the registry.
+func getModelManifest(ctx context.Context, name string) (*modelManifest, error) {
+	manifestPath, err := modelManifestPath(name)
+	if err != nil {
+		return nil, err
+	}
+	var manifest modelManifest
+	if modelsDir, err := getModelsDirectory(ctx); err == nil {
+		contents, err := os.ReadFile(filepath.Join(modelsDir, filepath.FromSlash(manifestPath)))
+		if err == nil {
+			if err = json.Unmarshal(contents, &manifest); err != nil {
+				return nil, fmt.Errorf("error unmarshaling manifest of %s: %w", name, err)
+			}
+			return &manifest, nil
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("failed to read manifest of %s: %w", name, err)
+		}
+	}
+
+	// The path is manifests/<host>/<namespace>/<model>/<tag>.
+	parts := strings.Split(manifestPath, "/")
+	host, repo, tag := parts[1], path.Join(parts[2:len(parts)-1]...), parts[len(parts)-1]
+	manifestURL := fmt.Sprintf("https://%s/v2/%s/manifests/%s", host, repo, tag)
+	req, err := newRequest(ctx, http.MethodGet, manifestURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get manifest of %s: %w", name, err)
+	}
+	req.Header.Set("Accept", "application/vnd.docker.distribution.manifest.v2+json")
+	resp, err := downloadClient().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get manifest of %s: %w", name, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("failed to get manifest of %s: unexpected status %s", name, resp.Status)
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("failed to get manifest of %s: error unmarshaling response: %w", name, err)
+	}
+	return &manifest, nil
+}
+
+// checkModelFit estimates whether the given model fits in memory: in the GPU's
+// memory if there is a discrete GPU, or otherwise (or when offloading layers
+// from the GPU) in system memory.  This is only an estimate; it is used to
+// warn, never to refuse.
+func checkModelFit(ctx context.Context, name string) (*types.ModelFit, error) {
+	name = normalizeModelName(name)
+	manifest, err := getModelManifest(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	result := &types.ModelFit{SchemaVersion: types.SchemaVersion, Model: name, Accelerator: detectAccelerator()}
+	for _, layer := range manifest.Layers {
+		for _, mediaType := range loadedLayerTypes {
+			if layer.MediaType == mediaType {
+				result.ModelSize += layer.Size
+			}
+		}
+	}
+	result.RequiredMemory = uint64(float64(result.ModelSize) * modelMemoryOverhead)
+
+	if result.SystemMemory, result.AvailableMemory, err = getSystemMemory(); err != nil {
+		return nil, err
+	}
+	if result.GPUMemory, err = getGPUMemory(ctx, result.Accelerator); err != nil {
+		log.Printf("Could not determine GPU memory: %s", err)
+	}
+	// Prefer the memory currently available, where the platform reports it.
+	systemMemory := result.SystemMemory
+	if result.AvailableMemory > 0 {
+		systemMemory = result.AvailableMemory
+	}
+	switch {
+	case result.RequiredMemory <= result.GPUMemory:
+		result.Fits = true
+	case result.RequiredMemory <= result.GPUMemory+systemMemory:
+		result.Fits = true
+		if result.GPUMemory > 0 {
+			result.Warning = fmt.Sprintf("%s needs about %s but the GPU has %s; part of it will run on the CPU, more slowly",
+				name, formatBytes(result.RequiredMemory), formatBytes(result.GPUMemory))
+		}
+	default:
+		result.Warning = fmt.Sprintf("%s needs about %s but only %s of memory is available; it will likely fail to load",
+			name, formatBytes(result.RequiredMemory), formatBytes(result.GPUMemory+systemMemory))
+	}
+	return result, nil
+}
+
+// warnIfModelDoesNotFit logs a warning if the given model likely does not fit
+// in memory.  Failing to check is not an error.
+func warnIfModelDoesNotFit(ctx context.Context, name string) {
+	fit, err := checkModelFit(ctx, name)
+	if err != nil {
+		log.Printf("Could not check if %s fits in memory: %s", name, err)
+	} else if fit.Warning != "" {
+		log.Printf("Warning: %s", fit.Warning)
+	}
+}
+
+// formatBytes formats a size in bytes for display, such as "4.2 GiB".
+func formatBytes(size uint64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	value, exponent := float64(size)/unit, 0
+	for value >= unit && exponent < 4 {
+		value /= unit
+		exponent++
+	}
+	return fmt.Sprintf("%.1f %ciB", value, "KMGTP"[exponent])
+}
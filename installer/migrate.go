@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// copyTree recursively copies the directory src to dest, which must not exist.
+func copyTree(ctx context.Context, src, dest string) error {
+	return filepath.WalkDir(src, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dest, rel)
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		switch {
+		case entry.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm())
+		case entry.Type().IsRegular():
+			return copyFile(path, target, info.Mode().Perm())
+		default:
+			return fmt.Errorf("cannot copy %s: unsupported file type %s", path, entry.Type())
+		}
+	})
+}
+
+// migrateModels moves the models directory to the given destination, so that
+// models don't need to be pulled again.  The server is stopped while the
+// models are moved, and restarted afterwards if it was running.  If the moved
+// models fail verification, the move is rolled back.
+func migrateModels(ctx context.Context, destination string) error {
+	if destination == "" {
+		return fmt.Errorf("no destination given for migrating models")
+	}
+	destination, err := filepath.Abs(destination)
+	if err != nil {
+		return fmt.Errorf("failed to resolve %s: %w", destination, err)
+	}
+	source, err := getModelsDirectory(ctx)
+	if err != nil {
+		return err
+	}
+	if source == destination {
+		log.Printf("Models are already in %s", destination)
+		return nil
+	}
+	if entries, err := os.ReadDir(destination); err == nil && len(entries) > 0 {
+		return fmt.Errorf("failed to migrate models: %s is not empty", destination)
+	} else if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to migrate models: %w", err)
+	}
+	// Remove any empty destination directory, so it can be renamed over.
+	_ = os.Remove(destination)
+	if err = os.MkdirAll(filepath.Dir(destination), 0o755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(destination), err)
+	}
+
+	wasRunning, err := checkExistingInstance(ctx)
+	if err != nil {
+		return err
+	}
+	if wasRunning {
+		log.Printf("Stopping ollama to migrate models...")
+		if err = shutdownOllama(ctx); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("Moving models from %s to %s...", source, destination)
+	copied := false
+	if err = os.Rename(source, destination); err != nil {
+		// Renaming fails across filesystems; copy instead.
+		log.Printf("Could not rename models directory, copying instead: %s", err)
+		if err = copyTree(ctx, source, destination); err != nil {
+			_ = os.RemoveAll(destination)
+			return fmt.Errorf("failed to copy models: %w", err)
+		}
+		copied = true
+	}
+
+	rollback := func() {
+		log.Printf("Rolling back migration of models...")
+		if copied {
+			_ = os.RemoveAll(destination)
+		} else if err := os.Rename(destination, source); err != nil {
+			log.Printf("Failed to roll back models to %s: %s", source, err)
+		}
+	}
+	if err = verifyBlobs(ctx, destination); err != nil {
+		rollback()
+		return fmt.Errorf("failed to migrate models: %w", err)
+	}
+	err = updateState(ctx, func(state *installerState) error {
+		state.ModelsDirectory = destination
+		return nil
+	})
+	if err != nil {
+		rollback()
+		return err
+	}
+	if copied {
+		if err = os.RemoveAll(source); err != nil {
+			log.Printf("Failed to remove old models directory %s: %s", source, err)
+		}
+	}
+	log.Printf("Models migrated to %s", destination)
+
+	if wasRunning {
+		return startServe(ctx)
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rancher-sandbox/rd-open-webui-docker-ext/installer/types"
+)
+
+// listModels returns the models known to the running ollama server.
+func listModels(ctx context.Context) ([]types.ModelInfo, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ollamaURL+"/api/tags", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list models: %w", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list models: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("failed to list models: unexpected status %s", resp.Status)
+	}
+	var body struct {
+		Models []struct {
+			Name       string    `json:"name"`
+			Digest     string    `json:"digest"`
+			Size       int64     `json:"size"`
+			ModifiedAt time.Time `json:"modified_at"`
+		} `json:"models"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("failed to list models: error unmarshaling response: %w", err)
+	}
+	models := make([]types.ModelInfo, 0, len(body.Models))
+	for _, model := range body.Models {
+		models = append(models, types.ModelInfo{
+			Name:       model.Name,
+			Digest:     model.Digest,
+			Size:       model.Size,
+			ModifiedAt: model.ModifiedAt,
+		})
+	}
+	return models, nil
+}
+
+// ErrModelNotFound is returned when a model has not been pulled.
+var ErrModelNotFound = errors.New("model not found")
+
+// ErrModelExists is returned when a model would overwrite an existing one.
+var ErrModelExists = errors.New("model already exists")
+
+// modelRequest sends a request about models to the running ollama server, with
+// the given JSON body, mapping a 404 response to ErrModelNotFound.
+func modelRequest(ctx context.Context, method, apiPath string, body any) error {
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, ollamaURL+apiPath, bytes.NewReader(encoded))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrModelNotFound
+	} else if resp.StatusCode >= 300 {
+		var response struct {
+			Error string `json:"error"`
+		}
+		contents, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		if json.Unmarshal(contents, &response) == nil && response.Error != "" {
+			return fmt.Errorf("unexpected status %s: %s", resp.Status, response.Error)
+		}
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// copyModel copies the source model to a new name, without downloading
+// anything; the copy shares its blobs with the source.  The destination must
+// not already exist.
+func copyModel(ctx context.Context, source, destination string) error {
+	source, destination = normalizeModelName(source), normalizeModelName(destination)
+	if _, err := modelManifestPath(destination); err != nil {
+		return fmt.Errorf("failed to copy %s: %w", source, err)
+	}
+	if exists, err := modelPulled(ctx, destination); err != nil {
+		return fmt.Errorf("failed to copy %s: %w", source, err)
+	} else if exists {
+		return fmt.Errorf("failed to copy %s to %s: %w", source, destination, ErrModelExists)
+	}
+	err := modelRequest(ctx, http.MethodPost, "/api/copy", map[string]string{"source": source, "destination": destination})
+	if err != nil {
+		return fmt.Errorf("failed to copy %s to %s: %w", source, destination, err)
+	}
+	log.Printf("Copied %s to %s", source, destination)
+	return nil
+}
+
+// renameModel renames the source model, by copying it and deleting the source,
+// as ollama has no rename; the blobs are shared, so nothing is downloaded.
+func renameModel(ctx context.Context, source, destination string) error {
+	if err := copyModel(ctx, source, destination); err != nil {
+		return err
+	}
+	if err := deleteModel(ctx, source); err != nil {
+		return fmt.Errorf("failed to rename %s: %w", normalizeModelName(source), err)
+	}
+	return nil
+}
+
+// deleteModel removes the given model; blobs shared with other models are kept.
+func deleteModel(ctx context.Context, name string) error {
+	name = normalizeModelName(name)
+	if err := modelRequest(ctx, http.MethodDelete, "/api/delete", map[string]string{"model": name}); err != nil {
+		return fmt.Errorf("failed to remove %s: %w", name, err)
+	}
+	log.Printf("Removed %s", name)
+	return nil
+}
+
+// Print the locally available models as JSON.
+func printModels(ctx context.Context) error {
+	models, err := listModels(ctx)
+	if err != nil {
+		return err
+	}
+	return printJSON(types.ModelList{
+		SchemaVersion: types.SchemaVersion,
+		Models:        models,
+	})
+}
+
+// getModelsDirectory returns the directory ollama stores models in.  This does
+// not check that the directory exists.  In order of precedence, this is the
+// -models-dir flag, the location models were last migrated to, $OLLAMA_MODELS,
+// and finally ollama's default.
+func getModelsDirectory(ctx context.Context) (string, error) {
+	if *modelsDir != "" {
+		return filepath.Abs(*modelsDir)
+	}
+	state, err := loadState(ctx)
+	if err != nil {
+		return "", err
+	}
+	if state.ModelsDirectory != "" {
+		return state.ModelsDirectory, nil
+	}
+	if dir := os.Getenv("OLLAMA_MODELS"); dir != "" {
+		return filepath.Abs(dir)
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to determine models directory: %w", err)
+	}
+	return filepath.Join(homeDir, ".ollama", "models"), nil
+}
+
+// ensureModelsDirectory creates the models directory if needed, and checks that
+// it is writable; ollama otherwise fails on the first pull.  Returns the path
+// to the models directory.
+func ensureModelsDirectory(ctx context.Context) (string, error) {
+	dir, err := getModelsDirectory(ctx)
+	if err != nil {
+		return "", err
+	}
+	if err = ensureWritableDirectory(dir, "models directory"); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
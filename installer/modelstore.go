@@ -0,0 +1,332 @@
+package main
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// modelManifest is the subset of an ollama model manifest we need.
+type modelManifest struct {
+	Config manifestLayer   `json:"config"`
+	Layers []manifestLayer `json:"layers"`
+}
+
+type manifestLayer struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
+}
+
+// digests returns the digests of all blobs referenced by the manifest.
+func (m *modelManifest) digests() []string {
+	result := []string{m.Config.Digest}
+	for _, layer := range m.Layers {
+		result = append(result, layer.Digest)
+	}
+	return result
+}
+
+// modelManifestPath returns the path of the manifest for the given model,
+// relative to the models directory, using forward slashes.  This mirrors how
+// ollama expands short names such as "llama3" into
+// "registry.ollama.ai/library/llama3:latest".
+func modelManifestPath(name string) (string, error) {
+	// After normalizing, the tag always follows the last colon (the registry
+	// host may contain a port, too).
+	name = normalizeModelName(name)
+	i := strings.LastIndex(name, ":")
+	repo, tag := name[:i], name[i+1:]
+	parts := strings.Split(repo, "/")
+	switch len(parts) {
+	case 1:
+		parts = append([]string{"registry.ollama.ai", "library"}, parts...)
+	case 2:
+		parts = append([]string{"registry.ollama.ai"}, parts...)
+	case 3:
+	default:
+		return "", fmt.Errorf("invalid model name %q", name)
+	}
+	for _, part := range append(parts, tag) {
+		if part == "" || part == "." || part == ".." || strings.ContainsAny(part, `\`) {
+			return "", fmt.Errorf("invalid model name %q", name)
+		}
+	}
+	return path.Join(append([]string{"manifests"}, append(parts, tag)...)...), nil
+}
+
+// modelNameFromManifestPath returns the name of the model whose manifest is at
+// the given path, in the short form ollama lists it as; this is the reverse of
+// modelManifestPath.
+func modelNameFromManifestPath(manifestPath string) (string, error) {
+	parts := strings.Split(strings.TrimPrefix(manifestPath, "manifests/"), "/")
+	if len(parts) != 4 {
+		return "", fmt.Errorf("unexpected manifest %s", manifestPath)
+	}
+	repo, tag := parts[:3], parts[3]
+	if repo[0] == "registry.ollama.ai" {
+		repo = repo[1:]
+		if repo[0] == "library" {
+			repo = repo[1:]
+		}
+	}
+	return strings.Join(repo, "/") + ":" + tag, nil
+}
+
+// blobPath returns the path of the blob with the given digest, relative to the
+// models directory, using forward slashes.
+func blobPath(digest string) (string, error) {
+	algorithm, hash, ok := strings.Cut(digest, ":")
+	if !ok || algorithm != "sha256" || len(hash) != sha256.Size*2 {
+		return "", fmt.Errorf("invalid digest %q", digest)
+	}
+	if _, err := hex.DecodeString(hash); err != nil {
+		return "", fmt.Errorf("invalid digest %q", digest)
+	}
+	return "blobs/sha256-" + hash, nil
+}
+
+// readModelManifest reads the manifest of the given model from the models
+// directory.
+func readModelManifest(modelsDir, name string) (*modelManifest, string, error) {
+	manifestPath, err := modelManifestPath(name)
+	if err != nil {
+		return nil, "", err
+	}
+	contents, err := os.ReadFile(filepath.Join(modelsDir, filepath.FromSlash(manifestPath)))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, "", fmt.Errorf("model %s is not installed", name)
+	} else if err != nil {
+		return nil, "", fmt.Errorf("failed to read manifest for %s: %w", name, err)
+	}
+	var manifest modelManifest
+	if err = json.Unmarshal(contents, &manifest); err != nil {
+		return nil, "", fmt.Errorf("failed to read manifest for %s: %w", name, err)
+	}
+	return &manifest, manifestPath, nil
+}
+
+// addFileToTar writes the file at the given path to the tar archive, under the
+// given name.
+func addFileToTar(writer *tar.Writer, name, filePath string) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	header := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     info.Size(),
+		Mode:     0o644,
+		ModTime:  info.ModTime(),
+	}
+	if err = writer.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err = io.Copy(writer, file)
+	return err
+}
+
+// exportModel writes the given model's manifest and blobs into a tarball that
+// can be moved to another machine and imported with importModel.
+func exportModel(ctx context.Context, name, outputPath string) error {
+	modelsDir, err := getModelsDirectory(ctx)
+	if err != nil {
+		return err
+	}
+	manifest, manifestPath, err := readModelManifest(modelsDir, name)
+	if err != nil {
+		return err
+	}
+
+	output, err := os.OpenFile(outputPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", outputPath, err)
+	}
+	succeeded := false
+	defer func() {
+		output.Close()
+		if !succeeded {
+			_ = os.Remove(outputPath)
+		}
+	}()
+
+	writer := tar.NewWriter(output)
+	for _, digest := range manifest.digests() {
+		if err = ctx.Err(); err != nil {
+			return fmt.Errorf("export of %s aborted: %w", name, err)
+		}
+		blob, err := blobPath(digest)
+		if err != nil {
+			return fmt.Errorf("failed to export %s: %w", name, err)
+		}
+		log.Printf("Exporting %s...", blob)
+		if err = addFileToTar(writer, blob, filepath.Join(modelsDir, filepath.FromSlash(blob))); err != nil {
+			return fmt.Errorf("failed to export %s: %w", blob, err)
+		}
+	}
+	// The manifest is written last, so that on import all blobs are in place
+	// before the model becomes visible to ollama.
+	if err = addFileToTar(writer, manifestPath, filepath.Join(modelsDir, filepath.FromSlash(manifestPath))); err != nil {
+		return fmt.Errorf("failed to export manifest: %w", err)
+	}
+	if err = writer.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", outputPath, err)
+	}
+	if err = output.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", outputPath, err)
+	}
+	succeeded = true
+	log.Printf("Exported %s to %s", name, outputPath)
+	return nil
+}
+
+// importBlob writes a blob from the archive into the models directory,
+// verifying that its contents match its digest.
+func importBlob(modelsDir, name string, reader io.Reader) error {
+	hash := strings.TrimPrefix(path.Base(name), "sha256-")
+	outPath := filepath.Join(modelsDir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(outPath), 0o755); err != nil {
+		return fmt.Errorf("failed to create blobs directory: %w", err)
+	}
+	file, err := os.CreateTemp(filepath.Dir(outPath), path.Base(name)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to import %s: %w", name, err)
+	}
+	defer func() {
+		file.Close()
+		_ = os.Remove(file.Name())
+	}()
+	hasher := sha256.New()
+	if _, err = io.Copy(io.MultiWriter(file, hasher), reader); err != nil {
+		return fmt.Errorf("failed to import %s: %w", name, err)
+	}
+	if actual := hex.EncodeToString(hasher.Sum(nil)); actual != hash {
+		return fmt.Errorf("failed to import %s: got sha256 %s: %w", name, actual, ErrChecksumMismatch)
+	}
+	if err = file.Close(); err != nil {
+		return fmt.Errorf("failed to import %s: %w", name, err)
+	}
+	if err = os.Rename(file.Name(), outPath); err != nil {
+		return fmt.Errorf("failed to import %s: %w", name, err)
+	}
+	return nil
+}
+
+// importModel unpacks a tarball created by exportModel into the models
+// directory.  Blob digests are verified, so corrupted transfers are rejected.
+// Ollama reads manifests from disk, so the model is available once its
+// manifest is written.
+func importModel(ctx context.Context, inputPath string) error {
+	modelsDir, err := ensureModelsDirectory(ctx)
+	if err != nil {
+		return err
+	}
+	input, err := os.Open(inputPath)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", inputPath, err)
+	}
+	defer input.Close()
+
+	manifests := make(map[string][]byte)
+	reader := tar.NewReader(input)
+	for {
+		if err = ctx.Err(); err != nil {
+			return fmt.Errorf("import aborted: %w", err)
+		}
+		header, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return fmt.Errorf("failed to read %s: %w", inputPath, err)
+		}
+		if header.Typeflag != tar.TypeReg || !filepath.IsLocal(header.Name) {
+			return fmt.Errorf("failed to import %s: unexpected entry %s", inputPath, header.Name)
+		}
+		switch {
+		case strings.HasPrefix(header.Name, "blobs/sha256-"):
+			log.Printf("Importing %s...", header.Name)
+			if err = importBlob(modelsDir, header.Name, reader); err != nil {
+				return err
+			}
+		case strings.HasPrefix(header.Name, "manifests/"):
+			// Manifests are small; hold them until all blobs are verified.
+			if manifests[header.Name], err = io.ReadAll(io.LimitReader(reader, 1<<20)); err != nil {
+				return fmt.Errorf("failed to read manifest %s: %w", header.Name, err)
+			}
+		default:
+			return fmt.Errorf("failed to import %s: unexpected entry %s", inputPath, header.Name)
+		}
+	}
+	if len(manifests) == 0 {
+		return fmt.Errorf("failed to import %s: no model manifest found", inputPath)
+	}
+
+	for name, contents := range manifests {
+		var manifest modelManifest
+		if err = json.Unmarshal(contents, &manifest); err != nil {
+			return fmt.Errorf("failed to import %s: invalid manifest: %w", name, err)
+		}
+		for _, digest := range manifest.digests() {
+			blob, err := blobPath(digest)
+			if err != nil {
+				return fmt.Errorf("failed to import %s: %w", name, err)
+			}
+			if _, err = os.Stat(filepath.Join(modelsDir, filepath.FromSlash(blob))); err != nil {
+				return fmt.Errorf("failed to import %s: missing blob %s: %w", name, digest, err)
+			}
+		}
+		outPath := filepath.Join(modelsDir, filepath.FromSlash(name))
+		if err = os.MkdirAll(filepath.Dir(outPath), 0o755); err != nil {
+			return fmt.Errorf("failed to import %s: %w", name, err)
+		}
+		if err = os.WriteFile(outPath, contents, 0o644); err != nil {
+			return fmt.Errorf("failed to import %s: %w", name, err)
+		}
+		log.Printf("Imported %s", name)
+	}
+	return nil
+}
+
+// verifyBlobs checks that the contents of every blob in the models directory
+// match their digests.
+func verifyBlobs(ctx context.Context, modelsDir string) error {
+	entries, err := os.ReadDir(filepath.Join(modelsDir, "blobs"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to list blobs: %w", err)
+	}
+	for _, entry := range entries {
+		hash, ok := strings.CutPrefix(entry.Name(), "sha256-")
+		if !ok || !entry.Type().IsRegular() {
+			continue
+		}
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		actual, err := hashFile(filepath.Join(modelsDir, "blobs", entry.Name()))
+		if err != nil {
+			return fmt.Errorf("failed to verify blob %s: %w", entry.Name(), err)
+		}
+		if actual != hash {
+			return fmt.Errorf("failed to verify blob %s: got sha256 %s: %w", entry.Name(), actual, ErrChecksumMismatch)
+		}
+	}
+	return nil
+}
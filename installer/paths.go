@@ -0,0 +1,240 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// Where ollama is installed when -install-dir is not given, as set by
+// -install-scope.
+const (
+	InstallScopeUser   = "user"   // Within the extension, for the current user only.
+	InstallScopeSystem = "system" // A system-wide location, shared by all users.
+)
+
+// ErrInstallNotOwned is returned when asked to remove or replace an install
+// location the installer did not create.
+var ErrInstallNotOwned = errors.New("install location was not created by the installer")
+
+// installMarkerName is the file recording that the installer created the
+// install location; see checkInstallOwnership.
+const installMarkerName = ".rd-open-webui-installer"
+
+// checkInstallScopePrivileges returns an error if installing to (or
+// uninstalling from) the system-wide location without elevated privileges,
+// rather than failing partway with a permission error.
+func checkInstallScopePrivileges() error {
+	if installScope != InstallScopeSystem || *installDir != "" || isElevated() {
+		return nil
+	}
+	location, err := systemInstallLocation()
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("the system-wide install location %s requires running as %s; use -install-scope=%s to install for the current user only",
+		location, elevatedUserName, InstallScopeUser)
+}
+
+// alternateInstallLocation returns the default install location of the scope
+// not selected, so that an install there is still found; it is empty when
+// -install-dir overrides both.
+func alternateInstallLocation() string {
+	if *installDir != "" {
+		return ""
+	}
+	scope := InstallScopeSystem
+	if installScope == InstallScopeSystem {
+		scope = InstallScopeUser
+	}
+	location, err := getScopeInstallLocation(scope)
+	if err != nil {
+		return ""
+	}
+	return location
+}
+
+// ensureWritableDirectory creates the given directory if needed, and checks
+// that it is writable.  The description is used in error messages.
+func ensureWritableDirectory(dir, description string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create %s %s: %w", description, dir, err)
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("failed to check %s %s: %w", description, dir, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s %s is not a directory", description, dir)
+	}
+	probe, err := os.CreateTemp(dir, ".write-check-*")
+	if err != nil {
+		return fmt.Errorf("%s %s is not writable: %w", description, dir, err)
+	}
+	probe.Close()
+	if err = os.Remove(probe.Name()); err != nil {
+		return fmt.Errorf("failed to clean up %s %s: %w", description, dir, err)
+	}
+	return nil
+}
+
+// tempDirectoryFor returns the directory to create temporary files in that are
+// later renamed into the (existing) directory dest.  This is -temp-dir if set,
+// as long as it is on the same filesystem as dest so that the rename is atomic;
+// otherwise, it is dest itself.
+func tempDirectoryFor(dest string) (string, error) {
+	if *tempDir == "" {
+		return dest, nil
+	}
+	if err := ensureWritableDirectory(*tempDir, "temporary directory"); err != nil {
+		return "", err
+	}
+	same, err := sameFilesystem(*tempDir, dest)
+	if err != nil {
+		return "", fmt.Errorf("failed to check temporary directory: %w", err)
+	}
+	if !same {
+		log.Printf("Temporary directory %s is not on the same filesystem as %s; using the latter", *tempDir, dest)
+		return dest, nil
+	}
+	return *tempDir, nil
+}
+
+// nearestExistingDirectory returns the given path if it exists, or otherwise
+// its closest ancestor that does.
+func nearestExistingDirectory(dir string) string {
+	for ; dir != filepath.Dir(dir); dir = filepath.Dir(dir) {
+		if _, err := os.Stat(dir); err == nil {
+			break
+		}
+	}
+	return dir
+}
+
+// warnIfNetworkFilesystem logs a warning if the install location is on a
+// network filesystem, where extracting is slow and renames may not be atomic.
+// Staging still happens on the same mount (see newStagingDirectory), which is
+// the best we can do there.  Failing to check is not an error.
+func warnIfNetworkFilesystem(installPath string) {
+	fsType, network, err := getFilesystemType(nearestExistingDirectory(installPath))
+	if err != nil {
+		log.Printf("Could not determine the filesystem of %s: %s", installPath, err)
+	} else if network {
+		log.Printf("Warning: %s is on a network filesystem (%s); installing may be slow, "+
+			"and an interrupted install may not be cleaned up.  Use -install-dir to install to local storage.", installPath, fsType)
+	}
+}
+
+// newStagingDirectory creates an empty directory to extract an install into,
+// on the same filesystem as installPath, so that the finished install can be
+// moved into place with commitStaged.
+func newStagingDirectory(installPath string) (string, error) {
+	parent := filepath.Dir(installPath)
+	if err := os.MkdirAll(parent, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create staging directory: %w", err)
+	}
+	root, err := tempDirectoryFor(parent)
+	if err != nil {
+		return "", err
+	}
+	staging, err := os.MkdirTemp(root, ".ollama-staging-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create staging directory: %w", err)
+	}
+	// The staging directory becomes the install directory on some platforms.
+	if err = os.Chmod(staging, 0o755); err != nil {
+		_ = os.RemoveAll(staging)
+		return "", fmt.Errorf("failed to create staging directory: %w", err)
+	}
+	return staging, nil
+}
+
+// commitStaged moves a staged file or directory to its final path, replacing
+// any remains of an earlier failed install.  Anything at the final path that
+// the installer did not create (see checkInstallOwnership) is left alone, and
+// an error returned instead.
+func commitStaged(staged, dest string) error {
+	if err := checkInstallOwnership(dest); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(dest); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", dest, err)
+	}
+	if err := os.Rename(staged, dest); err != nil {
+		return fmt.Errorf("failed to move install into place: %w", err)
+	}
+	return writeInstallMarker(dest)
+}
+
+// checkInstallLocation validates the install location before we write to or
+// remove it.  A symbolic link as the install location itself is rejected rather
+// than followed: removing it on uninstall (or after a failed install) would
+// otherwise either only remove the link, or delete the contents of a directory
+// the user pointed it at.  Symbolic links in parent directories are fine.  As a
+// last line of defence, filesystem roots and the home directory are refused.
+func checkInstallLocation(installPath string) error {
+	if installPath == filepath.Dir(installPath) {
+		return fmt.Errorf("refusing to use filesystem root %s as the install location", installPath)
+	}
+	if homeDir, err := os.UserHomeDir(); err == nil && filepath.Clean(homeDir) == installPath {
+		return fmt.Errorf("refusing to use home directory %s as the install location", installPath)
+	}
+	info, err := os.Lstat(installPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to check install location %s: %w", installPath, err)
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		return fmt.Errorf("install location %s is a symbolic link; use -install-dir to give the real path instead", installPath)
+	}
+	return nil
+}
+
+// installMarkerPath returns where the marker for the given install location is
+// kept: within it, or on macOS (where the install location is the executable)
+// beside it.
+func installMarkerPath(installPath string) string {
+	if runtime.GOOS == "darwin" {
+		return filepath.Join(filepath.Dir(installPath), "."+filepath.Base(installPath)+installMarkerName)
+	}
+	return filepath.Join(installPath, installMarkerName)
+}
+
+// writeInstallMarker records that the installer created the install location.
+func writeInstallMarker(installPath string) error {
+	content := fmt.Sprintf("Installed by the Open WebUI extension installer %s; removed on uninstall.\n", installerVersion)
+	if err := os.WriteFile(installMarkerPath(installPath), []byte(content), 0o644); err != nil {
+		return fmt.Errorf("failed to mark install location %s: %w", installPath, err)
+	}
+	return nil
+}
+
+// checkInstallOwnership returns an error unless the installer may remove or
+// replace the install location: it must be missing, an empty directory, marked
+// by writeInstallMarker, or the default location within the extension (which
+// installs made before the marker existed use).  This keeps a mistyped or
+// shared -install-dir, such as /usr/local, from being deleted.
+func checkInstallOwnership(installPath string) error {
+	info, err := os.Lstat(installPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to check install location %s: %w", installPath, err)
+	}
+	if info.IsDir() {
+		if entries, err := os.ReadDir(installPath); err == nil && len(entries) == 0 {
+			return nil
+		}
+	}
+	if _, err = os.Lstat(installMarkerPath(installPath)); err == nil {
+		return nil
+	}
+	if extensionPath, err := getScopeInstallLocation(InstallScopeUser); err == nil && extensionPath == installPath {
+		return nil
+	}
+	return fmt.Errorf("refusing to remove %s: %w; remove it yourself, or use -install-dir to choose another location", installPath, ErrInstallNotOwned)
+}
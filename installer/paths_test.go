@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckInstallOwnership(t *testing.T) {
+	root := t.TempDir()
+	missing := filepath.Join(root, "missing")
+	empty := filepath.Join(root, "empty")
+	marked := filepath.Join(root, "marked")
+	foreign := filepath.Join(root, "foreign")
+	for _, dir := range []string{empty, marked, foreign} {
+		if err := os.Mkdir(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, dir := range []string{marked, foreign} {
+		if err := os.WriteFile(filepath.Join(dir, "data"), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writeInstallMarker(marked); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name    string
+		path    string
+		wantErr error
+	}{
+		{"missing", missing, nil},
+		{"empty", empty, nil},
+		{"marked", marked, nil},
+		{"foreign", foreign, ErrInstallNotOwned},
+		{"foreign executable", filepath.Join(foreign, "data"), ErrInstallNotOwned},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkInstallOwnership(tt.path)
+			if tt.wantErr == nil && err != nil {
+				t.Errorf("checkInstallOwnership(%s) = %v, want no error", tt.path, err)
+			} else if !errors.Is(err, tt.wantErr) {
+				t.Errorf("checkInstallOwnership(%s) = %v, want %v", tt.path, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCommitStagedRefusesForeignDirectory(t *testing.T) {
+	root := t.TempDir()
+	staged := filepath.Join(root, "staged")
+	dest := filepath.Join(root, "local")
+	data := filepath.Join(dest, "data")
+	for _, dir := range []string{staged, dest} {
+		if err := os.Mkdir(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(data, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := commitStaged(staged, dest); !errors.Is(err, ErrInstallNotOwned) {
+		t.Errorf("commitStaged() = %v, want %v", err, ErrInstallNotOwned)
+	}
+	if _, err := os.Stat(data); err != nil {
+		t.Errorf("commitStaged removed unowned data: %v", err)
+	}
+}
+
+func TestCommitStagedReplacesOwnedDirectory(t *testing.T) {
+	root := t.TempDir()
+	staged := filepath.Join(root, "staged")
+	dest := filepath.Join(root, "ollama")
+	for _, dir := range []string{staged, dest} {
+		if err := os.Mkdir(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dest, "leftover"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeInstallMarker(dest); err != nil {
+		t.Fatal(err)
+	}
+	if err := commitStaged(staged, dest); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dest, "leftover")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("remains of the earlier install were kept: %v", err)
+	}
+}
+
+func TestCommitStagedMarksInstall(t *testing.T) {
+	root := t.TempDir()
+	staged := filepath.Join(root, "staged")
+	dest := filepath.Join(root, "ollama")
+	if err := os.Mkdir(staged, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := commitStaged(staged, dest); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(installMarkerPath(dest)); err != nil {
+		t.Errorf("install marker missing after commit: %v", err)
+	}
+}
+
+func TestUninstallRefusesForeignDirectory(t *testing.T) {
+	dir := t.TempDir()
+	data := filepath.Join(dir, "data")
+	if err := os.WriteFile(data, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	setForTest(t, installDir, dir)
+	if _, err := uninstallOllama(context.Background()); !errors.Is(err, ErrInstallNotOwned) {
+		t.Errorf("uninstallOllama() error = %v, want %v", err, ErrInstallNotOwned)
+	}
+	if _, err := os.Stat(data); err != nil {
+		t.Errorf("uninstall removed unowned data: %v", err)
+	}
+}
+
+// symlinkOrSkip creates a symbolic link, skipping the test where that is not
+// permitted (such as on Windows without developer mode).
+func symlinkOrSkip(t *testing.T, target, link string) {
+	t.Helper()
+	if err := os.Symlink(target, link); err != nil {
+		t.Skipf("cannot create symbolic links: %s", err)
+	}
+}
+
+// symlinkedInstall returns a directory holding (marked) installed data, and a
+// symbolic link to it.
+func symlinkedInstall(t *testing.T) (string, string) {
+	t.Helper()
+	root := t.TempDir()
+	target := filepath.Join(root, "target")
+	link := filepath.Join(root, "link")
+	if err := os.Mkdir(target, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(target, "data"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeInstallMarker(target); err != nil {
+		t.Fatal(err)
+	}
+	symlinkOrSkip(t, target, link)
+	return target, link
+}
+
+func TestCheckInstallLocationSymlink(t *testing.T) {
+	_, link := symlinkedInstall(t)
+	if err := checkInstallLocation(link); err == nil {
+		t.Errorf("checkInstallLocation(%s) accepted a symbolic link", link)
+	}
+	// Links in parent directories are fine.
+	if err := checkInstallLocation(filepath.Join(link, "ollama")); err != nil {
+		t.Errorf("checkInstallLocation(%s) = %v, want no error", filepath.Join(link, "ollama"), err)
+	}
+}
+
+func TestInstallRefusesSymlinkedLocation(t *testing.T) {
+	if checkHealth(context.Background(), time.Second) == nil {
+		t.Skip("ollama is running, so nothing is installed")
+	}
+	target, link := symlinkedInstall(t)
+	setForTest(t, installDir, link)
+	setForTest(t, stateDir, t.TempDir())
+	if _, err := install(context.Background()); err == nil || !strings.Contains(err.Error(), "symbolic link") {
+		t.Errorf("install() through a symbolic link = %v, want it refused", err)
+	}
+	if _, err := os.Stat(filepath.Join(target, "data")); err != nil {
+		t.Errorf("install changed the link target: %v", err)
+	}
+}
+
+func TestUninstallRefusesSymlinkedLocation(t *testing.T) {
+	target, link := symlinkedInstall(t)
+	setForTest(t, installDir, link)
+	if _, err := uninstallOllama(context.Background()); err == nil || !strings.Contains(err.Error(), "symbolic link") {
+		t.Errorf("uninstallOllama() through a symbolic link = %v, want it refused", err)
+	}
+	if _, err := os.Stat(filepath.Join(target, "data")); err != nil {
+		t.Errorf("uninstall removed the link target's contents: %v", err)
+	}
+	if _, err := os.Lstat(link); err != nil {
+		t.Errorf("uninstall removed the link: %v", err)
+	}
+}
+
+func TestCommitStagedThroughSymlinkedParent(t *testing.T) {
+	target, link := symlinkedInstall(t)
+	dest := filepath.Join(link, "ollama")
+	staged, err := newStagingDirectory(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = commitStaged(staged, dest); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(filepath.Join(target, "ollama")); err != nil || !info.IsDir() {
+		t.Errorf("install through a linked parent is missing from the target: %v", err)
+	}
+}
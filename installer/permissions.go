@@ -0,0 +1,266 @@
+package main
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/rancher-sandbox/rd-open-webui-docker-ext/installer/types"
+)
+
+// Sources of the expected file modes when fixing permissions.
+const (
+	PermissionsSourceArchive  = "archive"  // Modes recorded in the cached install archives.
+	PermissionsSourceDefaults = "defaults" // Only the executable and directories are fixed.
+)
+
+// parseExecutableMode parses the mode given by -executable-mode, as an octal
+// permission such as 0700; the owner must be able to execute ollama.
+func parseExecutableMode(s string) (fs.FileMode, error) {
+	mode, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || mode > 0o777 {
+		return 0, fmt.Errorf("invalid executable mode %q: should be an octal permission such as 0755", s)
+	}
+	if mode&0o100 == 0 {
+		return 0, fmt.Errorf("invalid executable mode %04o: ollama would not be executable by its owner", mode)
+	}
+	return fs.FileMode(mode), nil
+}
+
+// resolveExecutableMode returns the mode the ollama executable should have,
+// given the mode it would otherwise have (such as that in the archive): the
+// mode set by -executable-mode if any, or else the given mode, made executable
+// by the owner.
+func resolveExecutableMode(mode fs.FileMode) fs.FileMode {
+	if executableMode != 0 {
+		return executableMode
+	}
+	return mode.Perm() | 0o100
+}
+
+// applyExecutableMode sets the mode of the ollama executable at the given path
+// according to resolveExecutableMode, and checks that it took effect.
+func applyExecutableMode(executablePath string) error {
+	info, err := os.Stat(executablePath)
+	if err != nil {
+		return fmt.Errorf("failed to change ollama file mode: %w", err)
+	}
+	mode := resolveExecutableMode(info.Mode())
+	if info.Mode().Perm() == mode {
+		return nil
+	}
+	if err = os.Chmod(executablePath, mode); err != nil {
+		return fmt.Errorf("failed to change ollama file mode: %w", err)
+	}
+	if info, err = os.Stat(executablePath); err != nil {
+		return fmt.Errorf("failed to change ollama file mode: %w", err)
+	} else if info.Mode().Perm()&0o100 == 0 {
+		return fmt.Errorf("failed to change ollama file mode: %s has mode %04o, which is not executable", executablePath, info.Mode().Perm())
+	}
+	return nil
+}
+
+// archiveModes reads the modes of the regular files and directories in the
+// given compressed tar archive, keyed by their path within the archive.
+func archiveModes(archivePath string, modes map[string]fs.FileMode) error {
+	archive, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+	decompressor, err := newDecompressor(archive)
+	if err != nil {
+		return err
+	}
+	defer decompressor.Close()
+	tarReader := tar.NewReader(decompressor)
+	for {
+		header, err := tarReader.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if header.Typeflag == tar.TypeReg || header.Typeflag == tar.TypeDir {
+			modes[filepath.Clean(filepath.FromSlash(header.Name))] = header.FileInfo().Mode().Perm()
+		}
+	}
+}
+
+// Reasons the archives of the install are not available, from
+// findInstalledArchives.
+var (
+	errArchivesNotCached = errors.New("the archives of the install are not cached; reinstall ollama instead")
+	errNotFromArchives   = errors.New("the install did not come from tar archives, so it cannot be checked against them; reinstall ollama instead")
+)
+
+// findInstalledArchives returns the paths of the (cached) tar archives of the
+// current install, or errArchivesNotCached if the cache was cleared, or
+// errNotFromArchives if the install did not come from tar archives (such as
+// the zip archive on Windows or the executable on macOS).
+func findInstalledArchives(ctx context.Context) ([]string, error) {
+	state, err := loadState(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(state.InstalledArchives) == 0 {
+		return nil, errArchivesNotCached
+	}
+	cacheDir, err := getCacheDirectory()
+	if err != nil {
+		return nil, err
+	}
+	var archives []string
+	for _, checksum := range state.InstalledArchives {
+		entries, err := filepath.Glob(filepath.Join(cacheDir, checksum, "*"))
+		if err != nil || len(entries) == 0 {
+			return nil, errArchivesNotCached
+		}
+		found := false
+		for _, entry := range entries {
+			if compressionForName(entry) != "" {
+				archives = append(archives, entry)
+				found = true
+			}
+		}
+		if !found {
+			return nil, errNotFromArchives
+		}
+	}
+	return archives, nil
+}
+
+// installedArchives returns the paths of the (cached) tar archives of the
+// current install.  Returns nil if they are not available, either because the
+// cache was cleared or because the install did not come from tar archives.
+func installedArchives(ctx context.Context) ([]string, error) {
+	archives, err := findInstalledArchives(ctx)
+	if errors.Is(err, errArchivesNotCached) || errors.Is(err, errNotFromArchives) {
+		return nil, nil
+	}
+	return archives, err
+}
+
+// installedArchiveModes returns the file modes recorded in the (cached) archives
+// of the current install, keyed by path relative to the install directory.
+// Returns nil if the archives are not available.
+func installedArchiveModes(ctx context.Context) (map[string]fs.FileMode, error) {
+	archives, err := installedArchives(ctx)
+	if err != nil || archives == nil {
+		return nil, err
+	}
+	modes := make(map[string]fs.FileMode)
+	for _, archivePath := range archives {
+		if err = archiveModes(archivePath, modes); err != nil {
+			log.Printf("Failed to read modes from %s: %s", archivePath, err)
+			return nil, nil
+		}
+	}
+	return modes, nil
+}
+
+// fixPermissions restores the modes of the files in the managed install, using
+// the cached install archives if available; otherwise, the executable and
+// directories are made accessible.  Nothing is downloaded.
+func fixPermissions(ctx context.Context) (*types.PermissionsResult, error) {
+	executablePath, err := findExecutable(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+	if executablePath == "" {
+		return nil, fmt.Errorf("failed to fix permissions: ollama is not installed")
+	}
+	installPath, err := getDefaultInstallLocation(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkInstallLocation(installPath); err != nil {
+		return nil, err
+	}
+	root := installPath
+	if info, err := os.Stat(installPath); err == nil && !info.IsDir() {
+		// On macOS, the install location is the executable itself.
+		root = filepath.Dir(installPath)
+	}
+	executableName, err := filepath.Rel(root, executablePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fix permissions: %w", err)
+	}
+
+	result := &types.PermissionsResult{SchemaVersion: types.SchemaVersion, Source: PermissionsSourceArchive, Changed: []types.PermissionChange{}}
+	modes, err := installedArchiveModes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if modes == nil {
+		result.Source = PermissionsSourceDefaults
+		modes = map[string]fs.FileMode{executableName: 0o755}
+		if root == installPath {
+			err = filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+				if err == nil && entry.IsDir() {
+					if name, err := filepath.Rel(root, path); err == nil && name != "." {
+						modes[name] = 0o755
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to fix permissions: %w", err)
+			}
+		}
+	}
+
+	if mode, ok := modes[executableName]; ok {
+		modes[executableName] = resolveExecutableMode(mode)
+	}
+
+	names := make([]string, 0, len(modes))
+	for name := range modes {
+		names = append(names, name)
+	}
+	// Sorting puts directories before their contents.
+	slices.Sort(names)
+	var changed []string
+	for _, name := range names {
+		if name == "." || strings.HasPrefix(name, "..") {
+			continue
+		}
+		path := filepath.Join(root, name)
+		info, err := os.Lstat(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to fix permissions of %s: %w", path, err)
+		}
+		if info.Mode()&fs.ModeSymlink != 0 || info.Mode().Perm() == modes[name] {
+			continue
+		}
+		if err = os.Chmod(path, modes[name]); err != nil {
+			return nil, fmt.Errorf("failed to fix permissions of %s: %w", path, err)
+		}
+		changed = append(changed, name)
+		log.Printf("Changed mode of %s from %04o to %04o", path, info.Mode().Perm(), modes[name])
+		result.Changed = append(result.Changed, types.PermissionChange{
+			Path:   path,
+			Before: fmt.Sprintf("%04o", info.Mode().Perm()),
+			After:  fmt.Sprintf("%04o", modes[name]),
+		})
+	}
+	// Changing the mode changes the change time, which repair would otherwise
+	// take to mean the files were modified.
+	if root == installPath {
+		if err = refreshInstalledFiles(ctx, installPath, changed); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strconv"
+)
+
+// Which process is answering on the ollama API port.
+const (
+	PortOwnerManaged  = "managed"  // The managed serve process.
+	PortOwnerExternal = "external" // Some other ollama, or another program entirely.
+	PortOwnerNone     = "none"     // Nothing is listening.
+	PortOwnerUnknown  = "unknown"  // Something is listening, but the process could not be determined.
+)
+
+// ollamaPort returns the TCP port the ollama API is served on.
+func ollamaPort() (int, error) {
+	u, err := url.Parse(ollamaURL)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(u.Port())
+}
+
+// sameExecutable returns whether the given process runs the given executable.
+func sameExecutable(pid int, executablePath string) bool {
+	if pid <= 0 || executablePath == "" {
+		return false
+	}
+	processPath, err := processExecutable(pid)
+	if err != nil {
+		return false
+	}
+	processInfo, err := os.Stat(processPath)
+	if err != nil {
+		return false
+	}
+	executableInfo, err := os.Stat(executablePath)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(processInfo, executableInfo)
+}
+
+// checkPortOwner determines which process is listening on the ollama API port,
+// and whether it is the managed serve process recorded in the installer state.
+// Returns the owner (one of the PortOwner constants), the pid of the listening
+// process if known, and the pid of the managed serve process if it is running.
+func checkPortOwner(ctx context.Context) (string, int, int, error) {
+	port, err := ollamaPort()
+	if err != nil {
+		return "", 0, 0, fmt.Errorf("failed to determine ollama port: %w", err)
+	}
+	state, err := loadState(ctx)
+	if err != nil {
+		return "", 0, 0, err
+	}
+	managedPID := 0
+	if state.Serve != nil && sameExecutable(state.Serve.PID, state.Serve.ExecutablePath) {
+		managedPID = state.Serve.PID
+	}
+	listenerPID, err := findPortListener(ctx, port)
+	if err != nil {
+		return "", 0, managedPID, err
+	}
+	switch {
+	case listenerPID == 0:
+		return PortOwnerNone, 0, managedPID, nil
+	case listenerPID < 0:
+		return PortOwnerUnknown, 0, managedPID, nil
+	case listenerPID == managedPID:
+		return PortOwnerManaged, listenerPID, managedPID, nil
+	}
+	// The serve process may have been started before its pid was recorded,
+	// such as by an older installer; fall back to comparing executables.
+	if managedPath, err := findExecutable(ctx, true); err == nil && sameExecutable(listenerPID, managedPath) {
+		return PortOwnerManaged, listenerPID, managedPID, nil
+	}
+	return PortOwnerExternal, listenerPID, managedPID, nil
+}
+
+// describePortOwner logs a summary of which process is answering on the ollama
+// API port, such as an external ollama shadowing the managed serve process.
+func describePortOwner(owner string, listenerPID, managedPID int) {
+	port, _ := ollamaPort()
+	switch owner {
+	case PortOwnerExternal:
+		managed := "the managed serve is not running"
+		if managedPID > 0 {
+			managed = fmt.Sprintf("the managed serve (pid %d) is idle", managedPID)
+		}
+		log.Printf("External process (pid %d) is answering on port %d; %s", listenerPID, port, managed)
+	case PortOwnerUnknown:
+		log.Printf("An unidentified process is answering on port %d", port)
+	case PortOwnerNone:
+		if managedPID > 0 {
+			log.Printf("Nothing is answering on port %d, but the managed serve (pid %d) is running", port, managedPID)
+		}
+	}
+}
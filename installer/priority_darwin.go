@@ -0,0 +1,30 @@
+package main
+
+import (
+	"log"
+	"os/exec"
+
+	"golang.org/x/sys/unix"
+)
+
+// Range of niceness the managed serve may be given; raising its priority
+// (negative values) requires elevated privileges.
+const (
+	minServeNice = -20
+	maxServeNice = 19
+)
+
+// startWithPriority starts cmd with the given niceness.  Niceness applies to
+// the whole process on macOS, so it is set once cmd has started; there is no
+// way to set the I/O priority of another process.
+func startWithPriority(cmd *exec.Cmd, nice int) error {
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	if nice != 0 {
+		if err := unix.Setpriority(unix.PRIO_PROCESS, cmd.Process.Pid, nice); err != nil {
+			log.Printf("Failed to set serve niceness %d: %s", nice, err)
+		}
+	}
+	return nil
+}
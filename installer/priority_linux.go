@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os/exec"
+	"runtime"
+
+	"golang.org/x/sys/unix"
+)
+
+// Range of niceness the managed serve may be given; raising its priority
+// (negative values) requires elevated privileges.
+const (
+	minServeNice = -20
+	maxServeNice = 19
+)
+
+// For ioprio_set(2): who to apply the priority to (one thread), and the
+// best-effort class, whose levels go from 0 (highest) to 7.
+const (
+	ioprioWhoProcess = 1
+	ioprioClassBE    = 2 << 13
+)
+
+// startWithPriority starts cmd with the given niceness, and a matching
+// best-effort I/O priority.  Both are per thread on Linux, and inherited by
+// processes a thread starts, so they are set on a thread of our own from which
+// cmd is started; as they cannot be restored without privileges, the thread
+// is then discarded by exiting its goroutine while still locked to it.
+func startWithPriority(cmd *exec.Cmd, nice int) error {
+	if nice == 0 {
+		return cmd.Start()
+	}
+	errs := make(chan error, 1)
+	go func() {
+		runtime.LockOSThread()
+		// A pid of 0 is the calling thread.
+		if err := unix.Setpriority(unix.PRIO_PROCESS, 0, nice); err != nil {
+			errs <- fmt.Errorf("failed to set serve niceness %d: %w", nice, err)
+			return
+		}
+		// As the kernel does when no I/O priority is set: levels 0 to 7
+		// map onto niceness -20 to 19.
+		ioprio := ioprioClassBE | (nice+20)/5
+		if _, _, errno := unix.Syscall(unix.SYS_IOPRIO_SET, ioprioWhoProcess, 0, uintptr(ioprio)); errno != 0 {
+			log.Printf("Failed to set serve I/O priority: %s", errno)
+		}
+		errs <- cmd.Start()
+	}()
+	return <-errs
+}
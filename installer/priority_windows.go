@@ -0,0 +1,34 @@
+package main
+
+import (
+	"os/exec"
+	"syscall"
+
+	"golang.org/x/sys/windows"
+)
+
+// Range of niceness the managed serve may be given; Windows priority classes
+// are coarser, and only lowering the priority is supported.
+const (
+	minServeNice = 0
+	maxServeNice = 19
+)
+
+// startWithPriority starts cmd with the priority class closest to the given
+// niceness: below normal up to 9, and idle beyond that.
+func startWithPriority(cmd *exec.Cmd, nice int) error {
+	var class uint32
+	switch {
+	case nice >= 10:
+		class = windows.IDLE_PRIORITY_CLASS
+	case nice > 0:
+		class = windows.BELOW_NORMAL_PRIORITY_CLASS
+	}
+	if class != 0 {
+		if cmd.SysProcAttr == nil {
+			cmd.SysProcAttr = &syscall.SysProcAttr{}
+		}
+		cmd.SysProcAttr.CreationFlags |= class
+	}
+	return cmd.Start()
+}
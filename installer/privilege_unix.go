@@ -0,0 +1,14 @@
+//go:build !windows
+
+package main
+
+import "os"
+
+// elevatedUserName describes the user with elevated privileges, for messages.
+const elevatedUserName = "root"
+
+// isElevated returns whether the installer is running with elevated
+// privileges, as needed to write to system-wide locations.
+func isElevated() bool {
+	return os.Geteuid() == 0
+}
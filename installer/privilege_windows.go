@@ -0,0 +1,12 @@
+package main
+
+import "golang.org/x/sys/windows"
+
+// elevatedUserName describes the user with elevated privileges, for messages.
+const elevatedUserName = "administrator"
+
+// isElevated returns whether the installer is running with elevated
+// privileges, as needed to write to system-wide locations.
+func isElevated() bool {
+	return windows.GetCurrentProcessToken().IsElevated()
+}
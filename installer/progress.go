@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rancher-sandbox/rd-open-webui-docker-ext/installer/types"
+)
+
+// Phases of an install, as reported in progress events.
+const (
+	PhaseDownload = "download"
+	PhaseExtract  = "extract"
+	PhasePull     = "pull"
+	PhaseUpload   = "upload"
+)
+
+// progressInterval is the minimum time between progress events for a phase.
+const progressInterval = 500 * time.Millisecond
+
+// progressCallback receives progress events; by default, they are either logged
+// or written to standard output as JSON lines, depending on -json-events.
+var progressCallback = emitProgress
+
+var progressOutput sync.Mutex
+
+// emitProgress reports a progress event to the user.
+func emitProgress(event types.ProgressEvent) {
+	if !*jsonEvents {
+		if event.Total > 0 {
+			log.Printf("%s: %d/%d bytes (%d%%) %s", event.Phase, event.Completed, event.Total, event.Percent, event.File)
+		} else {
+			log.Printf("%s: %d bytes %s", event.Phase, event.Completed, event.File)
+		}
+		return
+	}
+	progressOutput.Lock()
+	defer progressOutput.Unlock()
+	if err := json.NewEncoder(os.Stdout).Encode(event); err != nil {
+		log.Printf("Failed to write progress: %s", err)
+	}
+}
+
+// progressReporter tracks progress through a phase, limiting how often events
+// are emitted.
+type progressReporter struct {
+	event      types.ProgressEvent
+	lastReport time.Time
+}
+
+// newProgressReporter starts reporting progress for a phase; total is zero if
+// unknown.
+func newProgressReporter(phase string, total int64) *progressReporter {
+	return &progressReporter{event: types.ProgressEvent{Phase: phase, Total: total}}
+}
+
+// setFile records the file currently being processed.
+func (p *progressReporter) setFile(name string) {
+	p.event.File = name
+}
+
+// add records further progress, emitting an event if enough time has passed.
+// The total may be an estimate; it grows as needed, so that progress never
+// exceeds 100%.
+func (p *progressReporter) add(n int64) {
+	p.event.Completed += n
+	if p.event.Total > 0 && p.event.Completed > p.event.Total {
+		p.event.Total = p.event.Completed
+	}
+	if p.event.Total > 0 {
+		p.event.Percent = p.event.Completed * 100 / p.event.Total
+	}
+	if now := time.Now(); now.Sub(p.lastReport) >= progressInterval {
+		p.lastReport = now
+		progressCallback(p.event)
+	}
+}
+
+// done emits the final event for the phase.
+func (p *progressReporter) done() {
+	p.event.File = ""
+	p.event.Done = true
+	progressCallback(p.event)
+}
+
+// progressReader reports the bytes read through it.
+type progressReader struct {
+	io.Reader
+	reporter *progressReporter
+}
+
+func (r *progressReader) Read(buf []byte) (int, error) {
+	n, err := r.Reader.Read(buf)
+	r.reporter.add(int64(n))
+	return n, err
+}
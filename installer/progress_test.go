@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rancher-sandbox/rd-open-webui-docker-ext/installer/types"
+)
+
+func TestProgressReporterPercent(t *testing.T) {
+	var events []types.ProgressEvent
+	setForTest(t, &progressCallback, func(event types.ProgressEvent) { events = append(events, event) })
+	reporter := newProgressReporter(PhaseDownload, 200)
+	reporter.add(50)
+	reporter.lastReport = time.Time{}
+	reporter.add(250)
+	reporter.done()
+	if len(events) != 3 {
+		t.Fatalf("got %d events, want 3: %+v", len(events), events)
+	}
+	if events[0].Percent != 25 || events[0].Total != 200 {
+		t.Errorf("first event %+v, want 25%% of 200", events[0])
+	}
+	// More than the total was processed; the total grows to match.
+	if events[1].Percent != 100 || events[1].Total != 300 {
+		t.Errorf("second event %+v, want 100%% of 300", events[1])
+	}
+	if !events[2].Done || events[2].Percent != 100 {
+		t.Errorf("final event %+v, want done at 100%%", events[2])
+	}
+
+	events = nil
+	unknown := newProgressReporter(PhaseExtract, 0)
+	unknown.add(10)
+	if len(events) != 1 || events[0].Percent != 0 {
+		t.Errorf("event of unknown total %+v, want no percentage", events)
+	}
+}
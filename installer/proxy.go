@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sync/atomic"
+	"time"
+)
+
+// proxiedPaths are the ollama API endpoints forwarded by the proxy.
+var proxiedPaths = []string{"/api/generate", "/api/chat"}
+
+// proxyStatusPath is the proxy's own endpoint reporting the requests in flight.
+const proxyStatusPath = "/proxy/status"
+
+// proxyStatus is the response of the proxy status endpoint.
+type proxyStatus struct {
+	InFlight int64 `json:"inFlight"`
+}
+
+// maxProxyBodySize is the largest request body the proxy reads in order to fill
+// in the default model; chat requests may include images.
+const maxProxyBodySize = 64 << 20
+
+// proxyResponseWriter records the status and time of the first write of a
+// proxied response, for logging.
+type proxyResponseWriter struct {
+	http.ResponseWriter
+	status     int
+	firstWrite time.Time
+	written    int64
+}
+
+func (w *proxyResponseWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *proxyResponseWriter) Write(b []byte) (int, error) {
+	if w.firstWrite.IsZero() {
+		w.firstWrite = time.Now()
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.written += int64(n)
+	return n, err
+}
+
+// Unwrap allows http.ResponseController to flush the underlying writer.
+func (w *proxyResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// checkBearerToken reports whether the request presents the given token as a
+// bearer token; any request is accepted if the token is empty.
+func checkBearerToken(r *http.Request, token string) bool {
+	if token == "" {
+		return true
+	}
+	expected := "Bearer " + token
+	return subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte(expected)) == 1
+}
+
+// newProxyHandler returns a handler forwarding the generate and chat APIs to
+// the ollama server.  Streamed (NDJSON) responses are flushed as they arrive,
+// and the upstream request is cancelled if the client disconnects.  If -proxy-
+// token is set, clients must present it as a bearer token; it is not forwarded.
+// Requests that do not name a model use the default model, if one is set.
+func newProxyHandler() (http.Handler, error) {
+	target, err := url.Parse(ollamaURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse ollama URL: %w", err)
+	}
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	// Flush immediately, rather than buffering, so tokens stream to the UI.
+	proxy.FlushInterval = -1
+	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		if errors.Is(err, context.Canceled) {
+			// The client went away; there is no one to respond to.
+			return
+		}
+		log.Printf("proxy: path=%s error=%q", r.URL.Path, err)
+		w.WriteHeader(http.StatusBadGateway)
+	}
+
+	// inFlight counts the requests being proxied, so that restarting serve can
+	// wait for them to finish; see drainProxy.
+	var inFlight atomic.Int64
+	mux := http.NewServeMux()
+	mux.HandleFunc(proxyStatusPath, func(w http.ResponseWriter, r *http.Request) {
+		if !checkBearerToken(r, *proxyToken) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(proxyStatus{InFlight: inFlight.Load()})
+	})
+	for _, path := range proxiedPaths {
+		mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			if !checkBearerToken(r, *proxyToken) {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			r.Header.Del("Authorization")
+			if state, err := loadState(r.Context()); err != nil {
+				log.Printf("proxy: failed to read default model: %s", err)
+			} else if state.DefaultModel != "" {
+				body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxProxyBodySize))
+				if err != nil {
+					http.Error(w, "failed to read request", http.StatusBadRequest)
+					return
+				}
+				body = withDefaultModel(body, state.DefaultModel)
+				r.Body = io.NopCloser(bytes.NewReader(body))
+				r.ContentLength = int64(len(body))
+			}
+			start := time.Now()
+			writer := &proxyResponseWriter{ResponseWriter: w, status: http.StatusOK}
+			inFlight.Add(1)
+			proxy.ServeHTTP(writer, r)
+			inFlight.Add(-1)
+			firstByte := time.Duration(0)
+			if !writer.firstWrite.IsZero() {
+				firstByte = writer.firstWrite.Sub(start)
+			}
+			log.Printf("proxy: path=%s status=%d bytes=%d first_byte=%s duration=%s canceled=%t",
+				r.URL.Path, writer.status, writer.written, firstByte, time.Since(start), r.Context().Err() != nil)
+		})
+	}
+	return mux, nil
+}
+
+// runProxy serves the proxy on -listen until the context is cancelled.
+func runProxy(ctx context.Context) error {
+	handler, err := newProxyHandler()
+	if err != nil {
+		return err
+	}
+	server := &http.Server{Addr: *listenAddress, Handler: handler}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+	endpoint, err := resolveBackendEndpoint(ctx)
+	if err != nil {
+		return err
+	}
+	log.Printf("Proxying %v to %s ollama at %s on %s", proxiedPaths, endpoint.Backend, endpoint.URL, *listenAddress)
+	if err = server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("failed to run proxy: %w", err)
+	}
+	return nil
+}
+
+// drainProxy waits up to the given time for the requests in flight through the
+// proxy (if it is running) to finish, reporting whether they all did.  New
+// requests are not refused meanwhile; they are waited for too.
+func drainProxy(ctx context.Context, timeout time.Duration) (bool, error) {
+	deadline := time.Now().Add(timeout)
+	logged := false
+	for {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+*listenAddress+proxyStatusPath, nil)
+		if err != nil {
+			return false, fmt.Errorf("failed to check proxy: %w", err)
+		}
+		if *proxyToken != "" {
+			req.Header.Set("Authorization", "Bearer "+*proxyToken)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			if ctx.Err() != nil {
+				return false, fmt.Errorf("failed to check proxy: %w", ctx.Err())
+			}
+			// The proxy is not running, so nothing is in flight through it.
+			return true, nil
+		}
+		var status proxyStatus
+		err = json.NewDecoder(resp.Body).Decode(&status)
+		resp.Body.Close()
+		if resp.StatusCode >= 300 || err != nil {
+			log.Printf("Could not check requests in flight through the proxy (status %s): %v", resp.Status, err)
+			return false, nil
+		}
+		if status.InFlight == 0 {
+			return true, nil
+		}
+		if time.Now().After(deadline) {
+			log.Printf("Warning: %d requests still in flight after %s", status.InFlight, timeout)
+			return false, nil
+		}
+		if !logged {
+			log.Printf("Waiting for %d requests in flight to finish...", status.InFlight)
+			logged = true
+		}
+		select {
+		case <-ctx.Done():
+			return false, fmt.Errorf("failed to drain proxy: %w", ctx.Err())
+		case <-time.After(time.Second):
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rancher-sandbox/rd-open-webui-docker-ext/installer/types"
+)
+
+// pruneGracePeriod is how old an unreferenced blob must be before it is pruned.
+// Both ollama pulls and our imports write blobs before the manifest that
+// references them, so a recent blob may belong to an operation in progress.
+const pruneGracePeriod = time.Hour
+
+// walkManifests calls fn with the path (relative to the models directory, using
+// forward slashes) and contents of every manifest in the models directory.
+// Any manifest that cannot be read is an error.
+func walkManifests(ctx context.Context, modelsDir string, fn func(manifestPath string, manifest *modelManifest) error) error {
+	manifestsDir := filepath.Join(modelsDir, "manifests")
+	err := filepath.WalkDir(manifestsDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		contents, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var manifest modelManifest
+		if err = json.Unmarshal(contents, &manifest); err != nil {
+			return fmt.Errorf("error unmarshaling %s: %w", path, err)
+		}
+		relPath, err := filepath.Rel(modelsDir, path)
+		if err != nil {
+			return err
+		}
+		return fn(filepath.ToSlash(relPath), &manifest)
+	})
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to read model manifests: %w", err)
+	}
+	return nil
+}
+
+// referencedBlobs returns the digests referenced by every manifest in the
+// models directory.  Any manifest that cannot be read is an error, as we could
+// otherwise consider its blobs unreferenced.
+func referencedBlobs(ctx context.Context, modelsDir string) (map[string]bool, error) {
+	referenced := make(map[string]bool)
+	err := walkManifests(ctx, modelsDir, func(_ string, manifest *modelManifest) error {
+		for _, digest := range manifest.digests() {
+			referenced[digest] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return referenced, nil
+}
+
+// pruneBlobs removes blobs in the models directory that no manifest references.
+// Only complete blobs (named after their digest) older than the grace period
+// are considered; partial downloads are left for ollama to manage.  If dryRun
+// is set, the blobs are reported but not removed.
+func pruneBlobs(ctx context.Context, dryRun bool) (*types.PruneResult, error) {
+	modelsDir, err := getModelsDirectory(ctx)
+	if err != nil {
+		return nil, err
+	}
+	referenced, err := referencedBlobs(ctx, modelsDir)
+	if err != nil {
+		return nil, err
+	}
+	result := &types.PruneResult{SchemaVersion: types.SchemaVersion, DryRun: dryRun, Removed: []types.PrunedBlob{}}
+	blobsDir := filepath.Join(modelsDir, "blobs")
+	entries, err := os.ReadDir(blobsDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return result, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to list blobs: %w", err)
+	}
+	for _, entry := range entries {
+		hash, ok := strings.CutPrefix(entry.Name(), "sha256-")
+		if !ok || !entry.Type().IsRegular() {
+			continue
+		}
+		digest := "sha256:" + hash
+		if _, err := blobPath(digest); err != nil {
+			// Not a complete blob, such as a partial download.
+			continue
+		}
+		if referenced[digest] {
+			continue
+		}
+		info, err := entry.Info()
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to check blob %s: %w", entry.Name(), err)
+		}
+		if time.Since(info.ModTime()) < pruneGracePeriod {
+			log.Printf("Keeping unreferenced blob %s, which may be in use", entry.Name())
+			continue
+		}
+		if !dryRun {
+			if err = os.Remove(filepath.Join(blobsDir, entry.Name())); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return nil, fmt.Errorf("failed to remove blob %s: %w", entry.Name(), err)
+			}
+			log.Printf("Removed unreferenced blob %s", entry.Name())
+		}
+		result.Removed = append(result.Removed, types.PrunedBlob{Digest: digest, Size: info.Size()})
+		result.ReclaimedBytes += info.Size()
+	}
+	return result, nil
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rancher-sandbox/rd-open-webui-docker-ext/installer/types"
+)
+
+// pullProgress is a single progress update from the ollama pull API.
+type pullProgress struct {
+	Status    string `json:"status"`
+	Digest    string `json:"digest,omitempty"`
+	Total     int64  `json:"total,omitempty"`
+	Completed int64  `json:"completed,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// normalizeModelName adds the default tag to the model name if it has none, so
+// that "llama3" and "llama3:latest" refer to the same pull.
+func normalizeModelName(name string) string {
+	if !strings.Contains(name[strings.LastIndex(name, "/")+1:], ":") {
+		return name + ":latest"
+	}
+	return name
+}
+
+// pullModel pulls the given model via the running ollama server, calling
+// progress for each update received.  Cancelling the context closes the
+// connection, which aborts the pull; ollama keeps any partially downloaded
+// blobs so a later pull can resume.
+func pullModel(ctx context.Context, name string, progress func(pullProgress)) error {
+	body, err := json.Marshal(map[string]any{"model": name, "stream": true})
+	if err != nil {
+		return fmt.Errorf("failed to pull %s: %w", name, err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ollamaURL+"/api/pull", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to pull %s: %w", name, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to pull %s: %w", name, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("failed to pull %s: unexpected status %s", name, resp.Status)
+	}
+
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var update pullProgress
+		if err = decoder.Decode(&update); err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return fmt.Errorf("pull of %s aborted: %w", name, ctxErr)
+			}
+			return fmt.Errorf("failed to pull %s: error reading response: %w", name, err)
+		}
+		if update.Error != "" {
+			return fmt.Errorf("failed to pull %s: %s", name, update.Error)
+		}
+		if progress != nil {
+			progress(update)
+		}
+		if update.Status == "success" {
+			return nil
+		}
+	}
+}
+
+// getPullFile returns the path of the file recording the pid of the process
+// pulling the given model.
+func getPullFile(ctx context.Context, name string) (string, error) {
+	stateDir, err := getStateDirectory(ctx)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(stateDir, "pulls", url.QueryEscape(normalizeModelName(name))+".pid"), nil
+}
+
+// pullProgressInterval is how often the progress of a pull is persisted.
+const pullProgressInterval = time.Second
+
+// pullCancelInterval is how often a running pull checks if it was cancelled.
+const pullCancelInterval = time.Second
+
+// pullRecord is the persisted progress of a pull, kept until it succeeds so that
+// an interrupted pull can be reported and resumed.
+type pullRecord struct {
+	Model     string    `json:"model"`
+	Status    string    `json:"status"`
+	Completed int64     `json:"completed"` // Bytes downloaded, over all layers.
+	Total     int64     `json:"total"`     // Bytes to download, over all layers seen so far.
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// getPullProgressFile returns the path of the file recording the progress of
+// pulling the given model.
+func getPullProgressFile(ctx context.Context, name string) (string, error) {
+	pullFile, err := getPullFile(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(pullFile, ".pid") + ".json", nil
+}
+
+// writePullRecord persists the progress of a pull, replacing the file so that
+// readers never see a partial record.
+func writePullRecord(path string, record *pullRecord) error {
+	contents, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	tempPath := path + ".tmp"
+	if err = os.WriteFile(tempPath, contents, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tempPath, path)
+}
+
+// listPulls returns the pulls that have not completed, whether in progress or
+// interrupted.
+func listPulls(ctx context.Context) ([]types.PullState, error) {
+	stateDir, err := getStateDirectory(ctx)
+	if err != nil {
+		return nil, err
+	}
+	progressFiles, err := filepath.Glob(filepath.Join(stateDir, "pulls", "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pulls: %w", err)
+	}
+	pulls := []types.PullState{}
+	for _, progressFile := range progressFiles {
+		contents, err := os.ReadFile(progressFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list pulls: %w", err)
+		}
+		var record pullRecord
+		if err = json.Unmarshal(contents, &record); err != nil || record.Model == "" {
+			log.Printf("Ignoring invalid pull record %s", progressFile)
+			continue
+		}
+		pull := types.PullState{
+			Model:     record.Model,
+			Status:    record.Status,
+			Completed: record.Completed,
+			Total:     record.Total,
+			UpdatedAt: record.UpdatedAt,
+		}
+		if record.Total > 0 {
+			pull.Percent = float64(record.Completed) * 100 / float64(record.Total)
+		}
+		_, err = os.Stat(strings.TrimSuffix(progressFile, ".json") + ".pid")
+		pull.InProgress = err == nil
+		pulls = append(pulls, pull)
+	}
+	return pulls, nil
+}
+
+// Print the pulls that have not completed as JSON.
+func printPulls(ctx context.Context) error {
+	pulls, err := listPulls(ctx)
+	if err != nil {
+		return err
+	}
+	return printJSON(types.PullList{SchemaVersion: types.SchemaVersion, Pulls: pulls})
+}
+
+// resumePulls restarts every interrupted pull; ollama keeps the blobs already
+// downloaded, so each continues where it left off.
+func resumePulls(ctx context.Context) error {
+	pulls, err := listPulls(ctx)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, pull := range pulls {
+		if pull.InProgress {
+			continue
+		}
+		if err = runPull(ctx, pull.Model); err != nil {
+			if ctx.Err() != nil {
+				return err
+			}
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// recordPull records that this process is pulling (or about to pull) the given
+// model, so that the pull can be aborted via cancelPull.
+func recordPull(ctx context.Context, name string) (string, error) {
+	pullFile, err := getPullFile(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	if err = os.MkdirAll(filepath.Dir(pullFile), 0o755); err != nil {
+		return "", fmt.Errorf("failed to create state directory: %w", err)
+	}
+	if err = os.WriteFile(pullFile, []byte(strconv.Itoa(os.Getpid())), 0o644); err != nil {
+		return "", fmt.Errorf("failed to record pull of %s: %w", name, err)
+	}
+	return pullFile, nil
+}
+
+// watchPullFile calls cancel once the given pull file is removed by cancelPull,
+// until the context is done.
+func watchPullFile(ctx context.Context, pullFile string, cancel context.CancelFunc) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(pullCancelInterval):
+		}
+		if _, err := os.Stat(pullFile); errors.Is(err, os.ErrNotExist) {
+			cancel()
+			return
+		}
+	}
+}
+
+// runPull pulls the given model, logging progress.  While the pull is running,
+// its pid is recorded so that it can be aborted via cancelPull, and its progress
+// is persisted until it succeeds; running it again after an interruption or
+// cancellation resumes the pull.
+func runPull(ctx context.Context, name string) error {
+	name = normalizeModelName(name)
+	pullFile, err := recordPull(ctx, name)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(pullFile)
+	// Other pulls in this process continue when this one is cancelled.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go watchPullFile(ctx, pullFile, cancel)
+
+	progressFile, err := getPullProgressFile(ctx, name)
+	if err != nil {
+		return err
+	}
+	warnIfModelDoesNotFit(ctx, name)
+	record := &pullRecord{Model: name}
+	if contents, err := os.ReadFile(progressFile); err == nil && json.Unmarshal(contents, record) == nil && record.Total > 0 {
+		log.Printf("Resuming pull of %s (%d%%)...", name, record.Completed*100/record.Total)
+	} else {
+		log.Printf("Pulling %s...", name)
+	}
+	record.Model = name
+	// Progress is reported per layer; sum them for the overall progress.
+	layerTotals := make(map[string]int64)
+	layerCompleted := make(map[string]int64)
+	lastStatus := ""
+	var lastSaved time.Time
+	reporter := newProgressReporter(PhasePull, record.Total)
+	reporter.setFile(name)
+	err = pullModel(ctx, name, func(update pullProgress) {
+		if update.Total == 0 && update.Status != lastStatus {
+			log.Printf("%s", update.Status)
+		}
+		lastStatus = update.Status
+		if update.Digest != "" && update.Total > 0 {
+			layerTotals[update.Digest] = update.Total
+			layerCompleted[update.Digest] = update.Completed
+			record.Total, record.Completed = 0, 0
+			for digest, total := range layerTotals {
+				record.Total += total
+				record.Completed += layerCompleted[digest]
+			}
+			reporter.event.Total = record.Total
+			reporter.add(record.Completed - reporter.event.Completed)
+		}
+		record.Status = update.Status
+		if time.Since(lastSaved) >= pullProgressInterval {
+			record.UpdatedAt = time.Now()
+			if err := writePullRecord(progressFile, record); err != nil {
+				log.Printf("Failed to record progress of %s: %s", name, err)
+			}
+			lastSaved = record.UpdatedAt
+		}
+	})
+	if err != nil {
+		// Keep the record (with the latest progress) so the pull can resume.
+		record.UpdatedAt = time.Now()
+		if recordErr := writePullRecord(progressFile, record); recordErr != nil {
+			log.Printf("Failed to record progress of %s: %s", name, recordErr)
+		}
+		return err
+	}
+	reporter.done()
+	if err = os.Remove(progressFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("Failed to remove pull record of %s: %s", name, err)
+	}
+	return nil
+}
+
+// cancelPull aborts an in-progress (or queued) pull of the given model, if any,
+// by removing its pull file; the process pulling it notices within
+// pullCancelInterval, and aborts only that pull.
+func cancelPull(ctx context.Context, name string) error {
+	name = normalizeModelName(name)
+	pullFile, err := getPullFile(ctx, name)
+	if err != nil {
+		return err
+	}
+	contents, err := os.ReadFile(pullFile)
+	if errors.Is(err, os.ErrNotExist) {
+		log.Printf("No pull of %s is in progress.", name)
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to read pull state for %s: %w", name, err)
+	}
+	if err = os.Remove(pullFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to cancel pull of %s: %w", name, err)
+	}
+	log.Printf("Cancelled pull of %s (pid %s).", name, strings.TrimSpace(string(contents)))
+	return nil
+}
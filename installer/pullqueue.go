@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/rancher-sandbox/rd-open-webui-docker-ext/installer/types"
+)
+
+// States of a queued pull.
+const (
+	PullStateQueued    = "queued"
+	PullStateRunning   = "running"
+	PullStateSucceeded = "succeeded"
+	PullStateFailed    = "failed"
+	PullStateCancelled = "cancelled"
+)
+
+// pullJob is a pull requested of a pullQueue.
+type pullJob struct {
+	model string
+	state string
+	err   error
+}
+
+// pullQueue runs model pulls in the order they were requested, up to a fixed
+// number at a time; the rest wait their turn.  Each pull runs independently, so
+// cancelling (or failing) one does not affect the others.
+type pullQueue struct {
+	ctx         context.Context
+	concurrency int
+
+	mu      sync.Mutex
+	jobs    []*pullJob // In the order requested, including finished ones.
+	running int
+	wg      sync.WaitGroup
+}
+
+// newPullQueue returns a queue running pulls with the given context, up to
+// concurrency at a time.
+func newPullQueue(ctx context.Context, concurrency int) *pullQueue {
+	return &pullQueue{ctx: ctx, concurrency: max(concurrency, 1)}
+}
+
+// add queues a pull of the given model, unless one is already queued or
+// running.  The pull is recorded straight away, so that it can be cancelled
+// (with cancelPull) before its turn comes.
+func (q *pullQueue) add(model string) error {
+	model = normalizeModelName(model)
+	if _, err := modelManifestPath(model); err != nil {
+		return fmt.Errorf("failed to pull %s: %w", model, err)
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i, job := range q.jobs {
+		if job.model != model {
+			continue
+		}
+		if job.state == PullStateQueued || job.state == PullStateRunning {
+			return nil
+		}
+		// Requeue it at the end.
+		q.jobs = append(q.jobs[:i], q.jobs[i+1:]...)
+		break
+	}
+	if _, err := recordPull(q.ctx, model); err != nil {
+		return err
+	}
+	q.jobs = append(q.jobs, &pullJob{model: model, state: PullStateQueued})
+	q.wg.Add(1)
+	q.dispatch()
+	return nil
+}
+
+// dispatch starts queued pulls while there is room; q.mu must be held.
+func (q *pullQueue) dispatch() {
+	for _, job := range q.jobs {
+		if q.running >= q.concurrency {
+			return
+		}
+		if job.state != PullStateQueued {
+			continue
+		}
+		pullFile, err := getPullFile(q.ctx, job.model)
+		if err == nil {
+			if ctxErr := q.ctx.Err(); ctxErr != nil {
+				_ = os.Remove(pullFile)
+				err = ctxErr
+			} else if _, err = os.Stat(pullFile); errors.Is(err, os.ErrNotExist) {
+				err = context.Canceled
+			}
+		}
+		if err != nil {
+			log.Printf("Pull of %s was cancelled before it started", job.model)
+			job.state, job.err = PullStateCancelled, fmt.Errorf("failed to pull %s: %w", job.model, err)
+			q.wg.Done()
+			continue
+		}
+		job.state = PullStateRunning
+		q.running++
+		go q.run(job)
+	}
+}
+
+// run pulls the model of the given job, then starts the next queued pull.
+func (q *pullQueue) run(job *pullJob) {
+	defer q.wg.Done()
+	err := runPull(q.ctx, job.model)
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.running--
+	switch {
+	case err == nil:
+		job.state = PullStateSucceeded
+	case errors.Is(err, context.Canceled):
+		job.state, job.err = PullStateCancelled, err
+	default:
+		job.state, job.err = PullStateFailed, err
+		log.Printf("%s", err)
+	}
+	q.dispatch()
+}
+
+// cancel cancels the pull of the given model, whether queued or running.
+func (q *pullQueue) cancel(model string) error {
+	return cancelPull(q.ctx, model)
+}
+
+// wait waits for every queued pull to finish, returning the errors of those
+// that failed.
+func (q *pullQueue) wait() error {
+	q.wg.Wait()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var errs []error
+	for _, job := range q.jobs {
+		if job.state == PullStateFailed || job.state == PullStateCancelled {
+			errs = append(errs, job.err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// status reports the pulls requested, with the progress of those running.
+func (q *pullQueue) status() types.PullQueue {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	result := types.PullQueue{SchemaVersion: types.SchemaVersion, Pulls: []types.QueuedPull{}}
+	for _, job := range q.jobs {
+		pull := types.QueuedPull{Model: job.model, State: job.state}
+		if job.err != nil {
+			pull.Error = job.err.Error()
+		}
+		if job.state == PullStateRunning {
+			if progressFile, err := getPullProgressFile(q.ctx, job.model); err == nil {
+				var record pullRecord
+				if contents, err := os.ReadFile(progressFile); err == nil && json.Unmarshal(contents, &record) == nil {
+					pull.Completed, pull.Total = record.Completed, record.Total
+				}
+			}
+		}
+		result.Pulls = append(result.Pulls, pull)
+	}
+	return result
+}
+
+// runPulls pulls the given comma-separated models, up to -pull-concurrency at a
+// time, returning once all have finished.
+func runPulls(ctx context.Context, models string) error {
+	var names []string
+	for _, model := range strings.Split(models, ",") {
+		if model = strings.TrimSpace(model); model == "" {
+			continue
+		}
+		// Check every name up front, so none are queued if any is invalid.
+		if _, err := modelManifestPath(normalizeModelName(model)); err != nil {
+			return fmt.Errorf("failed to pull %s: %w", model, err)
+		}
+		names = append(names, model)
+	}
+	queue := newPullQueue(ctx, *pullConcurrency)
+	for _, model := range names {
+		if err := queue.add(model); err != nil {
+			return errors.Join(err, queue.wait())
+		}
+	}
+	return queue.wait()
+}
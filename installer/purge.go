@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/rancher-sandbox/rd-open-webui-docker-ext/installer/types"
+)
+
+// removePurgedDirectory removes the given directory for -purge, recording it
+// in the result if it existed.  As with the install location, symbolic links,
+// filesystem roots and the home directory are refused.
+func removePurgedDirectory(result *types.UninstallResult, dir, description string) error {
+	if dir == filepath.Dir(dir) {
+		return fmt.Errorf("refusing to remove filesystem root %s as the %s", dir, description)
+	}
+	if homeDir, err := os.UserHomeDir(); err == nil && filepath.Clean(homeDir) == dir {
+		return fmt.Errorf("refusing to remove home directory %s as the %s", dir, description)
+	}
+	info, err := os.Lstat(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to check %s %s: %w", description, dir, err)
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		return fmt.Errorf("refusing to remove %s %s, which is a symbolic link", description, dir)
+	}
+	if err = os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("failed to remove %s %s: %w", description, dir, err)
+	}
+	log.Printf("Removed %s %s", description, dir)
+	result.Purged = append(result.Purged, dir)
+	return nil
+}
+
+// purgeInstallerData removes what the installer leaves behind after ollama
+// itself has been uninstalled: cached archives, then the state directory
+// (including the serve log and any pulls in progress).  The models directory
+// is only removed if includeModels is set, as it may hold models pulled with
+// another install of ollama.  Everything removed is recorded in the result.
+func purgeInstallerData(ctx context.Context, result *types.UninstallResult, includeModels bool) error {
+	// Find the models directory before removing the state it may be recorded
+	// in.
+	var modelsDir string
+	if includeModels {
+		var err error
+		if modelsDir, err = getModelsDirectory(ctx); err != nil {
+			return err
+		}
+	}
+	cacheDir, err := getCacheDirectory()
+	if err != nil {
+		return err
+	}
+	cleared, err := clearCache(ctx, false)
+	if err != nil {
+		return err
+	}
+	for _, entry := range cleared.Removed {
+		log.Printf("Removed cached %s (%s)", entry.Asset, entry.Release)
+		result.Purged = append(result.Purged, filepath.Join(cacheDir, entry.Checksum))
+	}
+	stateDir, err := getStateDirectory(ctx)
+	if err != nil {
+		return err
+	}
+	if err = removePurgedDirectory(result, stateDir, "state directory"); err != nil {
+		return err
+	}
+	if includeModels {
+		if err = removePurgedDirectory(result, modelsDir, "models directory"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rancher-sandbox/rd-open-webui-docker-ext/installer/types"
+)
+
+// Problems found with the files of an install.
+const (
+	FileProblemMissing  = "missing"  // The file does not exist.
+	FileProblemCorrupt  = "corrupt"  // The contents were damaged, rather than written.
+	FileProblemModified = "modified" // The file was written since it was installed.
+)
+
+// Results of comparing an installed file with its archive entry.
+const (
+	contentsSame      = iota
+	contentsDamaged   // Truncated at a block boundary, or with blocks zeroed.
+	contentsDifferent // Otherwise different.
+)
+
+// contentsBlockSize is the size of the blocks compareContents compares; a
+// crash or failing disk loses whole filesystem blocks, which are at least this
+// large and aligned to it.
+const contentsBlockSize = 4096
+
+// compareContents compares the contents of an installed file with those of its
+// archive entry.  Damage typical of a crash or failing disk, which no edit
+// would make, is told apart from other differences.
+func compareContents(installed, entry io.Reader) (int, error) {
+	result := contentsSame
+	installedBlock := make([]byte, contentsBlockSize)
+	entryBlock := make([]byte, contentsBlockSize)
+	for {
+		n, err := io.ReadFull(entry, entryBlock)
+		if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return 0, err
+		}
+		m, err := io.ReadFull(installed, installedBlock[:n])
+		if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return 0, err
+		}
+		if m < n {
+			// Truncated; unless at a block boundary, it was edited.
+			if m > 0 {
+				return contentsDifferent, nil
+			}
+			return contentsDamaged, nil
+		}
+		if !bytes.Equal(installedBlock[:n], entryBlock[:n]) {
+			if !isZeroed(installedBlock[:n]) {
+				return contentsDifferent, nil
+			}
+			result = contentsDamaged
+		}
+		if n < contentsBlockSize {
+			// The end of the entry; anything more was added.
+			if extra, _ := io.ReadFull(installed, installedBlock[:1]); extra > 0 {
+				return contentsDifferent, nil
+			}
+			return result, nil
+		}
+	}
+}
+
+// isZeroed reports whether the given block is all zeros.
+func isZeroed(block []byte) bool {
+	for _, b := range block {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// checkInstalledFile compares the installed copy of an archive entry with the
+// entry, read from r, returning the problem if they differ.  The user may
+// restore the modification time of a file they edited (such as with cp -p), so
+// that cannot tell a modified file from a corrupt one.  Instead, a file with
+// damage no edit would make, or whose change time (which cannot be set) is the
+// one recorded when the installer last wrote it, is corrupt; any other
+// difference was written since, and is taken to be a modification.  The
+// recorded change time is zero if unknown, such as for older installs.
+func checkInstalledFile(filePath string, r io.Reader, recorded time.Time) (string, error) {
+	info, err := os.Lstat(filePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return FileProblemMissing, nil
+	} else if err != nil {
+		return "", err
+	}
+	if !info.Mode().IsRegular() {
+		return FileProblemModified, nil
+	}
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	contents, err := compareContents(file, r)
+	if err != nil {
+		return "", err
+	}
+	switch contents {
+	case contentsSame:
+		return "", nil
+	case contentsDamaged:
+		return FileProblemCorrupt, nil
+	}
+	if !recorded.IsZero() {
+		if changed, err := fileChangeTime(filePath); err == nil && changed.Equal(recorded) {
+			return FileProblemCorrupt, nil
+		}
+	}
+	return FileProblemModified, nil
+}
+
+// recordInstalledFiles records the change times of the regular files of the
+// install the installer just wrote, for checkInstalledFile.
+func recordInstalledFiles(ctx context.Context, installPath string) error {
+	changed := make(map[string]time.Time)
+	err := filepath.WalkDir(installPath, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.Type().IsRegular() {
+			return err
+		}
+		name, err := filepath.Rel(installPath, path)
+		if err != nil {
+			return err
+		}
+		if changed[name], err = fileChangeTime(path); err != nil {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record installed files: %w", err)
+	}
+	return updateState(ctx, func(state *installerState) error {
+		state.InstalledFiles = changed
+		return nil
+	})
+}
+
+// refreshInstalledFiles updates the recorded change times of the given regular
+// files of the install (by path relative to it), after the installer wrote them
+// or changed their modes.
+func refreshInstalledFiles(ctx context.Context, installPath string, names []string) error {
+	if len(names) == 0 {
+		return nil
+	}
+	return updateState(ctx, func(state *installerState) error {
+		if state.InstalledFiles == nil {
+			state.InstalledFiles = make(map[string]time.Time)
+		}
+		for _, name := range names {
+			path := filepath.Join(installPath, name)
+			if info, err := os.Lstat(path); err != nil || !info.Mode().IsRegular() {
+				continue
+			}
+			if changed, err := fileChangeTime(path); err == nil {
+				state.InstalledFiles[name] = changed
+			}
+		}
+		return nil
+	})
+}
+
+// restoreInstalledFile replaces the installed copy of an archive entry with the
+// contents read from r.  The file is written to a new file next to its final
+// location, so that the replacement is atomic and a failure leaves the
+// existing file.
+func restoreInstalledFile(budget *archiveBudget, filePath string, header *tar.Header, r io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(filePath), 0o755); err != nil {
+		return err
+	}
+	tempFile, err := os.CreateTemp(filepath.Dir(filePath), "."+filepath.Base(filePath)+".*.repair")
+	if err != nil {
+		return err
+	}
+	tempPath := tempFile.Name()
+	tempFile.Close()
+	if _, err = writeArchiveEntry(budget, header.Name, tempPath, header.FileInfo().Mode(), r, header.Size); err != nil {
+		return err
+	}
+	if err = os.Chmod(tempPath, header.FileInfo().Mode().Perm()); err != nil {
+		_ = os.Remove(tempPath)
+		return err
+	}
+	if err = setModTime(header.Name, tempPath, header.ModTime); err != nil {
+		_ = os.Remove(tempPath)
+		return err
+	}
+	if err = os.Rename(tempPath, filePath); err != nil {
+		_ = os.Remove(tempPath)
+		return err
+	}
+	return nil
+}
+
+// walkArchiveFiles calls fn with each regular file in the given compressed tar
+// archive that would be extracted, along with its (cleaned, local) path.
+func walkArchiveFiles(ctx context.Context, archivePath string, fn func(name string, header *tar.Header, r io.Reader) error) error {
+	archive, err := os.Open(archivePath)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", archivePath, err)
+	}
+	defer archive.Close()
+	decompressor, err := newDecompressor(archive)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", archivePath, err)
+	}
+	defer decompressor.Close()
+	tarReader := tar.NewReader(decompressor)
+	for {
+		header, err := tarReader.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed to read %s: %w", archivePath, err)
+		}
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		name := filepath.Clean(filepath.FromSlash(header.Name))
+		if header.Typeflag != tar.TypeReg || !filepath.IsLocal(name) {
+			continue
+		}
+		if err = fn(name, header, tarReader); err != nil {
+			return err
+		}
+	}
+}
+
+// repairFiles checks the regular files of the managed install against the
+// cached archives it was installed from, restoring those that are missing or
+// corrupt.  Files the user modified are preserved, unless -overwrite-modified
+// is set.  If dryRun is set, problems are only reported.  As with links,
+// nothing is downloaded; if the archives are no longer cached (or the install
+// did not come from tar archives), the install must be reinstalled instead.
+func repairFiles(ctx context.Context, dryRun bool) (*types.FileRepairResult, error) {
+	installPath, err := getDefaultInstallLocation(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkInstallLocation(installPath); err != nil {
+		return nil, err
+	}
+	if info, err := os.Stat(installPath); err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("failed to repair files: ollama is not installed at %s", installPath)
+	}
+	archives, err := findInstalledArchives(ctx)
+	if errors.Is(err, errArchivesNotCached) || errors.Is(err, errNotFromArchives) {
+		return nil, fmt.Errorf("failed to repair files: %w", err)
+	} else if err != nil {
+		return nil, err
+	}
+	state, err := loadState(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &types.FileRepairResult{
+		SchemaVersion: types.SchemaVersion,
+		DryRun:        dryRun,
+		Restored:      []types.FileRepair{},
+		Preserved:     []types.FileRepair{},
+	}
+	// Later archives are extracted over earlier ones, so they are checked
+	// first, and files they contain are skipped in earlier ones.
+	seen := make(map[string]bool)
+	for i := len(archives) - 1; i >= 0; i-- {
+		if err = repairArchiveFiles(ctx, archives[i], installPath, dryRun, state.InstalledFiles, seen, result); err != nil {
+			return nil, err
+		}
+	}
+	if !dryRun {
+		restored := make([]string, 0, len(result.Restored))
+		for _, repair := range result.Restored {
+			if name, err := filepath.Rel(installPath, repair.Path); err == nil {
+				restored = append(restored, name)
+			}
+		}
+		if err = refreshInstalledFiles(ctx, installPath, restored); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// repairArchiveFiles checks the installed copies of the regular files in the
+// given archive, adding those with problems to the result, then (unless dryRun
+// is set) restores them with a second pass over the archive, rather than
+// extracting the whole install again.  The change times recorded for the
+// files are in installed.  Files in seen are skipped, and those checked are
+// added to it.
+func repairArchiveFiles(ctx context.Context, archivePath, installPath string, dryRun bool, installed map[string]time.Time, seen map[string]bool, result *types.FileRepairResult) error {
+	restore := make(map[string]string) // Problem, by name.
+	err := walkArchiveFiles(ctx, archivePath, func(name string, header *tar.Header, r io.Reader) error {
+		if seen[name] {
+			return nil
+		}
+		seen[name] = true
+		result.Checked++
+		filePath := filepath.Join(installPath, name)
+		problem, err := checkInstalledFile(filePath, r, installed[name])
+		if err != nil {
+			return fmt.Errorf("failed to check %s: %w", filePath, err)
+		}
+		if problem == FileProblemModified && !*overwriteModified {
+			log.Printf("Preserving %s, which was modified since it was installed", filePath)
+			result.Preserved = append(result.Preserved, types.FileRepair{Path: filePath, Problem: problem})
+		} else if problem != "" && dryRun {
+			result.Restored = append(result.Restored, types.FileRepair{Path: filePath, Problem: problem})
+		} else if problem != "" {
+			restore[name] = problem
+		}
+		return nil
+	})
+	if err != nil || len(restore) == 0 {
+		return err
+	}
+
+	budget := newArchiveBudget()
+	return walkArchiveFiles(ctx, archivePath, func(name string, header *tar.Header, r io.Reader) error {
+		problem, ok := restore[name]
+		if !ok {
+			return nil
+		}
+		// Only restore the first entry of each name, as was checked.
+		delete(restore, name)
+		filePath := filepath.Join(installPath, name)
+		if err := restoreInstalledFile(budget, filePath, header, r); err != nil {
+			return fmt.Errorf("failed to restore %s: %w", filePath, err)
+		}
+		log.Printf("Restored %s (%s)", filePath, problem)
+		result.Restored = append(result.Restored, types.FileRepair{Path: filePath, Problem: problem})
+		return nil
+	})
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCompareContents(t *testing.T) {
+	entry := []byte(strings.Repeat("0123456789abcdef", 3*contentsBlockSize/16))
+	zeroed := bytes.Clone(entry)
+	copy(zeroed[contentsBlockSize:2*contentsBlockSize], make([]byte, contentsBlockSize))
+	edited := bytes.Clone(entry)
+	edited[contentsBlockSize+1] = 'x'
+	for _, tt := range []struct {
+		name      string
+		installed []byte
+		want      int
+	}{
+		{"same", entry, contentsSame},
+		{"zeroed block", zeroed, contentsDamaged},
+		{"truncated at a block", entry[:contentsBlockSize], contentsDamaged},
+		{"emptied", nil, contentsDamaged},
+		{"truncated within a block", entry[:contentsBlockSize+10], contentsDifferent},
+		{"edited", edited, contentsDifferent},
+		{"appended", append(bytes.Clone(entry), '\n'), contentsDifferent},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := compareContents(bytes.NewReader(tt.installed), bytes.NewReader(entry))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("compareContents() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckInstalledFile(t *testing.T) {
+	entry := []byte("#!/bin/sh\nexec ollama \"$@\"\n")
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, tt := range []struct {
+		name     string
+		setup    func(t *testing.T, path string)
+		recorded bool // Whether to record the change time after setup.
+		want     string
+	}{
+		{"intact", func(t *testing.T, path string) {}, true, ""},
+		{"missing", func(t *testing.T, path string) {
+			if err := os.Remove(path); err != nil {
+				t.Fatal(err)
+			}
+		}, false, FileProblemMissing},
+		{"emptied", func(t *testing.T, path string) {
+			if err := os.Truncate(path, 0); err != nil {
+				t.Fatal(err)
+			}
+		}, false, FileProblemCorrupt},
+		{"changed without being written", func(t *testing.T, path string) {
+			if err := os.WriteFile(path, []byte("#!/bin/sh\nexec ollamb \"$@\"\n"), 0o755); err != nil {
+				t.Fatal(err)
+			}
+		}, true, FileProblemCorrupt},
+		{"edited", func(t *testing.T, path string) {
+			if err := os.WriteFile(path, []byte("#!/bin/sh\nexec nice ollama \"$@\"\n"), 0o755); err != nil {
+				t.Fatal(err)
+			}
+		}, false, FileProblemModified},
+		{"edited with the time restored", func(t *testing.T, path string) {
+			if err := os.WriteFile(path, []byte("#!/bin/sh\nexec nice ollama \"$@\"\n"), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chtimes(path, modTime, modTime); err != nil {
+				t.Fatal(err)
+			}
+		}, false, FileProblemModified},
+		{"replaced by a directory", func(t *testing.T, path string) {
+			if err := os.Remove(path); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Mkdir(path, 0o755); err != nil {
+				t.Fatal(err)
+			}
+		}, false, FileProblemModified},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "ollama-wrapper")
+			if err := os.WriteFile(path, entry, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chtimes(path, modTime, modTime); err != nil {
+				t.Fatal(err)
+			}
+			// Until recorded otherwise, the change time is that of install.
+			recorded, err := fileChangeTime(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Ensure any write is seen as a change, on filesystems with
+			// coarse timestamps.
+			time.Sleep(10 * time.Millisecond)
+			tt.setup(t, path)
+			if tt.recorded {
+				// Simulate the contents changing without a write, as by
+				// a failing disk, which cannot be done for real.
+				if recorded, err = fileChangeTime(path); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got, err := checkInstalledFile(path, bytes.NewReader(entry), recorded)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("checkInstalledFile() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckInstalledFileWithoutRecord(t *testing.T) {
+	// Without a recorded change time, as for older installs, a file that was
+	// changed is preserved, unless it is plainly damaged.
+	path := filepath.Join(t.TempDir(), "ollama-wrapper")
+	if err := os.WriteFile(path, []byte("edited"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	got, err := checkInstalledFile(path, strings.NewReader("original"), time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != FileProblemModified {
+		t.Errorf("checkInstalledFile() = %q, want %q", got, FileProblemModified)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/rancher-sandbox/rd-open-webui-docker-ext/installer/types"
+)
+
+// resolveAsset determines the URL the given release asset would be downloaded
+// from, and checks it with a HEAD request; the body is never downloaded.
+// Failures of the HEAD request are reported in the result.
+func resolveAsset(ctx context.Context, release, assetName string) (*types.ResolvedAsset, error) {
+	assetURL, err := getReleaseAssetURL(ctx, release, assetName)
+	if err != nil {
+		return nil, err
+	}
+	result := &types.ResolvedAsset{SchemaVersion: types.SchemaVersion, Asset: assetName, URL: assetURL}
+	req, err := newAssetRequest(ctx, http.MethodHead, assetURL)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := downloadClient().Do(req)
+	if err != nil {
+		result.Error = err.Error()
+		return result, nil
+	}
+	resp.Body.Close()
+	result.Status = resp.Status
+	result.Size = max(resp.ContentLength, 0)
+	result.ContentType = resp.Header.Get("Content-Type")
+	result.FinalURL = resp.Request.URL.Redacted()
+	return result, nil
+}
+
+// printResolvedAssets prints, as JSON, where the given asset of a release would
+// be downloaded from.  If no asset is given, the assets that would be installed
+// on this machine are resolved instead, one JSON document each.
+func printResolvedAssets(ctx context.Context, release, assetName string) error {
+	assetNames := []string{assetName}
+	if assetName == "" {
+		selection, err := selectAsset(ctx)
+		if err != nil {
+			return err
+		}
+		chooseReleaseAssets(ctx, release, selection)
+		assetNames = selection.Assets
+	}
+	for _, name := range assetNames {
+		result, err := resolveAsset(ctx, release, name)
+		if err != nil {
+			return err
+		}
+		if err = printJSON(result); err != nil {
+			return err
+		}
+	}
+	return nil
+}
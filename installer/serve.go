@@ -0,0 +1,436 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rancher-sandbox/rd-open-webui-docker-ext/installer/types"
+)
+
+// envVarNamePattern matches valid environment variable names.
+var envVarNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// envFlag collects KEY=VALUE pairs from a repeatable flag.
+type envFlag map[string]string
+
+func (f envFlag) String() string {
+	return strings.Join(environmentList(f), " ")
+}
+
+func (f envFlag) Set(value string) error {
+	key, val, ok := strings.Cut(value, "=")
+	if !ok {
+		return fmt.Errorf("expected KEY=VALUE, got %q", value)
+	}
+	if !envVarNamePattern.MatchString(key) {
+		return fmt.Errorf("invalid environment variable name %q", key)
+	}
+	f[key] = val
+	return nil
+}
+
+// environmentList converts a map of environment variables to KEY=VALUE form,
+// sorted by name for stable output.
+func environmentList(env map[string]string) []string {
+	result := make([]string, 0, len(env))
+	for key, value := range env {
+		result = append(result, key+"="+value)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// parseKeepAlive validates a keep-alive value in the forms ollama accepts for
+// OLLAMA_KEEP_ALIVE: a duration such as "5m", a number of seconds, or a
+// negative value to keep models loaded indefinitely.
+func parseKeepAlive(value string) error {
+	if _, err := time.ParseDuration(value); err == nil {
+		return nil
+	}
+	if _, err := strconv.Atoi(value); err == nil {
+		return nil
+	}
+	return fmt.Errorf("invalid keep-alive %q: should be a duration such as 5m, a number of seconds, or -1 to never unload", value)
+}
+
+// serveEnvironment returns the environment variables to set on the managed
+// serve process, in addition to those inherited from the installer.  User
+// supplied variables take precedence over the defaults.
+func serveEnvironment(ctx context.Context, modelsDir string) map[string]string {
+	env := map[string]string{"OLLAMA_MODELS": modelsDir}
+	if u, err := url.Parse(ollamaURL); err == nil {
+		env["OLLAMA_HOST"] = u.Host
+	}
+	if selection, err := selectAsset(ctx); err != nil {
+		log.Printf("Failed to determine accelerator: %s", err)
+	} else {
+		for key, value := range cpuOnlyEnvironment(selection) {
+			env[key] = value
+		}
+	}
+	if keepAlive != "" {
+		env["OLLAMA_KEEP_ALIVE"] = keepAlive
+	}
+	for key, value := range serveEnv {
+		env[key] = value
+	}
+	return env
+}
+
+// serveLogCheckInterval is how often the supervisor checks whether the serve
+// log needs rotating.
+const serveLogCheckInterval = time.Minute
+
+// getServeLogFile returns the path of the log of the managed serve process.
+func getServeLogFile(ctx context.Context) (string, error) {
+	stateDir, err := getStateDirectory(ctx)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(stateDir, "serve.log"), nil
+}
+
+// rotatedLogFiles returns the rotated copies of the given log that exist,
+// oldest first.
+func rotatedLogFiles(logPath string) []string {
+	var files []string
+	for i := 1; ; i++ {
+		rotated := fmt.Sprintf("%s.%d", logPath, i)
+		if _, err := os.Stat(rotated); err != nil {
+			break
+		}
+		files = append(files, rotated)
+	}
+	slices.Reverse(files)
+	return files
+}
+
+// rotateServeLog rotates the serve log if it is larger than -serve-log-size,
+// or regardless if force is set, keeping -serve-log-keep rotated copies as
+// serve.log.1 (the newest) onwards.  As serve may be writing to the log, it is
+// copied and then truncated, rather than renamed; serve appends to it, so it
+// carries on writing at the start.
+func rotateServeLog(ctx context.Context, force bool) (*types.LogRotation, error) {
+	logPath, err := getServeLogFile(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result := &types.LogRotation{SchemaVersion: types.SchemaVersion, Path: logPath, Files: []string{}}
+	unlock, err := acquireLock(ctx, logPath+".lock")
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	defer func() { result.Files = append(result.Files, rotatedLogFiles(logPath)...) }()
+
+	info, err := os.Stat(logPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return result, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to rotate serve log: %w", err)
+	}
+	if info.Size() == 0 || (!force && info.Size() <= *serveLogSize) {
+		return result, nil
+	}
+	keep := max(*serveLogKeep, 1)
+	for i := len(rotatedLogFiles(logPath)); i >= keep; i-- {
+		if err = os.Remove(fmt.Sprintf("%s.%d", logPath, i)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("failed to remove old serve log: %w", err)
+		}
+	}
+	for i := keep - 1; i >= 1; i-- {
+		err = os.Rename(fmt.Sprintf("%s.%d", logPath, i), fmt.Sprintf("%s.%d", logPath, i+1))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("failed to rotate serve log: %w", err)
+		}
+	}
+	file, err := os.OpenFile(logPath, os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to rotate serve log: %w", err)
+	}
+	defer file.Close()
+	rotated, err := os.OpenFile(logPath+".1", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to rotate serve log: %w", err)
+	}
+	result.Size, err = io.Copy(rotated, file)
+	if closeErr := rotated.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to rotate serve log: %w", err)
+	}
+	if err = file.Truncate(0); err != nil {
+		return nil, fmt.Errorf("failed to rotate serve log: %w", err)
+	}
+	result.Rotated = true
+	log.Printf("Rotated %s (%d bytes)", logPath, result.Size)
+	return result, nil
+}
+
+// openServeLog opens the serve log for appending, rotating it first if it has
+// grown too large.
+func openServeLog(ctx context.Context) (*os.File, error) {
+	logPath, err := getServeLogFile(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := rotateServeLog(ctx, false); err != nil {
+		log.Printf("Failed to rotate %s: %s", logPath, err)
+	}
+	file, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open serve log: %w", err)
+	}
+	return file, nil
+}
+
+// launchServe starts `ollama serve` in the background, recording it in the
+// persisted state.  Its output is written to the serve log, as it may outlive
+// the installer.  The caller may wait for the returned command.
+func launchServe(ctx context.Context, executablePath, modelsDir string) (*exec.Cmd, error) {
+	env := serveEnvironment(ctx, modelsDir)
+	logFile, err := openServeLog(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// The serve process keeps its own handle to the log.
+	defer logFile.Close()
+	serveProc := exec.Command(executablePath, "serve")
+	serveProc.Env = append(os.Environ(), environmentList(env)...)
+	serveProc.Stdout = logFile
+	serveProc.Stderr = logFile
+	allowGracefulStop(serveProc)
+	if err := startWithPriority(serveProc, serveNice); err != nil {
+		return nil, fmt.Errorf("failed to start ollama server: %v", err)
+	}
+	err = updateState(ctx, func(state *installerState) error {
+		state.Serve = &serveState{
+			PID:            serveProc.Process.Pid,
+			ExecutablePath: executablePath,
+			Environment:    env,
+			Nice:           serveNice,
+			StartedAt:      time.Now(),
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Failed to record serve process: %s", err)
+	}
+	return serveProc, nil
+}
+
+// startSupervisor runs this installer in supervise mode in the background,
+// passing along the current flags.
+func startSupervisor() error {
+	self, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to find executable path: %w", err)
+	}
+	// Later flags override earlier ones, so this replaces any -mode given.
+	args := append(slices.Clone(os.Args[1:]), "-mode="+string(ModeSupervise))
+	supervisor := exec.Command(self, args...)
+	supervisor.Stdout = os.Stdout
+	supervisor.Stderr = os.Stderr
+	if err = supervisor.Start(); err != nil {
+		return fmt.Errorf("failed to start supervisor: %w", err)
+	}
+	return nil
+}
+
+// stopSupervisor stops the watchdog supervisor, if one is running.  The
+// supervisor stops the serve process it manages as it exits.
+func stopSupervisor(ctx context.Context) error {
+	state, err := loadState(ctx)
+	if err != nil {
+		return err
+	}
+	if state.Supervisor == nil || state.Supervisor.Crashing {
+		return nil
+	}
+	if proc, err := os.FindProcess(state.Supervisor.PID); err == nil {
+		if err = cancelProcess(proc); err == nil {
+			log.Printf("Stopped supervisor %d", state.Supervisor.PID)
+		}
+	}
+	return updateState(ctx, func(state *installerState) error {
+		state.Supervisor = nil
+		return nil
+	})
+}
+
+// superviseServe runs `ollama serve`, restarting it if it exits unexpectedly.
+// If it exits more than the allowed number of times within the watchdog
+// window, it is considered to be repeatedly crashing and is left stopped.
+func superviseServe(ctx context.Context) error {
+	executablePath, err := findExecutable(ctx, false)
+	if err != nil {
+		return err
+	}
+	if executablePath == "" {
+		return fmt.Errorf("failed to find ollama executable; was it installed?")
+	}
+	modelsDir, err := ensureModelsDirectory(ctx)
+	if err != nil {
+		return err
+	}
+
+	supervisor := &supervisorState{PID: os.Getpid()}
+	saveSupervisor := func() {
+		err := updateState(ctx, func(state *installerState) error {
+			state.Supervisor = supervisor
+			return nil
+		})
+		if err != nil {
+			log.Printf("Failed to record supervisor state: %s", err)
+		}
+	}
+	saveSupervisor()
+
+	// Serve may run for a long time; keep its log from growing without bound.
+	go func() {
+		ticker := time.NewTicker(serveLogCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if _, err := rotateServeLog(ctx, false); err != nil {
+					log.Printf("%s", err)
+				}
+			}
+		}
+	}()
+
+	var crashes []time.Time
+	for {
+		serveProc, err := launchServe(ctx, executablePath, modelsDir)
+		if err != nil {
+			return err
+		}
+		done := make(chan error, 1)
+		go func() { done <- serveProc.Wait() }()
+		select {
+		case <-ctx.Done():
+			log.Printf("Stopping ollama (pid %d)...", serveProc.Process.Pid)
+			_ = cancelProcess(serveProc.Process)
+			<-done
+			return nil
+		case <-done:
+		}
+
+		supervisor.LastExitCode = serveProc.ProcessState.ExitCode()
+		log.Printf("ollama serve exited unexpectedly with code %d", supervisor.LastExitCode)
+		now := time.Now()
+		crashes = slices.DeleteFunc(append(crashes, now), func(t time.Time) bool {
+			return now.Sub(t) > *watchdogWindow
+		})
+		if len(crashes) > *watchdogRestarts {
+			supervisor.Crashing = true
+			saveSupervisor()
+			return fmt.Errorf("ollama serve is repeatedly crashing: exited %d times within %s", len(crashes), *watchdogWindow)
+		}
+		supervisor.Restarts++
+		saveSupervisor()
+
+		delay := min(time.Second<<(len(crashes)-1), 30*time.Second)
+		log.Printf("Restarting ollama in %s (restart %d)...", delay, supervisor.Restarts)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(delay):
+		}
+	}
+}
+
+// reloadServe applies the current serve settings (such as -keep-alive and
+// -serve-env) to the managed serve process, by restarting it if they differ
+// from those it was started with.  Requests in flight through the proxy are
+// given up to -drain-timeout to finish first.  If serve is not running, the
+// settings simply apply when it is next started.
+func reloadServe(ctx context.Context) (*types.ReloadResult, error) {
+	externalPath, err := useExternalServer(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if externalPath != "" {
+		return nil, fmt.Errorf("cannot reload the externally managed ollama at %s", externalPath)
+	}
+	stateDir, err := getStateDirectory(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// Hold a lock throughout, so concurrent reloads do not interleave.
+	unlock, err := acquireLock(ctx, filepath.Join(stateDir, "serve.lock"))
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	state, err := loadState(ctx)
+	if err != nil {
+		return nil, err
+	}
+	modelsDir, err := getModelsDirectory(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result := &types.ReloadResult{
+		SchemaVersion: types.SchemaVersion,
+		Before:        map[string]string{},
+		After:         serveEnvironment(ctx, modelsDir),
+		Changed:       []string{},
+	}
+	if state.Serve != nil && state.Serve.Environment != nil {
+		result.Before = state.Serve.Environment
+	}
+	for key, value := range result.After {
+		if before, ok := result.Before[key]; !ok || before != value {
+			result.Changed = append(result.Changed, key)
+		}
+	}
+	for key := range result.Before {
+		if _, ok := result.After[key]; !ok {
+			result.Changed = append(result.Changed, key)
+		}
+	}
+	sort.Strings(result.Changed)
+
+	if result.Running, err = checkExistingInstance(ctx); err != nil {
+		return nil, err
+	}
+	if !result.Running {
+		log.Printf("Ollama is not running; the settings apply when it is started")
+		return result, nil
+	}
+	if len(result.Changed) == 0 && state.Serve != nil {
+		log.Printf("Serve settings are unchanged")
+		return result, nil
+	}
+	log.Printf("Restarting ollama to apply changes to %v...", result.Changed)
+	if result.Drained, err = drainProxy(ctx, *drainTimeout); err != nil {
+		return nil, err
+	}
+	if err = shutdownOllama(ctx); err != nil {
+		return nil, err
+	}
+	if err = startServe(ctx); err != nil {
+		return nil, err
+	}
+	result.Restarted = true
+	return result, nil
+}
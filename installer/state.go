@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// installerState is persisted between runs of the installer.
+type installerState struct {
+	Serve      *serveState      `json:"serve,omitempty"`      // The managed serve process, if started.
+	Supervisor *supervisorState `json:"supervisor,omitempty"` // The watchdog supervising serve, if any.
+	// ModelsDirectory overrides $OLLAMA_MODELS, after migrating models.
+	ModelsDirectory string `json:"modelsDirectory,omitempty"`
+	// InstalledArchives are the checksums of the cached archives that make up
+	// the current install.
+	InstalledArchives []string `json:"installedArchives,omitempty"`
+	// InstalledFiles are the change times of the regular files of the current
+	// install when the installer last wrote them, by path relative to the
+	// install, so that repair can tell which were written since.
+	InstalledFiles map[string]time.Time `json:"installedFiles,omitempty"`
+	// Backend is the ollama chosen with the switch mode: "managed", "external",
+	// or empty to decide based on -reuse-app.
+	Backend string `json:"backend,omitempty"`
+	// SelectedExecutable is the ollama chosen with the select-install mode,
+	// preferred over the first one found.
+	SelectedExecutable string `json:"selectedExecutable,omitempty"`
+	// DefaultModel is the model the UI uses unless another is chosen.
+	DefaultModel string `json:"defaultModel,omitempty"`
+}
+
+// serveState describes how the managed serve process was started.
+type serveState struct {
+	PID            int               `json:"pid"`
+	ExecutablePath string            `json:"executablePath"`
+	Environment    map[string]string `json:"environment"`    // Variables set in addition to the installer's own.
+	Nice           int               `json:"nice,omitempty"` // Niceness it was started with.
+	StartedAt      time.Time         `json:"startedAt"`
+}
+
+// supervisorState describes the watchdog process supervising serve.
+type supervisorState struct {
+	PID          int  `json:"pid"`
+	Restarts     int  `json:"restarts"`     // Number of times serve has been restarted.
+	LastExitCode int  `json:"lastExitCode"` // Exit code of the last unexpected exit.
+	Crashing     bool `json:"crashing"`     // Whether the supervisor gave up restarting.
+}
+
+// getStateFile returns the path of the file holding the persisted state.
+func getStateFile(ctx context.Context) (string, error) {
+	stateDir, err := getStateDirectory(ctx)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(stateDir, "state.json"), nil
+}
+
+// loadState reads the persisted state; if there is none, an empty state is
+// returned.
+func loadState(ctx context.Context) (*installerState, error) {
+	stateFile, err := getStateFile(ctx)
+	if err != nil {
+		return nil, err
+	}
+	state := &installerState{}
+	contents, err := os.ReadFile(stateFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read state: %w", err)
+	}
+	if err = json.Unmarshal(contents, state); err != nil {
+		return nil, fmt.Errorf("failed to read state: error unmarshaling %s: %w", stateFile, err)
+	}
+	return state, nil
+}
+
+// updateState applies the given function to the persisted state and saves the
+// result.  Concurrent updates are serialized via a lock file.
+func updateState(ctx context.Context, update func(*installerState) error) error {
+	stateFile, err := getStateFile(ctx)
+	if err != nil {
+		return err
+	}
+	unlock, err := acquireLock(ctx, stateFile+".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	state, err := loadState(ctx)
+	if err != nil {
+		return err
+	}
+	if err = update(state); err != nil {
+		return err
+	}
+	contents, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to save state: %w", err)
+	}
+	// Write to a temporary file first, so that a crash never leaves behind a
+	// partially written state file.
+	tempFile, err := os.CreateTemp(filepath.Dir(stateFile), "state.*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to save state: %w", err)
+	}
+	defer os.Remove(tempFile.Name())
+	_, err = tempFile.Write(contents)
+	if closeErr := tempFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save state: %w", err)
+	}
+	if err = os.Rename(tempFile.Name(), stateFile); err != nil {
+		return fmt.Errorf("failed to save state: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/rancher-sandbox/rd-open-webui-docker-ext/installer/types"
+)
+
+// printJSON writes the given value to standard output as JSON.
+func printJSON(v any) error {
+	encoder := json.NewEncoder(os.Stdout)
+	if !*jsonEvents {
+		// When emitting events, each must be on a single line.
+		encoder.SetIndent("", "  ")
+	}
+	if err := encoder.Encode(v); err != nil {
+		return fmt.Errorf("failed to output result: %w", err)
+	}
+	return nil
+}
+
+// getStatus collects the current state of the ollama install.
+func getStatus(ctx context.Context) (*types.InstallStatus, error) {
+	status := &types.InstallStatus{SchemaVersion: types.SchemaVersion}
+
+	isRunning, err := checkExistingInstance(ctx)
+	if err != nil {
+		return nil, err
+	}
+	status.Running = isRunning
+
+	status.SearchedLocations, err = searchExecutable(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	executablePath, err := findExecutable(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	status.ExternalServer, err = findExternalServer(ctx)
+	if err != nil {
+		return nil, err
+	}
+	status.Backend, err = currentBackend(ctx, status.ExternalServer)
+	if err != nil {
+		return nil, err
+	}
+	status.ExternalPolicy = externalPolicy
+	for i, location := range status.SearchedLocations {
+		if i > 0 && location.Exists && location.Path != executablePath && !acceptExternalExecutable(ctx, location.Path) {
+			status.IncompatibleExternal = location.Path
+			break
+		}
+	}
+	if state, err := loadState(ctx); err == nil {
+		status.DefaultModel = state.DefaultModel
+	}
+	if executablePath != "" {
+		status.Installed = true
+		status.ExecutablePath = executablePath
+		managedPath, err := findExecutable(ctx, true)
+		if err != nil {
+			return nil, err
+		}
+		status.Managed = managedPath == executablePath
+	}
+
+	if isRunning {
+		status.Version, err = getRunningVersion(ctx)
+	} else if executablePath != "" {
+		status.Version, err = getExecutableVersion(ctx, executablePath)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if status.Version != "" {
+		status.Compatibility, status.CompatibilityMessage = checkVersionCompatibility(status.Version)
+	}
+
+	status.ModelsDirectory, err = getModelsDirectory(ctx)
+	if err != nil {
+		return nil, err
+	}
+	status.InstallLocation, err = getDefaultInstallLocation(ctx)
+	if err != nil {
+		return nil, err
+	}
+	status.StateDirectory, err = getStateDirectory(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	selection, err := selectAsset(ctx)
+	if err != nil {
+		return nil, err
+	}
+	status.Accelerator = selection.Accelerator
+	status.ForcedCPU = selection.ForcedCPU
+	status.GPUDriverVersion = selection.DriverVersion
+	status.AcceleratorReason = selection.Reason
+	if status.SystemMemory, _, err = getSystemMemory(); err != nil {
+		log.Printf("Could not determine system memory: %s", err)
+	}
+	if status.GPUMemory, err = getGPUMemory(ctx, selection.Accelerator); err != nil {
+		log.Printf("Could not determine GPU memory: %s", err)
+	}
+
+	state, err := loadState(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if state.Serve != nil {
+		status.ServeEnvironment = state.Serve.Environment
+		status.KeepAlive = state.Serve.Environment["OLLAMA_KEEP_ALIVE"]
+		status.ServeNice = state.Serve.Nice
+	}
+	if state.Supervisor != nil {
+		status.ServeRestarts = state.Supervisor.Restarts
+		status.ServeCrashing = state.Supervisor.Crashing
+	}
+	status.PortOwner, status.PortOwnerPID, status.ManagedServePID, err = checkPortOwner(ctx)
+	if err != nil {
+		log.Printf("Could not determine which process is listening on the ollama port: %s", err)
+	} else {
+		describePortOwner(status.PortOwner, status.PortOwnerPID, status.ManagedServePID)
+	}
+
+	return status, nil
+}
+
+// Print the install status as JSON.
+func printStatus(ctx context.Context) error {
+	status, err := getStatus(ctx)
+	if err != nil {
+		return err
+	}
+	return printJSON(status)
+}
+
+// getRunningVersion returns the version reported by the running ollama server.
+func getRunningVersion(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ollamaURL+"/api/version", nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to get ollama version: %w", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to get ollama version: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return "", fmt.Errorf("failed to get ollama version: unexpected status %s", resp.Status)
+	}
+	var body struct {
+		Version string `json:"version"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("failed to get ollama version: error unmarshaling response: %w", err)
+	}
+	return body.Version, nil
+}
+
+// getExecutableVersion returns the version of the given ollama executable, by
+// running it with `--version`.
+func getExecutableVersion(ctx context.Context, executablePath string) (string, error) {
+	output, err := exec.CommandContext(ctx, executablePath, "--version").Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to get ollama version: %w", err)
+	}
+	// The output may contain warnings about not being able to connect to the
+	// server, so look for the line with the version.
+	const prefix = "version is "
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		if _, version, ok := strings.Cut(scanner.Text(), prefix); ok {
+			return strings.TrimSpace(version), nil
+		}
+	}
+	return "", fmt.Errorf("failed to get ollama version: unexpected output %q", output)
+}
+
+// Print information about the given release as JSON.
+func printRelease(ctx context.Context, release string) error {
+	info, err := getRelease(ctx, release)
+	if err != nil {
+		return err
+	}
+	return printJSON(types.ReleaseInfo{
+		SchemaVersion: types.SchemaVersion,
+		Tag:           info.TagName,
+		Name:          info.Name,
+		PublishedAt:   info.PublishedAt,
+		URL:           info.HTMLURL,
+	})
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/rancher-sandbox/rd-open-webui-docker-ext/installer/types"
+)
+
+// ollamaProcessNames are the executable names (without any .exe extension) of
+// ollama processes: the server itself, and the desktop apps that start it.
+var ollamaProcessNames = []string{"ollama", "ollama app"}
+
+// isOllamaExecutable returns whether the given path is that of an ollama
+// executable, ignoring case as the macOS app is named "Ollama".
+func isOllamaExecutable(executablePath string) bool {
+	name := strings.ToLower(strings.TrimSuffix(filepath.Base(executablePath), ".exe"))
+	return slices.Contains(ollamaProcessNames, name)
+}
+
+// stopAllProcesses stops every ollama process, whether it runs the managed
+// install or an external one, as a last resort when ollama is wedged.  The
+// supervisor is stopped first, so that it does not restart the managed serve.
+func stopAllProcesses(ctx context.Context) (*types.StopAllResult, error) {
+	if !*confirm {
+		return nil, fmt.Errorf("stopping all ollama processes also stops external installs; pass -confirm to proceed")
+	}
+	if err := stopSupervisor(ctx); err != nil {
+		log.Printf("Failed to stop supervisor: %s", err)
+	}
+	var managedInfo os.FileInfo
+	if managedPath, err := findExecutable(ctx, true); err != nil {
+		return nil, err
+	} else if managedPath != "" {
+		if managedInfo, err = os.Stat(managedPath); err != nil {
+			return nil, fmt.Errorf("failed to get executable info: %w", err)
+		}
+	}
+
+	result := &types.StopAllResult{SchemaVersion: types.SchemaVersion, Processes: []types.StoppedProcess{}}
+	pids, err := findProcesses(ctx, func(pid int, executablePath string, info os.FileInfo) bool {
+		if pid == os.Getpid() || !isOllamaExecutable(executablePath) {
+			return false
+		}
+		result.Processes = append(result.Processes, types.StoppedProcess{
+			PID:            pid,
+			ExecutablePath: executablePath,
+			Managed:        managedInfo != nil && os.SameFile(managedInfo, info),
+		})
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	stopped := stopProcesses(ctx, pids)
+	for i := range result.Processes {
+		process := &result.Processes[i]
+		process.Stopped = slices.Contains(stopped, process.PID)
+		kind := "external"
+		if process.Managed {
+			kind = "managed"
+		}
+		log.Printf("Found %s ollama process %d (%s); stopped: %t", kind, process.PID, process.ExecutablePath, process.Stopped)
+	}
+	return result, nil
+}
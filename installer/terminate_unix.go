@@ -0,0 +1,67 @@
+//go:build !windows
+
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"os/exec"
+	"slices"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// allowGracefulStop prepares cmd to be stopped by stopProcesses; nothing is
+// needed, as SIGTERM can be sent to any process.
+func allowGracefulStop(cmd *exec.Cmd) {}
+
+// stopProcesses asks the given processes to exit via SIGTERM, which lets ollama
+// shut down its server cleanly (ollama has no API to request a shutdown, nor to
+// stop accepting requests first).  Any processes still running after the stop
+// timeout are killed.  Returns the pids that were stopped.
+func stopProcesses(ctx context.Context, pids []int) []int {
+	var stopped []int
+	var remaining []*os.Process
+	for _, pid := range pids {
+		proc, err := os.FindProcess(pid)
+		if err != nil {
+			continue
+		}
+		err = proc.Signal(unix.SIGTERM)
+		if err == nil {
+			log.Printf("Terminated process %d", pid)
+			stopped = append(stopped, pid)
+			remaining = append(remaining, proc)
+		} else if !errors.Is(err, unix.EINVAL) {
+			log.Printf("Ignoring failure to terminate pid %d: %s", pid, err)
+		}
+	}
+
+	deadline := time.Now().Add(*stopTimeout)
+	for len(remaining) > 0 && time.Now().Before(deadline) && ctx.Err() == nil {
+		time.Sleep(100 * time.Millisecond)
+		// Signal 0 only checks whether the process still exists.
+		remaining = slices.DeleteFunc(remaining, func(proc *os.Process) bool {
+			return proc.Signal(unix.Signal(0)) != nil
+		})
+	}
+
+	for _, proc := range remaining {
+		log.Printf("Process %d did not exit within %s, killing it", proc.Pid, *stopTimeout)
+		if err := proc.Kill(); err != nil && !errors.Is(err, os.ErrProcessDone) {
+			log.Printf("Ignoring failure to kill pid %d: %s", proc.Pid, err)
+		}
+	}
+	return stopped
+}
+
+// processRunning reports whether the given process exists.
+func processRunning(pid int) bool {
+	// Signal 0 only checks whether the process exists; it may belong to
+	// another user, which we cannot signal.
+	err := unix.Kill(pid, 0)
+	return err == nil || errors.Is(err, unix.EPERM)
+}
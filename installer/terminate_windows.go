@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os/exec"
+	"slices"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	kernel32          = windows.NewLazySystemDLL("kernel32.dll")
+	procAttachConsole = kernel32.NewProc("AttachConsole")
+	procFreeConsole   = kernel32.NewProc("FreeConsole")
+)
+
+// allowGracefulStop starts cmd in its own process group, so that it can later
+// be sent Ctrl+Break by interruptProcess without affecting anything else.
+func allowGracefulStop(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.CreationFlags |= windows.CREATE_NEW_PROCESS_GROUP
+}
+
+// interruptProcess sends Ctrl+Break to the process group led by the given
+// process, which ollama handles as it does SIGTERM elsewhere.  This is only
+// possible from the same console, so that console is attached to for the
+// duration.  It fails for processes that are not console process group
+// leaders, such as ones not started by allowGracefulStop.
+func interruptProcess(pid int) error {
+	if r1, _, err := procAttachConsole.Call(uintptr(pid)); r1 == 0 {
+		// Access is denied if we already have a console; it may be shared
+		// with the process.
+		if !errors.Is(err, windows.ERROR_ACCESS_DENIED) {
+			return fmt.Errorf("failed to attach to console of process %d: %w", pid, err)
+		}
+	} else {
+		defer procFreeConsole.Call()
+	}
+	if err := windows.GenerateConsoleCtrlEvent(windows.CTRL_BREAK_EVENT, uint32(pid)); err != nil {
+		return fmt.Errorf("failed to interrupt process %d: %w", pid, err)
+	}
+	return nil
+}
+
+// stopProcesses asks the given processes to exit via Ctrl+Break, which lets
+// ollama shut down its server cleanly (ollama has no API to request a
+// shutdown, nor to stop accepting requests first).  Processes that cannot be
+// interrupted, or are still running after the stop timeout, are terminated.
+// Returns the pids that were stopped.
+func stopProcesses(ctx context.Context, pids []int) []int {
+	var stopped []int
+	var remaining []int
+	for _, pid := range pids {
+		if err := interruptProcess(pid); err != nil {
+			log.Printf("Failed to interrupt pid %d, terminating it instead: %s", pid, err)
+			if terminateProcessID(pid) {
+				stopped = append(stopped, pid)
+			}
+			continue
+		}
+		log.Printf("Interrupted process %d", pid)
+		stopped = append(stopped, pid)
+		remaining = append(remaining, pid)
+	}
+
+	deadline := time.Now().Add(*stopTimeout)
+	for len(remaining) > 0 && time.Now().Before(deadline) && ctx.Err() == nil {
+		time.Sleep(100 * time.Millisecond)
+		remaining = slices.DeleteFunc(remaining, func(pid int) bool {
+			return !processRunning(pid)
+		})
+	}
+
+	for _, pid := range remaining {
+		log.Printf("Process %d did not exit within %s, terminating it", pid, *stopTimeout)
+		terminateProcessID(pid)
+	}
+	return stopped
+}
+
+// terminateProcessID terminates the given process immediately, reporting
+// whether it succeeded.
+func terminateProcessID(pid int) bool {
+	hProc, err := windows.OpenProcess(windows.PROCESS_TERMINATE, false, uint32(pid))
+	if err != nil {
+		log.Printf("Ignoring error opening process %d: %s", pid, err)
+		return false
+	}
+	defer windows.CloseHandle(hProc)
+	if err = windows.TerminateProcess(hProc, 0); err != nil {
+		log.Printf("Failed to terminate pid %d: %s", pid, err)
+		return false
+	}
+	log.Printf("Terminated process %d", pid)
+	return true
+}
+
+// processRunning reports whether the given process exists.
+func processRunning(pid int) bool {
+	hProc, err := windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION, false, uint32(pid))
+	if err != nil {
+		// Processes we may not query exist, even if we cannot tell more.
+		return errors.Is(err, windows.ERROR_ACCESS_DENIED)
+	}
+	defer windows.CloseHandle(hProc)
+	// The process handle is signaled once it exits.
+	event, err := windows.WaitForSingleObject(hProc, 0)
+	return err != nil || event == uint32(windows.WAIT_TIMEOUT)
+}
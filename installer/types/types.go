@@ -0,0 +1,639 @@
+// Package types describes the JSON emitted by the installer, which is consumed
+// by the extension UI.  Any incompatible change must increment SchemaVersion so
+// that the UI can detect it.
+package types
+
+import "time"
+
+// SchemaVersion is the version of the JSON structures in this package.
+const SchemaVersion = 1
+
+// InstallStatus describes the state of the ollama install, as emitted by the
+// `status` mode.
+type InstallStatus struct {
+	SchemaVersion  int    `json:"schemaVersion"`
+	Installed      bool   `json:"installed"`                // Whether any ollama executable was found.
+	Running        bool   `json:"running"`                  // Whether an ollama server is responding.
+	Managed        bool   `json:"managed"`                  // Whether the executable is the one we installed.
+	ExecutablePath string `json:"executablePath,omitempty"` // Path to the ollama executable, if found.
+	Version        string `json:"version,omitempty"`        // Version of ollama, if known.
+	// Compatibility of the version with the extension; one of "supported",
+	// "older", "newer", or "unknown".  Empty if the version is not known.
+	Compatibility        string `json:"compatibility,omitempty"`
+	CompatibilityMessage string `json:"compatibilityMessage,omitempty"` // Explanation if not supported.
+	ModelsDirectory      string `json:"modelsDirectory,omitempty"`      // Where models are stored.
+	InstallLocation      string `json:"installLocation,omitempty"`      // Where ollama is installed to; may be read-only.
+	StateDirectory       string `json:"stateDirectory,omitempty"`       // Where installer state is kept.
+	// Accelerator the ollama build is selected for; one of "cpu", "cuda",
+	// "rocm", or "metal".
+	Accelerator string `json:"accelerator,omitempty"`
+	ForcedCPU   bool   `json:"forcedCPU,omitempty"` // Whether the CPU was forced, ignoring detected GPUs.
+	// Why the accelerator differs from the detected GPU, such as an outdated
+	// driver; empty if it does not.
+	AcceleratorReason string `json:"acceleratorReason,omitempty"`
+	GPUDriverVersion  string `json:"gpuDriverVersion,omitempty"` // Version of the GPU driver, if known.
+	SystemMemory      uint64 `json:"systemMemory,omitempty"`     // Total system memory, in bytes.
+	GPUMemory         uint64 `json:"gpuMemory,omitempty"`        // Total discrete GPU memory, in bytes, if known.
+	// Environment variables the managed serve process was started with, in
+	// addition to those inherited.
+	ServeEnvironment map[string]string `json:"serveEnvironment,omitempty"`
+	KeepAlive        string            `json:"keepAlive,omitempty"`     // How long serve keeps idle models loaded, if set.
+	ServeNice        int               `json:"serveNice,omitempty"`     // Niceness the managed serve was started with.
+	ServeRestarts    int               `json:"serveRestarts,omitempty"` // Times the watchdog restarted serve.
+	ServeCrashing    bool              `json:"serveCrashing,omitempty"` // Whether the watchdog gave up restarting serve.
+	// Path of a running, externally managed ollama application (Ollama.app on
+	// macOS), if any.  The installer never stops or uninstalls it.
+	ExternalServer string `json:"externalServer,omitempty"`
+	// Backend serving the ollama API: "managed" or "external".
+	Backend string `json:"backend,omitempty"`
+	// What is done with an external install of an unsupported version: "warn"
+	// to use it anyway, or "managed" to install a managed copy alongside it.
+	ExternalPolicy string `json:"externalPolicy,omitempty"`
+	// External install passed over for being of an unsupported version, if any.
+	IncompatibleExternal string `json:"incompatibleExternal,omitempty"`
+	DefaultModel         string `json:"defaultModel,omitempty"` // Model the UI uses unless another is chosen.
+	// Which process is answering on the ollama API port: "managed",
+	// "external", "none", or "unknown" if it could not be determined.
+	PortOwner       string `json:"portOwner,omitempty"`
+	PortOwnerPID    int    `json:"portOwnerPID,omitempty"`    // Process listening on the port, if known.
+	ManagedServePID int    `json:"managedServePID,omitempty"` // Managed serve process, if running.
+	// Locations searched for the ollama executable, in order.
+	SearchedLocations []SearchedLocation `json:"searchedLocations,omitempty"`
+}
+
+// SearchedLocation is a location searched for the ollama executable.
+type SearchedLocation struct {
+	Path   string `json:"path"`
+	Exists bool   `json:"exists"`
+	// Why the location is broken, if it is a symbolic link that cannot be
+	// followed (such as one whose target was deleted); it then does not exist.
+	Broken string `json:"broken,omitempty"`
+}
+
+// Install is a distinct install of ollama found on the machine.
+type Install struct {
+	Path     string `json:"path"`
+	Version  string `json:"version,omitempty"` // Empty if it could not be determined.
+	Managed  bool   `json:"managed"`           // Whether this is the install managed by the extension.
+	Selected bool   `json:"selected"`          // Whether this is the install the extension uses.
+}
+
+// InstallList is the output of the `list-installs` and `select-install` modes.
+type InstallList struct {
+	SchemaVersion int       `json:"schemaVersion"`
+	Installs      []Install `json:"installs"`
+}
+
+// ModelInfo describes a single locally available model.
+type ModelInfo struct {
+	Name       string    `json:"name"`
+	Digest     string    `json:"digest"`
+	Size       int64     `json:"size"` // Size in bytes, as reported by ollama.
+	ModifiedAt time.Time `json:"modifiedAt"`
+}
+
+// ModelList is the list of models, as emitted by the `list` mode.
+type ModelList struct {
+	SchemaVersion int         `json:"schemaVersion"`
+	Models        []ModelInfo `json:"models"`
+}
+
+// ReleaseInfo describes an ollama release, as emitted by the `latest` mode.
+type ReleaseInfo struct {
+	SchemaVersion int       `json:"schemaVersion"`
+	Tag           string    `json:"tag"`
+	Name          string    `json:"name"`
+	PublishedAt   time.Time `json:"publishedAt"`
+	URL           string    `json:"url"`
+}
+
+// ResolvedAsset describes where a release asset would be downloaded from, as
+// emitted by the `resolve-url` mode.
+type ResolvedAsset struct {
+	SchemaVersion int    `json:"schemaVersion"`
+	Asset         string `json:"asset"`
+	URL           string `json:"url"`                   // URL the installer would request.
+	Status        string `json:"status,omitempty"`      // Status of a HEAD request to the URL, if it succeeded.
+	Size          int64  `json:"size,omitempty"`        // Content length reported by the HEAD request, if any.
+	ContentType   string `json:"contentType,omitempty"` // Content type reported by the HEAD request, if any.
+	FinalURL      string `json:"finalURL,omitempty"`    // URL after following any redirects.
+	Error         string `json:"error,omitempty"`       // Error making the HEAD request, if any.
+}
+
+// UpgradePlan describes what installing a release would change, as output by
+// the `plan-upgrade` mode.
+type UpgradePlan struct {
+	SchemaVersion    int    `json:"schemaVersion"`
+	ExecutablePath   string `json:"executablePath,omitempty"`   // The current install, if any.
+	Managed          bool   `json:"managed"`                    // Whether the current install is managed by the extension.
+	InstalledVersion string `json:"installedVersion,omitempty"` // Empty if not installed or unknown.
+	TargetVersion    string `json:"targetVersion"`              // Tag of the release that would be installed.
+	UpgradeAvailable bool   `json:"upgradeAvailable"`           // Whether the target is newer than the install.
+	// Compatibility of the target version; one of "supported", "older",
+	// "newer", or "unknown".
+	Compatibility        string          `json:"compatibility"`
+	CompatibilityMessage string          `json:"compatibilityMessage,omitempty"`
+	Assets               []ResolvedAsset `json:"assets"`       // Assets that would be downloaded.
+	DownloadSize         int64           `json:"downloadSize"` // Total size of the assets, as far as known.
+	ModelsDirectory      string          `json:"modelsDirectory"`
+	ModelsPreserved      bool            `json:"modelsPreserved"` // Whether models are kept outside the install.
+}
+
+// ProgressEvent reports progress through a phase of an install or model pull,
+// as emitted (one per line) when the installer is run with -json-events, and
+// streamed by the `api` mode.
+type ProgressEvent struct {
+	Phase     string `json:"phase"`             // One of "download", "extract" or "pull".
+	Completed int64  `json:"completed"`         // Bytes processed so far.
+	Total     int64  `json:"total"`             // Total bytes, or zero if unknown.
+	Percent   int64  `json:"percent,omitempty"` // Percentage complete, if the total is known.
+	File      string `json:"file,omitempty"`    // The file (or model) currently being processed, if any.
+	Done      bool   `json:"done,omitempty"`    // Whether the phase is complete.
+}
+
+// GPUUsage reports GPU usage during a test generation, as emitted by the
+// `gpu-usage` mode.
+type GPUUsage struct {
+	SchemaVersion   int    `json:"schemaVersion"`
+	Accelerator     string `json:"accelerator"`
+	Model           string `json:"model"`
+	Duration        string `json:"duration"`        // How long the generation took.
+	Samples         int    `json:"samples"`         // Number of measurements taken.
+	PeakUtilization int    `json:"peakUtilization"` // Percent.
+	PeakVRAMBytes   int64  `json:"peakVRAMBytes"`
+}
+
+// AssetVerification is the check of a release asset of the install against its
+// published checksum.
+type AssetVerification struct {
+	Asset    string `json:"asset"`
+	Expected string `json:"expected,omitempty"` // Published SHA-256 checksum.
+	Actual   string `json:"actual,omitempty"`   // SHA-256 checksum of the installed copy.
+	Archive  string `json:"archive,omitempty"`  // Cached archive checked, if the asset is an archive.
+	Passed   bool   `json:"passed"`
+	Message  string `json:"message,omitempty"` // Why the check failed.
+}
+
+// ReleaseVerification is the output of the `verify-release` mode.
+type ReleaseVerification struct {
+	SchemaVersion      int    `json:"schemaVersion"`
+	Release            string `json:"release"` // Tag the install was checked against.
+	ExecutablePath     string `json:"executablePath"`
+	ExecutableChecksum string `json:"executableChecksum"`
+	// How the executable was matched to the release: "file" if the asset is
+	// the executable, or "archive" if it matches its copy in a verified
+	// archive; empty if it could not be matched.
+	Method string              `json:"method,omitempty"`
+	Passed bool                `json:"passed"`
+	Assets []AssetVerification `json:"assets"`
+}
+
+// ModelImport is the output of the `import-gguf` mode.
+type ModelImport struct {
+	SchemaVersion int    `json:"schemaVersion"`
+	Model         string `json:"model"`            // The model created.
+	Source        string `json:"source"`           // The GGUF file or registry reference imported.
+	Digest        string `json:"digest,omitempty"` // Digest of the uploaded GGUF file.
+	Size          int64  `json:"size,omitempty"`   // Size of the GGUF file, in bytes.
+}
+
+// WarmResult is the output of the `warm` mode.
+type WarmResult struct {
+	SchemaVersion int     `json:"schemaVersion"`
+	Model         string  `json:"model"`
+	AlreadyLoaded bool    `json:"alreadyLoaded"`       // Whether the model was loaded beforehand.
+	LoadSeconds   float64 `json:"loadSeconds"`         // Time taken to load the model.
+	KeepAlive     string  `json:"keepAlive,omitempty"` // How long the model stays loaded; empty for the server default.
+	Size          int64   `json:"size,omitempty"`      // Bytes in memory, if known.
+	VRAMSize      int64   `json:"vramSize,omitempty"`  // Bytes of that in VRAM.
+}
+
+// InferenceDevice is a compute device the server reported at startup.
+type InferenceDevice struct {
+	ID          string `json:"id"`
+	Library     string `json:"library"` // Such as "cuda", "rocm", "metal", or "cpu".
+	Name        string `json:"name,omitempty"`
+	Driver      string `json:"driver,omitempty"`
+	TotalMemory string `json:"totalMemory,omitempty"` // As logged, such as "24.0 GiB".
+}
+
+// LoadedModel is a model the running server has loaded.
+type LoadedModel struct {
+	Name     string `json:"name"`
+	Size     int64  `json:"size"`     // Bytes in memory.
+	VRAMSize int64  `json:"vramSize"` // Bytes of that in VRAM; less than Size if partly on the CPU.
+}
+
+// GPUStatus is the output of the `gpu-status` mode: whether the running server
+// actually uses the GPU, as opposed to which build was installed.
+type GPUStatus struct {
+	SchemaVersion int `json:"schemaVersion"`
+	// Accelerator the installed build is selected for; one of "cpu", "cuda",
+	// "rocm", or "metal".
+	InstalledAccelerator string `json:"installedAccelerator"`
+	// Library the server runs inference with, such as "cuda" or "cpu"; empty
+	// if it could not be determined.
+	Backend      string            `json:"backend,omitempty"`
+	UsingGPU     bool              `json:"usingGPU"`
+	Reason       string            `json:"reason,omitempty"`       // Why a GPU build is not using the GPU, if known.
+	Devices      []InferenceDevice `json:"devices,omitempty"`      // Devices the server reported when it started.
+	LoadedModels []LoadedModel     `json:"loadedModels,omitempty"` // Models currently loaded.
+	LogFile      string            `json:"logFile,omitempty"`      // The server log the devices were read from.
+}
+
+// BenchStats summarizes a set of measurements, in seconds.
+type BenchStats struct {
+	Min    float64 `json:"min"`
+	Median float64 `json:"median"`
+	Max    float64 `json:"max"`
+}
+
+// BenchResult is the result of benchmarking a model, as emitted by the `bench`
+// mode.
+type BenchResult struct {
+	SchemaVersion     int        `json:"schemaVersion"`
+	Model             string     `json:"model"`
+	Accelerator       string     `json:"accelerator"`
+	Runs              int        `json:"runs"`
+	LoadSeconds       BenchStats `json:"loadSeconds"`       // Time to load the model from cold.
+	FirstTokenSeconds BenchStats `json:"firstTokenSeconds"` // Time to first token, once loaded.
+}
+
+// InstallResult describes the outcome of an install, as emitted by the
+// `install` mode.
+type InstallResult struct {
+	SchemaVersion   int      `json:"schemaVersion"`
+	ExecutablePath  string   `json:"executablePath,omitempty"` // Empty if an ollama server was already running.
+	Version         string   `json:"version,omitempty"`
+	Fresh           bool     `json:"fresh"`                 // Whether ollama was installed, rather than already present.
+	External        bool     `json:"external,omitempty"`    // Whether an externally managed ollama is reused.
+	Assets          []string `json:"assets,omitempty"`      // Release assets installed.
+	Checksums       []string `json:"checksums,omitempty"`   // SHA-256 checksums of the assets, in the same order.
+	Accelerator     string   `json:"accelerator,omitempty"` // Accelerator the assets were selected for.
+	Size            int64    `json:"size,omitempty"`        // Total size of the assets, in bytes.
+	DurationSeconds float64  `json:"durationSeconds"`
+}
+
+// UninstallResult describes the outcome of the `uninstall` mode.  Uninstalling
+// when ollama is not installed is not an error; Removed is false instead.
+type UninstallResult struct {
+	SchemaVersion  int      `json:"schemaVersion"`
+	Removed        bool     `json:"removed"`                  // Whether an install was present and removed.
+	Path           string   `json:"path"`                     // The install location.
+	Version        string   `json:"version,omitempty"`        // Version of the removed ollama, if known.
+	TerminatedPIDs []int    `json:"terminatedPIDs,omitempty"` // Processes stopped before removing ollama.
+	Purged         []string `json:"purged,omitempty"`         // With -purge, the installer data removed too.
+}
+
+// PermissionChange describes a file whose mode was fixed.
+type PermissionChange struct {
+	Path   string `json:"path"`
+	Before string `json:"before"` // Octal mode before fixing, such as "0644".
+	After  string `json:"after"`  // Octal mode after fixing, such as "0755".
+}
+
+// PermissionsResult describes the outcome of the `fix-permissions` mode.
+type PermissionsResult struct {
+	SchemaVersion int `json:"schemaVersion"`
+	// Where the expected modes came from; either "archive" (the cached install
+	// archives) or "defaults" (only the executable and directories are fixed).
+	Source  string             `json:"source"`
+	Changed []PermissionChange `json:"changed"`
+}
+
+// PrunedBlob is a model blob removed by the `prune` mode.
+type PrunedBlob struct {
+	Digest string `json:"digest"`
+	Size   int64  `json:"size"` // Size in bytes.
+}
+
+// PruneResult describes the outcome of the `prune` mode.
+type PruneResult struct {
+	SchemaVersion  int          `json:"schemaVersion"`
+	DryRun         bool         `json:"dryRun"` // If set, blobs were reported but not removed.
+	Removed        []PrunedBlob `json:"removed"`
+	ReclaimedBytes int64        `json:"reclaimedBytes"`
+}
+
+// CacheEntry describes a downloaded archive in the installer's cache.
+type CacheEntry struct {
+	Asset     string    `json:"asset"`
+	Release   string    `json:"release,omitempty"` // Release the archive was downloaded for, if known.
+	Checksum  string    `json:"checksum"`          // SHA-256 checksum of the archive.
+	Size      int64     `json:"size"`              // Size in bytes.
+	LastUsed  time.Time `json:"lastUsed"`
+	Installed bool      `json:"installed"` // Whether the archive is part of the current install.
+}
+
+// CacheList is the contents of the archive cache, as emitted by the
+// `cache-list` mode.
+type CacheList struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	Directory     string       `json:"directory"`
+	Entries       []CacheEntry `json:"entries"`
+}
+
+// CacheClearResult describes the archives removed by the `cache-clear` mode.
+type CacheClearResult struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	Removed       []CacheEntry `json:"removed"`
+	FreedBytes    int64        `json:"freedBytes"`
+}
+
+// DiagnosticsResult describes the bundle written by the `diagnostics` mode.
+type DiagnosticsResult struct {
+	SchemaVersion int      `json:"schemaVersion"`
+	Path          string   `json:"path"`
+	Files         []string `json:"files"` // Names of the files in the bundle.
+}
+
+// SwitchResult describes the outcome of the `switch` mode.
+type SwitchResult struct {
+	SchemaVersion  int    `json:"schemaVersion"`
+	Backend        string `json:"backend"`                  // "managed" or "external".
+	Previous       string `json:"previous"`                 // The backend in use before switching.
+	ExternalServer string `json:"externalServer,omitempty"` // Path of the external ollama switched to.
+}
+
+// DefaultModel is the output of the `default-model` and `set-default` modes.
+type DefaultModel struct {
+	SchemaVersion int    `json:"schemaVersion"`
+	Model         string `json:"model"`  // Empty if no default is set.
+	Pulled        bool   `json:"pulled"` // Whether the model is available locally.
+}
+
+// PullState describes a model pull that has not completed.
+type PullState struct {
+	Model      string    `json:"model"`
+	Status     string    `json:"status"`     // The last status reported by ollama.
+	Completed  int64     `json:"completed"`  // Bytes downloaded, over all layers.
+	Total      int64     `json:"total"`      // Bytes to download, over all layers seen so far.
+	Percent    float64   `json:"percent"`    // Completed as a percentage of total.
+	InProgress bool      `json:"inProgress"` // Whether the pull is running; otherwise it was interrupted.
+	UpdatedAt  time.Time `json:"updatedAt"`
+}
+
+// PullList is the output of the `pulls` mode.
+type PullList struct {
+	SchemaVersion int         `json:"schemaVersion"`
+	Pulls         []PullState `json:"pulls"`
+}
+
+// ModelFit estimates whether a model fits in memory, as output by the
+// `check-fit` mode.  All sizes are in bytes.
+type ModelFit struct {
+	SchemaVersion   int    `json:"schemaVersion"`
+	Model           string `json:"model"`
+	Accelerator     string `json:"accelerator"`
+	ModelSize       int64  `json:"modelSize"`                 // Size of the weights loaded into memory.
+	RequiredMemory  uint64 `json:"requiredMemory"`            // Estimated memory needed to run the model.
+	SystemMemory    uint64 `json:"systemMemory"`              // Total system memory.
+	AvailableMemory uint64 `json:"availableMemory,omitempty"` // System memory available now, if known.
+	GPUMemory       uint64 `json:"gpuMemory,omitempty"`       // Total discrete GPU memory, if any.
+	Fits            bool   `json:"fits"`
+	Warning         string `json:"warning,omitempty"` // Why the model may not run well, if it may not.
+}
+
+// Health reports whether the ollama server is responding, as served by the
+// `api` mode.
+type Health struct {
+	SchemaVersion int    `json:"schemaVersion"`
+	Running       bool   `json:"running"`
+	Starting      bool   `json:"starting"`        // Whether ollama is listening but not responding in time.
+	Error         string `json:"error,omitempty"` // Why the health check failed, if it did.
+}
+
+// ReloadResult is the output of the `reload-serve` mode.
+type ReloadResult struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	Before        map[string]string `json:"before"`    // Serve environment the running serve was started with.
+	After         map[string]string `json:"after"`     // Serve environment from the current settings.
+	Changed       []string          `json:"changed"`   // Names of the variables that differ.
+	Running       bool              `json:"running"`   // Whether the managed serve was running.
+	Drained       bool              `json:"drained"`   // Whether all requests in flight finished before restarting.
+	Restarted     bool              `json:"restarted"` // Whether serve was restarted to apply the changes.
+}
+
+// ConnectivityCheck is the result of checking one host, as part of the output
+// of the `check-connectivity` mode.
+type ConnectivityCheck struct {
+	Name      string `json:"name"` // One of "github-api", "github" or "mirror".
+	URL       string `json:"url"`
+	Proxy     string `json:"proxy,omitempty"` // The proxy used to reach the URL, if any.
+	Reachable bool   `json:"reachable"`
+	Status    int    `json:"status,omitempty"`  // The HTTP status of the response, if any.
+	Latency   string `json:"latency,omitempty"` // Time until the response headers were received.
+	// ErrorKind is one of "proxy", "dns", "connect", "timeout", "tls", "http"
+	// or "other", telling a broken proxy from a broken network or server.
+	ErrorKind string `json:"errorKind,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Connectivity is the output of the `check-connectivity` mode.
+type Connectivity struct {
+	SchemaVersion int                 `json:"schemaVersion"`
+	Reachable     bool                `json:"reachable"` // Whether every host could be reached.
+	Checks        []ConnectivityCheck `json:"checks"`
+}
+
+// QueuedPull is a model pull requested of the `api` mode.
+type QueuedPull struct {
+	Model     string `json:"model"`
+	State     string `json:"state"`               // One of "queued", "running", "succeeded", "failed" or "cancelled".
+	Completed int64  `json:"completed,omitempty"` // Bytes downloaded so far, while running.
+	Total     int64  `json:"total,omitempty"`     // Bytes to download, over all layers seen so far, while running.
+	Error     string `json:"error,omitempty"`     // Why the pull failed, if it did.
+}
+
+// PullQueue lists the model pulls requested of the `api` mode, in the order
+// they were requested.
+type PullQueue struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	Pulls         []QueuedPull `json:"pulls"`
+}
+
+// ModelDiskUsage describes the space a model's blobs occupy on disk.
+type ModelDiskUsage struct {
+	Name         string `json:"name"`
+	Size         int64  `json:"size"`                   // Bytes of every blob the model uses.
+	UniqueSize   int64  `json:"uniqueSize"`             // Bytes of the blobs only this model uses; deleting it reclaims these.
+	SharedSize   int64  `json:"sharedSize"`             // Bytes of the blobs other models use too.
+	MissingBlobs int    `json:"missingBlobs,omitempty"` // Blobs the manifest references that are not on disk.
+}
+
+// DiskUsage describes the output of the `disk-usage` mode.
+type DiskUsage struct {
+	SchemaVersion    int              `json:"schemaVersion"`
+	ModelsDir        string           `json:"modelsDir"`
+	Models           []ModelDiskUsage `json:"models"`
+	TotalSize        int64            `json:"totalSize"`        // Bytes of the blobs any model uses, counting shared blobs once.
+	SharedSize       int64            `json:"sharedSize"`       // Bytes of the blobs more than one model uses, counted once.
+	UnreferencedSize int64            `json:"unreferencedSize"` // Bytes of blobs no model uses, such as partial downloads; see the `prune` mode.
+}
+
+// LockInfo describes a lock file found by the `unlock` mode.
+type LockInfo struct {
+	Path       string     `json:"path"`
+	PID        int        `json:"pid,omitempty"`        // Process holding the lock, if recorded.
+	AcquiredAt *time.Time `json:"acquiredAt,omitempty"` // When it was taken, if recorded.
+	Running    bool       `json:"running"`              // Whether the process holding it is running.
+	Unreadable bool       `json:"unreadable,omitempty"` // Whether its owner could not be read, so it may be held.
+	Removed    bool       `json:"removed"`
+}
+
+// UnlockResult is the output of the `unlock` mode.
+type UnlockResult struct {
+	SchemaVersion int        `json:"schemaVersion"`
+	Locks         []LockInfo `json:"locks"`
+}
+
+// Capability is a feature of the ollama API, and whether the server has it.
+type Capability struct {
+	Name      string `json:"name"`
+	Endpoint  string `json:"endpoint"`  // Method and path providing it, such as "POST /api/chat".
+	Available bool   `json:"available"` // Whether the running server supports it.
+	// First ollama version supporting it, if it is a later addition to an
+	// existing endpoint.
+	MinVersion string `json:"minVersion,omitempty"`
+}
+
+// Capabilities is the output of the `capabilities` mode.
+type Capabilities struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	Version       string          `json:"version"`   // Version of the running server.
+	Available     map[string]bool `json:"available"` // Whether each capability is supported, by name.
+	Capabilities  []Capability    `json:"capabilities"`
+}
+
+// FootprintCategory is the disk space used by one kind of data.
+type FootprintCategory struct {
+	// What the space is used by; one of "install", "cache", "logs", or
+	// "models".
+	Name string `json:"name"`
+	Path string `json:"path"` // Where the data is kept.
+	Size int64  `json:"size"` // Bytes used.
+	// Bytes that can be reclaimed without losing anything in use, such as
+	// archives of other releases, and the mode that does so.
+	ReclaimableSize int64  `json:"reclaimableSize,omitempty"`
+	ReclaimMode     string `json:"reclaimMode,omitempty"`
+}
+
+// Footprint is the output of the `footprint` mode.
+type Footprint struct {
+	SchemaVersion int                 `json:"schemaVersion"`
+	TotalSize     int64               `json:"totalSize"` // Bytes used, over all categories.
+	Categories    []FootprintCategory `json:"categories"`
+}
+
+// EndpointCheck is the output of the `check-endpoint` mode.
+type EndpointCheck struct {
+	SchemaVersion int    `json:"schemaVersion"`
+	Endpoint      string `json:"endpoint"`          // Base URL of the API checked, after filling in defaults.
+	Reachable     bool   `json:"reachable"`         // Whether an ollama server responded.
+	Status        int    `json:"status,omitempty"`  // The HTTP status of the response, if any.
+	Latency       string `json:"latency,omitempty"` // Time until the response headers were received.
+	Version       string `json:"version,omitempty"` // Version the server reported, if reachable.
+	Compatible    bool   `json:"compatible"`        // Whether the version is supported by the extension.
+	// Compatibility of the version with the extension; one of "supported",
+	// "older", "newer", or "unknown".  Empty if not reachable.
+	Compatibility        string `json:"compatibility,omitempty"`
+	CompatibilityMessage string `json:"compatibilityMessage,omitempty"` // Explanation if not supported.
+	// ErrorKind is one of "proxy", "dns", "connect", "timeout", "tls", "http"
+	// or "other", as for the `check-connectivity` mode.
+	ErrorKind string `json:"errorKind,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// LinkRepair describes a link of the install that was found broken by the
+// `repair-links` mode.
+type LinkRepair struct {
+	Path     string `json:"path"`
+	Target   string `json:"target"`   // What the link refers to, as recorded in the archive.
+	Symbolic bool   `json:"symbolic"` // Whether this is a symbolic (rather than hard) link.
+	Problem  string `json:"problem"`  // One of "missing", "copied" or "wrong-target".
+}
+
+// LinkRepairResult describes the outcome of the `repair-links` mode.
+type LinkRepairResult struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	DryRun        bool         `json:"dryRun"`  // If set, broken links were reported but not repaired.
+	Checked       int          `json:"checked"` // Number of links checked.
+	Repaired      []LinkRepair `json:"repaired"`
+}
+
+// BackendEndpoint is the output of the `backend-endpoint` mode: where the UI
+// (and the proxy) should send ollama API requests.  The address is always the
+// default ollama one; custom and remote endpoints are not supported.
+type BackendEndpoint struct {
+	SchemaVersion int    `json:"schemaVersion"`
+	Backend       string `json:"backend"` // "managed" or "external".
+	URL           string `json:"url"`     // Base URL of the ollama API.
+	Host          string `json:"host"`    // Host and port of the ollama API.
+	TLS           bool   `json:"tls"`     // Whether the API is served over TLS.
+	// Path of the running external ollama in use, if any.
+	ExternalServer string `json:"externalServer,omitempty"`
+	// Which process answers at the address: "managed", "external", "none" or
+	// "unknown".
+	PortOwner string `json:"portOwner"`
+	// Why the backend may not be reached at the address, if so.
+	Warning string `json:"warning,omitempty"`
+}
+
+// FileRepair is a file of the install found to differ from its archive.
+type FileRepair struct {
+	Path    string `json:"path"`
+	Problem string `json:"problem"` // One of "missing", "corrupt" or "modified".
+}
+
+// FileRepairResult describes the outcome of the `repair` mode.
+type FileRepairResult struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	DryRun        bool         `json:"dryRun"`    // If set, files were reported but not restored.
+	Checked       int          `json:"checked"`   // Number of files checked.
+	Restored      []FileRepair `json:"restored"`  // Files restored from the archives.
+	Preserved     []FileRepair `json:"preserved"` // Files modified by the user, which were left alone.
+}
+
+// ChangelogEntry holds the release notes of a single release.
+type ChangelogEntry struct {
+	Tag         string    `json:"tag"`
+	Name        string    `json:"name"`
+	PublishedAt time.Time `json:"publishedAt"`
+	URL         string    `json:"url"`             // The release page.
+	Notes       string    `json:"notes,omitempty"` // In Markdown; empty if the release has none.
+}
+
+// Changelog is the output of the `changelog` mode: the releases after the
+// installed version, up to and including the target, newest first.
+type Changelog struct {
+	SchemaVersion    int              `json:"schemaVersion"`
+	InstalledVersion string           `json:"installedVersion,omitempty"` // Empty if not installed or unknown.
+	TargetVersion    string           `json:"targetVersion"`
+	Releases         []ChangelogEntry `json:"releases"`
+	// Truncated is set if older releases after the installed version were
+	// left out, as there were too many to list.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// StoppedProcess is an ollama process found by the `stop-all` mode.
+type StoppedProcess struct {
+	PID            int    `json:"pid"`
+	ExecutablePath string `json:"executablePath"`
+	Managed        bool   `json:"managed"` // Whether it runs the managed install, rather than an external one.
+	Stopped        bool   `json:"stopped"` // Whether it was stopped; false if, for example, it belongs to another user.
+}
+
+// StopAllResult is the output of the `stop-all` mode.
+type StopAllResult struct {
+	SchemaVersion int              `json:"schemaVersion"`
+	Processes     []StoppedProcess `json:"processes"`
+}
+
+// LogRotation is the output of the `rotate-logs` mode.
+type LogRotation struct {
+	SchemaVersion int      `json:"schemaVersion"`
+	Path          string   `json:"path"`    // The serve log.
+	Rotated       bool     `json:"rotated"` // Whether there was anything to rotate.
+	Size          int64    `json:"size"`    // Bytes moved to the newest rotated log.
+	Files         []string `json:"files"`   // The rotated logs kept, oldest first.
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"log"
+	"path/filepath"
+
+	"github.com/rancher-sandbox/rd-open-webui-docker-ext/installer/types"
+)
+
+// planUpgrade reports what installing the given release would do, compared to
+// the current install, without changing anything.  Assets are resolved with a
+// HEAD request to find their size; nothing is downloaded.
+func planUpgrade(ctx context.Context, release string) (*types.UpgradePlan, error) {
+	plan := &types.UpgradePlan{SchemaVersion: types.SchemaVersion, Assets: []types.ResolvedAsset{}}
+
+	executablePath, err := findExecutable(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	if executablePath != "" {
+		managedPath, err := findExecutable(ctx, true)
+		if err != nil {
+			return nil, err
+		}
+		plan.ExecutablePath = executablePath
+		plan.Managed = managedPath == executablePath
+		if plan.InstalledVersion, err = getExecutableVersion(ctx, executablePath); err != nil {
+			log.Printf("Failed to determine installed version: %s", err)
+		}
+	}
+
+	info, err := getRelease(ctx, release)
+	if err != nil {
+		return nil, err
+	}
+	plan.TargetVersion = info.TagName
+	plan.Compatibility, plan.CompatibilityMessage = checkVersionCompatibility(info.TagName)
+	if installed, err := parseVersion(plan.InstalledVersion); err != nil {
+		// Not installed, or of unknown version.
+		plan.UpgradeAvailable = true
+	} else if target, err := parseVersion(info.TagName); err == nil {
+		plan.UpgradeAvailable = compareVersionPrefix(installed, target) < 0
+	}
+
+	selection, err := selectAsset(ctx)
+	if err != nil {
+		return nil, err
+	}
+	chooseReleaseAssets(ctx, info.TagName, selection)
+	for _, assetName := range selection.Assets {
+		asset, err := resolveAsset(ctx, info.TagName, assetName)
+		if err != nil {
+			return nil, err
+		}
+		plan.Assets = append(plan.Assets, *asset)
+		plan.DownloadSize += asset.Size
+	}
+
+	// Models survive as long as they are not stored within the install.
+	installPath, err := getDefaultInstallLocation(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if plan.ModelsDirectory, err = getModelsDirectory(ctx); err != nil {
+		return nil, err
+	}
+	relative, err := filepath.Rel(installPath, plan.ModelsDirectory)
+	plan.ModelsPreserved = err != nil || !filepath.IsLocal(relative)
+	return plan, nil
+}
+
+// Print the plan for upgrading to the given release as JSON.
+func printUpgradePlan(ctx context.Context, release string) error {
+	plan, err := planUpgrade(ctx, release)
+	if err != nil {
+		return err
+	}
+	return printJSON(plan)
+}
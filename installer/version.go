@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Compatibility of the detected ollama version with the supported range.
+const (
+	CompatibilitySupported = "supported"
+	CompatibilityOlder     = "older"
+	CompatibilityNewer     = "newer"
+	CompatibilityUnknown   = "unknown"
+)
+
+// How strictly the version of a freshly installed executable must match the
+// release it was installed from, as set by -version-check.
+const (
+	VersionCheckStrict  = "strict"  // The version must match the tag, including any prerelease suffix.
+	VersionCheckNumeric = "numeric" // Only the numeric components must match, for prereleases reporting other versions.
+	VersionCheckOff     = "off"     // The version is not checked.
+)
+
+// parseVersion parses a version string such as "v0.3.12-rc1" into its numeric
+// components, ignoring any pre-release or build suffix.
+func parseVersion(version string) ([]int, error) {
+	trimmed := strings.TrimPrefix(strings.TrimSpace(version), "v")
+	if i := strings.IndexAny(trimmed, "-+ "); i > -1 {
+		trimmed = trimmed[:i]
+	}
+	if trimmed == "" {
+		return nil, fmt.Errorf("invalid version %q", version)
+	}
+	var result []int
+	for _, part := range strings.Split(trimmed, ".") {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid version %q", version)
+		}
+		result = append(result, n)
+	}
+	return result, nil
+}
+
+// formatVersion formats parsed version components, such as "0.5.5".
+func formatVersion(version []int) string {
+	parts := make([]string, len(version))
+	for i, n := range version {
+		parts[i] = strconv.Itoa(n)
+	}
+	return strings.Join(parts, ".")
+}
+
+// compareVersionPrefix compares a version against a bound, considering only as
+// many components as the bound has; that is, 0.3.12 is equal to the bound 0.3.
+// Returns -1, 0, or 1 if the version is less than, equal to, or greater than
+// the bound respectively.
+func compareVersionPrefix(version, bound []int) int {
+	for i, b := range bound {
+		v := 0
+		if i < len(version) {
+			v = version[i]
+		}
+		if v < b {
+			return -1
+		} else if v > b {
+			return 1
+		}
+	}
+	return 0
+}
+
+// describeVersionRange formats the supported version range for display, such
+// as "0.3–0.x".
+func describeVersionRange(minVersion, maxVersion string) string {
+	if minVersion == "" {
+		minVersion = "any"
+	}
+	if maxVersion == "" {
+		maxVersion = "any"
+	} else if strings.Count(maxVersion, ".") < 2 {
+		maxVersion += ".x"
+	}
+	return fmt.Sprintf("%s–%s", minVersion, maxVersion)
+}
+
+// checkVersionCompatibility compares the given ollama version against the
+// supported range, returning the compatibility and a message suitable for
+// displaying to the user.  This never fails; unparsable versions are reported
+// as having unknown compatibility.
+func checkVersionCompatibility(version string) (string, string) {
+	supported := describeVersionRange(*minOllamaVersion, *maxOllamaVersion)
+	parsed, err := parseVersion(version)
+	if err != nil {
+		return CompatibilityUnknown, fmt.Sprintf("could not determine if ollama %q is supported: %s", version, err)
+	}
+	if *minOllamaVersion != "" {
+		bound, err := parseVersion(*minOllamaVersion)
+		if err != nil {
+			return CompatibilityUnknown, fmt.Sprintf("invalid minimum supported version: %s", err)
+		}
+		if compareVersionPrefix(parsed, bound) < 0 {
+			return CompatibilityOlder, fmt.Sprintf("installed ollama %s is older than supported %s", version, supported)
+		}
+	}
+	if *maxOllamaVersion != "" {
+		bound, err := parseVersion(*maxOllamaVersion)
+		if err != nil {
+			return CompatibilityUnknown, fmt.Sprintf("invalid maximum supported version: %s", err)
+		}
+		if compareVersionPrefix(parsed, bound) > 0 {
+			return CompatibilityNewer, fmt.Sprintf("installed ollama %s is newer than supported %s", version, supported)
+		}
+	}
+	return CompatibilitySupported, ""
+}
+
+// verifyExecutableVersion checks that the given (staged) ollama executable
+// reports the version of the release it was installed from, to catch a mirror
+// serving the wrong build under a tag before it is put into service.  The
+// latest release is not checked, as its tag is not known up front.
+func verifyExecutableVersion(ctx context.Context, release, executablePath string) error {
+	if versionCheck == VersionCheckOff || release == "latest" {
+		return nil
+	}
+	var version string
+	var err error
+	// Anti-virus might have locked the new executable; retry for a while.
+	for i := 0; i < 10; i++ {
+		if version, err = getExecutableVersion(ctx, executablePath); err == nil {
+			break
+		}
+		time.Sleep(time.Second)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to verify version of %s: %w", release, err)
+	}
+	expected := strings.TrimPrefix(release, "v")
+	actual := strings.TrimPrefix(version, "v")
+	matches := expected == actual
+	if !matches && versionCheck == VersionCheckNumeric {
+		expectedParts, expectedErr := parseVersion(expected)
+		actualParts, actualErr := parseVersion(actual)
+		matches = expectedErr == nil && actualErr == nil && slices.Equal(expectedParts, actualParts)
+	}
+	if !matches {
+		hint := ""
+		if versionCheck == VersionCheckStrict {
+			hint = fmt.Sprintf(" (use -version-check=%s for prereleases reporting other versions)", VersionCheckNumeric)
+		}
+		return fmt.Errorf("installed ollama reports version %s, but release %s was requested; the download may be mislabeled%s",
+			version, release, hint)
+	}
+	log.Printf("Verified ollama version %s", version)
+	return nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/rancher-sandbox/rd-open-webui-docker-ext/installer/types"
+)
+
+// findLoadedModel returns the given model if the running server has it loaded,
+// or nil if it does not.
+func findLoadedModel(ctx context.Context, model string) (*types.LoadedModel, error) {
+	models, err := listLoadedModels(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, loaded := range models {
+		if normalizeModelName(loaded.Name) == model {
+			return &loaded, nil
+		}
+	}
+	return nil, nil
+}
+
+// warmModel loads the given model into memory without generating anything, so
+// that the first chat message need not wait for it.  The model then stays
+// loaded for -keep-alive if given, or else for the server's default.
+func warmModel(ctx context.Context, model string) (*types.WarmResult, error) {
+	if model == "" {
+		return nil, fmt.Errorf("no model given to warm")
+	}
+	model = normalizeModelName(model)
+	result := &types.WarmResult{SchemaVersion: types.SchemaVersion, Model: model, KeepAlive: keepAlive}
+	if loaded, err := findLoadedModel(ctx, model); err != nil {
+		log.Printf("Could not determine whether %s is loaded: %s", model, err)
+	} else {
+		result.AlreadyLoaded = loaded != nil
+	}
+
+	start := time.Now()
+	// An empty prompt only loads the model; this also resets how long it
+	// stays loaded if it already was.
+	if _, err := generate(ctx, generateRequest{Model: model, KeepAlive: keepAlive}); err != nil {
+		return nil, err
+	}
+	result.LoadSeconds = time.Since(start).Seconds()
+	log.Printf("Loaded %s in %.1fs", model, result.LoadSeconds)
+
+	if loaded, err := findLoadedModel(ctx, model); err != nil {
+		log.Printf("Could not determine memory used by %s: %s", model, err)
+	} else if loaded != nil {
+		result.Size = loaded.Size
+		result.VRAMSize = loaded.VRAMSize
+	}
+	return result, nil
+}